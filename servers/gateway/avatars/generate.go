@@ -0,0 +1,238 @@
+package avatars
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strings"
+	"unicode"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+//Styles of avatar that are generated locally, without needing
+//to reach an external service like Gravatar
+const (
+	StyleIdenticon = "identicon"
+	StyleInitials  = "initials"
+)
+
+//MinGeneratedSize and MaxGeneratedSize bound the size, in pixels,
+//of generated avatars
+const (
+	MinGeneratedSize = 16
+	MaxGeneratedSize = 512
+)
+
+//identiconGrid is the number of cells across and down an identicon
+const identiconGrid = 5
+
+//background is the color behind every generated avatar
+var background = color.RGBA{0xf0, 0xf0, 0xf0, 0xff}
+
+//Generated describes an avatar generated from a seed, such as the
+//user's ID. The same seed always generates the same avatar.
+type Generated struct {
+	Style    string
+	Seed     string
+	Initials string
+}
+
+//ClampSize limits the size to the range of sizes we generate
+func ClampSize(size int) int {
+	if size < MinGeneratedSize {
+		return MinGeneratedSize
+	}
+	if size > MaxGeneratedSize {
+		return MaxGeneratedSize
+	}
+	return size
+}
+
+//InitialsOf returns up to two uppercase initials from the given names,
+//skipping empty ones. For example "Joshua", "Maza" gives "JM".
+func InitialsOf(names ...string) string {
+	initials := []rune{}
+	for _, name := range names {
+		for _, word := range strings.Fields(name) {
+			if len(initials) == 2 {
+				break
+			}
+			initials = append(initials, unicode.ToUpper([]rune(word)[0]))
+		}
+	}
+	return string(initials)
+}
+
+//hash returns the bytes everything about the avatar is derived from
+func (g *Generated) hash() [sha256.Size]byte {
+	return sha256.Sum256([]byte(g.Seed))
+}
+
+//foreground picks a mid-toned color from the hash
+func (g *Generated) foreground() color.RGBA {
+	sum := g.hash()
+	hue := float64(int(sum[0])<<8|int(sum[1])) / 65536 * 360
+	return hslToRGB(hue, 0.55, 0.5)
+}
+
+//cells returns which cells of the identicon are filled. The left
+//half is taken from the hash and mirrored onto the right.
+func (g *Generated) cells() [identiconGrid][identiconGrid]bool {
+	sum := g.hash()
+	var cells [identiconGrid][identiconGrid]bool
+	bit := 0
+	for x := 0; x < (identiconGrid+1)/2; x++ {
+		for y := 0; y < identiconGrid; y++ {
+			// skip the bytes used for the color
+			filled := sum[2+bit/8]>>(uint(bit)%8)&1 == 1
+			cells[y][x] = filled
+			cells[y][identiconGrid-1-x] = filled
+			bit++
+		}
+	}
+	return cells
+}
+
+//useInitials returns true if the avatar should be drawn as initials.
+//The bitmap font used for PNGs only covers ASCII, so other initials
+//fall back to an identicon there.
+func (g *Generated) useInitials(bitmapFont bool) bool {
+	if g.Style != StyleInitials || len(g.Initials) == 0 {
+		return false
+	}
+	if !bitmapFont {
+		return true
+	}
+	for _, r := range g.Initials {
+		if r > unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
+
+//PNG draws the avatar as a size x size PNG image
+func (g *Generated) PNG(size int) ([]byte, error) {
+	size = ClampSize(size)
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	if g.useInitials(true) {
+		g.drawInitials(img)
+	} else {
+		g.drawIdenticon(img)
+	}
+
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("Problem encoding avatar: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+//drawIdenticon fills the identicon's cells, leaving half a cell of margin
+func (g *Generated) drawIdenticon(img *image.RGBA) {
+	size := img.Bounds().Dx()
+	fg := image.NewUniform(g.foreground())
+	cells := g.cells()
+	// grid plus a half-cell margin on each side
+	span := float64(size) / (identiconGrid + 1)
+	for y := 0; y < identiconGrid; y++ {
+		for x := 0; x < identiconGrid; x++ {
+			if !cells[y][x] {
+				continue
+			}
+			rect := image.Rect(
+				int(span*(float64(x)+0.5)), int(span*(float64(y)+0.5)),
+				int(span*(float64(x)+1.5)), int(span*(float64(y)+1.5)))
+			draw.Draw(img, rect, fg, image.Point{}, draw.Src)
+		}
+	}
+}
+
+//drawInitials fills the background with the avatar's color and draws
+//the initials in white, scaling up the bitmap font to fit
+func (g *Generated) drawInitials(img *image.RGBA) {
+	draw.Draw(img, img.Bounds(), image.NewUniform(g.foreground()), image.Point{}, draw.Src)
+
+	face := basicfont.Face7x13
+	text := image.NewRGBA(image.Rect(0, 0, face.Advance*len(g.Initials), face.Height))
+	drawer := font.Drawer{
+		Dst:  text,
+		Src:  image.White,
+		Face: face,
+		Dot:  fixed.P(0, face.Ascent),
+	}
+	drawer.DrawString(g.Initials)
+
+	// the initials take up about half the height of the avatar
+	size := img.Bounds().Dx()
+	scale := float64(size) / 2 / float64(face.Height)
+	w := int(float64(text.Bounds().Dx()) * scale)
+	h := int(float64(text.Bounds().Dy()) * scale)
+	dst := image.Rect((size-w)/2, (size-h)/2, (size-w)/2+w, (size-h)/2+h)
+	draw.NearestNeighbor.Scale(img, dst, text, text.Bounds(), draw.Over, nil)
+}
+
+//SVG draws the avatar as a size x size SVG image
+func (g *Generated) SVG(size int) []byte {
+	size = ClampSize(size)
+	fg := g.foreground()
+	fill := fmt.Sprintf("#%02x%02x%02x", fg.R, fg.G, fg.B)
+
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`,
+		size, size, (identiconGrid+1)*2, (identiconGrid+1)*2)
+
+	if g.useInitials(false) {
+		fmt.Fprintf(&buf, `<rect width="100%%" height="100%%" fill="%s"/>`, fill)
+		fmt.Fprintf(&buf, `<text x="50%%" y="50%%" dy=".35em" text-anchor="middle" fill="#ffffff" font-family="sans-serif" font-size="5">%s</text>`,
+			html.EscapeString(g.Initials))
+	} else {
+		fmt.Fprintf(&buf, `<rect width="100%%" height="100%%" fill="#%02x%02x%02x"/>`, background.R, background.G, background.B)
+		cells := g.cells()
+		for y := 0; y < identiconGrid; y++ {
+			for x := 0; x < identiconGrid; x++ {
+				if cells[y][x] {
+					// in units of half a cell, to keep the margin whole
+					fmt.Fprintf(&buf, `<rect x="%d" y="%d" width="2" height="2" fill="%s"/>`, x*2+1, y*2+1, fill)
+				}
+			}
+		}
+	}
+	buf.WriteString("</svg>")
+	return buf.Bytes()
+}
+
+//hslToRGB converts a hue (0-360), saturation and lightness (0-1) to a color
+func hslToRGB(h float64, s float64, l float64) color.RGBA {
+	c := (1 - math.Abs(2*l-1)) * s
+	hp := h / 60
+	x := c * (1 - math.Abs(math.Mod(hp, 2)-1))
+	var r, g, b float64
+	switch {
+	case hp < 1:
+		r, g, b = c, x, 0
+	case hp < 2:
+		r, g, b = x, c, 0
+	case hp < 3:
+		r, g, b = 0, c, x
+	case hp < 4:
+		r, g, b = 0, x, c
+	case hp < 5:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	m := l - c/2
+	return color.RGBA{uint8((r + m) * 255), uint8((g + m) * 255), uint8((b + m) * 255), 0xff}
+}
@@ -0,0 +1,89 @@
+package avatars
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestInitialsOf(t *testing.T) {
+	cases := []struct {
+		names    []string
+		expected string
+	}{
+		{[]string{"Joshua", "Maza"}, "JM"},
+		{[]string{"", "maza"}, "M"},
+		{[]string{"Mary Jane", "Watson"}, "MJ"},
+		{[]string{"élodie", ""}, "É"},
+		{[]string{"", "  "}, ""},
+	}
+	for _, c := range cases {
+		if initials := InitialsOf(c.names...); initials != c.expected {
+			t.Errorf("expected initials of %q to be %q but got %q", c.names, c.expected, initials)
+		}
+	}
+}
+
+func TestIdenticonIsDeterministic(t *testing.T) {
+	a := Generated{Style: StyleIdenticon, Seed: "1"}
+	b := Generated{Style: StyleIdenticon, Seed: "1"}
+	c := Generated{Style: StyleIdenticon, Seed: "2"}
+
+	pngA, err := a.PNG(64)
+	if err != nil {
+		t.Fatalf("error generating PNG: %v", err)
+	}
+	pngB, _ := b.PNG(64)
+	pngC, _ := c.PNG(64)
+	if !bytes.Equal(pngA, pngB) {
+		t.Error("expected the same seed to generate the same PNG")
+	}
+	if bytes.Equal(pngA, pngC) {
+		t.Error("expected different seeds to generate different PNGs")
+	}
+	if !bytes.Equal(a.SVG(64), b.SVG(64)) {
+		t.Error("expected the same seed to generate the same SVG")
+	}
+
+	// identicons are mirrored left to right
+	cells := a.cells()
+	for y := range cells {
+		for x := range cells[y] {
+			if cells[y][x] != cells[y][identiconGrid-1-x] {
+				t.Fatalf("identicon isn't symmetric at row %d", y)
+			}
+		}
+	}
+}
+
+func TestGeneratedPNGSize(t *testing.T) {
+	for _, style := range []string{StyleIdenticon, StyleInitials} {
+		g := Generated{Style: style, Seed: "42", Initials: "JM"}
+		for _, size := range []int{1, 64, 10000} {
+			data, err := g.PNG(size)
+			if err != nil {
+				t.Fatalf("error generating %s PNG: %v", style, err)
+			}
+			img, err := png.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("generated %s isn't a valid PNG: %v", style, err)
+			}
+			expected := ClampSize(size)
+			if img.Bounds().Dx() != expected || img.Bounds().Dy() != expected {
+				t.Errorf("expected %s of size %d to be %dx%d but got %v", style, size, expected, expected, img.Bounds())
+			}
+		}
+	}
+}
+
+func TestInitialsSVGEscapes(t *testing.T) {
+	g := Generated{Style: StyleInitials, Seed: "1", Initials: "<&"}
+	svg := string(g.SVG(64))
+	if strings.Contains(svg, "<&") || !strings.Contains(svg, "&lt;&amp;") {
+		t.Errorf("expected initials to be escaped in the SVG: %s", svg)
+	}
+	if !strings.HasPrefix(svg, "<svg ") || !strings.HasSuffix(svg, "</svg>") {
+		t.Errorf("expected a single svg element: %s", svg)
+	}
+}
@@ -0,0 +1,111 @@
+package avatars
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+)
+
+//MaxUploadBytes is the largest avatar upload we accept
+const MaxUploadBytes = 5 << 20
+
+//MaxDimension is the largest width or height we'll decode,
+//checked before decoding so huge images can't exhaust memory
+const MaxDimension = 4096
+
+//MinDimension is the smallest width or height we accept
+const MinDimension = 32
+
+//Sizes are the square sizes, in pixels, every avatar is resized into
+var Sizes = []int{32, 64, 128, 256}
+
+//ErrUnsupportedType is returned when the upload isn't a PNG, JPEG or GIF
+var ErrUnsupportedType = errors.New("avatar must be a PNG, JPEG or GIF image")
+
+//ErrTooLarge is returned when the upload is over MaxUploadBytes
+var ErrTooLarge = fmt.Errorf("avatar must be at most %d bytes", MaxUploadBytes)
+
+//ErrBadDimensions is returned when the image is too big or too small
+var ErrBadDimensions = fmt.Errorf("avatar must be between %d and %d pixels wide and tall", MinDimension, MaxDimension)
+
+//Process sniffs and decodes the uploaded image, then crops it to a centered
+//square and resizes it into each of the `Sizes`, returning PNG-encoded bytes
+//keyed by size. Re-encoding drops any EXIF or other metadata in the upload,
+//after the EXIF orientation has been applied to JPEGs.
+func Process(data []byte) (map[int][]byte, error) {
+	if len(data) > MaxUploadBytes {
+		return nil, ErrTooLarge
+	}
+
+	// trust the bytes, not the Content-Type the client sent
+	contentType := http.DetectContentType(data)
+	var decode func([]byte) (image.Image, error)
+	var decodeConfig func([]byte) (image.Config, error)
+	switch contentType {
+	case "image/png":
+		decode = func(b []byte) (image.Image, error) { return png.Decode(bytes.NewReader(b)) }
+		decodeConfig = func(b []byte) (image.Config, error) { return png.DecodeConfig(bytes.NewReader(b)) }
+	case "image/jpeg":
+		decode = func(b []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(b)) }
+		decodeConfig = func(b []byte) (image.Config, error) { return jpeg.DecodeConfig(bytes.NewReader(b)) }
+	case "image/gif":
+		// only the first frame of an animated GIF is kept
+		decode = func(b []byte) (image.Image, error) { return gif.Decode(bytes.NewReader(b)) }
+		decodeConfig = func(b []byte) (image.Config, error) { return gif.DecodeConfig(bytes.NewReader(b)) }
+	default:
+		return nil, ErrUnsupportedType
+	}
+
+	config, err := decodeConfig(data)
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	if config.Width > MaxDimension || config.Height > MaxDimension ||
+		config.Width < MinDimension || config.Height < MinDimension {
+		return nil, ErrBadDimensions
+	}
+
+	img, err := decode(data)
+	if err != nil {
+		return nil, errors.New("Problem decoding image: " + err.Error())
+	}
+	if contentType == "image/jpeg" {
+		img = applyOrientation(img, exifOrientation(data))
+	}
+
+	square := cropSquare(img)
+	resized := map[int][]byte{}
+	for _, size := range Sizes {
+		dst := image.NewRGBA(image.Rect(0, 0, size, size))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), square, square.Bounds(), draw.Src, nil)
+
+		buf := bytes.Buffer{}
+		if err := png.Encode(&buf, dst); err != nil {
+			return nil, errors.New("Problem encoding image: " + err.Error())
+		}
+		resized[size] = buf.Bytes()
+	}
+	return resized, nil
+}
+
+//cropSquare returns the largest centered square within the image
+func cropSquare(img image.Image) image.Image {
+	bounds := img.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Copy(square, image.Point{}, img, image.Rect(x, y, x+side, y+side), draw.Src, nil)
+	return square
+}
@@ -0,0 +1,122 @@
+package avatars
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+//testImage returns a w x h image that is red on the left half
+//and blue on the right half
+func testImage(w int, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				img.Set(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestProcess(t *testing.T) {
+	pngBuf := bytes.Buffer{}
+	png.Encode(&pngBuf, testImage(300, 200))
+	jpegBuf := bytes.Buffer{}
+	jpeg.Encode(&jpegBuf, testImage(200, 300), nil)
+	gifBuf := bytes.Buffer{}
+	gif.Encode(&gifBuf, testImage(100, 100), nil)
+	tinyBuf := bytes.Buffer{}
+	png.Encode(&tinyBuf, testImage(10, 10))
+	hugeBuf := bytes.Buffer{}
+	png.Encode(&hugeBuf, image.NewGray(image.Rect(0, 0, MaxDimension+1, 1)))
+
+	cases := []struct {
+		name        string
+		data        []byte
+		expectedErr error
+	}{
+		{"PNG", pngBuf.Bytes(), nil},
+		{"JPEG", jpegBuf.Bytes(), nil},
+		{"GIF", gifBuf.Bytes(), nil},
+		{"Too Small", tinyBuf.Bytes(), ErrBadDimensions},
+		{"Too Wide", hugeBuf.Bytes(), ErrBadDimensions},
+		{"Not An Image", []byte("<html><body>hi</body></html>"), ErrUnsupportedType},
+		{"Too Many Bytes", make([]byte, MaxUploadBytes+1), ErrTooLarge},
+	}
+
+	for _, c := range cases {
+		resized, err := Process(c.data)
+		if err != c.expectedErr {
+			t.Errorf("case %s: expected error %v but got %v", c.name, c.expectedErr, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		for _, size := range Sizes {
+			img, err := png.Decode(bytes.NewReader(resized[size]))
+			if err != nil {
+				t.Errorf("case %s: size %d isn't a valid PNG: %v", c.name, size, err)
+				continue
+			}
+			if img.Bounds().Dx() != size || img.Bounds().Dy() != size {
+				t.Errorf("case %s: expected %dx%d but got %v", c.name, size, size, img.Bounds())
+			}
+		}
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// 4x2, red on the left, blue on the right
+	img := testImage(4, 2)
+
+	// rotating 90 degrees clockwise puts red on top
+	rotated := applyOrientation(img, 6)
+	if rotated.Bounds().Dx() != 2 || rotated.Bounds().Dy() != 4 {
+		t.Fatalf("expected rotated image to be 2x4 but got %v", rotated.Bounds())
+	}
+	if r, _, _, _ := rotated.At(0, 0).RGBA(); r == 0 {
+		t.Errorf("expected red at the top after rotating clockwise")
+	}
+	if _, _, b, _ := rotated.At(0, 3).RGBA(); b == 0 {
+		t.Errorf("expected blue at the bottom after rotating clockwise")
+	}
+
+	// flipping horizontally puts blue on the left
+	flipped := applyOrientation(img, 2)
+	if _, _, b, _ := flipped.At(0, 0).RGBA(); b == 0 {
+		t.Errorf("expected blue on the left after flipping horizontally")
+	}
+
+	if applyOrientation(img, 1) != img {
+		t.Errorf("expected normal orientation to return the image unchanged")
+	}
+}
+
+func TestExifOrientation(t *testing.T) {
+	// a minimal JPEG prefix with a big-endian Exif segment
+	// holding orientation 6 in IFD0
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8,
+		0, 1, // one entry
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, 6, 0, 0,
+		0, 0, 0, 0}
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	length := len(segment) + 2
+	data := append([]byte{0xFF, 0xD8, 0xFF, 0xE1, byte(length >> 8), byte(length)}, segment...)
+	data = append(data, 0xFF, 0xDA, 0, 2)
+
+	if o := exifOrientation(data); o != 6 {
+		t.Errorf("expected orientation 6 but got %d", o)
+	}
+	if o := exifOrientation([]byte{0xFF, 0xD8, 0xFF}); o != 1 {
+		t.Errorf("expected orientation 1 for truncated data but got %d", o)
+	}
+}
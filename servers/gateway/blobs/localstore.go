@@ -0,0 +1,116 @@
+package blobs
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//LocalStore is a Store that keeps blobs as files under a root directory.
+//This is suitable for single-node deployments, or multiple nodes
+//sharing a network filesystem.
+type LocalStore struct {
+	Root string
+}
+
+//NewLocalStore constructs a new LocalStore, creating the
+//root directory if it doesn't exist yet
+func NewLocalStore(root string) (*LocalStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, errors.New("Problem creating blob directory: " + err.Error())
+	}
+	return &LocalStore{Root: root}, nil
+}
+
+//filePath returns the path of the file for the key,
+//rejecting keys that would land outside the root directory
+func (ls *LocalStore) filePath(key string) (string, error) {
+	if len(key) == 0 || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || cleaned != "/"+key {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(ls.Root, filepath.FromSlash(cleaned)), nil
+}
+
+//Put saves the contents of `data` under the key. The file is written
+//to a temporary file first and renamed into place, so readers never
+//see a partially written blob. The content type isn't stored; Get works
+//it out from the key's extension, so keys should have one.
+func (ls *LocalStore) Put(key string, data io.Reader, contentType string) error {
+	filename, err := ls.filePath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return errors.New("Problem creating blob directory: " + err.Error())
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), ".upload-")
+	if err != nil {
+		return errors.New("Problem creating blob file: " + err.Error())
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, data); err != nil {
+		tmp.Close()
+		return errors.New("Problem writing blob: " + err.Error())
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.New("Problem writing blob: " + err.Error())
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return errors.New("Problem saving blob: " + err.Error())
+	}
+	return nil
+}
+
+//Get returns the file stored under the key. The content type
+//is worked out from the key's file extension.
+func (ls *LocalStore) Get(key string) (io.ReadCloser, *Info, error) {
+	filename, err := ls.filePath(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrBlobNotFound
+		}
+		return nil, nil, errors.New("Problem opening blob: " + err.Error())
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, errors.New("Problem reading blob info: " + err.Error())
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	return file, &Info{
+		ContentType: contentType,
+		Size:        stat.Size(),
+		ModTime:     stat.ModTime(),
+	}, nil
+}
+
+//Delete deletes the file stored under the key, if there is one
+func (ls *LocalStore) Delete(key string) error {
+	filename, err := ls.filePath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return errors.New("Problem deleting blob: " + err.Error())
+	}
+	return nil
+}
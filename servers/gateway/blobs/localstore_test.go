@@ -0,0 +1,72 @@
+package blobs
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+/*
+TestLocalStore runs through a full CRUD cycle on a LocalStore
+rooted in a temporary directory.
+*/
+func TestLocalStore(t *testing.T) {
+	root, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	store, err := NewLocalStore(root)
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+
+	key := "avatars/1/abc/256.png"
+	if _, _, err := store.Get(key); err != ErrBlobNotFound {
+		t.Errorf("incorrect error when getting blob that was never stored: expected %v but got %v", ErrBlobNotFound, err)
+	}
+
+	if err := store.Put(key, strings.NewReader("image bytes"), "image/png"); err != nil {
+		t.Fatalf("error putting blob: %v", err)
+	}
+
+	blob, info, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("error getting blob: %v", err)
+	}
+	data, _ := ioutil.ReadAll(blob)
+	blob.Close()
+	if string(data) != "image bytes" {
+		t.Errorf("incorrect blob contents: %q", string(data))
+	}
+	if info.ContentType != "image/png" || info.Size != int64(len(data)) {
+		t.Errorf("incorrect blob info: %+v", info)
+	}
+
+	if err := store.Delete(key); err != nil {
+		t.Errorf("error deleting blob: %v", err)
+	}
+	if _, _, err := store.Get(key); err != ErrBlobNotFound {
+		t.Errorf("incorrect error when getting blob that was deleted: expected %v but got %v", ErrBlobNotFound, err)
+	}
+	if err := store.Delete(key); err != nil {
+		t.Errorf("deleting a missing blob should not be an error: %v", err)
+	}
+}
+
+func TestLocalStoreInvalidKeys(t *testing.T) {
+	root, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	store, _ := NewLocalStore(root)
+
+	for _, key := range []string{"", "/", "../escape.png", "avatars/../../escape.png", "/absolute.png", "a//b.png", `a\b.png`} {
+		if err := store.Put(key, strings.NewReader("x"), "image/png"); err != ErrInvalidKey {
+			t.Errorf("expected %v when putting key %q but got %v", ErrInvalidKey, key, err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package blobs
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+//ErrBlobNotFound is returned from Store.Get() when there's
+//no blob stored under the requested key
+var ErrBlobNotFound = errors.New("blob not found")
+
+//ErrInvalidKey is returned when a key is empty or tries to escape the store
+var ErrInvalidKey = errors.New("invalid blob key")
+
+//Info describes a stored blob
+type Info struct {
+	ContentType string
+	Size        int64
+	ModTime     time.Time
+}
+
+//Store represents a store for binary blobs such as uploaded images.
+//This is an abstract interface that can be implemented against
+//the local filesystem or a shared object store. Keys are slash-separated
+//paths like "avatars/12/256.png".
+type Store interface {
+	//Put saves the contents of `data` under the key, replacing any
+	//existing blob with that key
+	Put(key string, data io.Reader, contentType string) error
+
+	//Get returns a reader for the blob stored under the key, and
+	//information about it. The caller must close the reader.
+	Get(key string) (io.ReadCloser, *Info, error)
+
+	//Delete deletes the blob stored under the key, if there is one
+	Delete(key string) error
+}
@@ -0,0 +1,281 @@
+package bulk
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/mailer"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+)
+
+//DefaultBatchSize is how many users are inserted per transaction
+const DefaultBatchSize = 100
+
+//UserStore is the part of the user store the importer needs
+type UserStore interface {
+	GetByEmail(email string) (*users.User, error)
+	GetByUserName(username string) (*users.User, error)
+	InsertBatch(list []*users.User) error
+	CreateInvitation(userID int64, tokenHash []byte, expiresAt time.Time) error
+}
+
+//Inviter sends an invited user the token they can set their password with
+type Inviter interface {
+	Invite(user *users.User, token string) error
+}
+
+//EmailInviter is an Inviter that emails a link to accept the invitation
+type EmailInviter struct {
+	Mailer mailer.Mailer
+	//AcceptURL is the page where users accept invitations.
+	//The token is added as the `token` query string parameter.
+	AcceptURL string
+}
+
+//Invite emails the user a link to accept their invitation
+func (ei *EmailInviter) Invite(user *users.User, token string) error {
+	link, err := url.Parse(ei.AcceptURL)
+	if err != nil {
+		return errors.New("Invalid invitation URL: " + err.Error())
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	name := user.FirstName
+	if len(name) == 0 {
+		name = user.UserName
+	}
+	body := fmt.Sprintf("Hi %s,\n\nAn account has been created for you with the username %s.\n"+
+		"Choose a password to sign in here:\n\n%s\n\nThis link expires in %d days.\n",
+		name, user.UserName, link.String(), int(users.InvitationLifetime.Hours()/24))
+	return ei.Mailer.Send(user.Email, "You've been invited", body)
+}
+
+//Importer validates and inserts imported users
+type Importer struct {
+	Store UserStore
+	//Inviter, if set, is sent an invitation for every user without
+	//a password. Without one, every user needs a password.
+	Inviter Inviter
+	//BatchSize is how many users are inserted per transaction
+	BatchSize int
+	//DryRun validates the users without inserting any
+	DryRun bool
+}
+
+//Report is the outcome of an import
+type Report struct {
+	Total int `json:"total"`
+	//Valid is how many users passed validation
+	Valid int `json:"valid"`
+	//Imported is how many users were inserted, zero for a dry run
+	Imported int         `json:"imported"`
+	Invited  int         `json:"invited"`
+	DryRun   bool        `json:"dryRun"`
+	Errors   []*RowError `json:"errors"`
+}
+
+//pending is a validated row waiting to be inserted
+type pending struct {
+	row     *Row
+	newUser *users.NewUser
+	invite  bool
+}
+
+//Import validates every row, then inserts the valid ones in batches.
+//`rowErrors` are rows that couldn't be parsed, which are added to the report.
+//Rows that fail don't stop the others from being imported.
+func (im *Importer) Import(rows []*Row, rowErrors []*RowError) *Report {
+	report := &Report{
+		Total:  len(rows) + len(rowErrors),
+		DryRun: im.DryRun,
+		Errors: append([]*RowError{}, rowErrors...),
+	}
+	fail := func(row *Row, err error) {
+		report.Errors = append(report.Errors, &RowError{Row: row.Line, Email: row.Record.Email, Error: err.Error()})
+	}
+
+	valid := []*pending{}
+	seenEmails := map[string]int{}
+	seenUserNames := map[string]int{}
+	for _, row := range rows {
+		p, err := im.validate(row)
+		if err != nil {
+			fail(row, err)
+			continue
+		}
+		email := strings.ToLower(row.Record.Email)
+		userName := strings.ToLower(row.Record.UserName)
+		if line, found := seenEmails[email]; found {
+			fail(row, fmt.Errorf("email is a duplicate of row %d", line))
+			continue
+		}
+		if line, found := seenUserNames[userName]; found {
+			fail(row, fmt.Errorf("username is a duplicate of row %d", line))
+			continue
+		}
+		seenEmails[email] = row.Line
+		seenUserNames[userName] = row.Line
+		valid = append(valid, p)
+	}
+	report.Valid = len(valid)
+	if im.DryRun {
+		return report
+	}
+
+	batchSize := im.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	for start := 0; start < len(valid); start += batchSize {
+		end := start + batchSize
+		if end > len(valid) {
+			end = len(valid)
+		}
+		im.insert(valid[start:end], report, fail)
+	}
+	return report
+}
+
+//validate checks one row, including whether its email
+//or username is already in use
+func (im *Importer) validate(row *Row) (*pending, error) {
+	rec := &row.Record
+	p := &pending{row: row}
+
+	password := rec.Password
+	if len(password) == 0 {
+		if im.Inviter == nil {
+			return nil, errors.New("password is required unless sending invitations")
+		}
+		// no one knows this password, until the invitation replaces it
+		random, err := randomPassword()
+		if err != nil {
+			return nil, err
+		}
+		password = random
+		p.invite = true
+	}
+
+	p.newUser = &users.NewUser{
+		Email:        rec.Email,
+		Password:     password,
+		PasswordConf: password,
+		UserName:     rec.UserName,
+		FirstName:    rec.FirstName,
+		LastName:     rec.LastName,
+	}
+	if err := p.newUser.Validate(); err != nil {
+		return nil, err
+	}
+	if len(rec.Role) > 0 && !users.ValidRole(rec.Role) {
+		return nil, errors.New("Unknown role: " + rec.Role)
+	}
+	profile := users.Updates{
+		FirstName:   users.Patch(rec.FirstName),
+		LastName:    users.Patch(rec.LastName),
+		DisplayName: users.Patch(rec.DisplayName),
+		Title:       users.Patch(rec.Title),
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+
+	existing, err := im.Store.GetByEmail(rec.Email)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, errors.New("a user with this email already exists")
+	}
+	existing, err = im.Store.GetByUserName(rec.UserName)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, users.ErrUserNameTaken
+	}
+	return p, nil
+}
+
+//insert inserts a batch. If the batch fails, each user in it is inserted
+//on their own so failures are reported against the right rows.
+func (im *Importer) insert(batch []*pending, report *Report, fail func(*Row, error)) {
+	list := make([]*users.User, 0, len(batch))
+	inserting := make([]*pending, 0, len(batch))
+	for _, p := range batch {
+		user, err := p.newUser.ToUser()
+		if err != nil {
+			fail(p.row, err)
+			continue
+		}
+		if len(p.row.Record.Role) > 0 {
+			user.Role = p.row.Record.Role
+		}
+		user.DisplayName = p.row.Record.DisplayName
+		user.Title = p.row.Record.Title
+		list = append(list, user)
+		inserting = append(inserting, p)
+	}
+	if len(list) == 0 {
+		return
+	}
+
+	if err := im.Store.InsertBatch(list); err != nil {
+		// find the users that failed by inserting them one at a time
+		for i, user := range list {
+			if err := im.Store.InsertBatch([]*users.User{user}); err != nil {
+				if batchErr, ok := err.(*users.BatchError); ok {
+					err = batchErr.Err
+				}
+				fail(inserting[i].row, err)
+				continue
+			}
+			im.inserted(user, inserting[i], report, fail)
+		}
+		return
+	}
+	for i, user := range list {
+		im.inserted(user, inserting[i], report, fail)
+	}
+}
+
+//inserted counts an inserted user and sends their invitation, if they need one
+func (im *Importer) inserted(user *users.User, p *pending, report *Report, fail func(*Row, error)) {
+	report.Imported++
+	if !p.invite {
+		return
+	}
+	if err := im.invite(user); err != nil {
+		fail(p.row, errors.New("imported, but the invitation failed: "+err.Error()))
+		return
+	}
+	report.Invited++
+}
+
+//invite creates an invitation for the user and sends it
+func (im *Importer) invite(user *users.User) error {
+	token, hash, err := users.NewInvitationToken()
+	if err != nil {
+		return err
+	}
+	if err := im.Store.CreateInvitation(user.ID, hash, time.Now().UTC().Add(users.InvitationLifetime)); err != nil {
+		return err
+	}
+	return im.Inviter.Invite(user, token)
+}
+
+//randomPassword returns a random password that no one knows
+func randomPassword() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.New("Problem generating random password.")
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
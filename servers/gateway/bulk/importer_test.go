@@ -0,0 +1,130 @@
+package bulk
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+)
+
+//fakeStore is an in-memory UserStore
+type fakeStore struct {
+	users       []*users.User
+	invitations map[int64][]byte
+	batches     int
+}
+
+func (fs *fakeStore) GetByEmail(email string) (*users.User, error) {
+	for _, u := range fs.users {
+		if strings.EqualFold(u.Email, email) {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (fs *fakeStore) GetByUserName(userName string) (*users.User, error) {
+	for _, u := range fs.users {
+		if strings.EqualFold(u.UserName, userName) {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+//InsertBatch fails the whole batch if any user is named "fail"
+func (fs *fakeStore) InsertBatch(list []*users.User) error {
+	fs.batches++
+	for i, u := range list {
+		if u.UserName == "fail" {
+			return &users.BatchError{Index: i, Err: errors.New("Error inserting row")}
+		}
+	}
+	for _, u := range list {
+		u.ID = int64(len(fs.users) + 1)
+		fs.users = append(fs.users, u)
+	}
+	return nil
+}
+
+func (fs *fakeStore) CreateInvitation(userID int64, tokenHash []byte, expiresAt time.Time) error {
+	fs.invitations[userID] = tokenHash
+	return nil
+}
+
+//fakeInviter records the tokens it was asked to send
+type fakeInviter struct {
+	tokens map[string]string
+}
+
+func (fi *fakeInviter) Invite(user *users.User, token string) error {
+	fi.tokens[user.Email] = token
+	return nil
+}
+
+func TestImport(t *testing.T) {
+	store := &fakeStore{
+		users:       []*users.User{{ID: 1, Email: "taken@uw.edu", UserName: "taken"}},
+		invitations: map[int64][]byte{},
+	}
+	inviter := &fakeInviter{tokens: map[string]string{}}
+	rows := []*Row{
+		{Line: 2, Record: Record{Email: "jsm209@uw.edu", UserName: "jsm209", Password: "password", Role: "moderator"}},
+		{Line: 3, Record: Record{Email: "alice@uw.edu", UserName: "alice"}},
+		{Line: 4, Record: Record{Email: "bad email", UserName: "bad", Password: "password"}},
+		{Line: 5, Record: Record{Email: "JSM209@uw.edu", UserName: "other", Password: "password"}},
+		{Line: 6, Record: Record{Email: "taken2@uw.edu", UserName: "Taken", Password: "password"}},
+		{Line: 7, Record: Record{Email: "role@uw.edu", UserName: "role", Password: "password", Role: "owner"}},
+		{Line: 8, Record: Record{Email: "fail@uw.edu", UserName: "fail", Password: "password"}},
+	}
+	parseErrors := []*RowError{{Row: 9, Error: "invalid JSON"}}
+
+	// a dry run validates without inserting anything
+	dryRun := &Importer{Store: store, Inviter: inviter, DryRun: true}
+	report := dryRun.Import(rows, parseErrors)
+	if report.Total != 8 || report.Valid != 3 || report.Imported != 0 || len(report.Errors) != 5 {
+		t.Errorf("incorrect dry run report: %+v", report)
+	}
+	if store.batches != 0 || len(inviter.tokens) != 0 {
+		t.Errorf("dry run inserted users or sent invitations")
+	}
+
+	importer := &Importer{Store: store, Inviter: inviter, BatchSize: 10}
+	report = importer.Import(rows, parseErrors)
+	if report.Imported != 2 || report.Invited != 1 {
+		t.Errorf("incorrect import report: %+v", report)
+	}
+	failedRows := map[int]bool{}
+	for _, rowErr := range report.Errors {
+		failedRows[rowErr.Row] = true
+	}
+	for _, row := range []int{4, 5, 6, 7, 8, 9} {
+		if !failedRows[row] {
+			t.Errorf("expected row %d to be reported as failing", row)
+		}
+	}
+
+	// the failed batch was retried one user at a time
+	if store.batches != 4 {
+		t.Errorf("expected 1 failed batch and 3 single inserts but got %d batches", store.batches)
+	}
+
+	imported, _ := store.GetByUserName("jsm209")
+	if imported == nil || imported.Role != users.RoleModerator || imported.Authenticate("password") != nil {
+		t.Errorf("incorrectly imported user: %+v", imported)
+	}
+	invited, _ := store.GetByUserName("alice")
+	token := inviter.tokens["alice@uw.edu"]
+	if invited == nil || len(token) == 0 || string(store.invitations[invited.ID]) != string(users.HashInvitationToken(token)) {
+		t.Errorf("expected alice to be invited")
+	}
+
+	// without an inviter, passwords are required
+	noInvites := &Importer{Store: store, DryRun: true}
+	report = noInvites.Import([]*Row{{Line: 2, Record: Record{Email: "bob@uw.edu", UserName: "bob"}}}, nil)
+	if report.Valid != 0 || len(report.Errors) != 1 {
+		t.Errorf("expected a user without a password to fail without invitations: %+v", report)
+	}
+}
@@ -0,0 +1,209 @@
+package bulk
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+)
+
+//Formats users can be imported from and exported to
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+//Record is one user in an import or export file. Exports never
+//include a password, and imports may leave it out to send an
+//invitation instead.
+type Record struct {
+	Email       string `json:"email"`
+	UserName    string `json:"userName"`
+	FirstName   string `json:"firstName"`
+	LastName    string `json:"lastName"`
+	Password    string `json:"password,omitempty"`
+	Role        string `json:"role,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+	Title       string `json:"title,omitempty"`
+}
+
+//csvColumns are the CSV header names, matching the JSON field names
+var csvColumns = []string{"email", "userName", "firstName", "lastName", "password", "role", "displayName", "title"}
+
+//fields returns pointers to the record's fields in `csvColumns` order
+func (rec *Record) fields() []*string {
+	return []*string{&rec.Email, &rec.UserName, &rec.FirstName, &rec.LastName,
+		&rec.Password, &rec.Role, &rec.DisplayName, &rec.Title}
+}
+
+//RowError is a problem with one row of an import
+type RowError struct {
+	//Row is the line of the file, counting the CSV header as line 1
+	Row   int    `json:"row"`
+	Email string `json:"email,omitempty"`
+	Error string `json:"error"`
+}
+
+//Row is a record along with the line of the file it came from
+type Row struct {
+	Line   int
+	Record Record
+}
+
+//FormatFromName guesses the format from a file name's extension,
+//defaulting to CSV
+func FormatFromName(name string) string {
+	if strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".json") {
+		return FormatJSONL
+	}
+	return FormatCSV
+}
+
+//ReadRecords reads every record from `r`, returning the rows that could
+//be parsed along with an error for each one that couldn't. An error is
+//returned if the file as a whole can't be read.
+func ReadRecords(r io.Reader, format string) ([]*Row, []*RowError, error) {
+	switch format {
+	case FormatCSV:
+		return readCSV(r)
+	case FormatJSONL:
+		return readJSONL(r)
+	}
+	return nil, nil, errors.New("Unknown format: " + format)
+}
+
+func readCSV(r io.Reader) ([]*Row, []*RowError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, errors.New("Problem reading CSV header: " + err.Error())
+	}
+
+	// columns may be in any order, but must all be known
+	columnIndex := map[string]int{}
+	for i, column := range csvColumns {
+		columnIndex[column] = i
+	}
+	order := make([]int, len(header))
+	for i, column := range header {
+		index, found := columnIndex[strings.TrimSpace(column)]
+		if !found {
+			return nil, nil, errors.New("Unknown CSV column: " + column)
+		}
+		order[i] = index
+	}
+
+	rows := []*Row{}
+	rowErrors := []*RowError{}
+	for line := 2; ; line++ {
+		values, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, &RowError{Row: line, Error: err.Error()})
+			continue
+		}
+		if len(values) != len(header) {
+			rowErrors = append(rowErrors, &RowError{Row: line,
+				Error: fmt.Sprintf("expected %d columns but got %d", len(header), len(values))})
+			continue
+		}
+		row := &Row{Line: line}
+		fields := row.Record.fields()
+		for i, value := range values {
+			*fields[order[i]] = strings.TrimSpace(value)
+		}
+		rows = append(rows, row)
+	}
+	return rows, rowErrors, nil
+}
+
+func readJSONL(r io.Reader) ([]*Row, []*RowError, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	rows := []*Row{}
+	rowErrors := []*RowError{}
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 {
+			continue
+		}
+		row := &Row{Line: line}
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&row.Record); err != nil {
+			rowErrors = append(rowErrors, &RowError{Row: line, Error: "invalid JSON: " + err.Error()})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, errors.New("Problem reading JSON lines: " + err.Error())
+	}
+	return rows, rowErrors, nil
+}
+
+//RecordWriter writes records in one of the formats
+type RecordWriter struct {
+	csv  *csv.Writer
+	json *json.Encoder
+}
+
+//NewRecordWriter constructs a new RecordWriter, writing the
+//CSV header right away
+func NewRecordWriter(w io.Writer, format string) (*RecordWriter, error) {
+	switch format {
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvColumns); err != nil {
+			return nil, err
+		}
+		return &RecordWriter{csv: writer}, nil
+	case FormatJSONL:
+		return &RecordWriter{json: json.NewEncoder(w)}, nil
+	}
+	return nil, errors.New("Unknown format: " + format)
+}
+
+//Write writes one record
+func (rw *RecordWriter) Write(rec *Record) error {
+	if rw.json != nil {
+		return rw.json.Encode(rec)
+	}
+	values := make([]string, len(csvColumns))
+	for i, field := range rec.fields() {
+		values[i] = *field
+	}
+	return rw.csv.Write(values)
+}
+
+//Flush writes any buffered records
+func (rw *RecordWriter) Flush() error {
+	if rw.csv != nil {
+		rw.csv.Flush()
+		return rw.csv.Error()
+	}
+	return nil
+}
+
+//RecordFromUser returns the record exported for the user, which never
+//includes their password
+func RecordFromUser(user *users.User) *Record {
+	return &Record{
+		Email:       user.Email,
+		UserName:    user.UserName,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		Role:        user.Role,
+		DisplayName: user.DisplayName,
+		Title:       user.Title,
+	}
+}
@@ -0,0 +1,81 @@
+package bulk
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadCSV(t *testing.T) {
+	input := "userName,email,firstName,lastName,password\n" +
+		"jsm209,jsm209@uw.edu,Joshua,Maza,password\n" +
+		"too,few\n" +
+		"alice, alice@uw.edu ,Alice,,\n"
+	rows, rowErrors, err := ReadRecords(strings.NewReader(input), FormatCSV)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rows) != 2 || len(rowErrors) != 1 {
+		t.Fatalf("expected 2 rows and 1 error but got %d and %d", len(rows), len(rowErrors))
+	}
+	if rows[0].Line != 2 || rows[0].Record.UserName != "jsm209" || rows[0].Record.Password != "password" {
+		t.Errorf("incorrect first row: %+v", rows[0])
+	}
+	if rows[1].Line != 4 || rows[1].Record.Email != "alice@uw.edu" {
+		t.Errorf("expected values to be trimmed: %+v", rows[1])
+	}
+	if rowErrors[0].Row != 3 {
+		t.Errorf("expected error on row 3 but got row %d", rowErrors[0].Row)
+	}
+
+	if _, _, err := ReadRecords(strings.NewReader("email,passHash\n"), FormatCSV); err == nil {
+		t.Error("expected an error for an unknown column")
+	}
+}
+
+func TestReadJSONL(t *testing.T) {
+	input := `{"email": "jsm209@uw.edu", "userName": "jsm209", "role": "admin"}` + "\n" +
+		"\n" +
+		`{"email": "alice@uw.edu", "passHash": "nope"}` + "\n" +
+		`not json` + "\n"
+	rows, rowErrors, err := ReadRecords(strings.NewReader(input), FormatJSONL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rows) != 1 || rows[0].Record.Role != "admin" {
+		t.Errorf("incorrect rows: %+v", rows)
+	}
+	if len(rowErrors) != 2 || rowErrors[0].Row != 3 || rowErrors[1].Row != 4 {
+		t.Errorf("expected errors on rows 3 and 4 but got %+v", rowErrors)
+	}
+}
+
+func TestRecordWriterRoundTrip(t *testing.T) {
+	records := []*Record{
+		{Email: "jsm209@uw.edu", UserName: "jsm209", FirstName: "Joshua", LastName: "Maza", Role: "admin"},
+		{Email: "alice@uw.edu", UserName: "alice", Title: "Head, of \"things\""},
+	}
+	for _, format := range []string{FormatCSV, FormatJSONL} {
+		buf := bytes.Buffer{}
+		writer, err := NewRecordWriter(&buf, format)
+		if err != nil {
+			t.Fatalf("unexpected error creating %s writer: %v", format, err)
+		}
+		for _, rec := range records {
+			writer.Write(rec)
+		}
+		if err := writer.Flush(); err != nil {
+			t.Fatalf("unexpected error flushing %s: %v", format, err)
+		}
+
+		rows, rowErrors, err := ReadRecords(&buf, format)
+		if err != nil || len(rowErrors) > 0 {
+			t.Fatalf("exported %s couldn't be read back: %v %+v", format, err, rowErrors)
+		}
+		for i, row := range rows {
+			if row.Record != *records[i] {
+				t.Errorf("%s record %d didn't round trip: %+v", format, i, row.Record)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package exports
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+//Archive writes the files of a personal data export into a ZIP archive
+type Archive struct {
+	zw      *zip.Writer
+	modTime time.Time
+}
+
+//NewArchive constructs a new Archive writing to `w`
+func NewArchive(w io.Writer) *Archive {
+	return &Archive{
+		zw:      zip.NewWriter(w),
+		modTime: time.Now(),
+	}
+}
+
+//create starts a new file in the archive
+func (a *Archive) create(name string) (io.Writer, error) {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: a.modTime,
+	}
+	file, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return nil, errors.New("Problem adding " + name + " to archive: " + err.Error())
+	}
+	return file, nil
+}
+
+//AddJSON adds `v` to the archive as an indented JSON file,
+//so it's readable without any other tools
+func (a *Archive) AddJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.New("Problem during marshal of " + name + ": " + err.Error())
+	}
+	file, err := a.create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		return errors.New("Problem writing " + name + ": " + err.Error())
+	}
+	return nil
+}
+
+//AddFile adds the contents of `r` to the archive
+func (a *Archive) AddFile(name string, r io.Reader) error {
+	file, err := a.create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		return errors.New("Problem writing " + name + ": " + err.Error())
+	}
+	return nil
+}
+
+//Close finishes the archive. It doesn't close the underlying writer.
+func (a *Archive) Close() error {
+	if err := a.zw.Close(); err != nil {
+		return errors.New("Problem finishing archive: " + err.Error())
+	}
+	return nil
+}
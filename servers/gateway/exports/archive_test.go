@@ -0,0 +1,47 @@
+package exports
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	buf := bytes.Buffer{}
+	archive := NewArchive(&buf)
+	if err := archive.AddJSON("profile.json", map[string]string{"userName": "jsm209"}); err != nil {
+		t.Fatalf("error adding JSON: %v", err)
+	}
+	if err := archive.AddFile("avatar.png", strings.NewReader("image bytes")); err != nil {
+		t.Fatalf("error adding file: %v", err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("error closing archive: %v", err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("archive isn't a valid ZIP: %v", err)
+	}
+	if len(reader.File) != 2 || reader.File[0].Name != "profile.json" || reader.File[1].Name != "avatar.png" {
+		t.Fatalf("incorrect files in archive: %v", reader.File)
+	}
+
+	file, _ := reader.File[0].Open()
+	contents, _ := ioutil.ReadAll(file)
+	file.Close()
+	profile := map[string]string{}
+	if err := json.Unmarshal(contents, &profile); err != nil || profile["userName"] != "jsm209" {
+		t.Errorf("incorrect profile.json: %s", contents)
+	}
+
+	file, _ = reader.File[1].Open()
+	contents, _ = ioutil.ReadAll(file)
+	file.Close()
+	if string(contents) != "image bytes" {
+		t.Errorf("incorrect avatar.png: %s", contents)
+	}
+}
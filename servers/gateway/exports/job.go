@@ -0,0 +1,213 @@
+package exports
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/patrickmn/go-cache"
+)
+
+//Statuses an export job moves through. Jobs start out pending,
+//and end up either ready to download or failed.
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusReady   = "ready"
+	StatusFailed  = "failed"
+)
+
+//JobLifetime is how long a job, and its archive, are kept
+//after the export is requested
+const JobLifetime = 7 * 24 * time.Hour
+
+//ErrJobNotFound is returned from JobStore.Get() when the job
+//is unknown or has expired
+var ErrJobNotFound = errors.New("export job not found or expired")
+
+//Job tracks the building of one personal data export archive
+type Job struct {
+	ID              string     `json:"id"`
+	UserID          int64      `json:"userID"`
+	Status          string     `json:"status"`
+	IncludeMessages bool       `json:"includeMessages"`
+	Error           string     `json:"error,omitempty"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	CompletedAt     *time.Time `json:"completedAt,omitempty"`
+	ExpiresAt       time.Time  `json:"expiresAt"`
+	//DownloadURL is a signed, short-lived link to the archive.
+	//It isn't stored; it's filled in each time a ready job is returned.
+	DownloadURL string `json:"downloadURL,omitempty"`
+}
+
+//NewJob constructs a new pending job with a random ID
+func NewJob(userID int64, includeMessages bool) (*Job, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, errors.New("Problem generating random bytes.")
+	}
+	now := time.Now().UTC()
+	return &Job{
+		ID:              hex.EncodeToString(buf),
+		UserID:          userID,
+		Status:          StatusPending,
+		IncludeMessages: includeMessages,
+		CreatedAt:       now,
+		ExpiresAt:       now.Add(JobLifetime),
+	}, nil
+}
+
+//ArchiveKey returns the blob key the job's archive is stored under
+func (j *Job) ArchiveKey() string {
+	return fmt.Sprintf("exports/%d/%s.zip", j.UserID, j.ID)
+}
+
+//Done returns true if the job has finished, successfully or not
+func (j *Job) Done() bool {
+	return j.Status == StatusReady || j.Status == StatusFailed
+}
+
+//Finish marks the job as ready, or as failed if err is not nil
+func (j *Job) Finish(err error) {
+	now := time.Now().UTC()
+	j.CompletedAt = &now
+	if err != nil {
+		j.Status = StatusFailed
+		j.Error = err.Error()
+		return
+	}
+	j.Status = StatusReady
+}
+
+//JobStore holds export jobs until they expire, so their status
+//can be polled from any gateway instance
+type JobStore interface {
+	//Save saves the job, and makes it the user's latest job
+	Save(job *Job) error
+
+	//Get returns the job with the given ID
+	Get(id string) (*Job, error)
+
+	//Latest returns the user's most recently saved job
+	Latest(userID int64) (*Job, error)
+}
+
+//RedisJobStore is a JobStore backed by redis
+type RedisJobStore struct {
+	Client redis.UniversalClient
+}
+
+//NewRedisJobStore constructs a new RedisJobStore
+func NewRedisJobStore(client redis.UniversalClient) *RedisJobStore {
+	return &RedisJobStore{Client: client}
+}
+
+//Save saves the job, and makes it the user's latest job.
+//Both keys expire along with the job.
+func (rs *RedisJobStore) Save(job *Job) error {
+	copied := *job
+	copied.DownloadURL = ""
+	value, err := json.Marshal(&copied)
+	if err != nil {
+		return errors.New("Problem during marshal of export job.")
+	}
+	ttl := time.Until(job.ExpiresAt)
+	pipe := rs.Client.TxPipeline()
+	pipe.Set(jobRedisKey(job.ID), value, ttl)
+	pipe.Set(latestRedisKey(job.UserID), job.ID, ttl)
+	if _, err := pipe.Exec(); err != nil {
+		return errors.New("Problem adding key and value: " + err.Error())
+	}
+	return nil
+}
+
+//Get returns the job with the given ID
+func (rs *RedisJobStore) Get(id string) (*Job, error) {
+	value, err := rs.Client.Get(jobRedisKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{}
+	if err := json.Unmarshal(value, job); err != nil {
+		return nil, errors.New("Problem during unmarshal of export job.")
+	}
+	return job, nil
+}
+
+//Latest returns the user's most recently saved job
+func (rs *RedisJobStore) Latest(userID int64) (*Job, error) {
+	id, err := rs.Client.Get(latestRedisKey(userID)).Result()
+	if err == redis.Nil {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rs.Get(id)
+}
+
+//jobRedisKey returns the redis key to use for the job ID
+func jobRedisKey(id string) string {
+	return "export:" + id
+}
+
+//latestRedisKey returns the redis key holding the ID of the user's latest job
+func latestRedisKey(userID int64) string {
+	return "export:user:" + strconv.FormatInt(userID, 10)
+}
+
+//MemJobStore is an in-process JobStore.
+//This should be used only for testing and single-instance deployments.
+type MemJobStore struct {
+	entries *cache.Cache
+	mx      sync.Mutex
+}
+
+//NewMemJobStore constructs and returns a new MemJobStore
+func NewMemJobStore() *MemJobStore {
+	return &MemJobStore{
+		entries: cache.New(JobLifetime, time.Hour),
+	}
+}
+
+//Save saves the job, and makes it the user's latest job
+func (ms *MemJobStore) Save(job *Job) error {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	copied := *job
+	copied.DownloadURL = ""
+	ttl := time.Until(job.ExpiresAt)
+	ms.entries.Set(jobRedisKey(job.ID), &copied, ttl)
+	ms.entries.Set(latestRedisKey(job.UserID), job.ID, ttl)
+	return nil
+}
+
+//Get returns the job with the given ID
+func (ms *MemJobStore) Get(id string) (*Job, error) {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	job, found := ms.entries.Get(jobRedisKey(id))
+	if !found {
+		return nil, ErrJobNotFound
+	}
+	copied := *job.(*Job)
+	return &copied, nil
+}
+
+//Latest returns the user's most recently saved job
+func (ms *MemJobStore) Latest(userID int64) (*Job, error) {
+	id, found := ms.entries.Get(latestRedisKey(userID))
+	if !found {
+		return nil, ErrJobNotFound
+	}
+	return ms.Get(id.(string))
+}
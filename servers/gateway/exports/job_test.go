@@ -0,0 +1,49 @@
+package exports
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMemJobStore(t *testing.T) {
+	store := NewMemJobStore()
+	if _, err := store.Latest(1); err != ErrJobNotFound {
+		t.Errorf("incorrect error for a user without jobs: expected %v but got %v", ErrJobNotFound, err)
+	}
+
+	first, err := NewJob(1, false)
+	if err != nil {
+		t.Fatalf("unexpected error creating job: %v", err)
+	}
+	if first.Status != StatusPending || first.Done() {
+		t.Errorf("new job should be pending: %+v", first)
+	}
+	first.DownloadURL = "/v1/exports/signed"
+	store.Save(first)
+
+	got, err := store.Get(first.ID)
+	if err != nil || got.ID != first.ID {
+		t.Fatalf("error getting job: %v", err)
+	}
+	if len(got.DownloadURL) > 0 {
+		t.Errorf("download URLs shouldn't be stored")
+	}
+
+	second, _ := NewJob(1, true)
+	second.Finish(errors.New("messaging service unavailable"))
+	store.Save(second)
+	if second.Status != StatusFailed || second.CompletedAt == nil || !second.Done() {
+		t.Errorf("failed job not marked as failed: %+v", second)
+	}
+
+	latest, err := store.Latest(1)
+	if err != nil || latest.ID != second.ID {
+		t.Errorf("expected the second job to be the latest but got %+v, %v", latest, err)
+	}
+	if _, err := store.Get(first.ID); err != nil {
+		t.Errorf("earlier jobs should still be available: %v", err)
+	}
+	if _, err := store.Get("unknown"); err != ErrJobNotFound {
+		t.Errorf("incorrect error for an unknown job: expected %v but got %v", ErrJobNotFound, err)
+	}
+}
@@ -0,0 +1,147 @@
+package exports
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+)
+
+//messagePageSize is the number of messages the messaging
+//service returns for each page of a channel
+const messagePageSize = 100
+
+//maxMessagePages stops paging through a channel that
+//never runs out of older messages
+const maxMessagePages = 1000
+
+//MessageSource finds the messages a user has posted
+type MessageSource interface {
+	//Messages returns the messages posted by the user, as
+	//they were returned by the messaging service
+	Messages(user *users.User) ([]json.RawMessage, error)
+}
+
+//ProxyMessageSource fetches a user's messages from the messaging
+//service through the gateway's reverse proxy, acting as the user
+//so the service only returns channels the user can see
+type ProxyMessageSource struct {
+	Proxy http.Handler
+}
+
+//channelSummary is the part of a channel we need from the messaging service
+type channelSummary struct {
+	ID string `json:"_id"`
+}
+
+//messageSummary is the part of a message we need from the messaging service
+type messageSummary struct {
+	ID      string `json:"_id"`
+	Creator struct {
+		ID int64 `json:"id"`
+	} `json:"creator"`
+}
+
+//Messages returns the messages posted by the user in every channel they can see
+func (ps *ProxyMessageSource) Messages(user *users.User) ([]json.RawMessage, error) {
+	channels := []*channelSummary{}
+	if err := ps.get(user, "/v1/channels", &channels); err != nil {
+		return nil, err
+	}
+
+	messages := []json.RawMessage{}
+	for _, channel := range channels {
+		posted, err := ps.channelMessages(user, channel.ID)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, posted...)
+	}
+	return messages, nil
+}
+
+//channelMessages pages back through a channel, newest first,
+//keeping the messages posted by the user
+func (ps *ProxyMessageSource) channelMessages(user *users.User, channelID string) ([]json.RawMessage, error) {
+	posted := []json.RawMessage{}
+	seen := map[string]bool{}
+	before := ""
+	for page := 0; page < maxMessagePages; page++ {
+		path := "/v1/channels/" + url.PathEscape(channelID)
+		if len(before) > 0 {
+			path += "?before=" + url.QueryEscape(before)
+		}
+		raw := []json.RawMessage{}
+		if err := ps.get(user, path, &raw); err != nil {
+			return nil, err
+		}
+
+		added := 0
+		for _, message := range raw {
+			summary := messageSummary{}
+			if err := json.Unmarshal(message, &summary); err != nil {
+				return nil, errors.New("Problem during unmarshal of message: " + err.Error())
+			}
+			if seen[summary.ID] {
+				continue
+			}
+			seen[summary.ID] = true
+			added++
+			before = summary.ID
+			if summary.Creator.ID == user.ID {
+				posted = append(posted, message)
+			}
+		}
+		if len(raw) < messagePageSize || added == 0 {
+			break
+		}
+	}
+	return posted, nil
+}
+
+//get sends a GET request for the path through the proxy as the user,
+//and unmarshals the JSON response into `v`
+func (ps *ProxyMessageSource) get(user *users.User, path string, v interface{}) error {
+	userData, err := json.Marshal(user)
+	if err != nil {
+		return errors.New("Problem while marshalling user data: " + err.Error())
+	}
+	r, err := http.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("X-User", string(userData))
+
+	w := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+	ps.Proxy.ServeHTTP(w, r)
+	if w.status != http.StatusOK {
+		return fmt.Errorf("Messaging service responded to %s with status %d", path, w.status)
+	}
+	if err := json.Unmarshal(w.body.Bytes(), v); err != nil {
+		return errors.New("Problem during unmarshal of " + path + ": " + err.Error())
+	}
+	return nil
+}
+
+//bufferedResponse is an http.ResponseWriter that keeps the response in memory
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (br *bufferedResponse) Header() http.Header {
+	return br.header
+}
+
+func (br *bufferedResponse) Write(data []byte) (int, error) {
+	return br.body.Write(data)
+}
+
+func (br *bufferedResponse) WriteHeader(status int) {
+	br.status = status
+}
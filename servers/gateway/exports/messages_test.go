@@ -0,0 +1,77 @@
+package exports
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+)
+
+//fakeMessaging serves one public channel with 250 messages,
+//every third of which was posted by user 1
+func fakeMessaging() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := &users.User{}
+		if err := json.Unmarshal([]byte(r.Header.Get("X-User")), user); err != nil || user.ID != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/channels":
+			fmt.Fprint(w, `[{"_id": "general"}]`)
+		case "/v1/channels/general":
+			// newest first, older than `before`
+			newest := 250
+			if before := r.URL.Query().Get("before"); len(before) > 0 {
+				newest, _ = strconv.Atoi(before)
+				newest--
+			}
+			page := []map[string]interface{}{}
+			for id := newest; id > 0 && len(page) < messagePageSize; id-- {
+				page = append(page, map[string]interface{}{
+					"_id":     strconv.Itoa(id),
+					"body":    "message " + strconv.Itoa(id),
+					"creator": map[string]interface{}{"id": id%3 + 1},
+				})
+			}
+			json.NewEncoder(w).Encode(page)
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+func TestProxyMessageSource(t *testing.T) {
+	source := &ProxyMessageSource{Proxy: fakeMessaging()}
+	messages, err := source.Messages(&users.User{ID: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// ids 3, 6, ... 249
+	if len(messages) != 83 {
+		t.Errorf("expected 83 messages but got %d", len(messages))
+	}
+	for _, message := range messages {
+		summary := messageSummary{}
+		json.Unmarshal(message, &summary)
+		if summary.Creator.ID != 1 {
+			t.Errorf("exported a message posted by someone else: %s", message)
+		}
+	}
+
+	if _, err := source.Messages(&users.User{ID: 2}); err == nil {
+		t.Errorf("expected an error when the messaging service refuses the request")
+	}
+
+	// a proxy that can't reach the service fails the export
+	source.Proxy = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	if _, err := source.Messages(&users.User{ID: 1}); err == nil {
+		t.Errorf("expected an error when the messaging service is down")
+	}
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+)
+
+// restorePathSuffix is added to a user's admin path to restore them
+const restorePathSuffix = "/restore"
+
+// defaultPerPage and maxPerPage bound the page size of the admin user list
+const defaultPerPage = 50
+const maxPerPage = 200
+
+// UserPage is a page of users returned from the admin user list
+type UserPage struct {
+	Users   []*users.User `json:"users"`
+	Page    int           `json:"page"`
+	PerPage int           `json:"perPage"`
+	Total   int           `json:"total"`
+}
+
+// AdminUpdates represents changes staff can make to another user's account
+type AdminUpdates struct {
+	Role     *string `json:"role"`
+	Disabled *bool   `json:"disabled"`
+}
+
+// AdminUsersHandler handles requests for /v1/admin/users.
+// GET lists all users, one page at a time, using the
+// `page` and `perPage` query string parameters.
+func (h *HandlerContext) AdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requirePermission(w, r, users.PermListUsers); !ok {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("perPage"))
+	if err != nil || perPage < 1 {
+		perPage = defaultPerPage
+	}
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+
+	list, total, err := h.UserStore.ListUsers((page-1)*perPage, perPage)
+	if err != nil {
+		http.Error(w, "Failed to list users: "+err.Error(), 500)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &UserPage{
+		Users:   list,
+		Page:    page,
+		PerPage: perPage,
+		Total:   total,
+	})
+}
+
+// AdminSpecificUserHandler handles requests for /v1/admin/users/{id}.
+// PATCH changes the user's role (admins only) and/or disables or
+// re-enables their account. Disabling a user ends their sessions.
+// DELETE soft-deletes the user (admins only); see AdminRestoreUserHandler.
+func (h *HandlerContext) AdminSpecificUserHandler(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, restorePathSuffix) {
+		h.AdminRestoreUserHandler(w, r)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		h.adminDeleteUser(w, r)
+		return
+	}
+
+	sessionState, ok := h.requirePermission(w, r, users.PermDisableUsers)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	staff := &sessionState.User
+
+	userID, err := strconv.ParseInt(path.Base(r.URL.Path), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID.", http.StatusBadRequest)
+		return
+	}
+	if userID == staff.ID {
+		http.Error(w, "You can't change your own role or disable yourself.", http.StatusForbidden)
+		return
+	}
+
+	var updates AdminUpdates
+	if err := readJSON(r, &updates); err != nil {
+		readJSONError(w, err)
+		return
+	}
+
+	target, err := h.UserStore.GetByID(userID)
+	if err != nil {
+		http.Error(w, "User with that ID cannot be found: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// only admins can manage other staff, or change roles at all
+	if (target.IsStaff() || updates.Role != nil) && !staff.Can(users.PermChangeRoles) {
+		http.Error(w, "You don't have permission to do that.", http.StatusForbidden)
+		return
+	}
+
+	if updates.Role != nil {
+		if !users.ValidRole(*updates.Role) {
+			http.Error(w, "Unknown role: "+*updates.Role, http.StatusBadRequest)
+			return
+		}
+		if target, err = h.UserStore.SetRole(userID, *updates.Role); err != nil {
+			http.Error(w, "Failed to change role: "+err.Error(), 500)
+			return
+		}
+	}
+
+	if updates.Disabled != nil {
+		if target, err = h.UserStore.SetDisabled(userID, *updates.Disabled); err != nil {
+			http.Error(w, "Failed to update account: "+err.Error(), 500)
+			return
+		}
+		// disabled users are signed out everywhere
+		if *updates.Disabled {
+			if err := h.endUserSessions(userID); err != nil {
+				http.Error(w, "Failed to end the user's sessions: "+err.Error(), 500)
+				return
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, target)
+}
+
+// adminDeleteUser handles DELETE requests for /v1/admin/users/{id},
+// soft-deleting the user. Their sessions stop working right away, and
+// they can be restored until users.DeletedUserRetention has passed.
+func (h *HandlerContext) adminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	sessionState, ok := h.requirePermission(w, r, users.PermDeleteUsers)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.ParseInt(path.Base(r.URL.Path), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID.", http.StatusBadRequest)
+		return
+	}
+	if userID == sessionState.User.ID {
+		http.Error(w, "You can't delete yourself.", http.StatusForbidden)
+		return
+	}
+
+	if err := h.UserStore.Delete(userID); err != nil {
+		if err == users.ErrUserNotFound {
+			http.Error(w, "User with that ID cannot be found.", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete user: "+err.Error(), 500)
+		return
+	}
+	if err := h.endUserSessions(userID); err != nil {
+		http.Error(w, "Failed to end the user's sessions: "+err.Error(), 500)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminRestoreUserHandler handles POST requests for
+// /v1/admin/users/{id}/restore, undoing the deletion of a user
+// within users.DeletedUserRetention. Admins only.
+func (h *HandlerContext) AdminRestoreUserHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requirePermission(w, r, users.PermDeleteUsers); !ok {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := strconv.ParseInt(path.Base(strings.TrimSuffix(r.URL.Path, restorePathSuffix)), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID.", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.UserStore.Restore(userID)
+	if err == users.ErrUserNotFound {
+		http.Error(w, "User with that ID cannot be found.", http.StatusNotFound)
+		return
+	}
+	if err == users.ErrUserNotDeleted || err == users.ErrEmailTaken {
+		http.Error(w, "Can't restore user: "+err.Error(), http.StatusConflict)
+		return
+	}
+	if err == users.ErrRestoreWindowPassed {
+		http.Error(w, "Can't restore user: "+err.Error(), http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to restore user: "+err.Error(), 500)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, user)
+}
+
+// AdminCacheStatsHandler handles GET requests for /v1/admin/cache,
+// returning how many user lookups the cache has answered on this
+// gateway since it started, and how many went to the database
+func (h *HandlerContext) AdminCacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requirePermission(w, r, users.PermListUsers); !ok {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.UserStore.Stats())
+}
@@ -1,12 +1,104 @@
 package handlers
 
-//TODO: define HTTP handler functions as described in the
-//assignment description. Remember to use your handler context
-//struct as the receiver on these functions so that you have
-//access to things like the session store and user store.
+import (
+	"errors"
+	"net/http"
+	"strings"
 
-func main() {
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/sessions"
+)
 
+// schemeToken is the Authorization scheme for personal access tokens
+const schemeToken = "Token "
 
-	
-}
\ No newline at end of file
+// ErrMFAPending is returned when a session has passed the password check
+// but still needs a two-factor code before it can be used
+var ErrMFAPending = errors.New("two-factor authentication required")
+
+// getSessionState gets the session ID from the request and populates
+// `state` with the session state, whether or not the session is fully signed in
+func (h *HandlerContext) getSessionState(r *http.Request, state *SessionState) (sessions.SessionID, error) {
+	sid, err := h.Keyring.GetState(r, h.SessionStore, state)
+	if err == sessions.ErrSessionExpired {
+		h.publishSessionEvent(sessions.EventExpired, sid)
+		return sessions.InvalidSessionID, err
+	}
+	return sid, err
+}
+
+// authenticate gets the session ID and state for the request,
+// returning an error if there's no valid session or if the session
+// still needs to finish two-factor authentication.
+// The user in the state is refreshed from the store so role changes
+// apply right away, and sessions of disabled users are ended.
+// The response tells the client when the session will expire.
+// Access tokens are accepted too, with an invalid session ID since
+// they don't belong to a session.
+func (h *HandlerContext) authenticate(w http.ResponseWriter, r *http.Request) (sessions.SessionID, *SessionState, error) {
+	if bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); h.Tokens != nil && sessions.IsAccessToken(bearer) {
+		state, err := h.accessTokenState(bearer)
+		return sessions.InvalidSessionID, state, err
+	}
+
+	state := &SessionState{}
+	sid, err := h.getSessionState(r, state)
+	if err != nil {
+		return sessions.InvalidSessionID, nil, err
+	}
+	if state.MFAPending {
+		return sessions.InvalidSessionID, nil, ErrMFAPending
+	}
+
+	user, err := h.UserStore.GetByID(state.User.ID)
+	if err != nil {
+		return sessions.InvalidSessionID, nil, err
+	}
+	if user.Disabled {
+		h.endSession(sid, sessions.EventRevoked)
+		return sessions.InvalidSessionID, nil, users.ErrUserDisabled
+	}
+	state.User = *user
+	// sessions from before metadata was tracked don't have any to update
+	h.SessionStore.Touch(sid, clientIP(r))
+	sessions.WriteExpiry(h.SessionStore, sid, w)
+	return sid, state, nil
+}
+
+// requirePermission authenticates the request and checks that the user's
+// role grants the permission. If not, it writes the error response
+// and returns false.
+func (h *HandlerContext) requirePermission(w http.ResponseWriter, r *http.Request, perm users.Permission) (*SessionState, bool) {
+	_, sessionState, err := h.authenticate(w, r)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+	if !sessionState.User.Can(perm) {
+		http.Error(w, "You don't have permission to do that.", http.StatusForbidden)
+		return nil, false
+	}
+	return sessionState, true
+}
+
+// authenticateToken resolves a personal access token sent as
+// "Authorization: Token <token>" to the token and the user who owns it.
+// It returns a nil token and user if the request doesn't use that scheme.
+func (h *HandlerContext) authenticateToken(r *http.Request) (*users.AccessToken, *users.User, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, schemeToken) {
+		return nil, nil, nil
+	}
+	token, err := h.UserStore.GetAccessTokenByToken(strings.TrimPrefix(header, schemeToken))
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := h.UserStore.GetByID(token.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.Disabled {
+		return nil, nil, users.ErrUserDisabled
+	}
+	return token, user, nil
+}
@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/avatars"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/blobs"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+)
+
+// avatarPathPrefix is the path uploaded avatars are served from, as
+// /v1/avatars/{userID}/{version}/{size}.png. Each upload gets a new
+// version so the images can be cached forever.
+const avatarPathPrefix = "/v1/avatars/"
+
+// avatarKey returns the blob key for one size of an uploaded avatar
+func avatarKey(userID int64, version string, size int) string {
+	return fmt.Sprintf("avatars/%d/%s/%d.png", userID, version, size)
+}
+
+// avatarURL returns the URL of one size of an uploaded avatar
+func avatarURL(userID int64, version string, size int) string {
+	return fmt.Sprintf("%s%d/%s/%d.png", avatarPathPrefix, userID, version, size)
+}
+
+// uploadedSize returns the smallest uploaded size that is at least
+// the requested size, or the largest if none are big enough
+func uploadedSize(size int) int {
+	for _, s := range avatars.Sizes {
+		if s >= size {
+			return s
+		}
+	}
+	return avatars.Sizes[len(avatars.Sizes)-1]
+}
+
+// parseAvatarURL returns the version of an uploaded avatar
+// from its PhotoURL, or an empty string if it isn't one
+func parseAvatarURL(userID int64, photoURL string) string {
+	prefix := fmt.Sprintf("%s%d/", avatarPathPrefix, userID)
+	if !strings.HasPrefix(photoURL, prefix) {
+		return ""
+	}
+	parts := strings.Split(strings.TrimPrefix(photoURL, prefix), "/")
+	if len(parts) != 2 {
+		return ""
+	}
+	return parts[0]
+}
+
+// AvatarUploadHandler handles requests for /v1/users/me/avatar.
+// PUT takes a PNG, JPEG or GIF image as the request body, resizes
+// it, stores it, and points the user's PhotoURL at it.
+func (h *HandlerContext) AvatarUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, sessionState, err := h.authenticate(w, r)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	user := &sessionState.User
+
+	// read one byte past the limit so we can tell when it's over
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, avatars.MaxUploadBytes+1))
+	defer r.Body.Close()
+	if err != nil {
+		http.Error(w, "Failed to properly read request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resized, err := avatars.Process(body)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err == avatars.ErrTooLarge {
+			status = http.StatusRequestEntityTooLarge
+		} else if err == avatars.ErrUnsupportedType {
+			status = http.StatusUnsupportedMediaType
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, "Problem generating avatar version.", 500)
+		return
+	}
+	version := hex.EncodeToString(buf)
+
+	for size, data := range resized {
+		if err := h.Avatars.Put(avatarKey(user.ID, version, size), bytes.NewReader(data), "image/png"); err != nil {
+			http.Error(w, "Failed to store avatar: "+err.Error(), 500)
+			return
+		}
+	}
+
+	oldPhotoURL := user.PhotoURL
+	user.AvatarSource = users.AvatarUpload
+	user.PhotoURL = avatarURL(user.ID, version, avatars.Sizes[len(avatars.Sizes)-1])
+	if err := h.UserStore.SetAvatar(user.ID, user.AvatarSource, user.PhotoURL); err != nil {
+		http.Error(w, "Failed to update user: "+err.Error(), 500)
+		return
+	}
+
+	// the old avatar's URLs won't be handed out any more
+	h.deleteUploadedAvatar(user.ID, oldPhotoURL)
+
+	writeJSON(w, http.StatusOK, user)
+}
+
+// deleteUploadedAvatar deletes every size of the uploaded avatar
+// at the PhotoURL, if it is one
+func (h *HandlerContext) deleteUploadedAvatar(userID int64, photoURL string) {
+	version := parseAvatarURL(userID, photoURL)
+	if len(version) == 0 {
+		return
+	}
+	for _, size := range avatars.Sizes {
+		h.Avatars.Delete(avatarKey(userID, version, size))
+	}
+}
+
+// AvatarPreference is the body of a request to change where
+// the user's avatar comes from
+type AvatarPreference struct {
+	Source string `json:"source"`
+}
+
+// AvatarSourceHandler handles requests for /v1/users/me/avatar/source.
+// PUT sets where the user's avatar comes from: gravatar, identicon,
+// initials, or empty for the server's default. To use an uploaded
+// image, upload one to /v1/users/me/avatar instead.
+func (h *HandlerContext) AvatarSourceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, sessionState, err := h.authenticate(w, r)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	user := &sessionState.User
+
+	preference := AvatarPreference{}
+	if err := readJSON(r, &preference); err != nil {
+		readJSONError(w, err)
+		return
+	}
+	if !users.ValidAvatarSource(preference.Source) {
+		http.Error(w, users.ErrInvalidAvatarSource.Error(), http.StatusBadRequest)
+		return
+	}
+	if preference.Source == users.AvatarUpload && user.AvatarSource != users.AvatarUpload {
+		http.Error(w, "Upload an image to /v1/users/me/avatar to use it as your avatar.", http.StatusBadRequest)
+		return
+	}
+	if preference.Source == user.AvatarSource {
+		writeJSON(w, http.StatusOK, user)
+		return
+	}
+
+	oldPhotoURL := user.PhotoURL
+	user.AvatarSource = preference.Source
+	user.PhotoURL = users.AvatarPath(user.ID)
+	if err := h.UserStore.SetAvatar(user.ID, user.AvatarSource, user.PhotoURL); err != nil {
+		http.Error(w, "Failed to update user: "+err.Error(), 500)
+		return
+	}
+	h.deleteUploadedAvatar(user.ID, oldPhotoURL)
+
+	writeJSON(w, http.StatusOK, user)
+}
+
+// setDefaultAvatar points a newly created user's PhotoURL at the
+// avatar endpoint, so it follows the server's configured default
+// rather than always linking to Gravatar
+func (h *HandlerContext) setDefaultAvatar(user *users.User) error {
+	user.AvatarSource = users.AvatarDefault
+	user.PhotoURL = users.AvatarPath(user.ID)
+	return h.UserStore.SetAvatar(user.ID, user.AvatarSource, user.PhotoURL)
+}
+
+// UserAvatarHandler handles requests for /v1/users/{id}/avatar, which
+// serves the user's avatar from their preferred source, or the server's
+// default. Gravatar and uploaded images are redirected to, while
+// identicons and initials are generated here, as a PNG or, with
+// ?format=svg, an SVG. The optional ?size= is the size in pixels.
+// These are public, so they can be used in <img> tags.
+func (h *HandlerContext) UserAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idString := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/users/"), "/avatar")
+	userID, err := strconv.ParseInt(idString, 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	size := avatars.Sizes[len(avatars.Sizes)-1]
+	if sizeString := r.URL.Query().Get("size"); len(sizeString) > 0 {
+		if size, err = strconv.Atoi(sizeString); err != nil {
+			http.Error(w, "size must be a number of pixels", http.StatusBadRequest)
+			return
+		}
+	}
+	size = avatars.ClampSize(size)
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 {
+		format = "png"
+	}
+	if format != "png" && format != "svg" {
+		http.Error(w, "format must be png or svg", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.UserStore.GetByID(userID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	source := user.AvatarSource
+	if source == users.AvatarUpload {
+		if version := parseAvatarURL(user.ID, user.PhotoURL); len(version) > 0 {
+			w.Header().Set("Cache-Control", "public, max-age=300")
+			http.Redirect(w, r, avatarURL(user.ID, version, uploadedSize(size)), http.StatusFound)
+			return
+		}
+		source = users.AvatarDefault
+	}
+	if source == users.AvatarDefault {
+		source = h.AvatarDefault
+	}
+
+	if source != users.AvatarIdenticon && source != users.AvatarInitials {
+		// Gravatar falls back to its own identicon for unknown emails
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		http.Redirect(w, r, fmt.Sprintf("%s?s=%d&d=identicon", users.GravatarURL(user.Email), size), http.StatusFound)
+		return
+	}
+
+	generated := avatars.Generated{
+		Style:    source,
+		Seed:     strconv.FormatInt(user.ID, 10),
+		Initials: avatars.InitialsOf(user.FirstName, user.LastName),
+	}
+	if len(generated.Initials) == 0 {
+		generated.Initials = avatars.InitialsOf(user.UserName)
+	}
+
+	// the avatar changes when the user's name or preference does
+	sum := sha256.Sum256([]byte(generated.Style + "\x00" + generated.Seed + "\x00" + generated.Initials))
+	etag := fmt.Sprintf(`"%s-%d.%s"`, hex.EncodeToString(sum[:8]), size, format)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	var data []byte
+	if format == "svg" {
+		data = generated.SVG(size)
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	} else {
+		if data, err = generated.PNG(size); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+// AvatarHandler handles requests for /v1/avatars/{userID}/{version}/{size}.png.
+// These are public, so they can be used in <img> tags, and never change.
+func (h *HandlerContext) AvatarHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, avatarPathPrefix), "/")
+	if len(parts) != 3 || !strings.HasSuffix(parts[2], ".png") {
+		http.NotFound(w, r)
+		return
+	}
+	userID, err1 := strconv.ParseInt(parts[0], 10, 64)
+	size, err2 := strconv.Atoi(strings.TrimSuffix(parts[2], ".png"))
+	if err1 != nil || err2 != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	etag := `"` + parts[1] + "-" + strconv.Itoa(size) + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	blob, info, err := h.Avatars.Get(avatarKey(userID, parts[1], size))
+	if err != nil {
+		if errors.Is(err, blobs.ErrBlobNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Failed to get avatar: "+err.Error(), 500)
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		io.Copy(w, blob)
+	}
+}
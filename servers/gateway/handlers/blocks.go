@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+)
+
+// blocksPath is the path of the signed in user's block list,
+// with each blocked user at blocksPath/{id}
+const blocksPath = "/v1/users/me/blocks"
+
+// BlocksHandler handles GET requests for /v1/users/me/blocks,
+// listing the users the signed in user has blocked
+func (h *HandlerContext) BlocksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, sessionState, err := h.authenticate(w, r)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	blockedIDs, err := h.UserStore.GetBlocked(sessionState.User.ID)
+	if err != nil {
+		http.Error(w, "Failed to get blocked users: "+err.Error(), 500)
+		return
+	}
+	blocked := []*users.User{}
+	for _, id := range blockedIDs {
+		// users who have since been deleted are skipped
+		if user, err := h.UserStore.GetByID(id); err == nil {
+			blocked = append(blocked, user)
+		}
+	}
+	writeJSON(w, http.StatusOK, blocked)
+}
+
+// SpecificBlockHandler handles requests for /v1/users/me/blocks/{id}.
+// POST blocks the user, hiding them from the signed in user's search
+// results and stopping their messages from being pushed to the signed
+// in user's websocket. DELETE unblocks them.
+func (h *HandlerContext) SpecificBlockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, sessionState, err := h.authenticate(w, r)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	userID := sessionState.User.ID
+
+	blockedID, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, blocksPath+"/"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID.", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if blockedID == userID {
+			http.Error(w, users.ErrCannotBlockSelf.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := h.UserStore.GetByID(blockedID); err != nil {
+			http.Error(w, "User with that ID cannot be found.", http.StatusNotFound)
+			return
+		}
+		if err := h.UserStore.Block(userID, blockedID); err != nil {
+			http.Error(w, "Failed to block user: "+err.Error(), 500)
+			return
+		}
+	} else if err := h.UserStore.Unblock(userID, blockedID); err != nil {
+		http.Error(w, "Failed to unblock user: "+err.Error(), 500)
+		return
+	}
+
+	if h.Sockets != nil {
+		h.Sockets.SetBlocked(userID, blockedID, r.Method == http.MethodPost)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -4,13 +4,15 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
-	"path"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/blobs"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/exports"
 	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/oidc"
 	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/sessions"
 )
 
@@ -22,32 +24,60 @@ import (
 //and the user store
 
 type HandlerContext struct {
-	Key          string
+	// Keyring signs and validates session IDs, and keys derived
+	// from it sign everything else, such as export download links
+	Keyring *sessions.Keyring
+	// Transport sends new session IDs in a header, a cookie or both
+	Transport *sessions.Transport
+	// AllowedOrigins may send credentials such as the session cookie,
+	// and open websockets with it
+	AllowedOrigins []string
+	// Tokens issues access and refresh tokens, if they're enabled
+	Tokens       *sessions.TokenService
 	SessionStore sessions.Store
-	UserStore    *users.SQLStore
+	UserStore    *users.CachedStore
+	// Tells every gateway when sessions end, so they
+	// close the websockets opened with them
+	SessionEvents sessions.Events
+	// Issuer shown in authenticator apps for two-factor enrollment
+	MFAIssuer string
+	// OpenID Connect providers users can sign in with, keyed by name
+	OIDCProviders map[string]*oidc.Provider
+	// Sign-in flows waiting for the provider's callback
+	OIDCFlows oidc.FlowStore
+	// Where uploaded avatar images are stored
+	Avatars blobs.Store
+	// Avatar source for users who haven't picked one:
+	// gravatar, identicon or initials
+	AvatarDefault string
+	// Personal data export jobs, and where their archives are stored
+	Exports        exports.JobStore
+	ExportArchives blobs.Store
+	// Where exports find the user's messages, or nil if they can't
+	Messages exports.MessageSource
+	// Websocket connections to this gateway, whose cached
+	// block lists are updated when users block each other,
+	// along with the presence of the users they belong to
+	Sockets *SocketStore
 }
 
 func (h *HandlerContext) Search(w http.ResponseWriter, r *http.Request) {
 	// first check if the user is authenticated
-	// 1) get sessionID from token
-	token := r.Header.Get("Authorization")
-	validSessionID, err5 := sessions.ValidateID(token, h.Key)
-	if err5 != nil {
-		http.Error(w, "Failed to validate authorization token: "+err5.Error(), 500)
+	_, sessionState, err4 := h.authenticate(w, r)
+	if err4 != nil {
+		http.Error(w, "You're not authorized to do that: "+err4.Error(), http.StatusUnauthorized)
+		return
 	}
 
-	// 2) get the current session state by getting the sessionState corresponding to our sessionID
-	sessionState := SessionState{}
-	err10 := h.SessionStore.Get(validSessionID, sessionState)
-	if err10 != nil {
-		http.Error(w, "Problem with getting the current session: "+err10.Error(), 500)
+	// users the searcher has blocked are left out of the results
+	blockedIDs, err := h.UserStore.GetBlocked(sessionState.User.ID)
+	if err != nil {
+		http.Error(w, "Failed to get blocked users: "+err.Error(), 500)
+		return
 	}
-
-	// 3) ask redisStore if that session is still valid.
-	// if invalid, err != nil
-	_, err4 := sessions.GetState(r, h.Key, h.SessionStore, sessionState)
-	if err4 != nil {
-		http.Error(w, "You're not authorized to do that: "+err4.Error(), http.StatusUnauthorized)
+	blocked := map[int64]bool{}
+	for _, id := range blockedIDs {
+		blocked[id] = true
 	}
 
 	// check that the query parameter "q" is not empty
@@ -56,15 +86,27 @@ func (h *HandlerContext) Search(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Query parameter cannot be empty.", http.StatusBadRequest)
 	}
 
-	// Get first 20 UserIDs
-	searchedIDs := h.UserStore.Query(strings.Join(query, ""), 20)
-
-	// Fetch profiles
+	// Fetch the profiles of the first 20 users. Blocked users are skipped,
+	// and so are disabled ones other gateways haven't taken out of their
+	// tries yet, so the trie is asked for more until there are 20 or it
+	// runs out. Its order isn't stable, so users already checked are skipped.
+	prefix := strings.Join(query, "")
 	fetchedUsers := []*users.User{} // ?
-	for _, element := range searchedIDs {
-		user, err := h.UserStore.GetByID(element)
-		if err == nil {
-			fetchedUsers = append(fetchedUsers, user)
+	checked := map[int64]bool{}
+	for max := 20 + len(blocked); len(fetchedUsers) < 20; max *= 2 {
+		searchedIDs := h.UserStore.Query(prefix, max)
+		for _, element := range searchedIDs {
+			if checked[element] || blocked[element] || len(fetchedUsers) == 20 {
+				continue
+			}
+			checked[element] = true
+			user, err := h.UserStore.GetByID(element)
+			if err == nil && !user.Disabled {
+				fetchedUsers = append(fetchedUsers, user)
+			}
+		}
+		if len(searchedIDs) < max {
+			break
 		}
 	}
 
@@ -86,6 +128,15 @@ func (h *HandlerContext) Search(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *HandlerContext) UsersHandler(w http.ResponseWriter, r *http.Request) {
+	// the mux ignores query strings, so searches arrive here
+	if r.Method == http.MethodGet && len(r.URL.Query().Get("q")) > 0 {
+		h.Search(w, r)
+		return
+	}
+	if r.Method == http.MethodGet {
+		h.DirectoryHandler(w, r)
+		return
+	}
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
@@ -128,28 +179,27 @@ func (h *HandlerContext) UsersHandler(w http.ResponseWriter, r *http.Request) {
 	// Add user's username, firstname, lastname, to the trie
 	h.UserStore.AddUserToTrie(insertedUser)
 
+	// serve the user's avatar from the configured default source
+	if err := h.setDefaultAvatar(insertedUser); err != nil {
+		http.Error(w, "Failed to set the user's avatar: "+err.Error(), 500)
+		return
+	}
+
 	// make a new sessionState for the valid user
 	newSessionState := SessionState{
 		Curtime: time.Now(),
 		User:    *insertedUser,
 	}
 
-	// make a new sessionID for valid user
-	mySessionID, err8 := sessions.NewSessionID(h.Key)
-	if err8 != nil {
-		http.Error(w, "Failed to create a new sessionID: "+err8.Error(), 500)
-	}
-
 	// begin new session for user
-	err6 := h.SessionStore.Save(mySessionID, newSessionState)
-	if err6 != nil {
-		http.Error(w, "Failed to save a new session for the user: "+err6.Error(), 500)
+	if _, err := h.beginSession(w, r, newSessionState); err != nil {
+		http.Error(w, "Failed to save a new session for the user: "+err.Error(), 500)
+		return
 	}
 
 	// if all is well up to this point,
 	// respond to the client
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cookie", mySessionID.String())
 	w.WriteHeader(http.StatusCreated)
 	myjson, err7 := json.Marshal(insertedUser)
 	if err7 != nil {
@@ -159,27 +209,17 @@ func (h *HandlerContext) UsersHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *HandlerContext) SpecificUserHandler(w http.ResponseWriter, r *http.Request) {
-	// first check if the user is authenticated
-
-	// 1) get sessionID from token
-	token := r.Header.Get("Authorization")
-	validSessionID, err := sessions.ValidateID(token, h.Key)
-	if err != nil {
-		http.Error(w, "Failed to validate authorization token: "+err.Error(), 500)
-	}
-
-	// 2) get the current session state by getting the sessionState corresponding to our sessionID
-	sessionState := SessionState{}
-	err10 := h.SessionStore.Get(validSessionID, sessionState)
-	if err10 != nil {
-		http.Error(w, "Problem with getting the current session: "+err10.Error(), 500)
+	// avatars are public, so they're served before checking authentication
+	if strings.HasSuffix(r.URL.Path, "/avatar") {
+		h.UserAvatarHandler(w, r)
+		return
 	}
 
-	// 3) ask redisStore if that session is still valid.
-	// if invalid, err != nil
-	_, err4 := sessions.GetState(r, h.Key, h.SessionStore, sessionState)
-	if err4 != nil {
-		http.Error(w, "You're not authorized to do that: "+err4.Error(), http.StatusUnauthorized)
+	// first check if the user is authenticated
+	_, sessionState, err := h.authenticate(w, r)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
 	}
 
 	if r.Method == http.MethodGet {
@@ -274,6 +314,10 @@ func (h *HandlerContext) SpecificUserHandler(w http.ResponseWriter, r *http.Requ
 }
 
 func (h *HandlerContext) SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		h.listSessions(w, r)
+		return
+	}
 	if r.Method == http.MethodPost {
 		if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
 			w.WriteHeader(http.StatusUnsupportedMediaType)
@@ -294,12 +338,14 @@ func (h *HandlerContext) SessionsHandler(w http.ResponseWriter, r *http.Request)
 			http.Error(w, "Failed to unmarshal request body into a struct: "+err2.Error(), 500)
 		}
 
-		// get the user from the store by email
-		user, err3 := h.UserStore.GetByEmail(userCredentials.Email)
-		if err3 != nil {
+		// get the user from the database by email, since
+		// password hashes aren't cached
+		user, err3 := h.UserStore.SQLStore.GetByEmail(userCredentials.Email)
+		if err3 != nil || user == nil {
 			// pretend to validate, then return an error.
 			time.Sleep(1 * time.Second)
 			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			return
 		}
 
 		// authenticate the user
@@ -307,25 +353,41 @@ func (h *HandlerContext) SessionsHandler(w http.ResponseWriter, r *http.Request)
 		if err4 != nil {
 			// incorrect password
 			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			return
+		}
+
+		// disabled users can't sign in
+		if user.Disabled {
+			http.Error(w, users.ErrUserDisabled.Error(), http.StatusForbidden)
+			return
+		}
+
+		// check whether the user also needs to supply a two-factor code
+		mfa, err7 := h.UserStore.GetMFA(user.ID)
+		if err7 != nil {
+			http.Error(w, "Failed to get two-factor settings: "+err7.Error(), 500)
+			return
 		}
 
 		// authorized, so we begin a new session.
 		// make a new sessionState for the valid user
 		newSessionState := SessionState{
-			Curtime: time.Now(),
-			User:    *user,
-		}
-
-		// make a new sessionID for valid user
-		mySessionID, err8 := sessions.NewSessionID(h.Key)
-		if err8 != nil {
-			http.Error(w, "Failed to create a new sessionID: "+err8.Error(), 500)
+			Curtime:    time.Now(),
+			User:       *user,
+			MFAPending: mfa.Enabled,
 		}
 
 		// begin new session for user
-		err5 := h.SessionStore.Save(mySessionID, newSessionState)
+		_, err5 := h.beginSession(w, r, newSessionState)
 		if err5 != nil {
-			http.Error(w, "Failed to save a new session for the user: "+err5.Error(), 500)
+			http.Error(w, "Failed to begin a new session for the user: "+err5.Error(), 500)
+			return
+		}
+
+		// the session can't be used until the code is sent to /v1/sessions/mfa
+		if mfa.Enabled {
+			writeJSON(w, http.StatusAccepted, map[string]bool{"mfaRequired": true})
+			return
 		}
 
 		// if all is well up to this point,
@@ -342,45 +404,4 @@ func (h *HandlerContext) SessionsHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-func (h *HandlerContext) SpecificSessionHandler(w http.ResponseWriter, r *http.Request) {
-	// first check if the user is authenticated
-
-	// 1) get sessionID from token
-	token := r.Header.Get("Authorization")
-	validSessionID, err5 := sessions.ValidateID(token, h.Key)
-	if err5 != nil {
-		http.Error(w, "Failed to validate authorization token: "+err5.Error(), 500)
-	}
-
-	// 2) get the current session state by getting the sessionState corresponding to our sessionID
-	sessionState := SessionState{}
-	err10 := h.SessionStore.Get(validSessionID, sessionState)
-	if err10 != nil {
-		http.Error(w, "Problem with getting the current session: "+err10.Error(), 500)
-	}
-
-	// 3) ask redisStore if that session is still valid.
-	// if invalid, err != nil
-	sessionID, err4 := sessions.GetState(r, h.Key, h.SessionStore, sessionState)
-	if err4 != nil {
-		http.Error(w, "You're not authorized to do that: "+err4.Error(), http.StatusUnauthorized)
-	}
-
-	if r.Method == http.MethodDelete {
-		if path.Base(r.URL.Path) != "mine" {
-			http.Error(w, "You can't do that.", http.StatusForbidden)
-		}
-		// deletes the session
-		err := h.SessionStore.Delete(sessionID)
-		if err != nil {
-			http.Error(w, "Something went wrong while deleting the session: "+err.Error(), 500)
-		}
-
-		w.Header().Set("Content-Type", "text/plain")
-		w.Write([]byte("signed out"))
-	} else {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
-}
-
 // Write a function later to authenticate tokens
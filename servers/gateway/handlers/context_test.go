@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/indexes"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/sessions"
+)
+
+func TestSearchSkipsHiddenUsers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	keyring := sessions.SingleKey("signing key")
+	store := sessions.NewMemStore(time.Hour, time.Minute)
+	trie := indexes.NewTrieNode()
+	h := &HandlerContext{
+		Keyring:      keyring,
+		SessionStore: store,
+		UserStore:    users.NewCachedStore(users.NewSQLStore(db, trie), users.NewMemUserCache(time.Minute, 100)),
+	}
+	sid, _ := keyring.NewSessionID()
+	store.Save(sid, &SessionState{User: users.User{ID: 1}})
+
+	// users 2 to 11 were disabled through another gateway, so they're
+	// still in this one's trie, and the searcher has blocked user 12
+	mock.MatchExpectationsInOrder(false)
+	columns := []string{"id", "Email", "PassHash", "UserName", "FirstName", "LastName", "PhotoURL", "Role", "Disabled",
+		"AvatarSource", "DisplayName", "Bio", "Title", "Pronouns", "Timezone", "StatusText", "EmailVerified", "CreatedAt"}
+	for id := int64(1); id <= 36; id++ {
+		userName := fmt.Sprintf("ann%02d", id)
+		if id > 1 {
+			trie.Add(userName, id)
+		}
+		mock.ExpectQuery(regexp.QuoteMeta(" from USERS where id = ?")).WithArgs(id).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(id, "", []byte{}, userName, "", "", "", users.RoleUser, id >= 2 && id <= 11,
+				"", "", "", "", "", "", "", false, time.Now()))
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("select BlockedID from BLOCKS where BlockerID = ?")).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"BlockedID"}).AddRow(12))
+
+	r := httptest.NewRequest("GET", "/v1/users?q=ann", nil)
+	r.Header.Set("Authorization", "Bearer "+sid.String())
+	w := httptest.NewRecorder()
+	h.Search(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d but got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	results := []*users.User{}
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatalf("error decoding results: %v", err)
+	}
+
+	// more are fetched to make up for the hidden ones
+	if len(results) != 20 {
+		t.Errorf("expected 20 results but got %d", len(results))
+	}
+	for _, user := range results {
+		if user.ID <= 12 {
+			t.Errorf("expected disabled and blocked users to be left out but got %s", user.UserName)
+		}
+	}
+}
@@ -9,19 +9,27 @@ with the following headers to all requests:
   Access-Control-Allow-Origin: *
   Access-Control-Allow-Methods: GET, PUT, POST, PATCH, DELETE
   Access-Control-Allow-Headers: Content-Type, Authorization
-  Access-Control-Expose-Headers: Authorization
+  Access-Control-Expose-Headers: Authorization, Session-Expires
   Access-Control-Max-Age: 600
 */
 
 type CORS struct {
 	Handler http.Handler
+	// AllowedOrigins may send credentials such as the session
+	// cookie. Browsers don't send them to "*".
+	AllowedOrigins []string
 }
 
 func (c *CORS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if origin := r.Header.Get("Origin"); c.allowsCredentials(origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Add("Vary", "Origin")
+	}
 	w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, POST, PATCH, DELETE")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-	w.Header().Set("Access-Control-Expose-Headers", "Authorization")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-CSRF-Token")
+	w.Header().Set("Access-Control-Expose-Headers", "Authorization, Session-Expires, X-CSRF-Token")
 	w.Header().Set("Access-Control-Max-Age", "600")
 
 	if r.Method == "OPTIONS" {
@@ -31,3 +39,13 @@ func (c *CORS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	c.Handler.ServeHTTP(w, r)
 }
+
+// allowsCredentials returns true if the origin may send credentials
+func (c *CORS) allowsCredentials(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"testing"
 )
 
 func ExampleResponseRecorder() {
@@ -29,3 +30,29 @@ func ExampleResponseRecorder() {
 	// text/html; charset=utf-8
 	// <html><body>Hello World!</body></html>
 }
+
+func TestCORSAllowedOrigins(t *testing.T) {
+	cors := &CORS{
+		Handler:        http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		AllowedOrigins: []string{"https://infoclass.me"},
+	}
+	cases := []struct {
+		origin      string
+		allowOrigin string
+		credentials string
+	}{
+		{"https://infoclass.me", "https://infoclass.me", "true"},
+		{"https://example.com", "*", ""},
+		{"", "*", ""},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/v1/users/me", nil)
+		req.Header.Set("Origin", c.origin)
+		w := httptest.NewRecorder()
+		cors.ServeHTTP(w, req)
+		if w.Header().Get("Access-Control-Allow-Origin") != c.allowOrigin ||
+			w.Header().Get("Access-Control-Allow-Credentials") != c.credentials {
+			t.Errorf("origin %q: incorrect CORS headers %v", c.origin, w.Header())
+		}
+	}
+}
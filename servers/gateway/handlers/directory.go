@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+)
+
+// directoryPurpose is what cursors' signing keys are derived for
+const directoryPurpose = "directory"
+
+// errInvalidCursor is returned for a cursor that wasn't issued by us
+var errInvalidCursor = errors.New("Invalid cursor.")
+
+// DirectoryPage is a page of the user directory. NextCursor is
+// empty on the last page.
+type DirectoryPage struct {
+	Users      []*users.User `json:"users"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+// directoryCursor is the signed contents of a cursor. It holds the
+// sort order and filters along with the position, so following
+// a cursor always continues the same listing.
+type directoryCursor struct {
+	Sort         string              `json:"s"`
+	Descending   bool                `json:"d,omitempty"`
+	Role         string              `json:"r,omitempty"`
+	Verified     *bool               `json:"v,omitempty"`
+	CreatedAfter *time.Time          `json:"ca,omitempty"`
+	After        *users.DirectoryKey `json:"a"`
+}
+
+// signCursor returns the opaque cursor for the given contents,
+// signed with a key derived from the session keyring's active key
+func (h *HandlerContext) signCursor(cursor *directoryCursor) (string, error) {
+	payload, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + h.Keyring.Sign(directoryPurpose, payload), nil
+}
+
+// parseCursor checks the signature of the cursor and returns its contents
+func (h *HandlerContext) parseCursor(cursor string) (*directoryCursor, error) {
+	parts := strings.SplitN(cursor, ".", 2)
+	if len(parts) != 2 {
+		return nil, errInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || !h.Keyring.Verify(directoryPurpose, payload, parts[1]) {
+		return nil, errInvalidCursor
+	}
+
+	contents := &directoryCursor{}
+	if err := json.Unmarshal(payload, contents); err != nil || contents.After == nil {
+		return nil, errInvalidCursor
+	}
+	return contents, nil
+}
+
+// directoryQuery reads the sort order and filters from the query string
+func directoryQuery(query url.Values) (*directoryCursor, error) {
+	cursor := &directoryCursor{Sort: users.SortByUserName}
+	if sort := query.Get("sort"); len(sort) > 0 {
+		cursor.Descending = strings.HasPrefix(sort, "-")
+		cursor.Sort = strings.TrimPrefix(sort, "-")
+		if cursor.Sort != users.SortByUserName && cursor.Sort != users.SortByCreatedAt {
+			return nil, errors.New("sort must be userName or createdAt, optionally starting with - for descending order")
+		}
+	}
+	if role := query.Get("role"); len(role) > 0 {
+		if !users.ValidRole(role) {
+			return nil, errors.New("Unknown role: " + role)
+		}
+		cursor.Role = role
+	}
+	if verified := query.Get("verified"); len(verified) > 0 {
+		v, err := strconv.ParseBool(verified)
+		if err != nil {
+			return nil, errors.New("verified must be true or false")
+		}
+		cursor.Verified = &v
+	}
+	if createdAfter := query.Get("createdAfter"); len(createdAfter) > 0 {
+		t, err := time.Parse(time.RFC3339, createdAfter)
+		if err != nil {
+			return nil, errors.New("createdAfter must be an RFC 3339 timestamp")
+		}
+		cursor.CreatedAfter = &t
+	}
+	return cursor, nil
+}
+
+// DirectoryHandler handles GET requests for /v1/users, listing enabled
+// users one page at a time. The `sort` parameter orders the users by
+// userName (the default) or createdAt, with a leading - for descending
+// order, and `role`, `verified` and `createdAfter` filter them. Each page
+// has a `nextCursor` to pass as the `cursor` parameter for the next page;
+// the cursor carries the sort order and filters, so they needn't be repeated.
+// Personal access tokens need the users:read scope.
+func (h *HandlerContext) DirectoryHandler(w http.ResponseWriter, r *http.Request) {
+	token, _, err := h.authenticateToken(r)
+	if token == nil && err == nil {
+		_, _, err = h.authenticate(w, r)
+	}
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if token != nil && !token.HasScope(users.ScopeUsersRead) {
+		http.Error(w, "Token is missing the "+users.ScopeUsersRead+" scope.", http.StatusForbidden)
+		return
+	}
+
+	perPage, err := strconv.Atoi(r.URL.Query().Get("perPage"))
+	if err != nil || perPage < 1 {
+		perPage = defaultPerPage
+	}
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+
+	var cursor *directoryCursor
+	if encoded := r.URL.Query().Get("cursor"); len(encoded) > 0 {
+		cursor, err = h.parseCursor(encoded)
+	} else {
+		cursor, err = directoryQuery(r.URL.Query())
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// ask for one extra user to find out if there's another page
+	list, err := h.UserStore.ListDirectory(&users.DirectoryQuery{
+		Sort:         cursor.Sort,
+		Descending:   cursor.Descending,
+		Role:         cursor.Role,
+		Verified:     cursor.Verified,
+		CreatedAfter: cursor.CreatedAfter,
+		After:        cursor.After,
+		Limit:        perPage + 1,
+	})
+	if err != nil {
+		http.Error(w, "Failed to list users: "+err.Error(), 500)
+		return
+	}
+
+	page := &DirectoryPage{Users: list}
+	if len(list) > perPage {
+		page.Users = list[:perPage]
+		key := page.Users[perPage-1].Key(cursor.Sort)
+		cursor.After = &key
+		if page.NextCursor, err = h.signCursor(cursor); err != nil {
+			http.Error(w, "Failed to create cursor: "+err.Error(), 500)
+			return
+		}
+		next := url.Values{"cursor": {page.NextCursor}, "perPage": {strconv.Itoa(perPage)}}
+		w.Header().Set("Link", "</v1/users?"+next.Encode()+`>; rel="next"`)
+	}
+	writeJSON(w, http.StatusOK, page)
+}
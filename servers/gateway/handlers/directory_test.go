@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/sessions"
+)
+
+func TestDirectoryCursor(t *testing.T) {
+	h := &HandlerContext{Keyring: sessions.SingleKey("signing key")}
+	verified := true
+	cursor := &directoryCursor{
+		Sort:     users.SortByUserName,
+		Role:     users.RoleAdmin,
+		Verified: &verified,
+		After:    &users.DirectoryKey{UserName: "jsm209", ID: 7},
+	}
+
+	encoded, err := h.signCursor(cursor)
+	if err != nil {
+		t.Fatalf("unexpected error signing cursor: %v", err)
+	}
+	parsed, err := h.parseCursor(encoded)
+	if err != nil {
+		t.Fatalf("unexpected error parsing cursor: %v", err)
+	}
+	if parsed.Sort != cursor.Sort || parsed.Role != cursor.Role || parsed.Verified == nil || !*parsed.Verified ||
+		*parsed.After != *cursor.After {
+		t.Errorf("cursor didn't round trip: %+v", parsed)
+	}
+
+	// cursors can't be edited, or used with a different key
+	payload := strings.Split(encoded, ".")[0]
+	other := &HandlerContext{Keyring: sessions.SingleKey("other key")}
+	for _, bad := range []string{"", "nope", payload, payload + ".AAAA", strings.Replace(encoded, "A", "B", 1)} {
+		if _, err := h.parseCursor(bad); err != errInvalidCursor && bad != encoded {
+			t.Errorf("expected %v for cursor %q but got %v", errInvalidCursor, bad, err)
+		}
+	}
+	if _, err := other.parseCursor(encoded); err != errInvalidCursor {
+		t.Errorf("expected %v for a cursor signed with another key but got %v", errInvalidCursor, err)
+	}
+}
+
+func TestDirectoryQuery(t *testing.T) {
+	cases := []struct {
+		query       string
+		expectError bool
+	}{
+		{"", false},
+		{"sort=-createdAt&role=moderator&verified=false&createdAfter=2020-03-01T00:00:00Z", false},
+		{"sort=email", true},
+		{"role=owner", true},
+		{"verified=maybe", true},
+		{"createdAfter=yesterday", true},
+	}
+	for _, c := range cases {
+		values, _ := url.ParseQuery(c.query)
+		cursor, err := directoryQuery(values)
+		if (err != nil) != c.expectError {
+			t.Errorf("query %q: expected error %v but got %v", c.query, c.expectError, err)
+		}
+		if c.query == "" && (cursor.Sort != users.SortByUserName || cursor.Descending) {
+			t.Errorf("expected default sort to be by username ascending but got %+v", cursor)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/avatars"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/blobs"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/exports"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/sessions"
+)
+
+// exportPathPrefix is the path export jobs are polled at,
+// as /v1/users/me/export/{jobID}
+const exportPathPrefix = "/v1/users/me/export/"
+
+// exportDownloadPathPrefix is the path finished archives are downloaded
+// from, as /v1/exports/{jobID}.zip?expires=...&signature=...
+const exportDownloadPathPrefix = "/v1/exports/"
+
+// exportDownloadLifetime is how long a signed download URL works for.
+// Polling the job again hands out a fresh one.
+const exportDownloadLifetime = 15 * time.Minute
+
+// exportPollInterval is how long clients are asked to wait
+// before polling an unfinished job again
+const exportPollInterval = 5 * time.Second
+
+// exportPurpose is what download URLs' signing keys are derived for
+const exportPurpose = "export"
+
+// errInvalidSignature is returned for download URLs we didn't sign, or that have expired
+var errInvalidSignature = errors.New("Download link is invalid or has expired.")
+
+// ExportRequest is the optional body of a request to export the user's data
+type ExportRequest struct {
+	// IncludeMessages also exports the messages the user has
+	// posted, fetched from the messaging service
+	IncludeMessages bool `json:"includeMessages"`
+}
+
+// ExportProfile is the user's profile as it appears in an export,
+// including the email address that's otherwise never returned
+type ExportProfile struct {
+	*users.User
+	Email string `json:"email"`
+}
+
+// ExportActivity is the history of the user's account as it appears in an export
+type ExportActivity struct {
+	PreviousUserNames []*users.PreviousUserName `json:"previousUserNames"`
+	LinkedIdentities  []*users.Identity         `json:"linkedIdentities"`
+	AccessTokens      []*users.AccessToken      `json:"accessTokens"`
+	TwoFactorEnabled  bool                      `json:"twoFactorEnabled"`
+}
+
+// ExportSession describes a session as it appears in an export
+type ExportSession struct {
+	SignedInAt time.Time `json:"signedInAt"`
+	LastSeenAt time.Time `json:"lastSeenAt,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+	DeviceName string    `json:"deviceName,omitempty"`
+	Current    bool      `json:"current"`
+}
+
+// exportSignature returns the signature for downloading the job's archive until `expires`
+func (h *HandlerContext) exportSignature(jobID string, expires int64) string {
+	return h.Keyring.Sign(exportPurpose, exportSigned(jobID, expires))
+}
+
+// exportSigned returns what's signed to download the job's archive until `expires`
+func exportSigned(jobID string, expires int64) []byte {
+	return []byte(jobID + "." + strconv.FormatInt(expires, 10))
+}
+
+// exportDownloadURL returns a signed URL for downloading the
+// job's archive, which works until exportDownloadLifetime from now
+func (h *HandlerContext) exportDownloadURL(job *exports.Job) string {
+	expires := time.Now().Add(exportDownloadLifetime).Unix()
+	return exportDownloadPathPrefix + job.ID + ".zip?expires=" + strconv.FormatInt(expires, 10) +
+		"&signature=" + h.exportSignature(job.ID, expires)
+}
+
+// verifyExportDownload checks the signature and expiry of a download URL
+func (h *HandlerContext) verifyExportDownload(jobID string, expiresParam string, signature string) error {
+	expires, err := strconv.ParseInt(expiresParam, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return errInvalidSignature
+	}
+	if !h.Keyring.Verify(exportPurpose, exportSigned(jobID, expires), signature) {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+// writeExportJob writes the job, with a fresh download URL if it's ready,
+// or a Retry-After header if clients should poll again
+func (h *HandlerContext) writeExportJob(w http.ResponseWriter, status int, job *exports.Job) {
+	w.Header().Set("Location", exportPathPrefix+job.ID)
+	if job.Status == exports.StatusReady {
+		job.DownloadURL = h.exportDownloadURL(job)
+	} else if !job.Done() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(exportPollInterval.Seconds())))
+	}
+	writeJSON(w, status, job)
+}
+
+// ExportHandler handles POST requests for /v1/users/me/export, starting
+// a job that builds a ZIP archive of the user's personal data in the
+// background. It responds with the job, whose Location can be polled
+// until the job is ready and has a downloadURL. Only one export can run
+// at a time; starting a new one replaces the previous archive.
+func (h *HandlerContext) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// personal data only goes to a signed in session, not to tokens
+	sessionID, sessionState, err := h.authenticate(w, r)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	user := &sessionState.User
+
+	request := ExportRequest{}
+	if r.ContentLength != 0 {
+		if err := readJSON(r, &request); err != nil {
+			readJSONError(w, err)
+			return
+		}
+	}
+	if request.IncludeMessages && h.Messages == nil {
+		http.Error(w, "Messages can't be exported from this server.", http.StatusBadRequest)
+		return
+	}
+
+	previous, err := h.Exports.Latest(user.ID)
+	if err != nil && err != exports.ErrJobNotFound {
+		http.Error(w, "Failed to get export jobs: "+err.Error(), 500)
+		return
+	}
+	if previous != nil && !previous.Done() {
+		w.Header().Set("Location", exportPathPrefix+previous.ID)
+		http.Error(w, "An export is already in progress.", http.StatusConflict)
+		return
+	}
+
+	job, err := exports.NewJob(user.ID, request.IncludeMessages)
+	if err != nil {
+		http.Error(w, "Failed to create export job: "+err.Error(), 500)
+		return
+	}
+	if err := h.Exports.Save(job); err != nil {
+		http.Error(w, "Failed to save export job: "+err.Error(), 500)
+		return
+	}
+	if previous != nil {
+		h.ExportArchives.Delete(previous.ArchiveKey())
+	}
+
+	go h.runExport(job, sessionID, sessionState)
+
+	h.writeExportJob(w, http.StatusAccepted, job)
+}
+
+// SpecificExportHandler handles GET requests for /v1/users/me/export/{jobID},
+// returning the status of one of the user's export jobs
+func (h *HandlerContext) SpecificExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, sessionState, err := h.authenticate(w, r)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	job, err := h.Exports.Get(strings.TrimPrefix(r.URL.Path, exportPathPrefix))
+	// other users' jobs look the same as ones that don't exist
+	if err == exports.ErrJobNotFound || (err == nil && job.UserID != sessionState.User.ID) {
+		http.Error(w, "Export not found.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get export job: "+err.Error(), 500)
+		return
+	}
+
+	h.writeExportJob(w, http.StatusOK, job)
+}
+
+// ExportDownloadHandler handles GET requests for /v1/exports/{jobID}.zip.
+// The signed URL is the only credential, so the link can be opened
+// directly in a browser while it lasts.
+func (h *HandlerContext) ExportDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, exportDownloadPathPrefix)
+	if !strings.HasSuffix(name, ".zip") {
+		http.Error(w, "Export not found.", http.StatusNotFound)
+		return
+	}
+	jobID := strings.TrimSuffix(name, ".zip")
+
+	query := r.URL.Query()
+	if err := h.verifyExportDownload(jobID, query.Get("expires"), query.Get("signature")); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	job, err := h.Exports.Get(jobID)
+	if err == exports.ErrJobNotFound || (err == nil && job.Status != exports.StatusReady) {
+		http.Error(w, "Export not found.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get export job: "+err.Error(), 500)
+		return
+	}
+
+	archive, info, err := h.ExportArchives.Get(job.ArchiveKey())
+	if err == blobs.ErrBlobNotFound {
+		http.Error(w, "Export not found.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get archive: "+err.Error(), 500)
+		return
+	}
+	defer archive.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	w.Header().Set("Content-Disposition", `attachment; filename="export-`+job.CreatedAt.Format("2006-01-02")+`.zip"`)
+	w.Header().Set("Cache-Control", "private, no-store")
+	io.Copy(w, archive)
+}
+
+// runExport builds the job's archive, streaming it into storage,
+// and saves the outcome of the job
+func (h *HandlerContext) runExport(job *exports.Job, sessionID sessions.SessionID, sessionState *SessionState) {
+	job.Status = exports.StatusRunning
+	if err := h.Exports.Save(job); err != nil {
+		log.Printf("error saving export job %s: %v", job.ID, err)
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(h.writeExport(writer, job, sessionID, sessionState))
+	}()
+	err := h.ExportArchives.Put(job.ArchiveKey(), reader, "application/zip")
+	// unblock the writer if storage gave up early
+	reader.CloseWithError(err)
+	if err != nil {
+		log.Printf("error exporting data for user %d: %v", job.UserID, err)
+		h.ExportArchives.Delete(job.ArchiveKey())
+	}
+
+	job.Finish(err)
+	if err := h.Exports.Save(job); err != nil {
+		log.Printf("error saving export job %s: %v", job.ID, err)
+	}
+}
+
+// exportSessions returns the user's sessions as they appear in an export.
+// The session that asked for the export is always included, even if it
+// began before sessions were tracked.
+func (h *HandlerContext) exportSessions(userID int64, sessionID sessions.SessionID, sessionState *SessionState) []*ExportSession {
+	list, err := h.SessionStore.Sessions(userID)
+	if err != nil {
+		log.Printf("error getting sessions of user %d: %v", userID, err)
+	}
+	exported := []*ExportSession{}
+	current := false
+	publicID := sessionID.PublicID()
+	for _, meta := range list {
+		exported = append(exported, &ExportSession{SignedInAt: meta.CreatedAt, LastSeenAt: meta.LastSeenAt,
+			IP: meta.IP, UserAgent: meta.UserAgent, DeviceName: meta.DeviceName, Current: meta.ID == publicID})
+		current = current || meta.ID == publicID
+	}
+	if !current {
+		exported = append(exported, &ExportSession{SignedInAt: sessionState.Curtime, Current: true})
+	}
+	return exported
+}
+
+// writeExport writes the ZIP archive of the user's personal data to `w`
+func (h *HandlerContext) writeExport(w io.Writer, job *exports.Job, sessionID sessions.SessionID, sessionState *SessionState) error {
+	user, err := h.UserStore.GetByID(job.UserID)
+	if err != nil {
+		return err
+	}
+
+	activity := &ExportActivity{}
+	if activity.PreviousUserNames, err = h.UserStore.GetUserNameHistory(user.ID); err != nil {
+		return err
+	}
+	if activity.LinkedIdentities, err = h.UserStore.GetIdentities(user.ID); err != nil {
+		return err
+	}
+	if activity.AccessTokens, err = h.UserStore.GetAccessTokens(user.ID); err != nil {
+		return err
+	}
+	mfa, err := h.UserStore.GetMFA(user.ID)
+	if err != nil {
+		return err
+	}
+	activity.TwoFactorEnabled = mfa.Enabled
+
+	archive := exports.NewArchive(w)
+	if err := archive.AddJSON("profile.json", &ExportProfile{User: user, Email: user.Email}); err != nil {
+		return err
+	}
+	if err := archive.AddJSON("activity.json", activity); err != nil {
+		return err
+	}
+	if err := archive.AddJSON("sessions.json", h.exportSessions(user.ID, sessionID, sessionState)); err != nil {
+		return err
+	}
+
+	if version := parseAvatarURL(user.ID, user.PhotoURL); len(version) > 0 {
+		size := avatars.Sizes[len(avatars.Sizes)-1]
+		avatar, _, err := h.Avatars.Get(avatarKey(user.ID, version, size))
+		if err != nil && err != blobs.ErrBlobNotFound {
+			return err
+		}
+		if err == nil {
+			err = archive.AddFile("avatar.png", avatar)
+			avatar.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if job.IncludeMessages {
+		messages, err := h.Messages.Messages(user)
+		if err != nil {
+			return err
+		}
+		if err := archive.AddJSON("messages.json", messages); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
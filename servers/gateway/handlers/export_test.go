@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/exports"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/sessions"
+)
+
+func TestExportDownloadURL(t *testing.T) {
+	h := &HandlerContext{Keyring: sessions.SingleKey("signing key")}
+	job := &exports.Job{ID: "abc123"}
+
+	downloadURL, err := url.Parse(h.exportDownloadURL(job))
+	if err != nil {
+		t.Fatalf("download URL doesn't parse: %v", err)
+	}
+	if downloadURL.Path != exportDownloadPathPrefix+"abc123.zip" {
+		t.Errorf("incorrect download path: %s", downloadURL.Path)
+	}
+	query := downloadURL.Query()
+	if err := h.verifyExportDownload("abc123", query.Get("expires"), query.Get("signature")); err != nil {
+		t.Errorf("unexpected error verifying download URL: %v", err)
+	}
+
+	cases := []struct {
+		name      string
+		jobID     string
+		expires   string
+		signature string
+	}{
+		{"another job", "abc124", query.Get("expires"), query.Get("signature")},
+		{"extended expiry", "abc123", query.Get("expires") + "0", query.Get("signature")},
+		{"tampered signature", "abc123", query.Get("expires"), strings.ToUpper(query.Get("signature"))},
+		{"missing expiry", "abc123", "", query.Get("signature")},
+	}
+	for _, c := range cases {
+		if err := h.verifyExportDownload(c.jobID, c.expires, c.signature); err != errInvalidSignature {
+			t.Errorf("%s: expected %v but got %v", c.name, errInvalidSignature, err)
+		}
+	}
+
+	// correctly signed, but past its expiry
+	expired := time.Now().Add(-time.Minute).Unix()
+	if err := h.verifyExportDownload("abc123", strconv.FormatInt(expired, 10), h.exportSignature("abc123", expired)); err != errInvalidSignature {
+		t.Errorf("expired URL: expected %v but got %v", errInvalidSignature, err)
+	}
+
+	other := &HandlerContext{Keyring: sessions.SingleKey("another key")}
+	if err := other.verifyExportDownload("abc123", query.Get("expires"), query.Get("signature")); err != errInvalidSignature {
+		t.Errorf("URL signed with another key: expected %v but got %v", errInvalidSignature, err)
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+)
+
+// InvitationAcceptance is the body of a request to accept an invitation
+type InvitationAcceptance struct {
+	Token        string `json:"token"`
+	Password     string `json:"password"`
+	PasswordConf string `json:"passwordConf"`
+}
+
+// InvitationHandler handles requests for /v1/invitations/accept.
+// POST sets the password of a user who was invited by an import,
+// using the token from their invitation email, and signs them in.
+func (h *HandlerContext) InvitationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	acceptance := InvitationAcceptance{}
+	if err := readJSON(r, &acceptance); err != nil {
+		readJSONError(w, err)
+		return
+	}
+
+	// the same password rules as signing up
+	if len(acceptance.Password) < 6 {
+		http.Error(w, "Password must be at least 6 characters.", http.StatusBadRequest)
+		return
+	}
+	if acceptance.Password != acceptance.PasswordConf {
+		http.Error(w, "Password and confirmed passwords must match.", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.UserStore.AcceptInvitation(users.HashInvitationToken(acceptance.Token), acceptance.Password)
+	if err == users.ErrInvitationNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to accept invitation: "+err.Error(), 500)
+		return
+	}
+	if user.Disabled {
+		http.Error(w, users.ErrUserDisabled.Error(), http.StatusForbidden)
+		return
+	}
+
+	newSessionState := SessionState{
+		Curtime: time.Now(),
+		User:    *user,
+	}
+	if _, err := h.beginSession(w, r, newSessionState); err != nil {
+		http.Error(w, "Failed to begin a new session for the user: "+err.Error(), 500)
+		return
+	}
+	writeJSON(w, http.StatusCreated, user)
+}
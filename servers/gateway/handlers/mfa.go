@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/sessions"
+)
+
+// MFAHandler handles requests for /v1/users/me/mfa.
+// POST begins enrolling the current user in two-factor authentication,
+// DELETE disables it after re-checking the user's password and a code.
+func (h *HandlerContext) MFAHandler(w http.ResponseWriter, r *http.Request) {
+	_, sessionState, err := h.authenticate(w, r)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	userID := sessionState.User.ID
+
+	mfa, err := h.UserStore.GetMFA(userID)
+	if err != nil {
+		http.Error(w, "Failed to get two-factor settings: "+err.Error(), 500)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if mfa.Enabled {
+			http.Error(w, "Two-factor authentication is already enabled.", http.StatusConflict)
+			return
+		}
+
+		// generate a new secret, which stays unconfirmed until
+		// the user sends back a valid code for it
+		secret, err := users.NewTOTPSecret()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if err := h.UserStore.BeginMFAEnrollment(userID, secret); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, &users.MFAEnrollment{
+			Secret: secret,
+			URI:    users.OTPAuthURI(h.MFAIssuer, sessionState.User.UserName, secret),
+		})
+
+	} else if r.Method == http.MethodDelete {
+		if !mfa.Enabled {
+			http.Error(w, "Two-factor authentication is not enabled.", http.StatusConflict)
+			return
+		}
+
+		var code users.MFACode
+		if err := readJSON(r, &code); err != nil {
+			readJSONError(w, err)
+			return
+		}
+
+		// re-authenticate before turning off the second factor,
+		// with the password hash from the database
+		user, err := h.UserStore.SQLStore.GetByID(userID)
+		if err != nil {
+			http.Error(w, "User with that ID cannot be found: "+err.Error(), http.StatusNotFound)
+			return
+		}
+		if err := user.Authenticate(code.Password); err != nil {
+			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		if err := h.checkMFACode(mfa, &code); err != nil {
+			if lockout, ok := err.(*users.MFALockoutError); ok {
+				mfaLockedOut(w, lockout)
+				return
+			}
+			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			return
+		}
+
+		if err := h.UserStore.DisableMFA(userID); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("two-factor authentication disabled"))
+
+	} else {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// MFAConfirmHandler handles requests for /v1/users/me/mfa/confirm.
+// It enables two-factor authentication once the user sends a valid
+// code for their pending secret, and responds with their recovery codes.
+func (h *HandlerContext) MFAConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, sessionState, err := h.authenticate(w, r)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	userID := sessionState.User.ID
+
+	var code users.MFACode
+	if err := readJSON(r, &code); err != nil {
+		readJSONError(w, err)
+		return
+	}
+
+	mfa, err := h.UserStore.GetMFA(userID)
+	if err != nil {
+		http.Error(w, "Failed to get two-factor settings: "+err.Error(), 500)
+		return
+	}
+	if len(mfa.Secret) == 0 {
+		http.Error(w, "Begin enrollment before confirming it.", http.StatusConflict)
+		return
+	}
+	if mfa.Enabled {
+		http.Error(w, "Two-factor authentication is already enabled.", http.StatusConflict)
+		return
+	}
+	step, err := users.ValidateTOTP(mfa.Secret, code.Code, time.Now(), mfa.LastStep)
+	if err == nil {
+		err = h.UserStore.UseTOTPStep(userID, step)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the recovery codes are only ever shown here,
+	// we keep just their hashes
+	recoveryCodes, hashes, err := users.NewRecoveryCodes()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := h.UserStore.EnableMFA(userID, hashes); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string][]string{"recoveryCodes": recoveryCodes})
+}
+
+// SessionMFAHandler handles requests for /v1/sessions/mfa.
+// It finishes a two-step sign-in by checking the code for an
+// mfa-pending session and replacing it with a fully signed in one.
+func (h *HandlerContext) SessionMFAHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionState := SessionState{}
+	sessionID, err := h.getSessionState(r, &sessionState)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !sessionState.MFAPending {
+		http.Error(w, "Session is already signed in.", http.StatusConflict)
+		return
+	}
+
+	var code users.MFACode
+	if err := readJSON(r, &code); err != nil {
+		readJSONError(w, err)
+		return
+	}
+
+	mfa, err := h.UserStore.GetMFA(sessionState.User.ID)
+	if err != nil {
+		http.Error(w, "Failed to get two-factor settings: "+err.Error(), 500)
+		return
+	}
+	if err := h.checkMFACode(mfa, &code); err != nil {
+		if lockout, ok := err.(*users.MFALockoutError); ok {
+			// the password has to be checked again after the lockout
+			h.endSession(sessionID, sessions.EventRevoked)
+			mfaLockedOut(w, lockout)
+			return
+		}
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	// swap the pending session for a new one so the
+	// pre-authentication session ID can't be reused
+	if err := h.SessionStore.Delete(sessionID); err != nil {
+		http.Error(w, "Something went wrong while deleting the session: "+err.Error(), 500)
+		return
+	}
+	sessionState.Curtime = time.Now()
+	sessionState.MFAPending = false
+	if _, err := h.beginSession(w, r, sessionState); err != nil {
+		http.Error(w, "Failed to begin a new session for the user: "+err.Error(), 500)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, &sessionState.User)
+}
+
+// checkMFACode returns nil if `code` holds either a valid, unused TOTP
+// code or an unused recovery code for the user's enabled two-factor settings.
+// Each code counts towards locking the user out until one is accepted, and
+// a *users.MFALockoutError is returned instead of checking it once they are.
+func (h *HandlerContext) checkMFACode(mfa *users.MFA, code *users.MFACode) error {
+	if !mfa.Enabled {
+		return users.ErrInvalidMFACode
+	}
+	if mfa.LockedUntil.After(time.Now()) {
+		return &users.MFALockoutError{RetryAt: mfa.LockedUntil}
+	}
+	// counted atomically before checking the code, so concurrent
+	// requests can't get more codes checked than the limit
+	if err := h.UserStore.BeginMFAAttempt(mfa.UserID, time.Now()); err != nil {
+		return err
+	}
+	if err := h.verifyMFACode(mfa, code); err != nil {
+		return err
+	}
+	if err := h.UserStore.ResetMFAAttempts(mfa.UserID); err != nil {
+		log.Printf("error resetting two-factor attempts of user %d: %v", mfa.UserID, err)
+	}
+	return nil
+}
+
+// verifyMFACode uses up the TOTP or recovery code in `code`
+// if it's valid and unused
+func (h *HandlerContext) verifyMFACode(mfa *users.MFA, code *users.MFACode) error {
+	if len(code.RecoveryCode) > 0 {
+		return h.UserStore.UseRecoveryCode(mfa.UserID, code.RecoveryCode)
+	}
+	step, err := users.ValidateTOTP(mfa.Secret, code.Code, time.Now(), mfa.LastStep)
+	if err != nil {
+		return err
+	}
+	// recorded atomically, so only one request can use each code
+	return h.UserStore.UseTOTPStep(mfa.UserID, step)
+}
+
+// mfaLockedOut responds that the user sent too many wrong
+// two-factor codes, and when they can send another
+func mfaLockedOut(w http.ResponseWriter, lockout *users.MFALockoutError) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(lockout.RetryAt).Seconds())+1))
+	http.Error(w, lockout.Error(), http.StatusTooManyRequests)
+}
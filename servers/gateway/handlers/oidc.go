@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/oidc"
+)
+
+// oidcStateCookie holds a hash of the state of the sign-in flow the
+// browser began, so only that browser can finish it. Otherwise anyone
+// could send someone a link to the callback for a flow they began, and
+// sign them in to the attacker's account.
+const oidcStateCookie = "oidc_state"
+
+// maxUserNameAttempts is how many usernames are tried for a new
+// user from an identity provider before giving up
+const maxUserNameAttempts = 10
+
+// userNameSuffixLimit bounds the random number put on the end of the
+// username of a new user from an identity provider if theirs is taken
+const userNameSuffixLimit = 100000
+
+// OIDCHandler handles requests for /v1/sessions/oidc/{provider}, which
+// redirects the user to the provider to sign in, and for
+// /v1/sessions/oidc/{provider}/callback, where the provider sends them back
+func (h *HandlerContext) OIDCHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/sessions/oidc/"), "/")
+	provider, ok := h.OIDCProviders[parts[0]]
+	if !ok {
+		http.Error(w, oidc.ErrUnknownProvider.Error(), http.StatusNotFound)
+		return
+	}
+
+	if len(parts) == 1 {
+		h.beginOIDC(w, r, provider)
+	} else if len(parts) == 2 && parts[1] == "callback" {
+		h.finishOIDC(w, r, provider)
+	} else {
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// beginOIDC saves a new flow and redirects the user to the provider
+func (h *HandlerContext) beginOIDC(w http.ResponseWriter, r *http.Request, provider *oidc.Provider) {
+	flow, err := oidc.NewFlow(provider.Name)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := h.OIDCFlows.Save(flow); err != nil {
+		http.Error(w, "Failed to save sign-in flow: "+err.Error(), 500)
+		return
+	}
+	// Lax, so it's sent when the provider redirects back
+	http.SetCookie(w, oidcCookie(stateHash(flow.State), int(oidc.FlowDuration.Seconds())))
+	http.Redirect(w, r, provider.AuthCodeURL(flow), http.StatusFound)
+}
+
+// finishOIDC checks the state, exchanges the code for a verified ID token,
+// and begins a session for the user linked to (or created from) its claims
+func (h *HandlerContext) finishOIDC(w http.ResponseWriter, r *http.Request, provider *oidc.Provider) {
+	query := r.URL.Query()
+	if errCode := query.Get("error"); len(errCode) > 0 {
+		http.Error(w, "Identity provider returned an error: "+errCode, http.StatusUnauthorized)
+		return
+	}
+
+	// the state has to be the one this browser began the flow with,
+	// checked before taking it so no one else can use it up
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(stateHash(state))) != 1 {
+		http.Error(w, "Invalid or expired sign-in attempt.", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, oidcCookie("", -1))
+
+	// the state can only be used once, and only with the provider it was made for
+	flow, err := h.OIDCFlows.Take(state)
+	if err != nil || flow.Provider != provider.Name {
+		http.Error(w, "Invalid or expired sign-in attempt.", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := provider.Exchange(query.Get("code"), flow)
+	if err != nil {
+		http.Error(w, "Failed to verify sign-in: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.userFromClaims(provider.Name, claims)
+	if err != nil {
+		http.Error(w, "Failed to sign in: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	if user.Disabled {
+		http.Error(w, users.ErrUserDisabled.Error(), http.StatusForbidden)
+		return
+	}
+
+	mfa, err := h.UserStore.GetMFA(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to get two-factor settings: "+err.Error(), 500)
+		return
+	}
+
+	newSessionState := SessionState{
+		Curtime:    time.Now(),
+		User:       *user,
+		MFAPending: mfa.Enabled,
+	}
+	if _, err := h.beginSession(w, r, newSessionState); err != nil {
+		http.Error(w, "Failed to begin a new session for the user: "+err.Error(), 500)
+		return
+	}
+
+	if mfa.Enabled {
+		writeJSON(w, http.StatusAccepted, map[string]bool{"mfaRequired": true})
+		return
+	}
+	writeJSON(w, http.StatusCreated, user)
+}
+
+// userFromClaims returns the user linked to the identity in the claims.
+// If none is linked yet, it links the user with the same verified email,
+// or creates a new user from the claims.
+func (h *HandlerContext) userFromClaims(providerName string, claims *oidc.Claims) (*users.User, error) {
+	user, err := h.UserStore.GetByIdentity(providerName, claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if err != users.ErrUserNotFound {
+		return nil, err
+	}
+
+	if len(claims.Email) == 0 || !claims.EmailVerified {
+		return nil, errors.New("identity provider didn't supply a verified email address")
+	}
+
+	// link an existing account with the same email
+	existing, err := h.UserStore.GetByEmail(claims.Email)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		if err := h.UserStore.LinkIdentity(existing.ID, providerName, claims.Subject); err != nil {
+			return nil, err
+		}
+		if err := h.UserStore.SetEmailVerified(existing.ID); err != nil {
+			return nil, err
+		}
+		existing.EmailVerified = true
+		return existing, nil
+	}
+
+	// otherwise create a new account, with a random password
+	// since the user will sign in through the provider
+	password, err := randomPassword()
+	if err != nil {
+		return nil, err
+	}
+	newUser := users.NewUser{
+		Email:        claims.Email,
+		Password:     password,
+		PasswordConf: password,
+		FirstName:    claims.GivenName,
+		LastName:     claims.FamilyName,
+	}
+	insertedUser, err := h.insertWithUserName(&newUser, userNameFromClaims(claims))
+	if err != nil {
+		return nil, err
+	}
+	h.UserStore.AddUserToTrie(insertedUser)
+	if err := h.setDefaultAvatar(insertedUser); err != nil {
+		return nil, err
+	}
+
+	if err := h.UserStore.LinkIdentity(insertedUser.ID, providerName, claims.Subject); err != nil {
+		return nil, err
+	}
+	if err := h.UserStore.SetEmailVerified(insertedUser.ID); err != nil {
+		return nil, err
+	}
+	insertedUser.EmailVerified = true
+	return insertedUser, nil
+}
+
+// insertWithUserName inserts the new user with the username, or if it's
+// not valid, reserved or taken, with a random number on the end of it
+func (h *HandlerContext) insertWithUserName(newUser *users.NewUser, userName string) (*users.User, error) {
+	var validUser *users.User
+	for attempt := 0; attempt < maxUserNameAttempts; attempt++ {
+		candidate, err := userNameCandidate(userName, attempt)
+		if err != nil {
+			return nil, err
+		}
+		// only the suffixed usernames are sure to be long enough
+		if users.ValidateUserName(candidate) != nil {
+			continue
+		}
+		if validUser == nil {
+			// the password is hashed once, however many usernames are tried
+			newUser.UserName = candidate
+			if validUser, err = newUser.ToUser(); err != nil {
+				return nil, err
+			}
+		}
+		validUser.UserName = candidate
+		insertedUser, err := h.UserStore.Insert(validUser)
+		if err == users.ErrUserNameTaken {
+			continue
+		}
+		return insertedUser, err
+	}
+	return nil, users.ErrUserNameTaken
+}
+
+// userNameCandidate returns the username to try for a new user from an
+// identity provider: the one from their claims at first, and then that
+// with a random number on the end, cut short to leave room for it
+func userNameCandidate(userName string, attempt int) (string, error) {
+	if attempt == 0 {
+		return userName, nil
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(userNameSuffixLimit))
+	if err != nil {
+		return "", errors.New("Problem generating username suffix.")
+	}
+	suffix := n.String()
+	if len(userName) > users.MaxUserNameLength-len(suffix) {
+		userName = userName[:users.MaxUserNameLength-len(suffix)]
+	}
+	if len(userName) == 0 {
+		userName = "user"
+	}
+	return userName + suffix, nil
+}
+
+// userNameFromClaims picks a username for a new user from the
+// preferred_username claim, falling back to the email's local part,
+// dropping the characters usernames can't have
+func userNameFromClaims(claims *oidc.Claims) string {
+	userName := claims.PreferredUsername
+	if len(userName) == 0 {
+		userName = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+	userName = strings.Map(func(r rune) rune {
+		if ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') || strings.ContainsRune("._-", r) {
+			return r
+		}
+		return -1
+	}, userName)
+	return strings.TrimLeft(userName, "._-")
+}
+
+// stateHash returns the hash of the flow's state that's kept in the cookie
+func stateHash(state string) string {
+	hash := sha256.Sum256([]byte(state))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// oidcCookie returns the state cookie with the value, lasting maxAge seconds
+func oidcCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/v1/sessions/oidc/",
+		MaxAge:   maxAge,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// randomPassword returns a random password that no one knows
+func randomPassword() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.New("Problem generating random password.")
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/indexes"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/oidc"
+)
+
+func TestOIDCStateCookie(t *testing.T) {
+	// a provider that refuses every code
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/.well-known/openid-configuration" {
+			writeJSON(w, http.StatusOK, map[string]string{
+				"issuer":                 server.URL,
+				"authorization_endpoint": server.URL + "/auth",
+				"token_endpoint":         server.URL + "/token",
+			})
+			return
+		}
+		http.Error(w, "invalid_grant", http.StatusBadRequest)
+	}))
+	defer server.Close()
+	provider, err := oidc.NewProvider(oidc.Config{Name: "test", Issuer: server.URL, ClientID: "client"}, nil)
+	if err != nil {
+		t.Fatalf("error creating provider: %v", err)
+	}
+	flows := oidc.NewMemFlowStore()
+	h := &HandlerContext{
+		OIDCProviders: map[string]*oidc.Provider{"test": provider},
+		OIDCFlows:     flows,
+	}
+
+	// beginning a flow sets the cookie and sends the state to the provider
+	begin := func() (string, *http.Cookie) {
+		w := httptest.NewRecorder()
+		h.OIDCHandler(w, httptest.NewRequest("GET", "/v1/sessions/oidc/test", nil))
+		if w.Code != http.StatusFound {
+			t.Fatalf("expected a redirect but got %d", w.Code)
+		}
+		location, _ := url.Parse(w.Header().Get("Location"))
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != oidcStateCookie {
+			t.Fatalf("expected the state cookie but got %v", cookies)
+		}
+		return location.Query().Get("state"), cookies[0]
+	}
+	state, cookie := begin()
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.Value == state {
+		t.Errorf("expected an HttpOnly, SameSite=Lax cookie with a hash of the state but got %v", cookie)
+	}
+	_, other := begin()
+
+	callback := func(cookie *http.Cookie) int {
+		r := httptest.NewRequest("GET", "/v1/sessions/oidc/test/callback?code=abc&state="+state, nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		h.OIDCHandler(w, r)
+		return w.Code
+	}
+	// another browser can't finish the flow, or use up its state
+	if code := callback(nil); code != http.StatusBadRequest {
+		t.Errorf("expected %d without the cookie but got %d", http.StatusBadRequest, code)
+	}
+	if code := callback(other); code != http.StatusBadRequest {
+		t.Errorf("expected %d with another flow's cookie but got %d", http.StatusBadRequest, code)
+	}
+	// the browser that began it gets as far as exchanging the code
+	if code := callback(cookie); code != http.StatusUnauthorized {
+		t.Errorf("expected the flow to reach the code exchange but got %d", code)
+	}
+	if _, err := flows.Take(state); err != oidc.ErrFlowNotFound {
+		t.Errorf("expected the flow to be used up but got %v", err)
+	}
+}
+
+func TestUserNameCandidate(t *testing.T) {
+	if userName, _ := userNameCandidate("jane", 0); userName != "jane" {
+		t.Errorf("expected the username from the claims first but got %s", userName)
+	}
+	long := strings.Repeat("a", users.MaxUserNameLength)
+	for _, userName := range []string{"jane", "me", "", long} {
+		candidate, err := userNameCandidate(userName, 1)
+		if err != nil {
+			t.Fatalf("error making username: %v", err)
+		}
+		if err := users.ValidateUserName(candidate); err != nil {
+			t.Errorf("expected a valid username for %q but got %q: %v", userName, candidate, err)
+		}
+	}
+}
+
+func TestInsertWithUserName(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	h := &HandlerContext{
+		UserStore: users.NewCachedStore(users.NewSQLStore(db, indexes.NewTrieNode()), users.NewMemUserCache(time.Minute, 10)),
+	}
+
+	// "admin" is reserved, and the first username with a number is
+	// still held by someone who changed theirs
+	history := regexp.QuoteMeta("select count(*) from USERNAME_HISTORY where UserName = ? and UserID != ? and ChangedAt > ?")
+	mock.ExpectBegin()
+	mock.ExpectQuery(history).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(history).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(regexp.QuoteMeta("insert into USERS")).WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.ExpectCommit()
+
+	newUser := &users.NewUser{Email: "admin@example.com", Password: "password", PasswordConf: "password"}
+	user, err := h.insertWithUserName(newUser, "admin")
+	if err != nil {
+		t.Fatalf("error inserting user: %v", err)
+	}
+	if user.ID != 5 || !strings.HasPrefix(user.UserName, "admin") || users.ValidateUserName(user.UserName) != nil {
+		t.Errorf("expected a valid username with a number on the end but got %q", user.UserName)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/presence"
+)
+
+// maxPresenceIDs is the most users whose presence can be
+// asked for, or watched, at once
+const maxPresenceIDs = 100
+
+// Types of websocket messages about presence. Clients send idle and
+// active as the user leaves and comes back, and presence-subscribe with
+// the userIDs they want presence-change events for.
+const (
+	messageIdle              = "idle"
+	messageActive            = "active"
+	messagePresenceSubscribe = "presence-subscribe"
+	messagePresenceChange    = "presence-change"
+)
+
+// PresenceChange is the websocket event sent when the presence
+// of a watched user changes
+type PresenceChange struct {
+	Type string `json:"type"`
+	*presence.Presence
+}
+
+// parsePresenceIDs parses a comma-separated list of user IDs
+func parsePresenceIDs(list string) ([]int64, error) {
+	ids := []int64{}
+	for _, field := range strings.Split(list, ",") {
+		if len(strings.TrimSpace(field)) == 0 {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+		if err != nil {
+			return nil, errors.New("ids must be a comma-separated list of user IDs")
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("ids must list at least one user ID")
+	}
+	if len(ids) > maxPresenceIDs {
+		return nil, errors.New("ids can list at most " + strconv.Itoa(maxPresenceIDs) + " user IDs")
+	}
+	return ids, nil
+}
+
+// PresenceHandler handles GET requests for /v1/presence?ids=1,2,3,
+// returning whether each of the users is online, away or offline.
+// Personal access tokens need the users:read scope.
+func (h *HandlerContext) PresenceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, _, err := h.authenticateToken(r)
+	if token == nil && err == nil {
+		_, _, err = h.authenticate(w, r)
+	}
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if token != nil && !token.HasScope(users.ScopeUsersRead) {
+		http.Error(w, "Access token doesn't have the required scope.", http.StatusForbidden)
+		return
+	}
+
+	ids, err := parsePresenceIDs(r.URL.Query().Get("ids"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	list, err := h.Sockets.Presence.Get(ids)
+	if err != nil {
+		http.Error(w, "Failed to get presence: "+err.Error(), 500)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handlePresenceMessage handles a presence message from the client,
+// returning false if it's some other kind of message. Every message
+// from the client counts as activity, except for idle signals.
+func (s *SocketStore) handlePresenceMessage(conn *Connection, m map[string]interface{}) bool {
+	messageType, _ := m["type"].(string)
+
+	idle := messageType == messageIdle
+	conn.lock.Lock()
+	wasIdle := conn.idle
+	conn.idle = idle
+	if !idle {
+		conn.lastActive = time.Now()
+	}
+	conn.lock.Unlock()
+
+	if idle != wasIdle {
+		if err := s.Presence.SetIdle(conn.UserID, idle); err != nil {
+			log.Printf("error updating presence of user %d: %v", conn.UserID, err)
+		}
+	}
+
+	switch messageType {
+	case messageIdle, messageActive:
+		return true
+	case messagePresenceSubscribe:
+		s.watchPresence(conn, m["userIDs"])
+		return true
+	}
+	return false
+}
+
+// watchPresence replaces the users the connection hears presence changes
+// for, and sends their current presence to start from
+func (s *SocketStore) watchPresence(conn *Connection, userIDs interface{}) {
+	list, _ := userIDs.([]interface{})
+	if len(list) > maxPresenceIDs {
+		list = list[:maxPresenceIDs]
+	}
+	watching := map[int64]bool{}
+	ids := []int64{}
+	for _, value := range list {
+		// JSON numbers are decoded as float64
+		if id, ok := value.(float64); ok && !watching[int64(id)] {
+			watching[int64(id)] = true
+			ids = append(ids, int64(id))
+		}
+	}
+
+	conn.lock.Lock()
+	conn.watching = watching
+	conn.lock.Unlock()
+
+	if len(ids) == 0 {
+		return
+	}
+	current, err := s.Presence.Get(ids)
+	if err != nil {
+		log.Printf("error getting presence for user %d: %v", conn.UserID, err)
+		return
+	}
+	for _, p := range current {
+		conn.WriteJSON(&PresenceChange{Type: messagePresenceChange, Presence: p})
+	}
+}
+
+// TrackPresence keeps the presence of this gateway's connected users up to
+// date, marking them away once they've been inactive for presence.AwayAfter,
+// and pushes presence changes from every gateway to the connections
+// watching those users. It runs until the process exits.
+func (s *SocketStore) TrackPresence() {
+	changes := s.Presence.Changes()
+	heartbeat := time.NewTicker(presence.HeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			for _, conn := range s.OpenConnections() {
+				conn.lock.Lock()
+				watching := conn.watching[change.UserID]
+				conn.lock.Unlock()
+				if watching {
+					conn.WriteJSON(&PresenceChange{Type: messagePresenceChange, Presence: change})
+				}
+			}
+
+		case <-heartbeat.C:
+			ids := []int64{}
+			for _, conn := range s.OpenConnections() {
+				ids = append(ids, conn.UserID)
+				conn.lock.Lock()
+				goneIdle := !conn.idle && time.Since(conn.lastActive) > presence.AwayAfter
+				if goneIdle {
+					conn.idle = true
+				}
+				conn.lock.Unlock()
+				if goneIdle {
+					if err := s.Presence.SetIdle(conn.UserID, true); err != nil {
+						log.Printf("error marking user %d away: %v", conn.UserID, err)
+					}
+				}
+			}
+			if err := s.Presence.Refresh(ids); err != nil {
+				log.Printf("error refreshing presence: %v", err)
+			}
+		}
+	}
+}
+
+// OpenConnections returns a snapshot of the open connections
+func (s *SocketStore) OpenConnections() []*Connection {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	list := []*Connection{}
+	for _, conns := range s.Connections {
+		for conn := range conns {
+			list = append(list, conn)
+		}
+	}
+	return list
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/presence"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/sessions"
+)
+
+func TestParsePresenceIDs(t *testing.T) {
+	ids, err := parsePresenceIDs("1, 2,,3")
+	if err != nil || len(ids) != 3 || ids[0] != 1 || ids[2] != 3 {
+		t.Errorf("incorrect IDs: %v, %v", ids, err)
+	}
+
+	tooMany := strings.Repeat("1,", maxPresenceIDs+1)
+	for _, list := range []string{"", "1,two", tooMany} {
+		if _, err := parsePresenceIDs(list); err == nil {
+			t.Errorf("expected an error for %q", list)
+		}
+	}
+}
+
+func TestHandlePresenceMessage(t *testing.T) {
+	tracker := presence.NewMemTracker()
+	store := NewSocketStore(nil, tracker)
+	conn := store.InsertConnection(nil, 1, sessions.InvalidSessionID, time.Time{})
+	tracker.Connect(1)
+
+	statusOf := func() string {
+		list, _ := tracker.Get([]int64{1})
+		return list[0].Status
+	}
+
+	if !store.handlePresenceMessage(conn, map[string]interface{}{"type": messageIdle}) {
+		t.Error("idle messages should be handled")
+	}
+	if statusOf() != presence.StatusAway {
+		t.Errorf("expected idle user to be away but got %s", statusOf())
+	}
+
+	// any other message is activity, and is left for the caller
+	if store.handlePresenceMessage(conn, map[string]interface{}{"body": "hello"}) {
+		t.Error("other messages shouldn't be handled")
+	}
+	if statusOf() != presence.StatusOnline {
+		t.Errorf("expected active user to be online but got %s", statusOf())
+	}
+}
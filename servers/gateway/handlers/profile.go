@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+)
+
+// mergePatchContentType is the media type of a JSON merge patch (RFC 7396)
+const mergePatchContentType = "application/merge-patch+json"
+
+// ProfileErrors is the response body when a profile update is invalid
+type ProfileErrors struct {
+	Errors users.ValidationErrors `json:"errors"`
+}
+
+// CurrentUserHandler handles requests for /v1/users/me. GET returns the
+// signed-in user's profile, and PATCH updates it with a JSON merge patch:
+// fields left out are unchanged, and fields set to null are cleared.
+func (h *HandlerContext) CurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, sessionState, err := h.authenticate(w, r)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	user := &sessionState.User
+
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, user)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, mergePatchContentType) && !strings.HasPrefix(contentType, "application/json") {
+		w.Header().Set("Accept-Patch", mergePatchContentType)
+		http.Error(w, "Request body must be a JSON merge patch.", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	updates, err := readMergePatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	updatedUser, err := h.UserStore.Update(user.ID, updates)
+	if err != nil {
+		if errs, ok := err.(users.ValidationErrors); ok {
+			writeJSON(w, http.StatusUnprocessableEntity, ProfileErrors{Errors: errs})
+			return
+		}
+		if err == users.ErrUserNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to update user: "+err.Error(), 500)
+		return
+	}
+	writeJSON(w, http.StatusOK, updatedUser)
+}
+
+// readMergePatch reads a merge patch of the user's profile from the request
+// body. The patch must be an object, and may only contain profile fields.
+func readMergePatch(r *http.Request) (*users.Updates, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		return nil, errors.New("Failed to properly read request body: " + err.Error())
+	}
+
+	// a patch that isn't an object would replace the whole profile
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, errors.New("Patch must be a JSON object.")
+	}
+
+	updates := &users.Updates{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(updates); err != nil {
+		return nil, errors.New("Invalid patch: " + err.Error())
+	}
+	return updates, nil
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ServiceProxy wraps a reverse proxy to a microservice. It authenticates
+// the request, by session or personal access token, and passes the user
+// along to the microservice as JSON in the X-User header.
+type ServiceProxy struct {
+	Context *HandlerContext
+	Proxy   http.Handler
+	// Scopes a personal access token needs for reading (GET, HEAD)
+	// and writing (everything else). Tokens are rejected if empty.
+	ReadScope  string
+	WriteScope string
+}
+
+func (sp *ServiceProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// never trust these from the client
+	r.Header.Del("X-User")
+	r.Header.Del("X-User-Scopes")
+
+	token, user, err := sp.Context.authenticateToken(r)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if token != nil {
+		scope := sp.WriteScope
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			scope = sp.ReadScope
+		}
+		if len(scope) == 0 || !token.HasScope(scope) {
+			http.Error(w, "Access token doesn't have the required scope.", http.StatusForbidden)
+			return
+		}
+		// let the microservice apply the same restrictions
+		r.Header.Set("X-User-Scopes", strings.Join(token.Scopes, " "))
+		// the token means nothing to the microservice
+		r.Header.Del("Authorization")
+	} else if _, sessionState, err := sp.Context.authenticate(w, r); err == nil {
+		user = &sessionState.User
+	}
+
+	if user != nil {
+		userData, err := json.Marshal(user)
+		if err != nil {
+			http.Error(w, "Problem while marshalling user data: "+err.Error(), 500)
+			return
+		}
+		r.Header.Set("X-User", string(userData))
+	}
+
+	sp.Proxy.ServeHTTP(w, r)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/sessions"
+)
+
+// refreshRequest is the body of POST /v1/sessions/refresh
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// accessTokenState verifies the access token and returns a session state
+// for its user. Verifying it doesn't look anything up, but the user is
+// still read from the user store so disabling them applies right away.
+func (h *HandlerContext) accessTokenState(accessToken string) (*SessionState, error) {
+	claims, err := h.Tokens.Verify(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	user, err := h.UserStore.GetByID(claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, users.ErrUserDisabled
+	}
+	return &SessionState{Curtime: time.Unix(claims.IssuedAt, 0), User: *user}, nil
+}
+
+// RefreshHandler handles requests for /v1/sessions/refresh. POST with
+// a refresh token in the body rotates it for a new access token and
+// refresh token. Without one, the request's session is exchanged for the
+// first pair, so clients sign in the usual way to get them, and the pair
+// is revoked when the session ends. DELETE revokes the refresh token in
+// the body, along with every token rotated from it, to sign out.
+func (h *HandlerContext) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.Tokens == nil {
+		http.Error(w, "Access tokens aren't enabled.", http.StatusNotFound)
+		return
+	}
+
+	request := &refreshRequest{}
+	if r.ContentLength != 0 {
+		if err := readJSON(r, request); err != nil {
+			readJSONError(w, err)
+			return
+		}
+	}
+
+	if r.Method == http.MethodDelete {
+		if len(request.RefreshToken) == 0 {
+			http.Error(w, "A refresh token is required.", http.StatusBadRequest)
+			return
+		}
+		if err := h.Tokens.Revoke(request.RefreshToken); err != nil {
+			if err == sessions.ErrInvalidToken {
+				http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			http.Error(w, "Failed to revoke the refresh token: "+err.Error(), 500)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if len(request.RefreshToken) == 0 {
+		h.exchangeSession(w, r)
+		return
+	}
+
+	pair, userID, err := h.Tokens.Refresh(request.RefreshToken)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	user, err := h.UserStore.GetByID(userID)
+	if err == nil && user.Disabled {
+		err = users.ErrUserDisabled
+	}
+	if err != nil {
+		h.Tokens.RevokeFamily(pair.Family)
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, pair)
+}
+
+// exchangeSession issues the first access token and refresh token for
+// the request's session, and records their family in the session so
+// it's revoked when the session ends
+func (h *HandlerContext) exchangeSession(w http.ResponseWriter, r *http.Request) {
+	sessionID, sessionState, err := h.authenticate(w, r)
+	if err == nil && sessionID == sessions.InvalidSessionID {
+		// access tokens can't be used to mint more
+		err = sessions.ErrInvalidID
+	}
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	pair, err := h.Tokens.Issue(sessionState.User.ID, sessionID, sessionState.Curtime)
+	if err != nil {
+		http.Error(w, "Failed to issue tokens: "+err.Error(), 500)
+		return
+	}
+	sessionState.RefreshFamilies = append(sessionState.RefreshFamilies, pair.Family)
+	if err := h.SessionStore.Save(sessionID, sessionState); err != nil {
+		h.Tokens.RevokeFamily(pair.Family)
+		http.Error(w, "Failed to save session: "+err.Error(), 500)
+		return
+	}
+	writeJSON(w, http.StatusOK, pair)
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// errNotJSON is returned by readJSON when the request body isn't JSON
+var errNotJSON = errors.New("Request body must be in JSON.")
+
+// readJSON checks that the request body is JSON and unmarshals it into `v`
+func readJSON(r *http.Request, v interface{}) error {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return errNotJSON
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		return errors.New("Failed to properly read request body: " + err.Error())
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return errors.New("Failed to unmarshal request body into a struct: " + err.Error())
+	}
+	return nil
+}
+
+// readJSONError writes the appropriate error response for an error from readJSON
+func readJSONError(w http.ResponseWriter, err error) {
+	if err == errNotJSON {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// writeJSON marshals `v` and writes it to the response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	myjson, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Failed to properly marshal object to a JSON format: "+err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(myjson)
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/sessions"
+)
+
+// otherSessions is the path segment that revokes every session but the current one
+const otherSessions = "others"
+
+// SessionInfo is one of the user's sessions, as listed by GET /v1/sessions
+type SessionInfo struct {
+	*sessions.Metadata
+	// Current is true for the session making the request
+	Current bool `json:"current"`
+}
+
+// userAgentPlatforms and userAgentBrowsers are the names shown for devices,
+// checked in order since user agents mention several of them
+var userAgentPlatforms = []struct{ token, name string }{
+	{"iPhone", "iPhone"}, {"iPad", "iPad"}, {"Android", "Android"}, {"CrOS", "Chrome OS"},
+	{"Mac OS X", "macOS"}, {"Windows", "Windows"}, {"Linux", "Linux"},
+}
+var userAgentBrowsers = []struct{ token, name string }{
+	{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"},
+	{"Safari/", "Safari"}, {"curl/", "curl"},
+}
+
+// deviceName returns a name for the device a user agent belongs to,
+// such as "Firefox on Windows", so users can recognize their sessions
+func deviceName(userAgent string) string {
+	browser, platform := "", ""
+	for _, b := range userAgentBrowsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, p := range userAgentPlatforms {
+		if strings.Contains(userAgent, p.token) {
+			platform = p.name
+			break
+		}
+	}
+	if len(browser) > 0 && len(platform) > 0 {
+		return browser + " on " + platform
+	}
+	if len(browser)+len(platform) > 0 {
+		return browser + platform
+	}
+	return "Unknown device"
+}
+
+// clientIP returns the IP address of the client. The gateway terminates
+// TLS itself, so there's no proxy in front of it to trust headers from.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// beginSession begins a new session for the state, and records where it
+// was signed in from so the user can find it in their list of sessions
+func (h *HandlerContext) beginSession(w http.ResponseWriter, r *http.Request, state SessionState) (sessions.SessionID, error) {
+	sid, err := h.Transport.BeginSession(h.Keyring, h.SessionStore, state, w)
+	if err != nil {
+		return sid, err
+	}
+	return sid, h.trackSession(r, sid, &state)
+}
+
+// trackSession saves the metadata of a session begun by the request.
+// Sessions waiting on a two-factor code aren't listed, since they're
+// replaced as soon as the code is checked.
+func (h *HandlerContext) trackSession(r *http.Request, sid sessions.SessionID, state *SessionState) error {
+	if state.MFAPending {
+		return nil
+	}
+	now := time.Now().UTC()
+	return h.SessionStore.SaveMetadata(sid, &sessions.Metadata{
+		UserID:     state.User.ID,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		IP:         clientIP(r),
+		UserAgent:  r.UserAgent(),
+		DeviceName: deviceName(r.UserAgent()),
+	})
+}
+
+// endSession deletes the session, revokes the refresh tokens issued from
+// it, and tells every gateway how it ended, so they close the websockets
+// opened with it
+func (h *HandlerContext) endSession(sid sessions.SessionID, eventType sessions.EventType) error {
+	if h.Tokens != nil {
+		// the state may have expired, taking the family IDs with it,
+		// but then the families have reached their maximum lifetime too
+		state := &SessionState{}
+		if err := h.SessionStore.Get(sid, state); err == nil {
+			for _, family := range state.RefreshFamilies {
+				if err := h.Tokens.RevokeFamily(family); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := h.SessionStore.Delete(sid); err != nil {
+		return err
+	}
+	h.publishSessionEvent(eventType, sid)
+	return nil
+}
+
+// endUserSessions ends every one of the user's sessions and revokes
+// their refresh token families, so they're signed out everywhere now
+// rather than the next time each session is used.
+func (h *HandlerContext) endUserSessions(userID int64) error {
+	list, err := h.SessionStore.Sessions(userID)
+	if err != nil {
+		return err
+	}
+	for _, meta := range list {
+		if err := h.endSession(meta.SessionID, sessions.EventRevoked); err != nil {
+			return err
+		}
+	}
+	if h.Tokens != nil {
+		return h.Tokens.RevokeUser(userID)
+	}
+	return nil
+}
+
+// publishSessionEvent tells every gateway that the session ended.
+// Gateways that miss it close the session's websockets once they find
+// it's gone, so failing to publish doesn't fail the request.
+func (h *HandlerContext) publishSessionEvent(eventType sessions.EventType, sid sessions.SessionID) {
+	if h.SessionEvents == nil {
+		return
+	}
+	if err := h.SessionEvents.Publish(sessions.NewEvent(eventType, sid)); err != nil {
+		log.Printf("error publishing session event: %v", err)
+	}
+}
+
+// listSessions handles GET requests for /v1/sessions,
+// listing the user's sessions, most recently used first
+func (h *HandlerContext) listSessions(w http.ResponseWriter, r *http.Request) {
+	sessionID, sessionState, err := h.authenticate(w, r)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	list, err := h.SessionStore.Sessions(sessionState.User.ID)
+	if err != nil {
+		http.Error(w, "Failed to get sessions: "+err.Error(), 500)
+		return
+	}
+	if _, err := sessions.FindSession(list, sessionID.PublicID()); err != nil && sessionID != sessions.InvalidSessionID {
+		// sessions begun before they were tracked are added when they're first listed
+		if err := h.trackSession(r, sessionID, sessionState); err != nil {
+			http.Error(w, "Failed to save session: "+err.Error(), 500)
+			return
+		}
+		if list, err = h.SessionStore.Sessions(sessionState.User.ID); err != nil {
+			http.Error(w, "Failed to get sessions: "+err.Error(), 500)
+			return
+		}
+	}
+
+	infos := make([]*SessionInfo, len(list))
+	for i, meta := range list {
+		infos[i] = &SessionInfo{Metadata: meta, Current: meta.ID == sessionID.PublicID()}
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+// SpecificSessionHandler handles DELETE requests for /v1/sessions/{id}.
+// "mine" signs out of the current session, "others" signs out of every
+// other session, and any other ID signs out of that one of the user's sessions.
+func (h *HandlerContext) SpecificSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := path.Base(r.URL.Path)
+	if id == "mine" {
+		// sessions waiting on a two-factor code can sign out too
+		sessionID, err := h.getSessionState(r, &SessionState{})
+		if err != nil {
+			http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err := h.endSession(sessionID, sessions.EventDeleted); err != nil {
+			http.Error(w, "Something went wrong while deleting the session: "+err.Error(), 500)
+			return
+		}
+		h.Transport.ClearCookies(w)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("signed out"))
+		return
+	}
+
+	sessionID, sessionState, err := h.authenticate(w, r)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	list, err := h.SessionStore.Sessions(sessionState.User.ID)
+	if err != nil {
+		http.Error(w, "Failed to get sessions: "+err.Error(), 500)
+		return
+	}
+
+	revoke := []*sessions.Metadata{}
+	if id == otherSessions {
+		for _, meta := range list {
+			if meta.ID != sessionID.PublicID() {
+				revoke = append(revoke, meta)
+			}
+		}
+	} else {
+		// other users' sessions look the same as ones that don't exist
+		meta, err := sessions.FindSession(list, id)
+		if err != nil {
+			http.Error(w, "Session not found.", http.StatusNotFound)
+			return
+		}
+		revoke = append(revoke, meta)
+	}
+
+	for _, meta := range revoke {
+		if err := h.endSession(meta.SessionID, sessions.EventRevoked); err != nil {
+			http.Error(w, "Something went wrong while deleting the session: "+err.Error(), 500)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
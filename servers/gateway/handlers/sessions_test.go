@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/sessions"
+)
+
+func TestDeviceName(t *testing.T) {
+	cases := []struct {
+		userAgent string
+		expected  string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:72.0) Gecko/20100101 Firefox/72.0", "Firefox on Windows"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_3) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.0.5 Safari/605.1.15", "Safari on macOS"},
+		{"Mozilla/5.0 (Linux; Android 10; Pixel 3) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/80.0.3987.99 Mobile Safari/537.36", "Chrome on Android"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/80.0.3987.106 Safari/537.36 Edg/80.0.361.54", "Edge on Windows"},
+		{"curl/7.68.0", "curl"},
+		{"", "Unknown device"},
+	}
+	for _, c := range cases {
+		if name := deviceName(c.userAgent); name != c.expected {
+			t.Errorf("expected %q for %q but got %q", c.expected, c.userAgent, name)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/v1/sessions", nil)
+	r.RemoteAddr = "[2001:db8::1]:52000"
+	if ip := clientIP(r); ip != "2001:db8::1" {
+		t.Errorf("incorrect IP address: %s", ip)
+	}
+}
+
+func TestEndUserSessions(t *testing.T) {
+	keyring := sessions.SingleKey("signing key")
+	store := sessions.NewMemStore(time.Hour, time.Minute)
+	h := &HandlerContext{
+		Keyring:       keyring,
+		SessionStore:  store,
+		SessionEvents: sessions.NewMemEvents(),
+		Tokens:        sessions.NewTokenService(keyring, sessions.NewStoreFamilies(sessions.NewMemStore(time.Hour, time.Minute)), time.Minute, time.Hour, 24*time.Hour),
+	}
+	events := h.SessionEvents.Subscribe()
+
+	// the user is signed in twice, once with a refresh token
+	refreshTokens := []string{}
+	for i := 0; i < 2; i++ {
+		sid, _ := keyring.NewSessionID()
+		pair, err := h.Tokens.Issue(1, sid, time.Now())
+		if err != nil {
+			t.Fatalf("error issuing tokens: %v", err)
+		}
+		refreshTokens = append(refreshTokens, pair.RefreshToken)
+		store.Save(sid, &SessionState{User: users.User{ID: 1}, RefreshFamilies: []string{pair.Family}})
+		store.SaveMetadata(sid, &sessions.Metadata{UserID: 1})
+	}
+
+	if err := h.endUserSessions(1); err != nil {
+		t.Fatalf("error ending sessions: %v", err)
+	}
+	if list, err := store.Sessions(1); err != nil || len(list) != 0 {
+		t.Errorf("expected no sessions but got %d: %v", len(list), err)
+	}
+	for _, token := range refreshTokens {
+		if _, _, err := h.Tokens.Refresh(token); err == nil {
+			t.Error("expected the refresh token to be revoked")
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if event := <-events; event.Type != sessions.EventRevoked {
+			t.Errorf("expected a %s event but got %s", sessions.EventRevoked, event.Type)
+		}
+	}
+}
@@ -12,4 +12,11 @@ import (
 type SessionState struct {
 	Curtime time.Time
 	User    users.User
+	// MFAPending is true for sessions that have passed the password
+	// check but haven't supplied a valid two-factor code yet.
+	// These sessions may only be used to finish signing in.
+	MFAPending bool
+	// RefreshFamilies are the IDs of the refresh token families
+	// issued from the session, which are revoked when it ends.
+	RefreshFamilies []string
 }
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+)
+
+// AccessTokensHandler handles requests for /v1/users/me/tokens.
+// GET lists the current user's personal access tokens, and POST creates
+// a new one, responding with the plaintext token the only time it's shown.
+func (h *HandlerContext) AccessTokensHandler(w http.ResponseWriter, r *http.Request) {
+	// tokens can only be managed from a signed in session,
+	// so a leaked token can't be used to mint more
+	_, sessionState, err := h.authenticate(w, r)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	userID := sessionState.User.ID
+
+	if r.Method == http.MethodGet {
+		tokens, err := h.UserStore.GetAccessTokens(userID)
+		if err != nil {
+			http.Error(w, "Failed to get access tokens: "+err.Error(), 500)
+			return
+		}
+		writeJSON(w, http.StatusOK, tokens)
+
+	} else if r.Method == http.MethodPost {
+		var newToken users.NewAccessToken
+		if err := readJSON(r, &newToken); err != nil {
+			readJSONError(w, err)
+			return
+		}
+
+		token, plaintext, err := newToken.ToAccessToken(userID)
+		if err != nil {
+			http.Error(w, "Failed to validate access token: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		insertedToken, err := h.UserStore.InsertAccessToken(token)
+		if err != nil {
+			http.Error(w, "Failed to insert access token: "+err.Error(), 500)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, struct {
+			*users.AccessToken
+			Token string `json:"token"`
+		}{insertedToken, plaintext})
+
+	} else {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// SpecificAccessTokenHandler handles requests for /v1/users/me/tokens/{id}.
+// DELETE revokes the token.
+func (h *HandlerContext) SpecificAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	_, sessionState, err := h.authenticate(w, r)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tokenID, err := strconv.ParseInt(path.Base(r.URL.Path), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token ID.", http.StatusBadRequest)
+		return
+	}
+	if err := h.UserStore.DeleteAccessToken(sessionState.User.ID, tokenID); err != nil {
+		if err == users.ErrAccessTokenNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to revoke access token: "+err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("token revoked"))
+}
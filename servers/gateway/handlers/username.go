@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+)
+
+// byUserNamePathPrefix is the path users are looked up by username from
+const byUserNamePathPrefix = "/v1/users/by-username/"
+
+// UserNameChange is the body of a request to change the user's username
+type UserNameChange struct {
+	UserName string `json:"userName"`
+}
+
+// UserNameHandler handles requests for /v1/users/me/username.
+// PUT changes the signed-in user's username.
+func (h *HandlerContext) UserNameHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, sessionState, err := h.authenticate(w, r)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	change := UserNameChange{}
+	if err := readJSON(r, &change); err != nil {
+		readJSONError(w, err)
+		return
+	}
+
+	if err := users.ValidateUserName(change.UserName); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.UserStore.ChangeUserName(sessionState.User.ID, change.UserName)
+	if cooldown, ok := err.(*users.UserNameCooldownError); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(cooldown.RetryAt).Seconds())+1))
+		http.Error(w, cooldown.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err == users.ErrUserNameTaken {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to change username: "+err.Error(), 500)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+// UserByNameHandler handles requests for /v1/users/by-username/{userName}.
+// GET returns the user with that username. If the username was changed
+// recently, it redirects to the user's new username instead.
+func (h *HandlerContext) UserByNameHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, _, err := h.authenticate(w, r); err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	userName := strings.TrimPrefix(r.URL.Path, byUserNamePathPrefix)
+	if len(userName) == 0 || strings.Contains(userName, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	user, err := h.UserStore.GetByUserName(userName)
+	if err != nil {
+		http.Error(w, "Failed to get user: "+err.Error(), 500)
+		return
+	}
+	if user != nil && !user.Disabled {
+		writeJSON(w, http.StatusOK, user)
+		return
+	}
+
+	// the old username might still redirect to the new one
+	previous, err := h.UserStore.GetByPreviousUserName(userName)
+	if err == users.ErrUserNotFound || (err == nil && previous.Disabled) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get user: "+err.Error(), 500)
+		return
+	}
+	// not permanent, since someone else may take the old name after the grace period
+	http.Redirect(w, r, byUserNamePathPrefix+url.PathEscape(previous.UserName), http.StatusFound)
+}
@@ -4,47 +4,322 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"reflect"
+	"net/url"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/presence"
 	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/sessions"
 	"github.com/gorilla/websocket"
 	"github.com/streadway/amqp"
 )
 
+// blockCacheLifetime is how long a connection trusts its cached block
+// list. Blocks made through this gateway update it right away; this
+// bounds how long blocks made through other gateways take to apply.
+const blockCacheLifetime = 5 * time.Minute
+
+// sessionCheckInterval is how often the sessions of open websockets
+// are checked, which bounds how long a websocket outlives a session
+// that ended without an event, such as one that reached its
+// maximum lifetime without being used
+const sessionCheckInterval = time.Minute
+
+// closeTimeout is how long closing a websocket waits to send the close message
+const closeTimeout = time.Second
+
+// Close codes sent to websockets when their session ends, from the
+// range reserved for applications
+const (
+	CloseSignedOut      = 4001
+	CloseSessionExpired = 4002
+	CloseSessionRevoked = 4003
+)
+
+// sessionCloseCodes are the close codes for each way a session ends
+var sessionCloseCodes = map[sessions.EventType]int{
+	sessions.EventDeleted: CloseSignedOut,
+	sessions.EventExpired: CloseSessionExpired,
+	sessions.EventRevoked: CloseSessionRevoked,
+}
+
 // A simple store to store all the connections
 type SocketStore struct {
-	Connections map[int64]*websocket.Conn
-	RedisStore  sessions.RedisStore
-	lock        sync.Mutex
+	// The open connections of each user, who may have several,
+	// such as from a browser tab and a phone
+	Connections map[int64]map[*Connection]bool
+	// The connections opened with each session, keyed by the session's
+	// public ID, or "" for connections opened with an access token
+	sessions map[string]map[*Connection]bool
+	// Where the block lists of connected users are loaded from
+	UserStore *users.SQLStore
+	// Tracks whether connected users are online or away
+	Presence *presence.Tracker
+	lock     sync.Mutex
+}
+
+// Connection is a user's websocket, along with the users they've blocked,
+// cached so broadcasts don't query the database for every event
+type Connection struct {
+	*websocket.Conn
+	UserID int64
+	// the session the websocket was opened with, or InvalidSessionID
+	// if it was opened with an access token, in which case it's
+	// closed when the token expires
+	SessionID sessions.SessionID
+	expires   time.Time
+
+	blocked  map[int64]bool
+	loadedAt time.Time
+	// when the client last did anything, whether it's been marked
+	// idle, and whose presence changes it wants to hear about
+	lastActive time.Time
+	idle       bool
+	watching   map[int64]bool
+	lock       sync.Mutex
+	// gorilla connections support only one concurrent writer
+	writeLock sync.Mutex
+}
+
+// WriteJSON writes `v` to the websocket as JSON. It's safe to call
+// from the broadcaster and the presence updates at the same time.
+func (c *Connection) WriteJSON(v interface{}) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.Conn.WriteJSON(v)
 }
 
 // Constructs a new socketstore
-func NewSocketStore() *SocketStore {
+func NewSocketStore(userStore *users.SQLStore, tracker *presence.Tracker) *SocketStore {
 	//initialize and return a new RedisStore struct
 	mySocketStore := SocketStore{
-		Connections: map[int64]*websocket.Conn{},
+		Connections: map[int64]map[*Connection]bool{},
+		sessions:    map[string]map[*Connection]bool{},
+		UserStore:   userStore,
+		Presence:    tracker,
 	}
 
 	return &mySocketStore
 }
 
-// Thread-safe method for inserting a connection
-func (s *SocketStore) InsertConnection(conn *websocket.Conn, userid int64) {
+// Thread-safe method for inserting a connection opened with the
+// session, or with an access token that expires at `expires`
+func (s *SocketStore) InsertConnection(conn *websocket.Conn, userid int64, sid sessions.SessionID, expires time.Time) *Connection {
+	connection := &Connection{Conn: conn, UserID: userid, SessionID: sid, expires: expires, lastActive: time.Now()}
+	key := sessionKey(sid)
 	s.lock.Lock()
 	// insert socket connection
-	s.Connections[userid] = conn
+	if s.Connections[userid] == nil {
+		s.Connections[userid] = map[*Connection]bool{}
+	}
+	s.Connections[userid][connection] = true
+	if s.sessions[key] == nil {
+		s.sessions[key] = map[*Connection]bool{}
+	}
+	s.sessions[key][connection] = true
 	s.lock.Unlock()
+	return connection
+}
+
+// UserConnections returns a snapshot of the user's connections
+// to this gateway
+func (s *SocketStore) UserConnections(userID int64) []*Connection {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	list := make([]*Connection, 0, len(s.Connections[userID]))
+	for conn := range s.Connections[userID] {
+		list = append(list, conn)
+	}
+	return list
+}
+
+// sessionKey returns the key of the session's connections
+func sessionKey(sid sessions.SessionID) string {
+	if sid == sessions.InvalidSessionID {
+		return ""
+	}
+	return sid.PublicID()
+}
+
+// Blocks returns true if the connected user has blocked the given user,
+// reloading the connection's block list when it's out of date. If the
+// list can't be loaded, the previous one is kept.
+func (s *SocketStore) Blocks(conn *Connection, userID int64) bool {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if conn.blocked == nil || time.Since(conn.loadedAt) > blockCacheLifetime {
+		ids, err := s.UserStore.GetBlocked(conn.UserID)
+		if err != nil {
+			log.Printf("error loading blocks of user %d: %v", conn.UserID, err)
+		} else {
+			conn.blocked = map[int64]bool{}
+			for _, id := range ids {
+				conn.blocked[id] = true
+			}
+		}
+		// don't retry a failing load for every event
+		conn.loadedAt = time.Now()
+	}
+	return conn.blocked[userID]
+}
+
+// SetBlocked updates the cached block lists of the user's connections
+// to this gateway
+func (s *SocketStore) SetBlocked(userID int64, blockedID int64, blocked bool) {
+	for _, conn := range s.UserConnections(userID) {
+		conn.setBlocked(blockedID, blocked)
+	}
+}
+
+// setBlocked updates the connection's cached block list
+func (c *Connection) setBlocked(blockedID int64, blocked bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// an unloaded list will be loaded with the change included
+	if c.blocked == nil {
+		return
+	}
+	if blocked {
+		c.blocked[blockedID] = true
+	} else {
+		delete(c.blocked, blockedID)
+	}
 }
 
-// Thread-safe method for inserting a connection
-func (s *SocketStore) RemoveConnection(userid int64) {
+// RemoveConnection removes the closed connection from the store, so
+// events and session checks skip it. Thread-safe. Any other connections
+// of its user or its session are left alone.
+func (s *SocketStore) RemoveConnection(conn *Connection) {
+	key := sessionKey(conn.SessionID)
 	s.lock.Lock()
 	// remove socket connection
-	delete(s.Connections, userid)
+	delete(s.Connections[conn.UserID], conn)
+	if len(s.Connections[conn.UserID]) == 0 {
+		delete(s.Connections, conn.UserID)
+	}
+	delete(s.sessions[key], conn)
+	if len(s.sessions[key]) == 0 {
+		delete(s.sessions, key)
+	}
 	s.lock.Unlock()
 }
 
+// CloseSession closes the connections opened with the event's session,
+// telling clients how it ended. Their read loops remove them.
+func (s *SocketStore) CloseSession(event *sessions.Event) {
+	s.lock.Lock()
+	conns := []*Connection{}
+	for conn := range s.sessions[event.ID] {
+		conns = append(conns, conn)
+	}
+	s.lock.Unlock()
+	for _, conn := range conns {
+		conn.closeSession(event.Type)
+	}
+}
+
+// closeSession sends the close code for how the connection's session
+// ended and closes it
+func (c *Connection) closeSession(eventType sessions.EventType) {
+	message := websocket.FormatCloseMessage(sessionCloseCodes[eventType], "session "+string(eventType))
+	c.Conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(closeTimeout))
+	c.Conn.Close()
+}
+
+// WatchSessions closes websockets when their session ends: right away
+// when any gateway publishes that it was deleted, revoked or expired,
+// and otherwise once it's found to be gone or its user is found to be
+// disabled. An open websocket doesn't count as using its session, so
+// the session still goes idle unless the client makes requests with it.
+// It runs until the process exits.
+func (s *SocketStore) WatchSessions(store sessions.Store, events sessions.Events) {
+	ended := events.Subscribe()
+	check := time.NewTicker(sessionCheckInterval)
+	defer check.Stop()
+	for {
+		select {
+		case event, ok := <-ended:
+			if !ok {
+				return
+			}
+			s.CloseSession(event)
+		case <-check.C:
+			s.checkSessions(store)
+		}
+	}
+}
+
+// checkSessions closes the connections whose session has ended, whose
+// access token has expired, or whose user has been disabled. It only
+// checks that sessions exist, so it doesn't keep them from going idle.
+func (s *SocketStore) checkSessions(store sessions.Store) {
+	s.lock.Lock()
+	bySession := map[string][]*Connection{}
+	for key, conns := range s.sessions {
+		for conn := range conns {
+			bySession[key] = append(bySession[key], conn)
+		}
+	}
+	s.lock.Unlock()
+
+	disabled := s.disabledUsers(bySession)
+	for key, conns := range bySession {
+		open := []*Connection{}
+		for _, conn := range conns {
+			if disabled[conn.UserID] {
+				conn.closeSession(sessions.EventRevoked)
+			} else {
+				open = append(open, conn)
+			}
+		}
+		if len(key) == 0 {
+			for _, conn := range open {
+				if time.Now().After(conn.expires) {
+					conn.closeSession(sessions.EventExpired)
+				}
+			}
+			continue
+		}
+		if len(open) == 0 {
+			continue
+		}
+		if _, err := store.Expiry(open[0].SessionID); err != sessions.ErrStateNotFound {
+			// the session is still going, or the store is unavailable
+			continue
+		}
+		for _, conn := range open {
+			conn.closeSession(sessions.EventExpired)
+		}
+	}
+}
+
+// disabledUsers returns which of the connected users have been
+// disabled. Users who can't be loaded are left connected.
+func (s *SocketStore) disabledUsers(bySession map[string][]*Connection) map[int64]bool {
+	disabled := map[int64]bool{}
+	if s.UserStore == nil {
+		return disabled
+	}
+	checked := map[int64]bool{}
+	for _, conns := range bySession {
+		for _, conn := range conns {
+			if checked[conn.UserID] {
+				continue
+			}
+			checked[conn.UserID] = true
+			user, err := s.UserStore.GetByID(conn.UserID)
+			if err != nil {
+				log.Printf("error loading user %d: %v", conn.UserID, err)
+				continue
+			}
+			disabled[conn.UserID] = user.Disabled
+		}
+	}
+	return disabled
+}
+
 //TODO: add a handler that upgrades clients to a WebSocket connection
 //and adds that to a list of WebSockets to notify when events are
 //read from the RabbitMQ server. Remember to synchronize changes
@@ -57,61 +332,110 @@ func (s *SocketStore) RemoveConnection(userid int64) {
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		// This function's purpose is to reject websocket upgrade requests if the
-		// origin of the websockete handshake request is coming from unknown domains.
-		// This prevents some random domain from opening up a socket with your server.
-		// TODO: make sure you modify this for your HW to check if r.Origin is your host
+}
+
+// checkOrigin reports whether a websocket handshake from the request's
+// origin is allowed. Browsers send cookies with handshakes from any site,
+// so when sessions are in cookies, only the gateway's own origin and the
+// ones in CORSORIGINS can open websockets. Clients that aren't browsers
+// don't send an Origin, and can't be made to by other sites.
+func (h *HandlerContext) checkOrigin(r *http.Request) bool {
+	if h.Transport == nil || !h.Transport.Cookie {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
 		return true
-	},
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range h.AllowedOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
 }
 
-func (s *SocketStore) WebSocketConnectionHandler(w http.ResponseWriter, r *http.Request) {
+// WebSocketConnectionHandler upgrades authenticated requests to a
+// websocket, which is closed when the session it was opened with ends.
+// Browsers can't set headers on websockets, so the credential can be
+// in the auth query parameter instead of the Authorization header,
+// unless sessions are in cookies, which browsers send instead. Query
+// strings end up in logs, so there's no reason to risk it then.
+func (h *HandlerContext) WebSocketConnectionHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.checkOrigin(r) {
+		http.Error(w, "Websockets can't be opened from that origin.", http.StatusForbidden)
+		return
+	}
+
 	// handle the websocket handshake
 	// get auth query string parameter, but only if there isn't a authorization header
-	sessionToken := r.Header.Get("Authorization")
-	if sessionToken == "" { // header was not set
-		sessionToken = r.URL.Query().Get("auth")
+	if auth := r.URL.Query().Get("auth"); len(auth) > 0 {
+		if h.Transport != nil && h.Transport.Cookie {
+			http.Error(w, "The auth parameter can't be used when sessions are in cookies.", http.StatusBadRequest)
+			return
+		}
+		if len(r.Header.Get("Authorization")) == 0 {
+			r.Header.Set("Authorization", "Bearer "+strings.TrimPrefix(auth, "Bearer "))
+		}
 	}
-
-	// TODO: use token to get session state
-	sessionState := SessionState{}
-	sessionid := sessions.SessionID(sessionToken)
-	s.RedisStore.Get(sessionid, sessionState)
-	if reflect.DeepEqual(sessionState, SessionState{}) { // if sessionState is empty
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	sessionID, sessionState, err := h.authenticate(w, r)
+	if err != nil {
+		http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	// access tokens can't be revoked, so their websockets last until they expire
+	var expires time.Time
+	if sessionID == sessions.InvalidSessionID {
+		claims, err := h.Tokens.Verify(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if err != nil {
+			http.Error(w, "You're not authorized to do that: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		expires = time.Unix(claims.ExpiresAt, 0)
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// the upgrader writes its own error response
+	checked := upgrader
+	checked.CheckOrigin = h.checkOrigin
+	conn, err := checked.Upgrade(w, r, nil)
 	if err != nil {
-		http.Error(w, "Failed to open websocket connection", 401)
+		return
 	}
 
-	// do something with connection
-	// like probably add it to some collection
-	// start a goroutine on the connection to read incoming messages
 	// for each new websocket, start a goroutine to read incoming messages
 	// if you run into an error while reading incoming messages, close the websocket and remove it from the list
-
-	s.InsertConnection(conn, sessionState.User.ID)
-	go s.read(conn, sessionState.User.ID)
-
+	s := h.Sockets
+	connection := s.InsertConnection(conn, sessionState.User.ID, sessionID, expires)
+	if err := s.Presence.Connect(sessionState.User.ID); err != nil {
+		log.Printf("error marking user %d online: %v", sessionState.User.ID, err)
+	}
+	go s.read(connection, sessionState.User.ID)
 }
 
-func (s *SocketStore) read(conn *websocket.Conn, userid int64) {
+func (s *SocketStore) read(conn *Connection, userid int64) {
 	for { // infinite loop
 		var m map[string]interface{}
 
 		err := conn.ReadJSON(&m)
 		if err != nil {
 			fmt.Println("Error reading json.", err)
-			s.RemoveConnection(userid)
+			s.RemoveConnection(conn)
+			if err := s.Presence.Disconnect(userid); err != nil {
+				log.Printf("error marking user %d disconnected: %v", userid, err)
+			}
 			conn.Close()
 			break
 		}
 
 		fmt.Printf("Got message: %#v\n", m)
 
+		// presence signals are handled here; anything else is echoed
+		if s.handlePresenceMessage(conn, m) {
+			continue
+		}
 		if err = conn.WriteJSON(m); err != nil {
 			fmt.Println(err)
 		}
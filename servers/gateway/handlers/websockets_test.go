@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/indexes"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/presence"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/sessions"
+	"github.com/gorilla/websocket"
+)
+
+func TestSocketStoreBlocks(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	store := NewSocketStore(users.NewSQLStore(db, indexes.NewTrieNode()), presence.NewMemTracker())
+	conn := store.InsertConnection(nil, 1, sessions.InvalidSessionID, time.Time{})
+
+	// the block list is loaded once, then served from the cache
+	mock.ExpectQuery(regexp.QuoteMeta("select BlockedID from BLOCKS where BlockerID = ?")).
+		WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"BlockedID"}).AddRow(2))
+	if !store.Blocks(conn, 2) {
+		t.Error("expected user 2 to be blocked")
+	}
+	if store.Blocks(conn, 3) {
+		t.Error("expected user 3 not to be blocked")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expected the block list to be loaded once: %v", err)
+	}
+
+	// blocks made through this gateway update the cache
+	store.SetBlocked(1, 3, true)
+	store.SetBlocked(1, 2, false)
+	if store.Blocks(conn, 2) || !store.Blocks(conn, 3) {
+		t.Error("cached block list wasn't updated")
+	}
+
+	// users who aren't connected are ignored
+	store.SetBlocked(4, 2, true)
+}
+
+func TestSocketStoreUserConnections(t *testing.T) {
+	store := NewSocketStore(nil, presence.NewMemTracker())
+	phone := store.InsertConnection(nil, 1, sessions.InvalidSessionID, time.Time{})
+	laptop := store.InsertConnection(nil, 1, sessions.InvalidSessionID, time.Time{})
+	other := store.InsertConnection(nil, 2, sessions.InvalidSessionID, time.Time{})
+	for _, conn := range []*Connection{phone, laptop, other} {
+		conn.blocked = map[int64]bool{}
+	}
+
+	// a user's second websocket doesn't replace the first
+	if conns := store.UserConnections(1); len(conns) != 2 {
+		t.Fatalf("expected both of the user's connections but got %d", len(conns))
+	}
+	if conns := store.OpenConnections(); len(conns) != 3 {
+		t.Errorf("expected 3 open connections but got %d", len(conns))
+	}
+
+	// blocks update each of the user's connections
+	store.SetBlocked(1, 3, true)
+	if !phone.blocked[3] || !laptop.blocked[3] || other.blocked[3] {
+		t.Error("expected the block to apply to both of the user's connections only")
+	}
+
+	// closing one leaves the other
+	store.RemoveConnection(phone)
+	if conns := store.UserConnections(1); len(conns) != 1 || conns[0] != laptop {
+		t.Errorf("expected the other connection to be kept but got %v", conns)
+	}
+	store.RemoveConnection(laptop)
+	if _, ok := store.Connections[1]; ok {
+		t.Error("expected a user without connections to be removed")
+	}
+}
+
+// openTestSocket opens a websocket to a test server, adds the server's
+// end to the store with the session, and returns the client's end
+func openTestSocket(t *testing.T, store *SocketStore, userID int64, sid sessions.SessionID, expires time.Time) *websocket.Conn {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("error upgrading: %v", err)
+			return
+		}
+		go store.read(store.InsertConnection(conn, userID, sid, expires), userID)
+	}))
+	t.Cleanup(server.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// closeCode waits for the websocket to be closed and returns its close code
+func closeCode(t *testing.T, client *websocket.Conn) int {
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, _, err := client.ReadMessage(); err != nil {
+			if closeErr, ok := err.(*websocket.CloseError); ok {
+				return closeErr.Code
+			}
+			t.Fatalf("expected a close message but got %v", err)
+		}
+	}
+}
+
+func TestSocketStoreCloseSession(t *testing.T) {
+	store := NewSocketStore(nil, presence.NewMemTracker())
+	sid, _ := sessions.NewSessionID("test key")
+	other, _ := sessions.NewSessionID("test key")
+	first := openTestSocket(t, store, 1, sid, time.Time{})
+	second := openTestSocket(t, store, 1, sid, time.Time{})
+	kept := openTestSocket(t, store, 2, other, time.Time{})
+
+	store.CloseSession(sessions.NewEvent(sessions.EventRevoked, sid))
+	for _, client := range []*websocket.Conn{first, second} {
+		if code := closeCode(t, client); code != CloseSessionRevoked {
+			t.Errorf("expected close code %d but got %d", CloseSessionRevoked, code)
+		}
+	}
+
+	// the other session's websocket stays open
+	if err := kept.WriteJSON(map[string]string{"body": "hello"}); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	kept.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := kept.ReadMessage(); err != nil {
+		t.Errorf("expected the other session's websocket to stay open: %v", err)
+	}
+
+	// closed websockets are removed once their read loop ends
+	deadline := time.Now().Add(time.Second)
+	for {
+		store.lock.Lock()
+		remaining := len(store.sessions[sid.PublicID()])
+		store.lock.Unlock()
+		if remaining == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the closed websockets to be removed but %d remain", remaining)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSocketStoreCheckSessions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := NewSocketStore(users.NewSQLStore(db, indexes.NewTrieNode()), presence.NewMemTracker())
+	sessionStore := sessions.NewMemStore(time.Hour, time.Minute)
+	live, _ := sessions.NewSessionID("test key")
+	gone, _ := sessions.NewSessionID("test key")
+	disabled, _ := sessions.NewSessionID("test key")
+	sessionStore.Save(live, &SessionState{})
+	sessionStore.Save(disabled, &SessionState{})
+	liveClient := openTestSocket(t, store, 1, live, time.Time{})
+	goneClient := openTestSocket(t, store, 2, gone, time.Time{})
+	tokenClient := openTestSocket(t, store, 3, sessions.InvalidSessionID, time.Now().Add(-time.Second))
+	disabledClient := openTestSocket(t, store, 4, disabled, time.Time{})
+	// wait for the connections to be inserted
+	deadline := time.Now().Add(time.Second)
+	for {
+		store.lock.Lock()
+		connected := len(store.Connections)
+		store.lock.Unlock()
+		if connected == 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 4 connections but got %d", connected)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// each connected user is loaded to see if they've been disabled
+	mock.MatchExpectationsInOrder(false)
+	columns := []string{"id", "Email", "PassHash", "UserName", "FirstName", "LastName", "PhotoURL", "Role", "Disabled",
+		"AvatarSource", "DisplayName", "Bio", "Title", "Pronouns", "Timezone", "StatusText", "EmailVerified", "CreatedAt"}
+	for id := int64(1); id <= 4; id++ {
+		mock.ExpectQuery(regexp.QuoteMeta(" from USERS where id = ?")).WithArgs(id).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(id, "", []byte{}, "", "", "", "", users.RoleUser, id == 4,
+				"", "", "", "", "", "", "", false, time.Now()))
+	}
+
+	// checking doesn't count as using the session
+	expires, _ := sessionStore.Expiry(live)
+	time.Sleep(10 * time.Millisecond)
+	store.checkSessions(sessionStore)
+	if after, _ := sessionStore.Expiry(live); !after.Equal(expires) {
+		t.Errorf("expected the session's expiry to stay %v but got %v", expires, after)
+	}
+
+	for _, client := range []*websocket.Conn{goneClient, tokenClient} {
+		if code := closeCode(t, client); code != CloseSessionExpired {
+			t.Errorf("expected close code %d but got %d", CloseSessionExpired, code)
+		}
+	}
+	if code := closeCode(t, disabledClient); code != CloseSessionRevoked {
+		t.Errorf("expected close code %d for the disabled user but got %d", CloseSessionRevoked, code)
+	}
+	liveClient.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := liveClient.ReadMessage(); websocket.IsCloseError(err, CloseSessionExpired) {
+		t.Error("expected the live session's websocket to stay open")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	h := &HandlerContext{
+		Transport:      &sessions.Transport{Cookie: true},
+		AllowedOrigins: []string{"https://app.example.com"},
+	}
+	cases := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"", true},
+		{"https://gateway.example.com", true},
+		{"https://app.example.com", true},
+		{"https://evil.example.com", false},
+		{"null", false},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "https://gateway.example.com/v1/ws", nil)
+		if len(c.origin) > 0 {
+			r.Header.Set("Origin", c.origin)
+		}
+		if allowed := h.checkOrigin(r); allowed != c.allowed {
+			t.Errorf("origin %q: expected allowed to be %v", c.origin, c.allowed)
+		}
+	}
+
+	// handshakes from other sites are rejected before anything else
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "https://gateway.example.com/v1/ws", nil)
+	r.Header.Set("Origin", "https://evil.example.com")
+	h.WebSocketConnectionHandler(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected %d for another site but got %d", http.StatusForbidden, w.Code)
+	}
+
+	// session IDs can't be put in the query string with cookies
+	w = httptest.NewRecorder()
+	h.WebSocketConnectionHandler(w, httptest.NewRequest("GET", "https://gateway.example.com/v1/ws?auth=abc", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected %d for the auth parameter but got %d", http.StatusBadRequest, w.Code)
+	}
+
+	// without cookies, browsers have nothing to send for other sites
+	header := &HandlerContext{Transport: sessions.HeaderTransport}
+	r = httptest.NewRequest("GET", "https://gateway.example.com/v1/ws", nil)
+	r.Header.Set("Origin", "https://evil.example.com")
+	if !header.checkOrigin(r) {
+		t.Error("expected any origin to be allowed without cookies")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/sessions"
+)
+
+// newKeyring returns the keyring session IDs are signed with: the file
+// at SESSIONKEYRING if it's set, which is reloaded whenever the gateway
+// gets a SIGHUP so keys can be rotated without a restart, or otherwise
+// just SESSIONKEY. To start rotating, add SESSIONKEY to the file
+// with an empty ID so existing sessions stay valid.
+func newKeyring(path string, sessionKey string) (*sessions.Keyring, error) {
+	if len(path) == 0 {
+		if len(sessionKey) == 0 {
+			return nil, errors.New("SESSIONKEY or SESSIONKEYRING must be set")
+		}
+		return sessions.SingleKey(sessionKey), nil
+	}
+	return loadKeyring(path)
+}
+
+// loadKeyring loads the keyring file at the path,
+// and reloads it whenever the gateway gets a SIGHUP
+func loadKeyring(path string) (*sessions.Keyring, error) {
+	keyring, err := sessions.LoadKeyring(path)
+	if err != nil {
+		return nil, err
+	}
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			if err := keyring.Reload(); err != nil {
+				log.Printf("error reloading keyring %s, keeping the current keys: %v", path, err)
+				continue
+			}
+			log.Printf("reloaded keyring %s", path)
+		}
+	}()
+	return keyring, nil
+}
@@ -0,0 +1,59 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+//Mailer sends plain text emails
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+//SMTPMailer is a Mailer that sends through an SMTP server
+type SMTPMailer struct {
+	//Addr is the host:port of the SMTP server
+	Addr string
+	//From is the address the emails are sent from
+	From string
+	//Username and Password, if set, are used to sign in to the server
+	Username string
+	Password string
+}
+
+//Send sends the email through the SMTP server
+func (m *SMTPMailer) Send(to string, subject string, body string) error {
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return errors.New("email headers may not contain line breaks")
+	}
+
+	var auth smtp.Auth
+	if len(m.Username) > 0 {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return errors.New("Invalid SMTP address: " + err.Error())
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	message := strings.Join([]string{
+		"From: " + m.From,
+		"To: " + to,
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		strings.Replace(body, "\n", "\r\n", -1),
+	}, "\r\n")
+
+	if err := smtp.SendMail(m.Addr, auth, m.From, []string{to}, []byte(message)); err != nil {
+		return fmt.Errorf("Problem sending email: %v", err)
+	}
+	return nil
+}
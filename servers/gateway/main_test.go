@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/handlers"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/indexes"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/presence"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/sessions"
+	"github.com/gorilla/websocket"
+	"github.com/streadway/amqp"
+)
+
+// connect opens a websocket for the user and adds it to the store
+func connect(t *testing.T, store *handlers.SocketStore, userID int64) *websocket.Conn {
+	upgrader := websocket.Upgrader{}
+	inserted := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("error upgrading: %v", err)
+			return
+		}
+		store.InsertConnection(conn, userID, sessions.InvalidSessionID, time.Now().Add(time.Hour))
+		inserted <- true
+	}))
+	t.Cleanup(server.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	<-inserted
+	return client
+}
+
+// receive returns the next event sent to the websocket, or nil
+// if there isn't one before the timeout
+func receive(t *testing.T, client *websocket.Conn, timeout time.Duration) map[string]interface{} {
+	client.SetReadDeadline(time.Now().Add(timeout))
+	_, data, err := client.ReadMessage()
+	if err != nil {
+		return nil
+	}
+	event := map[string]interface{}{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatalf("expected a JSON object but got %s: %v", data, err)
+	}
+	return event
+}
+
+func TestBroadcast(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := handlers.NewSocketStore(users.NewSQLStore(db, indexes.NewTrieNode()), presence.NewMemTracker())
+	member := connect(t, store, 1)
+	// the member has a second websocket open, from another device
+	memberPhone := connect(t, store, 1)
+	blocker := connect(t, store, 2)
+	outsider := connect(t, store, 3)
+
+	// user 2 blocked the author. Each connection loads its own list.
+	mock.MatchExpectationsInOrder(false)
+	blocks := regexp.QuoteMeta("select BlockedID from BLOCKS where BlockerID = ?")
+	mock.ExpectQuery(blocks).WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"BlockedID"}))
+	mock.ExpectQuery(blocks).WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"BlockedID"}))
+	mock.ExpectQuery(blocks).WithArgs(int64(2)).WillReturnRows(sqlmock.NewRows([]string{"BlockedID"}).AddRow(9))
+
+	// events as the messaging service sends them: a message in a private
+	// channel lists its members, and a public channel's list is empty
+	private := `{"type":"message-new","message":{"id":"m1","channelID":"c1","body":"hello",` +
+		`"creator":{"id":9,"userName":"author"}},"userIDs":[1,2]}`
+	public := `{"type":"channel-new","channel":{"id":"c2","name":"general","private":false},"userIDs":[]}`
+	msgs := make(chan amqp.Delivery, 2)
+	msgs <- amqp.Delivery{Body: []byte(private)}
+	msgs <- amqp.Delivery{Body: []byte(public)}
+	close(msgs)
+	Broadcast(msgs, store)
+
+	// events are sent as they are, not base64 encoded
+	var expected map[string]interface{}
+	json.Unmarshal([]byte(private), &expected)
+	for _, client := range []*websocket.Conn{member, memberPhone} {
+		if event := receive(t, client, time.Second); !reflect.DeepEqual(event, expected) {
+			t.Errorf("expected both of the member's websockets to get %v but got %v", expected, event)
+		}
+	}
+	expected = nil
+	json.Unmarshal([]byte(public), &expected)
+	for i, client := range []*websocket.Conn{member, memberPhone, blocker, outsider} {
+		if event := receive(t, client, time.Second); !reflect.DeepEqual(event, expected) {
+			t.Errorf("expected websocket %d to get %v but got %v", i+1, expected, event)
+		}
+	}
+	// the private message went to neither the blocker nor the outsider
+	for _, client := range []*websocket.Conn{blocker, outsider} {
+		if event := receive(t, client, 100*time.Millisecond); event != nil {
+			t.Errorf("expected no more events but got %v", event)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestRecipientID(t *testing.T) {
+	cases := []struct {
+		recipient interface{}
+		id        int64
+		ok        bool
+	}{
+		{float64(7), 7, true},
+		{map[string]interface{}{"id": float64(8), "userName": "a"}, 8, true},
+		{map[string]interface{}{"ID": float64(9), "UserName": "b"}, 9, true},
+		{"7", 0, false},
+		{map[string]interface{}{"UserName": "c"}, 0, false},
+	}
+	for _, c := range cases {
+		if id, ok := recipientID(c.recipient); id != c.id || ok != c.ok {
+			t.Errorf("expected %d, %v for %v but got %d, %v", c.id, c.ok, c.recipient, id, ok)
+		}
+	}
+}
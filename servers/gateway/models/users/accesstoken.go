@@ -0,0 +1,130 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+//accessTokenPrefix is prepended to every personal access token so they're
+//easy to recognize (and to find if they're accidentally committed somewhere)
+const accessTokenPrefix = "pat_"
+
+//accessTokenLength is the number of random bytes in a personal access token
+const accessTokenLength = 32
+
+//maxAccessTokenDays is the longest a personal access token may last
+const maxAccessTokenDays = 365
+
+//Scopes that can be granted to a personal access token
+const (
+	ScopeUsersRead     = "users:read"
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+)
+
+//validScopes is the set of scopes a token may be granted
+var validScopes = map[string]bool{
+	ScopeUsersRead:     true,
+	ScopeMessagesRead:  true,
+	ScopeMessagesWrite: true,
+}
+
+//ErrAccessTokenNotFound is returned when a personal access token
+//doesn't exist, has expired, or was revoked
+var ErrAccessTokenNotFound = errors.New("access token not found")
+
+//AccessToken represents a personal access token that bots and scripts
+//can use to act as the user who created it, limited to its scopes
+type AccessToken struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"-"`
+	Hash       []byte     `json:"-"` //never JSON encoded/decoded
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+//NewAccessToken represents a request to create a personal access token
+type NewAccessToken struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+//Validate validates the new access token and returns an error if
+//any of the validation rules fail, or nil if its valid
+func (nt *NewAccessToken) Validate() error {
+	if len(strings.TrimSpace(nt.Name)) == 0 || len(nt.Name) > 64 {
+		return errors.New("Token name must be between 1 and 64 characters.")
+	}
+	if len(nt.Scopes) == 0 {
+		return errors.New("Token must be granted at least one scope.")
+	}
+	for _, scope := range nt.Scopes {
+		if !validScopes[scope] {
+			return errors.New("Unknown scope: " + scope)
+		}
+	}
+	if nt.ExpiresInDays <= 0 || nt.ExpiresInDays > maxAccessTokenDays {
+		return errors.New("Token must expire in between 1 and 365 days.")
+	}
+	return nil
+}
+
+//ToAccessToken converts the NewAccessToken to an AccessToken for the given
+//user, returning the plaintext token along with it. The plaintext token
+//is never stored, so it can only be shown to the user this once.
+func (nt *NewAccessToken) ToAccessToken(userID int64) (*AccessToken, string, error) {
+	if err := nt.Validate(); err != nil {
+		return nil, "", err
+	}
+
+	buf := make([]byte, accessTokenLength)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", errors.New("Problem generating access token.")
+	}
+	token := accessTokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	return &AccessToken{
+		UserID:    userID,
+		Hash:      HashAccessToken(token),
+		Name:      strings.TrimSpace(nt.Name),
+		Scopes:    nt.Scopes,
+		CreatedAt: now,
+		ExpiresAt: now.AddDate(0, 0, nt.ExpiresInDays),
+	}, token, nil
+}
+
+//HashAccessToken returns the hash of the token that is stored and looked up.
+//Tokens are long and random, so unlike passwords a fast hash is enough.
+func HashAccessToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+//IsAccessToken returns true if the string looks like a personal access token
+func IsAccessToken(token string) bool {
+	return strings.HasPrefix(token, accessTokenPrefix)
+}
+
+//HasScope returns true if the token was granted the scope
+func (t *AccessToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+//Expired returns true if the token has expired
+func (t *AccessToken) Expired() bool {
+	return time.Now().After(t.ExpiresAt)
+}
@@ -0,0 +1,64 @@
+package users
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// Tests for the NewAccessToken Validate() method
+func TestValidateAccessToken(t *testing.T) {
+	cases := []struct {
+		input       NewAccessToken
+		expectError bool
+		errorReason string
+	}{
+		{NewAccessToken{"deploy bot", []string{ScopeMessagesRead}, 30}, false, "Failed to validate a correct token."},
+		{NewAccessToken{"", []string{ScopeMessagesRead}, 30}, true, "Incorrectly validated a token with no name."},
+		{NewAccessToken{"deploy bot", nil, 30}, true, "Incorrectly validated a token with no scopes."},
+		{NewAccessToken{"deploy bot", []string{"admin"}, 30}, true, "Incorrectly validated a token with an unknown scope."},
+		{NewAccessToken{"deploy bot", []string{ScopeMessagesRead}, 0}, true, "Incorrectly validated a token that never expires."},
+		{NewAccessToken{"deploy bot", []string{ScopeMessagesRead}, 1000}, true, "Incorrectly validated a token that expires too late."},
+	}
+
+	for _, c := range cases {
+		err := c.input.Validate()
+		if (!c.expectError && err != nil) || (c.expectError && err == nil) {
+			t.Errorf(c.errorReason)
+		}
+	}
+}
+
+// Tests for the ToAccessToken() method
+func TestToAccessToken(t *testing.T) {
+	newToken := NewAccessToken{
+		Name:          "deploy bot",
+		Scopes:        []string{ScopeMessagesRead, ScopeMessagesWrite},
+		ExpiresInDays: 30,
+	}
+
+	token, plaintext, err := newToken.ToAccessToken(7)
+	if err != nil {
+		t.Fatalf("unexpected error converting token: %v", err)
+	}
+	if !IsAccessToken(plaintext) {
+		t.Errorf("Plaintext token is missing its prefix: %s", plaintext)
+	}
+	if !bytes.Equal(token.Hash, HashAccessToken(plaintext)) {
+		t.Errorf("Stored hash doesn't match the plaintext token.")
+	}
+	if token.UserID != 7 {
+		t.Errorf("Incorrect user ID: expected 7 but got %d", token.UserID)
+	}
+	if token.Expired() || token.ExpiresAt.Before(time.Now().AddDate(0, 0, 29)) {
+		t.Errorf("Incorrect expiry time: %v", token.ExpiresAt)
+	}
+	if !token.HasScope(ScopeMessagesWrite) || token.HasScope(ScopeUsersRead) {
+		t.Errorf("Incorrect scopes granted: %v", token.Scopes)
+	}
+
+	_, plaintext2, _ := newToken.ToAccessToken(7)
+	if plaintext == plaintext2 {
+		t.Errorf("Generated the same token twice.")
+	}
+}
@@ -0,0 +1,95 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+//accessTokenColumns are the columns selected for an AccessToken
+const accessTokenColumns = "id, UserID, TokenHash, Name, Scopes, CreatedAt, ExpiresAt, LastUsedAt"
+
+//scanAccessToken scans a row of `accessTokenColumns` into an AccessToken
+func scanAccessToken(scanner interface{ Scan(...interface{}) error }) (*AccessToken, error) {
+	token := AccessToken{}
+	var scopes string
+	var lastUsed sql.NullTime
+	if err := scanner.Scan(&token.ID, &token.UserID, &token.Hash, &token.Name,
+		&scopes, &token.CreatedAt, &token.ExpiresAt, &lastUsed); err != nil {
+		return nil, err
+	}
+	token.Scopes = strings.Fields(scopes)
+	if lastUsed.Valid {
+		token.LastUsedAt = &lastUsed.Time
+	}
+	return &token, nil
+}
+
+//InsertAccessToken inserts the personal access token, and returns
+//it complete with the DBMS-assigned ID
+func (ss *SQLStore) InsertAccessToken(token *AccessToken) (*AccessToken, error) {
+	insq := "insert into ACCESS_TOKENS(UserID, TokenHash, Name, Scopes, CreatedAt, ExpiresAt) values(?,?,?,?,?,?)"
+	id, err := ss.db.Insert(insq, token.UserID, token.Hash, token.Name,
+		strings.Join(token.Scopes, " "), token.CreatedAt, token.ExpiresAt)
+	if err != nil {
+		return nil, errors.New("Error inserting row: " + err.Error())
+	}
+	token.ID = id
+	return token, nil
+}
+
+//GetAccessTokens returns all of the user's unexpired personal access tokens
+func (ss *SQLStore) GetAccessTokens(userID int64) ([]*AccessToken, error) {
+	rows, err := ss.db.Query("select "+accessTokenColumns+" from ACCESS_TOKENS where UserID = ? and ExpiresAt > ? order by CreatedAt",
+		userID, time.Now().UTC())
+	if err != nil {
+		return nil, errors.New("Failed to query.")
+	}
+	defer rows.Close()
+
+	tokens := []*AccessToken{}
+	for rows.Next() {
+		token, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, errors.New("Error scanning row.")
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+//GetAccessTokenByToken returns the unexpired personal access token matching
+//the plaintext token, or ErrAccessTokenNotFound if there isn't one.
+//It also records that the token was just used.
+func (ss *SQLStore) GetAccessTokenByToken(plaintext string) (*AccessToken, error) {
+	row := ss.db.QueryRow("select "+accessTokenColumns+" from ACCESS_TOKENS where TokenHash = ?", HashAccessToken(plaintext))
+	token, err := scanAccessToken(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAccessTokenNotFound
+		}
+		return nil, errors.New("Error scanning row.")
+	}
+	if token.Expired() {
+		return nil, ErrAccessTokenNotFound
+	}
+
+	now := time.Now().UTC()
+	if _, err := ss.db.Exec("update ACCESS_TOKENS set LastUsedAt = ? where id = ?", now, token.ID); err == nil {
+		token.LastUsedAt = &now
+	}
+	return token, nil
+}
+
+//DeleteAccessToken revokes the user's personal access token with the given ID
+func (ss *SQLStore) DeleteAccessToken(userID int64, id int64) error {
+	res, err := ss.db.Exec("delete from ACCESS_TOKENS where id = ? and UserID = ?", id, userID)
+	if err != nil {
+		return errors.New("Error deleting row: " + err.Error())
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrAccessTokenNotFound
+	}
+	return nil
+}
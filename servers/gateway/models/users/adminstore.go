@@ -0,0 +1,61 @@
+package users
+
+import (
+	"errors"
+)
+
+//ListUsers returns a page of users ordered by ID, along with the total
+//number of users, for the admin user list. Deleted users aren't included.
+func (ss *SQLStore) ListUsers(offset int, limit int) ([]*User, int, error) {
+	var total int
+	if err := ss.db.QueryRow("select count(*) from USERS where " + notDeleted).Scan(&total); err != nil {
+		return nil, 0, errors.New("Failed to count users.")
+	}
+
+	rows, err := ss.db.Query("select "+userColumns+" from USERS where "+notDeleted+" order by id limit ? offset ?", limit, offset)
+	if err != nil {
+		return nil, 0, errors.New("Failed to query.")
+	}
+	defer rows.Close()
+
+	list := []*User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, errors.New("Error scanning row.")
+		}
+		list = append(list, user)
+	}
+	return list, total, nil
+}
+
+//SetDisabled disables or re-enables the user with the given ID and returns
+//the updated user. Disabled users are removed from the search trie.
+func (ss *SQLStore) SetDisabled(id int64, disabled bool) (*User, error) {
+	if _, err := ss.db.Exec("update USERS set Disabled = ? where id = ? and "+notDeleted, disabled, id); err != nil {
+		return nil, errors.New("Error updating row: " + err.Error())
+	}
+	user, err := ss.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if disabled {
+		ss.DeleteUserFromTrie(user)
+	} else {
+		ss.AddUserToTrie(user)
+	}
+	return user, nil
+}
+
+//SetRole changes the role of the user with the given ID
+//and returns the updated user
+func (ss *SQLStore) SetRole(id int64, role string) (*User, error) {
+	if !ValidRole(role) {
+		return nil, errors.New("Unknown role: " + role)
+	}
+	if _, err := ss.db.Exec("update USERS set Role = ? where id = ? and "+notDeleted, role, id); err != nil {
+		return nil, errors.New("Error updating row: " + err.Error())
+	}
+	return ss.GetByID(id)
+}
@@ -0,0 +1,48 @@
+package users
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//Sources a user's avatar can come from. An empty source
+//means the server's configured default is used.
+const (
+	AvatarDefault   = ""
+	AvatarGravatar  = "gravatar"
+	AvatarIdenticon = "identicon"
+	AvatarInitials  = "initials"
+	AvatarUpload    = "upload"
+)
+
+//ErrInvalidAvatarSource is returned for an unknown avatar source
+var ErrInvalidAvatarSource = errors.New("avatar source must be one of gravatar, identicon, initials or upload")
+
+//ValidAvatarSource returns true if the source is one of the known
+//avatar sources, or the empty default
+func ValidAvatarSource(source string) bool {
+	switch source {
+	case AvatarDefault, AvatarGravatar, AvatarIdenticon, AvatarInitials, AvatarUpload:
+		return true
+	}
+	return false
+}
+
+//AvatarPath returns the URL that always serves the user's avatar,
+//from whichever source they or the server prefer
+func AvatarPath(userID int64) string {
+	return fmt.Sprintf("/v1/users/%d/avatar", userID)
+}
+
+//GravatarURL returns the Gravatar PhotoURL for the email address.
+//See https://en.gravatar.com/site/implement/hash/
+func GravatarURL(email string) string {
+	// Format the email to md5 hash for gravatar
+	formattedEmail := strings.ToLower(strings.TrimSpace(email))
+	m := md5.New()
+	m.Write([]byte(formattedEmail))
+	return gravatarBasePhotoURL + hex.EncodeToString(m.Sum(nil))
+}
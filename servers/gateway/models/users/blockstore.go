@@ -0,0 +1,51 @@
+package users
+
+import (
+	"errors"
+	"time"
+)
+
+//ErrCannotBlockSelf is returned when a user tries to block themselves
+var ErrCannotBlockSelf = errors.New("you can't block yourself")
+
+//Block records that the blocker has blocked the other user.
+//Blocking someone who's already blocked does nothing.
+func (ss *SQLStore) Block(blockerID int64, blockedID int64) error {
+	if blockerID == blockedID {
+		return ErrCannotBlockSelf
+	}
+	insq := "insert into BLOCKS(BlockerID, BlockedID, CreatedAt) values(?,?,?) " +
+		ss.db.dialect.Upsert("BlockerID, BlockedID")
+	if _, err := ss.db.Exec(insq, blockerID, blockedID, time.Now().UTC()); err != nil {
+		return errors.New("Error inserting row: " + err.Error())
+	}
+	return nil
+}
+
+//Unblock removes the blocker's block of the other user, if there is one
+func (ss *SQLStore) Unblock(blockerID int64, blockedID int64) error {
+	if _, err := ss.db.Exec("delete from BLOCKS where BlockerID = ? and BlockedID = ?", blockerID, blockedID); err != nil {
+		return errors.New("Error deleting row: " + err.Error())
+	}
+	return nil
+}
+
+//GetBlocked returns the IDs of the users the blocker has blocked,
+//most recently blocked first
+func (ss *SQLStore) GetBlocked(blockerID int64) ([]int64, error) {
+	rows, err := ss.db.Query("select BlockedID from BLOCKS where BlockerID = ? order by CreatedAt desc", blockerID)
+	if err != nil {
+		return nil, errors.New("Failed to query.")
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.New("Error scanning row.")
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
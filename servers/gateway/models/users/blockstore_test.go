@@ -0,0 +1,47 @@
+package users
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/indexes"
+)
+
+func TestBlocks(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	sqlStore := NewSQLStore(db, indexes.NewTrieNode())
+
+	if err := sqlStore.Block(1, 1); err != ErrCannotBlockSelf {
+		t.Errorf("incorrect error blocking yourself: expected %v but got %v", ErrCannotBlockSelf, err)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("insert into BLOCKS(BlockerID, BlockedID, CreatedAt) values(?,?,?)")).
+		WithArgs(int64(1), int64(2), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := sqlStore.Block(1, 2); err != nil {
+		t.Errorf("unexpected error blocking: %v", err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("select BlockedID from BLOCKS where BlockerID = ?")).
+		WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"BlockedID"}).AddRow(2).AddRow(5))
+	blocked, err := sqlStore.GetBlocked(1)
+	if err != nil || len(blocked) != 2 || blocked[0] != 2 || blocked[1] != 5 {
+		t.Errorf("incorrect blocked users: %v, %v", blocked, err)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("delete from BLOCKS where BlockerID = ? and BlockedID = ?")).
+		WithArgs(int64(1), int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := sqlStore.Unblock(1, 2); err != nil {
+		t.Errorf("unexpected error unblocking: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sql expectations: %v", err)
+	}
+}
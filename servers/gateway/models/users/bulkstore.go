@@ -0,0 +1,64 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+//BatchError is returned by InsertBatch when one of the users
+//couldn't be inserted, which rolls back the whole batch
+type BatchError struct {
+	//Index of the user in the batch that failed
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("user %d of the batch: %v", e.Index, e.Err)
+}
+
+//InsertBatch inserts the users in a single transaction, setting each
+//one's ID and pointing their PhotoURL at the avatar endpoint like
+//users who sign up. Either all of the users are inserted or none are.
+//The caller is responsible for adding them to the trie.
+func (ss *SQLStore) InsertBatch(list []*User) error {
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return errors.New("Error beginning transaction: " + err.Error())
+	}
+	defer tx.Rollback()
+
+	insq := "insert into USERS(Email, PassHash, UserName, FirstName, LastName, PhotoURL, Role, DisplayName, Title, EmailVerified, CreatedAt) " +
+		"values(?,?,?,?,?,?,?,?,?,?,?)"
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	ids := make([]int64, len(list))
+	for i, user := range list {
+		if err := checkUserNameHistory(tx, user.UserName, 0, createdAt); err != nil {
+			return &BatchError{Index: i, Err: err}
+		}
+		id, err := tx.Insert(insq, user.Email, user.PassHash, user.UserName, user.FirstName, user.LastName,
+			user.PhotoURL, user.Role, user.DisplayName, user.Title, user.EmailVerified, createdAt)
+		if isDuplicateEntry(err) {
+			return &BatchError{Index: i, Err: ErrUserNameTaken}
+		}
+		if err != nil {
+			return &BatchError{Index: i, Err: errors.New("Error inserting row: " + err.Error())}
+		}
+		if _, err := tx.Exec("update USERS set PhotoURL = ? where id = ?", AvatarPath(id), id); err != nil {
+			return &BatchError{Index: i, Err: errors.New("Error updating row: " + err.Error())}
+		}
+		ids[i] = id
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.New("Error committing batch: " + err.Error())
+	}
+
+	// only now that they're saved
+	for i, user := range list {
+		user.ID = ids[i]
+		user.PhotoURL = AvatarPath(ids[i])
+		user.CreatedAt = createdAt
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package users
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//CachedStore is a Store that reads users through a cache in front of
+//a SQLStore. Users are cached by ID, and emails and usernames are
+//cached as the ID they belong to, so changing a user only has to
+//invalidate their ID. Every other SQLStore method passes through.
+//A lookup that races a change can still cache the user as they were,
+//until the cache's TTL runs out. Password hashes are never cached, so
+//the users it returns don't have them: checking a password has to get
+//the user from the SQLStore.
+type CachedStore struct {
+	// counted atomically, so kept first for 64-bit alignment
+	hits   uint64
+	misses uint64
+	*SQLStore
+	cache   UserCache
+	flights flightGroup
+}
+
+//CacheStats counts how often lookups were answered by the cache
+type CacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+//cachedUser is a user as it's cached, including the email,
+//which is never JSON encoded otherwise
+type cachedUser struct {
+	*User
+	Email string `json:"email"`
+}
+
+//NewCachedStore constructs a new CachedStore. With a nil cache
+//every lookup goes to the SQLStore.
+func NewCachedStore(store *SQLStore, cache UserCache) *CachedStore {
+	return &CachedStore{
+		SQLStore: store,
+		cache:    cache,
+		flights:  flightGroup{calls: map[string]*flight{}},
+	}
+}
+
+//Stats returns the cache's hit and miss counts since the store was made
+func (cs *CachedStore) Stats() *CacheStats {
+	return &CacheStats{
+		Hits:   atomic.LoadUint64(&cs.hits),
+		Misses: atomic.LoadUint64(&cs.misses),
+	}
+}
+
+func userIDKey(id int64) string {
+	return "user:" + strconv.FormatInt(id, 10)
+}
+
+// emails and usernames are compared case-insensitively by the database
+func userEmailKey(email string) string {
+	return "user:email:" + strings.ToLower(email)
+}
+
+func userNameKey(userName string) string {
+	return "user:username:" + strings.ToLower(userName)
+}
+
+//GetByID returns the User with the given ID
+func (cs *CachedStore) GetByID(id int64) (*User, error) {
+	if user := cs.cached(id); user != nil {
+		atomic.AddUint64(&cs.hits, 1)
+		return user, nil
+	}
+	atomic.AddUint64(&cs.misses, 1)
+	return cs.load(userIDKey(id), "", func() (*User, error) {
+		return cs.SQLStore.GetByID(id)
+	})
+}
+
+//GetByEmail returns the User with the given email
+func (cs *CachedStore) GetByEmail(email string) (*User, error) {
+	key := userEmailKey(email)
+	// the ID may belong to a user whose email has since changed
+	if user := cs.cachedByKey(key); user != nil && strings.EqualFold(user.Email, email) {
+		atomic.AddUint64(&cs.hits, 1)
+		return user, nil
+	}
+	atomic.AddUint64(&cs.misses, 1)
+	return cs.load(key, key, func() (*User, error) {
+		return cs.SQLStore.GetByEmail(email)
+	})
+}
+
+//GetByUserName returns the User with the given Username
+func (cs *CachedStore) GetByUserName(username string) (*User, error) {
+	key := userNameKey(username)
+	if user := cs.cachedByKey(key); user != nil && strings.EqualFold(user.UserName, username) {
+		atomic.AddUint64(&cs.hits, 1)
+		return user, nil
+	}
+	atomic.AddUint64(&cs.misses, 1)
+	return cs.load(key, key, func() (*User, error) {
+		return cs.SQLStore.GetByUserName(username)
+	})
+}
+
+//cached returns the cached user with the given ID, or nil.
+//Cache errors are treated as misses, so the database still answers.
+func (cs *CachedStore) cached(id int64) *User {
+	if cs.cache == nil {
+		return nil
+	}
+	value, err := cs.cache.Get(userIDKey(id))
+	if err != nil {
+		return nil
+	}
+	entry := &cachedUser{User: &User{}}
+	if err := json.Unmarshal(value, entry); err != nil {
+		return nil
+	}
+	entry.User.Email = entry.Email
+	return entry.User
+}
+
+//cachedByKey returns the cached user whose ID is cached under the key, or nil
+func (cs *CachedStore) cachedByKey(key string) *User {
+	if cs.cache == nil {
+		return nil
+	}
+	value, err := cs.cache.Get(key)
+	if err != nil {
+		return nil
+	}
+	id, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return nil
+	}
+	return cs.cached(id)
+}
+
+//load gets the user from the database, with only one load per key at
+//a time, and caches them along with the ID under indexKey if it's set
+func (cs *CachedStore) load(key string, indexKey string, get func() (*User, error)) (*User, error) {
+	return cs.flights.do(key, func() (*User, error) {
+		user, err := get()
+		if err != nil || user == nil {
+			return user, err
+		}
+		// hits don't have it, so neither do misses
+		user.PassHash = nil
+		if cs.cache == nil {
+			return user, nil
+		}
+		if value, err := json.Marshal(&cachedUser{User: user, Email: user.Email}); err == nil {
+			cs.cache.Set(userIDKey(user.ID), value)
+		}
+		if len(indexKey) > 0 {
+			cs.cache.Set(indexKey, []byte(strconv.FormatInt(user.ID, 10)))
+		}
+		return user, nil
+	})
+}
+
+//invalidate removes the users from the cache after they've changed.
+//Their email and username keys are checked when they're used instead.
+func (cs *CachedStore) invalidate(ids ...int64) {
+	if cs.cache == nil || len(ids) == 0 {
+		return
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = userIDKey(id)
+	}
+	cs.cache.Delete(keys...)
+}
+
+//Update applies UserUpdates to the given user ID
+//and returns the newly-updated user
+func (cs *CachedStore) Update(id int64, updates *Updates) (*User, error) {
+	defer cs.invalidate(id)
+	return cs.SQLStore.Update(id, updates)
+}
+
+//Delete deletes the user with the given ID
+func (cs *CachedStore) Delete(id int64) error {
+	defer cs.invalidate(id)
+	return cs.SQLStore.Delete(id)
+}
+
+//SetAvatar sets where the user's avatar comes from
+func (cs *CachedStore) SetAvatar(id int64, source string, photoURL string) error {
+	defer cs.invalidate(id)
+	return cs.SQLStore.SetAvatar(id, source, photoURL)
+}
+
+//ChangeUserName changes the user's username
+func (cs *CachedStore) ChangeUserName(id int64, userName string) (*User, error) {
+	defer cs.invalidate(id)
+	return cs.SQLStore.ChangeUserName(id, userName)
+}
+
+//SetDisabled disables or re-enables the user
+func (cs *CachedStore) SetDisabled(id int64, disabled bool) (*User, error) {
+	defer cs.invalidate(id)
+	return cs.SQLStore.SetDisabled(id, disabled)
+}
+
+//SetRole changes the user's role
+func (cs *CachedStore) SetRole(id int64, role string) (*User, error) {
+	defer cs.invalidate(id)
+	return cs.SQLStore.SetRole(id, role)
+}
+
+//Restore undoes the deletion of the user
+func (cs *CachedStore) Restore(id int64) (*User, error) {
+	defer cs.invalidate(id)
+	return cs.SQLStore.Restore(id)
+}
+
+//SetEmailVerified marks the user's email address as verified
+func (cs *CachedStore) SetEmailVerified(userID int64) error {
+	defer cs.invalidate(userID)
+	return cs.SQLStore.SetEmailVerified(userID)
+}
+
+//AcceptInvitation sets the invited user's password
+func (cs *CachedStore) AcceptInvitation(tokenHash []byte, password string) (*User, error) {
+	user, err := cs.SQLStore.AcceptInvitation(tokenHash, password)
+	if err == nil {
+		cs.invalidate(user.ID)
+	}
+	return user, err
+}
+
+//PurgeDeleted permanently deletes the users who were deleted before `before`
+func (cs *CachedStore) PurgeDeleted(before time.Time) ([]int64, error) {
+	ids, err := cs.SQLStore.PurgeDeleted(before)
+	cs.invalidate(ids...)
+	return ids, err
+}
+
+//flightGroup makes concurrent loads of the same key share one
+//database query, so a popular user falling out of the cache
+//doesn't send a stampede of queries to the database
+type flightGroup struct {
+	calls map[string]*flight
+	lock  sync.Mutex
+}
+
+//flight is a load in progress
+type flight struct {
+	done chan struct{}
+	user *User
+	err  error
+}
+
+//do calls fn, unless a call for the key is already in progress,
+//in which case it waits for that call's result instead
+func (g *flightGroup) do(key string, fn func() (*User, error)) (*User, error) {
+	g.lock.Lock()
+	if f, ok := g.calls[key]; ok {
+		g.lock.Unlock()
+		<-f.done
+		if f.err != nil || f.user == nil {
+			return nil, f.err
+		}
+		// callers may change the user they get back
+		user := *f.user
+		return &user, nil
+	}
+	f := &flight{done: make(chan struct{})}
+	g.calls[key] = f
+	g.lock.Unlock()
+
+	f.user, f.err = fn()
+	g.lock.Lock()
+	delete(g.calls, key)
+	g.lock.Unlock()
+	close(f.done)
+
+	if f.err != nil || f.user == nil {
+		return nil, f.err
+	}
+	user := *f.user
+	return &user, nil
+}
@@ -0,0 +1,100 @@
+package users
+
+import (
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/indexes"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCachedStore(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := NewCachedStore(NewSQLStore(db, indexes.NewTrieNode()), NewMemUserCache(time.Hour, 100))
+
+	user := &User{ID: 1, Email: "jsm209@uw.edu", PassHash: []byte("hash"), UserName: "jsm209",
+		FirstName: "Joshua", LastName: "Maza", Role: RoleUser}
+	getByID := regexp.QuoteMeta("select " + userColumns + " from USERS where id = ? and " + notDeleted)
+
+	// only the first lookup goes to the database
+	mock.ExpectQuery(getByID).WithArgs(user.ID).WillReturnRows(userRows(user))
+	for i := 0; i < 3; i++ {
+		got, err := store.GetByID(user.ID)
+		if err != nil || got.UserName != user.UserName || got.Email != user.Email || got.PassHash != nil {
+			t.Fatalf("incorrect user: %+v, %v", got, err)
+		}
+	}
+	if stats := store.Stats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("expected 2 hits and 1 miss but got %+v", stats)
+	}
+
+	// usernames are cached as the ID, whatever their case
+	mock.ExpectQuery(regexp.QuoteMeta("select " + userColumns + " from USERS where UserName = ?")).
+		WithArgs("jsm209").WillReturnRows(userRows(user))
+	if got, err := store.GetByUserName("jsm209"); err != nil || got.ID != user.ID {
+		t.Fatalf("incorrect user: %+v, %v", got, err)
+	}
+	if got, err := store.GetByUserName("JSM209"); err != nil || got.ID != user.ID {
+		t.Errorf("expected a cached user but got %+v, %v", got, err)
+	}
+
+	// changing the user invalidates them
+	mock.ExpectExec(regexp.QuoteMeta("update USERS set AvatarSource = ?")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := store.SetAvatar(user.ID, "upload", "/v1/users/1/avatar"); err != nil {
+		t.Fatalf("error setting avatar: %v", err)
+	}
+	mock.ExpectQuery(getByID).WithArgs(user.ID).WillReturnRows(userRows(user))
+	if _, err := store.GetByID(user.ID); err != nil {
+		t.Errorf("error getting user: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestFlightGroup(t *testing.T) {
+	group := flightGroup{calls: map[string]*flight{}}
+	calls := 0
+	release := make(chan struct{})
+	load := func() (*User, error) {
+		calls++
+		<-release
+		return &User{ID: 1}, nil
+	}
+
+	// concurrent loads of the same key share the first one
+	wg := sync.WaitGroup{}
+	results := make([]*User, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = group.do("user:1", load)
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("expected one load but got %d", calls)
+	}
+	for _, user := range results {
+		if user == nil || user.ID != 1 {
+			t.Errorf("incorrect user: %+v", user)
+		}
+	}
+	// each caller gets their own copy
+	if results[0] == results[1] {
+		t.Error("expected callers to get different copies of the user")
+	}
+}
@@ -0,0 +1,132 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+//DeletedUserRetention is how long a deleted user can be restored
+//before PurgeDeleted removes them for good
+const DeletedUserRetention = 30 * 24 * time.Hour
+
+//ErrUserNotDeleted is returned when restoring a user who isn't deleted
+var ErrUserNotDeleted = errors.New("user is not deleted")
+
+//ErrRestoreWindowPassed is returned when restoring a user who was
+//deleted longer than DeletedUserRetention ago
+var ErrRestoreWindowPassed = errors.New("user was deleted too long ago to be restored")
+
+//ErrEmailTaken is returned when restoring a user whose email
+//address has since been used by another account
+var ErrEmailTaken = errors.New("email address is used by another account")
+
+//userTables are the tables holding rows that belong to a user,
+//which are purged along with them
+var userTables = []string{"USERNAME_HISTORY", "USER_MFA", "RECOVERY_CODES",
+	"USER_IDENTITIES", "ACCESS_TOKENS", "INVITATIONS"}
+
+//Restore undoes the deletion of the user with the given ID, as long as
+//it's within DeletedUserRetention, and returns the restored user
+func (ss *SQLStore) Restore(id int64) (*User, error) {
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return nil, errors.New("Error beginning transaction: " + err.Error())
+	}
+	defer tx.Rollback()
+
+	var email string
+	var deletedAt sql.NullTime
+	row := tx.QueryRow("select Email, DeletedAt from USERS where id = ? for update", id)
+	if err := row.Scan(&email, &deletedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, errors.New("Error scanning row.")
+	}
+	if !deletedAt.Valid {
+		return nil, ErrUserNotDeleted
+	}
+	if time.Since(deletedAt.Time) > DeletedUserRetention {
+		return nil, ErrRestoreWindowPassed
+	}
+
+	// sign ups can't see deleted users, so the email may have been reused
+	var count int
+	if err := tx.QueryRow("select count(*) from USERS where Email = ? and id != ? and "+notDeleted, email, id).Scan(&count); err != nil {
+		return nil, errors.New("Error checking email: " + err.Error())
+	}
+	if count > 0 {
+		return nil, ErrEmailTaken
+	}
+
+	if _, err := tx.Exec("update USERS set DeletedAt = null where id = ?", id); err != nil {
+		return nil, errors.New("Error updating row: " + err.Error())
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.New("Error committing restore: " + err.Error())
+	}
+
+	user, err := ss.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if !user.Disabled {
+		ss.AddUserToTrie(user)
+	}
+	return user, nil
+}
+
+//PurgeDeleted permanently deletes the users who were deleted before
+//`before`, along with everything else stored about them, and returns
+//their IDs. The rows are locked while they're purged, so gateways
+//purging at the same time each purge a user only once.
+func (ss *SQLStore) PurgeDeleted(before time.Time) ([]int64, error) {
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return nil, errors.New("Error beginning transaction: " + err.Error())
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("select id from USERS where DeletedAt < ? for update", before)
+	if err != nil {
+		return nil, errors.New("Failed to query.")
+	}
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, errors.New("Error scanning row.")
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return ids, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	for _, table := range userTables {
+		if _, err := tx.Exec("delete from "+table+" where UserID in ("+placeholders+")", args...); err != nil {
+			return nil, errors.New("Error purging " + table + ": " + err.Error())
+		}
+	}
+	// blocks refer to users from both sides
+	blockArgs := append(append([]interface{}{}, args...), args...)
+	if _, err := tx.Exec("delete from BLOCKS where BlockerID in ("+placeholders+") or BlockedID in ("+placeholders+")", blockArgs...); err != nil {
+		return nil, errors.New("Error purging BLOCKS: " + err.Error())
+	}
+	if _, err := tx.Exec("delete from USERS where id in ("+placeholders+")", args...); err != nil {
+		return nil, errors.New("Error purging users: " + err.Error())
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.New("Error committing purge: " + err.Error())
+	}
+	return ids, nil
+}
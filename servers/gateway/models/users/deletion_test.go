@@ -0,0 +1,141 @@
+package users
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/indexes"
+)
+
+func TestDeleteAndRestore(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	sqlStore := NewSQLStore(db, indexes.NewTrieNode())
+	existing := &User{ID: 1, Email: "jsm209@uw.edu", UserName: "jsm209", FirstName: "Joshua", Role: RoleUser}
+	sqlStore.AddUserToTrie(existing)
+
+	// deleting hides the user, and takes them out of search
+	mock.ExpectQuery(regexp.QuoteMeta("select " + userColumns + " from USERS where id = ? and DeletedAt is null")).
+		WithArgs(existing.ID).
+		WillReturnRows(userRows(existing))
+	mock.ExpectExec(regexp.QuoteMeta("update USERS set DeletedAt = ? where id = ? and DeletedAt is null")).
+		WithArgs(sqlmock.AnyArg(), existing.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := sqlStore.Delete(existing.ID); err != nil {
+		t.Fatalf("unexpected error deleting user: %v", err)
+	}
+	if found := sqlStore.Query("joshua", 10); len(found) != 0 {
+		t.Errorf("expected deleted user to be removed from search but got %v", found)
+	}
+
+	// deleted users look like they don't exist
+	mock.ExpectQuery(regexp.QuoteMeta("select " + userColumns + " from USERS where id = ? and DeletedAt is null")).
+		WithArgs(existing.ID).
+		WillReturnRows(userRows())
+	if err := sqlStore.Delete(existing.ID); err != ErrUserNotFound {
+		t.Errorf("incorrect error deleting a deleted user: expected %v but got %v", ErrUserNotFound, err)
+	}
+
+	// restoring within the window puts them back in search
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("select Email, DeletedAt from USERS where id = ? for update")).
+		WithArgs(existing.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"Email", "DeletedAt"}).AddRow(existing.Email, time.Now().Add(-time.Hour)))
+	mock.ExpectQuery(regexp.QuoteMeta("select count(*) from USERS where Email = ? and id != ? and DeletedAt is null")).
+		WithArgs(existing.Email, existing.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(regexp.QuoteMeta("update USERS set DeletedAt = null where id = ?")).
+		WithArgs(existing.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta("select " + userColumns + " from USERS where id = ? and DeletedAt is null")).
+		WithArgs(existing.ID).
+		WillReturnRows(userRows(existing))
+	restored, err := sqlStore.Restore(existing.ID)
+	if err != nil || restored.ID != existing.ID {
+		t.Fatalf("unexpected error restoring user: %v", err)
+	}
+	if found := sqlStore.Query("joshua", 10); len(found) != 1 {
+		t.Errorf("expected restored user to be searchable but got %v", found)
+	}
+
+	cases := []struct {
+		name      string
+		deletedAt interface{}
+		emailUses int
+		expected  error
+	}{
+		{"not deleted", nil, 0, ErrUserNotDeleted},
+		{"deleted too long ago", time.Now().Add(-DeletedUserRetention - time.Hour), 0, ErrRestoreWindowPassed},
+		{"email reused", time.Now().Add(-time.Hour), 1, ErrEmailTaken},
+	}
+	for _, c := range cases {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("select Email, DeletedAt from USERS where id = ? for update")).
+			WithArgs(existing.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"Email", "DeletedAt"}).AddRow(existing.Email, c.deletedAt))
+		if c.expected == ErrEmailTaken {
+			mock.ExpectQuery(regexp.QuoteMeta("select count(*) from USERS where Email = ?")).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(c.emailUses))
+		}
+		mock.ExpectRollback()
+		if _, err := sqlStore.Restore(existing.ID); err != c.expected {
+			t.Errorf("%s: expected %v but got %v", c.name, c.expected, err)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sql expectations: %v", err)
+	}
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	sqlStore := NewSQLStore(db, indexes.NewTrieNode())
+	before := time.Now().Add(-DeletedUserRetention)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("select id from USERS where DeletedAt < ? for update")).
+		WithArgs(before).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3).AddRow(7))
+	for _, table := range userTables {
+		mock.ExpectExec(regexp.QuoteMeta("delete from "+table+" where UserID in (?,?)")).
+			WithArgs(int64(3), int64(7)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(regexp.QuoteMeta("delete from BLOCKS where BlockerID in (?,?) or BlockedID in (?,?)")).
+		WithArgs(int64(3), int64(7), int64(3), int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("delete from USERS where id in (?,?)")).
+		WithArgs(int64(3), int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	purged, err := sqlStore.PurgeDeleted(before)
+	if err != nil {
+		t.Fatalf("unexpected error purging: %v", err)
+	}
+	if len(purged) != 2 || purged[0] != 3 || purged[1] != 7 {
+		t.Errorf("incorrect purged IDs: %v", purged)
+	}
+
+	// nothing to purge
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("select id from USERS where DeletedAt < ? for update")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+	if purged, err := sqlStore.PurgeDeleted(before); err != nil || len(purged) != 0 {
+		t.Errorf("expected nothing to be purged but got %v, %v", purged, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sql expectations: %v", err)
+	}
+}
@@ -0,0 +1,316 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+//mysqlDuplicateEntry is the MySQL error number for a unique key violation
+const mysqlDuplicateEntry = 1062
+
+//postgresUniqueViolation is the PostgreSQL SQLSTATE for a unique key violation
+const postgresUniqueViolation = "23505"
+
+//SQLite extended result codes for unique and primary key violations
+const (
+	sqliteConstraintPrimaryKey = 1555
+	sqliteConstraintUnique     = 2067
+)
+
+//ErrUnknownDSNScheme is returned for DSNs whose scheme isn't a supported database
+var ErrUnknownDSNScheme = errors.New("DSN must start with mysql://, postgres:// or sqlite://")
+
+//Dialect adapts the SQLStore's queries, which are written for MySQL
+//with ? placeholders, to the database engine they run on
+type Dialect interface {
+	//Name is the dialect's DSN scheme
+	Name() string
+
+	//DriverName is the database/sql driver the dialect's databases are opened with
+	DriverName() string
+
+	//Rebind rewrites a query written for MySQL for the dialect
+	Rebind(query string) string
+
+	//Upsert returns the clause that makes an insert update the given columns
+	//to the inserted values when a row with the same key exists. With no
+	//columns the insert does nothing instead.
+	Upsert(key string, columns ...string) string
+
+	//ReturningID is true if inserts have to return the new row's ID,
+	//because the driver doesn't support LastInsertId
+	ReturningID() bool
+
+	//Schema rewrites a migration statement's {tokens} for the dialect
+	Schema(statement string) string
+}
+
+//MySQL is the dialect of MySQL and MariaDB, which the queries are written for
+var MySQL Dialect = &mysqlDialect{}
+
+//Postgres is the dialect of PostgreSQL 12 and later
+var Postgres Dialect = &postgresDialect{}
+
+//SQLite is the dialect of SQLite, for single-node and test deployments
+var SQLite Dialect = &sqliteDialect{}
+
+type mysqlDialect struct{}
+
+func (d *mysqlDialect) Name() string       { return "mysql" }
+func (d *mysqlDialect) DriverName() string { return "mysql" }
+func (d *mysqlDialect) ReturningID() bool  { return false }
+
+func (d *mysqlDialect) Rebind(query string) string {
+	return query
+}
+
+func (d *mysqlDialect) Upsert(key string, columns ...string) string {
+	if len(columns) == 0 {
+		// assigning a key column to itself leaves the row as it was
+		column := strings.TrimSpace(strings.Split(key, ",")[0])
+		return "on duplicate key update " + column + " = " + column
+	}
+	assignments := make([]string, len(columns))
+	for i, column := range columns {
+		assignments[i] = column + " = values(" + column + ")"
+	}
+	return "on duplicate key update " + strings.Join(assignments, ", ")
+}
+
+var mysqlSchema = strings.NewReplacer(
+	"{id}", "int not null auto_increment primary key",
+	"{datetime}", "datetime",
+	"{hash}", "binary(32)",
+	// the default collation already ignores case
+	"{nocase}", "",
+	"{create nocase collation}", "",
+	"{now}", "current_timestamp",
+)
+
+func (d *mysqlDialect) Schema(statement string) string {
+	return mysqlSchema.Replace(statement)
+}
+
+type postgresDialect struct{}
+
+func (d *postgresDialect) Name() string       { return "postgres" }
+func (d *postgresDialect) DriverName() string { return "postgres" }
+func (d *postgresDialect) ReturningID() bool  { return true }
+
+//Rebind numbers the placeholders as $1, $2, ... None of the
+//queries have a ? inside a string literal.
+func (d *postgresDialect) Rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func (d *postgresDialect) Upsert(key string, columns ...string) string {
+	return onConflict(key, columns)
+}
+
+var postgresSchema = strings.NewReplacer(
+	"{id}", "serial primary key",
+	"{datetime}", "timestamp",
+	"{hash}", "bytea",
+	// compare emails and usernames the way MySQL does
+	"{nocase}", " collate nocase",
+	"{create nocase collation}", "create collation if not exists nocase "+
+		"(provider = icu, locale = 'und-u-ks-level2', deterministic = false)",
+	"{now}", "current_timestamp",
+)
+
+func (d *postgresDialect) Schema(statement string) string {
+	return postgresSchema.Replace(statement)
+}
+
+type sqliteDialect struct{}
+
+func (d *sqliteDialect) Name() string       { return "sqlite" }
+func (d *sqliteDialect) DriverName() string { return "sqlite" }
+func (d *sqliteDialect) ReturningID() bool  { return false }
+
+//Rebind drops row locks, which SQLite doesn't have. Open only allows one
+//connection to a SQLite database, so transactions can't interleave anyway.
+func (d *sqliteDialect) Rebind(query string) string {
+	return strings.Replace(query, " for update", "", -1)
+}
+
+func (d *sqliteDialect) Upsert(key string, columns ...string) string {
+	return onConflict(key, columns)
+}
+
+var sqliteSchema = strings.NewReplacer(
+	"{id}", "integer primary key autoincrement",
+	"{datetime}", "datetime",
+	"{hash}", "blob",
+	"{nocase}", " collate nocase",
+	"{create nocase collation}", "",
+	// columns can't be added with a default of the current time, so
+	// inserts set CreatedAt themselves. SQLite databases have only ever
+	// been made by migrating, so there aren't any rows to backfill.
+	"{now}", "'1970-01-01 00:00:00'",
+)
+
+func (d *sqliteDialect) Schema(statement string) string {
+	return sqliteSchema.Replace(statement)
+}
+
+//onConflict returns the standard SQL upsert clause used by Postgres and SQLite
+func onConflict(key string, columns []string) string {
+	if len(columns) == 0 {
+		return "on conflict (" + key + ") do nothing"
+	}
+	assignments := make([]string, len(columns))
+	for i, column := range columns {
+		assignments[i] = column + " = excluded." + column
+	}
+	return "on conflict (" + key + ") do update set " + strings.Join(assignments, ", ")
+}
+
+//ParseDSN returns the dialect named by the DSN's scheme, and the data
+//source name to open with its driver. DSNs without a scheme are MySQL's,
+//as they were before other databases were supported.
+func ParseDSN(dsn string) (Dialect, string, error) {
+	scheme := ""
+	if i := strings.Index(dsn, "://"); i >= 0 {
+		scheme = dsn[:i]
+	}
+	switch scheme {
+	case "":
+		return MySQL, dsn, nil
+	case "mysql":
+		return MySQL, strings.TrimPrefix(dsn, "mysql://"), nil
+	case "postgres", "postgresql":
+		// the driver takes the URL as it is
+		return Postgres, dsn, nil
+	case "sqlite":
+		return SQLite, strings.TrimPrefix(dsn, "sqlite://"), nil
+	}
+	return nil, "", ErrUnknownDSNScheme
+}
+
+//Open opens and pings the database named by the DSN,
+//returning it along with its dialect
+func Open(dsn string) (*sql.DB, Dialect, error) {
+	dialect, source, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := sql.Open(dialect.DriverName(), source)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dialect == SQLite {
+		// SQLite only has one writer at a time, and
+		// doesn't have row locks to coordinate them
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return db, dialect, nil
+}
+
+//isDuplicateEntry returns true if the error is a unique key violation
+func isDuplicateEntry(err error) bool {
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+		return mysqlErr.Number == mysqlDuplicateEntry
+	}
+	// Postgres drivers report the SQLSTATE, and SQLite's its result code
+	if pgErr, ok := err.(interface{ SQLState() string }); ok {
+		return pgErr.SQLState() == postgresUniqueViolation
+	}
+	if sqliteErr, ok := err.(interface{ Code() int }); ok {
+		return sqliteErr.Code() == sqliteConstraintUnique || sqliteErr.Code() == sqliteConstraintPrimaryKey
+	}
+	return false
+}
+
+//dialectDB runs queries on the database, rebinding them for its dialect
+type dialectDB struct {
+	*sql.DB
+	dialect Dialect
+}
+
+func (db *dialectDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.Rebind(query), args...)
+}
+
+func (db *dialectDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.Rebind(query), args...)
+}
+
+func (db *dialectDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.dialect.Rebind(query), args...)
+}
+
+func (db *dialectDB) Begin() (*dialectTx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &dialectTx{Tx: tx, dialect: db.dialect}, nil
+}
+
+//Insert runs the insert and returns the new row's ID
+func (db *dialectDB) Insert(query string, args ...interface{}) (int64, error) {
+	return insertID(db, db.dialect, query, args)
+}
+
+//dialectTx runs queries in a transaction, rebinding them for its dialect
+type dialectTx struct {
+	*sql.Tx
+	dialect Dialect
+}
+
+func (tx *dialectTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.Rebind(query), args...)
+}
+
+func (tx *dialectTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.Rebind(query), args...)
+}
+
+func (tx *dialectTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.Rebind(query), args...)
+}
+
+//Insert runs the insert and returns the new row's ID
+func (tx *dialectTx) Insert(query string, args ...interface{}) (int64, error) {
+	return insertID(tx, tx.dialect, query, args)
+}
+
+//execer is a dialectDB or dialectTx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+//insertID runs the insert and returns the new row's ID,
+//the way the dialect's driver supports
+func insertID(e execer, dialect Dialect, query string, args []interface{}) (int64, error) {
+	var id int64
+	if dialect.ReturningID() {
+		err := e.QueryRow(query+" returning id", args...).Scan(&id)
+		return id, err
+	}
+	res, err := e.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
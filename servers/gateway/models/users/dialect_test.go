@@ -0,0 +1,130 @@
+package users
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/indexes"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+)
+
+// pgError and sqliteError report errors the way the Postgres and SQLite drivers do
+type pgError string
+
+func (e pgError) Error() string    { return "pq: " + string(e) }
+func (e pgError) SQLState() string { return string(e) }
+
+type sqliteError int
+
+func (e sqliteError) Error() string { return "sqlite error" }
+func (e sqliteError) Code() int     { return int(e) }
+
+func TestParseDSN(t *testing.T) {
+	cases := []struct {
+		dsn     string
+		dialect Dialect
+		source  string
+	}{
+		{"root:password@tcp(mysqldemo:3306)/users?parseTime=true", MySQL, "root:password@tcp(mysqldemo:3306)/users?parseTime=true"},
+		{"mysql://root@tcp(localhost:3306)/users", MySQL, "root@tcp(localhost:3306)/users"},
+		{"postgres://gateway@localhost/users?sslmode=disable", Postgres, "postgres://gateway@localhost/users?sslmode=disable"},
+		{"postgresql://gateway@localhost/users", Postgres, "postgresql://gateway@localhost/users"},
+		{"sqlite:///var/lib/gateway/users.db", SQLite, "/var/lib/gateway/users.db"},
+	}
+	for _, c := range cases {
+		dialect, source, err := ParseDSN(c.dsn)
+		if err != nil || dialect != c.dialect || source != c.source {
+			t.Errorf("incorrect parse of %s: got %v, %s, %v", c.dsn, dialect, source, err)
+		}
+	}
+	if _, _, err := ParseDSN("oracle://localhost/users"); err != ErrUnknownDSNScheme {
+		t.Errorf("expected %v but got %v", ErrUnknownDSNScheme, err)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	query := "select id from USERS where Email = ? and id != ? for update"
+	if got := MySQL.Rebind(query); got != query {
+		t.Errorf("expected MySQL queries to be unchanged but got %s", got)
+	}
+	if got := Postgres.Rebind(query); got != "select id from USERS where Email = $1 and id != $2 for update" {
+		t.Errorf("incorrect Postgres query: %s", got)
+	}
+	if got := SQLite.Rebind(query); got != "select id from USERS where Email = ? and id != ?" {
+		t.Errorf("incorrect SQLite query: %s", got)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	cases := []struct {
+		dialect  Dialect
+		columns  []string
+		expected string
+	}{
+		{MySQL, []string{"UserID", "ChangedAt"}, "on duplicate key update UserID = values(UserID), ChangedAt = values(ChangedAt)"},
+		{MySQL, nil, "on duplicate key update BlockerID = BlockerID"},
+		{Postgres, []string{"UserID", "ChangedAt"}, "on conflict (BlockerID, BlockedID) do update set UserID = excluded.UserID, ChangedAt = excluded.ChangedAt"},
+		{SQLite, nil, "on conflict (BlockerID, BlockedID) do nothing"},
+	}
+	for _, c := range cases {
+		if got := c.dialect.Upsert("BlockerID, BlockedID", c.columns...); got != c.expected {
+			t.Errorf("incorrect %s upsert: expected %s but got %s", c.dialect.Name(), c.expected, got)
+		}
+	}
+}
+
+func TestIsDuplicateEntry(t *testing.T) {
+	duplicates := []error{&mysql.MySQLError{Number: mysqlDuplicateEntry}, pgError(postgresUniqueViolation),
+		sqliteError(sqliteConstraintUnique), sqliteError(sqliteConstraintPrimaryKey)}
+	for _, err := range duplicates {
+		if !isDuplicateEntry(err) {
+			t.Errorf("expected %#v to be a duplicate entry", err)
+		}
+	}
+	others := []error{nil, errors.New("other"), &mysql.MySQLError{Number: 1064}, pgError("42601"), sqliteError(1)}
+	for _, err := range others {
+		if isDuplicateEntry(err) {
+			t.Errorf("expected %#v not to be a duplicate entry", err)
+		}
+	}
+}
+
+func TestPostgresInsert(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := NewDialectSQLStore(db, Postgres, indexes.NewTrieNode())
+
+	history := func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("select count(*) from USERNAME_HISTORY where UserName = $1")).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	}
+
+	// Postgres returns the new ID instead of supporting LastInsertId
+	history()
+	mock.ExpectQuery(regexp.QuoteMeta("insert into USERS(Email, PassHash, UserName, FirstName, LastName, PhotoURL, CreatedAt) " +
+		"values($1,$2,$3,$4,$5,$6,$7) returning id")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectCommit()
+	user, err := store.Insert(&User{Email: "a@example.com", UserName: "a"})
+	if err != nil || user.ID != 7 {
+		t.Errorf("incorrect insert: %v, %v", user, err)
+	}
+
+	history()
+	mock.ExpectQuery(regexp.QuoteMeta("returning id")).WillReturnError(pgError(postgresUniqueViolation))
+	mock.ExpectRollback()
+	if _, err := store.Insert(&User{Email: "a@example.com", UserName: "a"}); err != ErrUserNameTaken {
+		t.Errorf("expected %v but got %v", ErrUserNameTaken, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
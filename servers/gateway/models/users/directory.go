@@ -0,0 +1,116 @@
+package users
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+//Orders the user directory can be sorted in
+const (
+	SortByUserName  = "userName"
+	SortByCreatedAt = "createdAt"
+)
+
+//DirectoryKey is the position of a user in the directory's sort order.
+//A page starts right after the key of the last user on the page before.
+type DirectoryKey struct {
+	UserName  string    `json:"u,omitempty"`
+	CreatedAt time.Time `json:"c"`
+	ID        int64     `json:"i"`
+}
+
+//DirectoryQuery selects one page of the user directory
+type DirectoryQuery struct {
+	//Sort is SortByUserName or SortByCreatedAt
+	Sort string
+	//Descending reverses the sort order
+	Descending bool
+	//Role, if set, only includes users with that role
+	Role string
+	//Verified, if set, only includes users whose email
+	//is or isn't verified
+	Verified *bool
+	//CreatedAfter, if set, only includes users created after it
+	CreatedAfter *time.Time
+	//After, if set, starts the page after this position
+	After *DirectoryKey
+	//Limit is the most users to return
+	Limit int
+}
+
+//Key returns the user's position in the given sort order
+func (u *User) Key(sort string) DirectoryKey {
+	if sort == SortByCreatedAt {
+		return DirectoryKey{CreatedAt: u.CreatedAt, ID: u.ID}
+	}
+	return DirectoryKey{UserName: u.UserName, ID: u.ID}
+}
+
+//ListDirectory returns a page of enabled users in the query's order,
+//using the position of the last page to seek straight to this one
+//rather than scanning past an offset
+func (ss *SQLStore) ListDirectory(query *DirectoryQuery) ([]*User, error) {
+	var column string
+	switch query.Sort {
+	case SortByUserName:
+		column = "UserName"
+	case SortByCreatedAt:
+		column = "CreatedAt"
+	default:
+		return nil, errors.New("Unknown sort order: " + query.Sort)
+	}
+	if query.Limit <= 0 {
+		return nil, errors.New("Limit must be positive.")
+	}
+	direction, comparison := "asc", ">"
+	if query.Descending {
+		direction, comparison = "desc", "<"
+	}
+
+	conditions := []string{"Disabled = false", notDeleted}
+	args := []interface{}{}
+	if len(query.Role) > 0 {
+		conditions = append(conditions, "Role = ?")
+		args = append(args, query.Role)
+	}
+	if query.Verified != nil {
+		conditions = append(conditions, "EmailVerified = ?")
+		args = append(args, *query.Verified)
+	}
+	if query.CreatedAfter != nil {
+		conditions = append(conditions, "CreatedAt > ?")
+		args = append(args, *query.CreatedAfter)
+	}
+	if query.After != nil {
+		// the id breaks ties between users created at the same time
+		conditions = append(conditions, "("+column+" "+comparison+" ? or ("+column+" = ? and id "+comparison+" ?))")
+		var key interface{} = query.After.UserName
+		if query.Sort == SortByCreatedAt {
+			key = query.After.CreatedAt
+		}
+		args = append(args, key, key, query.After.ID)
+	}
+	args = append(args, query.Limit)
+
+	sqlq := "select " + userColumns + " from USERS where " + strings.Join(conditions, " and ") +
+		" order by " + column + " " + direction + ", id " + direction + " limit ?"
+	rows, err := ss.db.Query(sqlq, args...)
+	if err != nil {
+		return nil, errors.New("Failed to query: " + err.Error())
+	}
+	defer rows.Close()
+
+	list := []*User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, errors.New("Error scanning row.")
+		}
+		list = append(list, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.New("Error reading rows: " + err.Error())
+	}
+	return list, nil
+}
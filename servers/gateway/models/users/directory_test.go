@@ -0,0 +1,67 @@
+package users
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/indexes"
+)
+
+func TestListDirectory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	sqlStore := NewSQLStore(db, indexes.NewTrieNode())
+	created := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// the first page by username
+	mock.ExpectQuery(regexp.QuoteMeta("select " + userColumns + " from USERS where Disabled = false and DeletedAt is null " +
+		"order by UserName asc, id asc limit ?")).
+		WithArgs(3).
+		WillReturnRows(userRows(&User{ID: 2, Email: "a@uw.edu", UserName: "alice", Role: RoleUser,
+			EmailVerified: true, CreatedAt: created}))
+
+	list, err := sqlStore.ListDirectory(&DirectoryQuery{Sort: SortByUserName, Limit: 3})
+	if err != nil {
+		t.Fatalf("unexpected error listing first page: %v", err)
+	}
+	if len(list) != 1 || list[0].UserName != "alice" || !list[0].EmailVerified || !list[0].CreatedAt.Equal(created) {
+		t.Errorf("incorrect first page: %+v", list)
+	}
+
+	// a later page, newest first, with every filter
+	verified := false
+	after := created.Add(-time.Hour)
+	key := list[0].Key(SortByCreatedAt)
+	mock.ExpectQuery(regexp.QuoteMeta("select "+userColumns+" from USERS where Disabled = false and DeletedAt is null and Role = ? "+
+		"and EmailVerified = ? and CreatedAt > ? and (CreatedAt < ? or (CreatedAt = ? and id < ?)) "+
+		"order by CreatedAt desc, id desc limit ?")).
+		WithArgs(RoleModerator, false, after, created, created, int64(2), 3).
+		WillReturnRows(userRows())
+
+	list, err = sqlStore.ListDirectory(&DirectoryQuery{
+		Sort:         SortByCreatedAt,
+		Descending:   true,
+		Role:         RoleModerator,
+		Verified:     &verified,
+		CreatedAfter: &after,
+		After:        &key,
+		Limit:        3,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error listing later page: %v", err)
+	}
+	if len(list) != 0 {
+		t.Errorf("expected an empty page but got %+v", list)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sql expectations: %v", err)
+	}
+
+	if _, err := sqlStore.ListDirectory(&DirectoryQuery{Sort: "PassHash", Limit: 3}); err == nil {
+		t.Error("expected an error when sorting by an unknown column")
+	}
+}
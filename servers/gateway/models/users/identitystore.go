@@ -0,0 +1,63 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+)
+
+//GetByIdentity returns the User linked to the given external identity
+//provider and subject, or ErrUserNotFound if no user is linked to it
+func (ss *SQLStore) GetByIdentity(provider string, subject string) (*User, error) {
+	var userID int64
+	row := ss.db.QueryRow("select UserID from USER_IDENTITIES where Provider = ? and Subject = ?", provider, subject)
+	if err := row.Scan(&userID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, errors.New("Error scanning row.")
+	}
+	return ss.GetByID(userID)
+}
+
+//Identity is an external identity provider account linked to a user
+type Identity struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+}
+
+//GetIdentities returns the external identities linked to the user
+func (ss *SQLStore) GetIdentities(userID int64) ([]*Identity, error) {
+	rows, err := ss.db.Query("select Provider, Subject from USER_IDENTITIES where UserID = ? order by Provider", userID)
+	if err != nil {
+		return nil, errors.New("Failed to query.")
+	}
+	defer rows.Close()
+
+	identities := []*Identity{}
+	for rows.Next() {
+		identity := Identity{}
+		if err := rows.Scan(&identity.Provider, &identity.Subject); err != nil {
+			return nil, errors.New("Error scanning row.")
+		}
+		identities = append(identities, &identity)
+	}
+	return identities, nil
+}
+
+//LinkIdentity links the external identity provider and subject to the user,
+//so that signing in with that identity signs in as the user
+func (ss *SQLStore) LinkIdentity(userID int64, provider string, subject string) error {
+	insq := "insert into USER_IDENTITIES(Provider, Subject, UserID) values(?,?,?)"
+	if _, err := ss.db.Exec(insq, provider, subject, userID); err != nil {
+		return errors.New("Error linking identity: " + err.Error())
+	}
+	return nil
+}
+
+//SetEmailVerified marks the email address of the user as verified
+func (ss *SQLStore) SetEmailVerified(userID int64) error {
+	if _, err := ss.db.Exec("update USERS set EmailVerified = true where id = ? and "+notDeleted, userID); err != nil {
+		return errors.New("Error updating row: " + err.Error())
+	}
+	return nil
+}
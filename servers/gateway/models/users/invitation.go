@@ -0,0 +1,81 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+//InvitationLifetime is how long an invitation can be accepted for
+const InvitationLifetime = 7 * 24 * time.Hour
+
+//ErrInvitationNotFound is returned when an invitation doesn't exist,
+//has expired, or was already accepted
+var ErrInvitationNotFound = errors.New("invitation not found or expired")
+
+//NewInvitationToken returns a new random invitation token along with
+//its hash, which is all that gets stored
+func NewInvitationToken() (string, []byte, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, errors.New("Problem generating invitation token.")
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashInvitationToken(token), nil
+}
+
+//HashInvitationToken returns the hash of the token that is stored and looked up
+func HashInvitationToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+//CreateInvitation stores an invitation for the user to set their password
+func (ss *SQLStore) CreateInvitation(userID int64, tokenHash []byte, expiresAt time.Time) error {
+	insq := "insert into INVITATIONS(TokenHash, UserID, ExpiresAt) values(?,?,?)"
+	if _, err := ss.db.Exec(insq, tokenHash, userID, expiresAt); err != nil {
+		return errors.New("Error saving invitation: " + err.Error())
+	}
+	return nil
+}
+
+//AcceptInvitation sets the password of the invited user and returns them.
+//Since the invitation was emailed to them, their email is now verified.
+//All of the user's invitations are used up.
+func (ss *SQLStore) AcceptInvitation(tokenHash []byte, password string) (*User, error) {
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return nil, errors.New("Error beginning transaction: " + err.Error())
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	// invitations for deleted users can't be accepted
+	row := tx.QueryRow("select i.UserID from INVITATIONS i join USERS u on u.id = i.UserID "+
+		"where i.TokenHash = ? and i.ExpiresAt > ? and u."+notDeleted+" for update",
+		tokenHash, time.Now().UTC())
+	if err := row.Scan(&userID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvitationNotFound
+		}
+		return nil, errors.New("Error scanning row.")
+	}
+
+	user := &User{ID: userID}
+	if err := user.SetPassword(password); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("update USERS set PassHash = ?, EmailVerified = true where id = ?", user.PassHash, userID); err != nil {
+		return nil, errors.New("Error updating row: " + err.Error())
+	}
+	if _, err := tx.Exec("delete from INVITATIONS where UserID = ?", userID); err != nil {
+		return nil, errors.New("Error deleting invitations: " + err.Error())
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.New("Error committing invitation: " + err.Error())
+	}
+	return ss.GetByID(userID)
+}
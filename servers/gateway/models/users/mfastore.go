@@ -0,0 +1,193 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//GetMFA returns the two-factor authentication settings for the given user ID.
+//Users who never enrolled get back settings with Enabled set to false.
+func (ss *SQLStore) GetMFA(userID int64) (*MFA, error) {
+	mfa := MFA{UserID: userID}
+	var lockedUntil sql.NullTime
+	row := ss.db.QueryRow("select Secret, Enabled, LastStep, LockedUntil from USER_MFA where UserID = ?", userID)
+	if err := row.Scan(&mfa.Secret, &mfa.Enabled, &mfa.LastStep, &lockedUntil); err != nil {
+		if err == sql.ErrNoRows {
+			return &mfa, nil
+		}
+		return nil, errors.New("Error scanning row.")
+	}
+	if lockedUntil.Valid {
+		mfa.LockedUntil = lockedUntil.Time
+	}
+	return &mfa, nil
+}
+
+//BeginMFAAttempt counts an attempt by the user to send a two-factor code,
+//before the code is checked, so concurrent requests can't get more codes
+//checked than one after another. Returns an *MFALockoutError if the user
+//is locked out, or this attempt is one too many since a code was last
+//accepted, which locks them out for the mfaLockout.
+func (ss *SQLStore) BeginMFAAttempt(userID int64, now time.Time) error {
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return errors.New("Error beginning transaction: " + err.Error())
+	}
+	defer tx.Rollback()
+
+	// incrementing first locks the row until the transaction ends
+	if _, err := tx.Exec("update USER_MFA set Attempts = Attempts + 1 where UserID = ?", userID); err != nil {
+		return errors.New("Error counting two-factor attempt: " + err.Error())
+	}
+	var attempts int
+	var lockedUntil sql.NullTime
+	row := tx.QueryRow("select Attempts, LockedUntil from USER_MFA where UserID = ?", userID)
+	if err := row.Scan(&attempts, &lockedUntil); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInvalidMFACode
+		}
+		return errors.New("Error scanning row.")
+	}
+	if lockedUntil.Valid && lockedUntil.Time.After(now) {
+		// rolled back, so attempts made while locked out don't count
+		return &MFALockoutError{RetryAt: lockedUntil.Time}
+	}
+	if attempts > maxMFAAttempts {
+		retryAt := now.Add(mfaLockout)
+		if _, err := tx.Exec("update USER_MFA set Attempts = 0, LockedUntil = ? where UserID = ?", retryAt, userID); err != nil {
+			return errors.New("Error locking out two-factor attempts: " + err.Error())
+		}
+		if err := tx.Commit(); err != nil {
+			return errors.New("Error committing transaction: " + err.Error())
+		}
+		return &MFALockoutError{RetryAt: retryAt}
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.New("Error committing transaction: " + err.Error())
+	}
+	return nil
+}
+
+//ResetMFAAttempts stops counting the user's two-factor code attempts
+//towards a lockout, once one of their codes was accepted
+func (ss *SQLStore) ResetMFAAttempts(userID int64) error {
+	if _, err := ss.db.Exec("update USER_MFA set Attempts = 0 where UserID = ? and Attempts > 0", userID); err != nil {
+		return errors.New("Error resetting two-factor attempts: " + err.Error())
+	}
+	return nil
+}
+
+//BeginMFAEnrollment stores a new, not yet confirmed, TOTP secret for the user,
+//replacing any previous unconfirmed secret
+func (ss *SQLStore) BeginMFAEnrollment(userID int64, secret string) error {
+	insq := "insert into USER_MFA(UserID, Secret, Enabled) values(?,?,false) " +
+		ss.db.dialect.Upsert("UserID", "Secret", "Enabled")
+	if _, err := ss.db.Exec(insq, userID, secret); err != nil {
+		return errors.New("Error saving TOTP secret: " + err.Error())
+	}
+	return nil
+}
+
+//UseTOTPStep records that the user's TOTP code from the time step was
+//accepted. Returns ErrInvalidMFACode if a code from that step or a later
+//one was accepted first, so two requests can't both use the same code.
+func (ss *SQLStore) UseTOTPStep(userID int64, step int64) error {
+	result, err := ss.db.Exec("update USER_MFA set LastStep = ? where UserID = ? and LastStep < ?", step, userID, step)
+	if err != nil {
+		return errors.New("Error recording TOTP code: " + err.Error())
+	}
+	if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+//EnableMFA marks the user's pending TOTP secret as confirmed and replaces
+//their recovery codes with the given bcrypt hashes
+func (ss *SQLStore) EnableMFA(userID int64, recoveryHashes [][]byte) error {
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return errors.New("Error beginning transaction: " + err.Error())
+	}
+	if _, err := tx.Exec("update USER_MFA set Enabled = true where UserID = ?", userID); err != nil {
+		tx.Rollback()
+		return errors.New("Error enabling two-factor authentication: " + err.Error())
+	}
+	if _, err := tx.Exec("delete from RECOVERY_CODES where UserID = ?", userID); err != nil {
+		tx.Rollback()
+		return errors.New("Error deleting old recovery codes: " + err.Error())
+	}
+	for _, hash := range recoveryHashes {
+		if _, err := tx.Exec("insert into RECOVERY_CODES(UserID, CodeHash) values(?,?)", userID, hash); err != nil {
+			tx.Rollback()
+			return errors.New("Error inserting recovery code: " + err.Error())
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.New("Error committing transaction: " + err.Error())
+	}
+	return nil
+}
+
+//DisableMFA removes the user's TOTP secret and recovery codes
+func (ss *SQLStore) DisableMFA(userID int64) error {
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return errors.New("Error beginning transaction: " + err.Error())
+	}
+	if _, err := tx.Exec("delete from RECOVERY_CODES where UserID = ?", userID); err != nil {
+		tx.Rollback()
+		return errors.New("Error deleting recovery codes: " + err.Error())
+	}
+	if _, err := tx.Exec("delete from USER_MFA where UserID = ?", userID); err != nil {
+		tx.Rollback()
+		return errors.New("Error deleting TOTP secret: " + err.Error())
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.New("Error committing transaction: " + err.Error())
+	}
+	return nil
+}
+
+//UseRecoveryCode checks the code against the user's unused recovery codes.
+//A matching code is consumed so it can't be used again, even by a
+//request checking it at the same time. Returns ErrInvalidMFACode if no
+//code matches, or another request used it first.
+func (ss *SQLStore) UseRecoveryCode(userID int64, code string) error {
+	code = normalizeRecoveryCode(code)
+	rows, err := ss.db.Query("select id, CodeHash from RECOVERY_CODES where UserID = ?", userID)
+	if err != nil {
+		return errors.New("Failed to query.")
+	}
+	defer rows.Close()
+
+	var matchedID int64
+	for rows.Next() {
+		var id int64
+		var hash []byte
+		if err := rows.Scan(&id, &hash); err != nil {
+			return errors.New("Error scanning row.")
+		}
+		if bcrypt.CompareHashAndPassword(hash, []byte(code)) == nil {
+			matchedID = id
+			break
+		}
+	}
+	rows.Close()
+
+	if matchedID == 0 {
+		return ErrInvalidMFACode
+	}
+	// only the request that deletes the code gets to use it
+	result, err := ss.db.Exec("delete from RECOVERY_CODES where id = ?", matchedID)
+	if err != nil {
+		return errors.New("Error consuming recovery code: " + err.Error())
+	}
+	if deleted, err := result.RowsAffected(); err != nil || deleted != 1 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package users
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/indexes"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBeginMFAAttempt(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	sqlStore := NewSQLStore(db, indexes.NewTrieNode())
+	now := time.Now()
+
+	count := regexp.QuoteMeta("update USER_MFA set Attempts = Attempts + 1 where UserID = ?")
+	attempts := regexp.QuoteMeta("select Attempts, LockedUntil from USER_MFA where UserID = ?")
+	lock := regexp.QuoteMeta("update USER_MFA set Attempts = 0, LockedUntil = ? where UserID = ?")
+
+	// attempts up to the limit are counted
+	mock.ExpectBegin()
+	mock.ExpectExec(count).WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(attempts).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"Attempts", "LockedUntil"}).AddRow(maxMFAAttempts, nil))
+	mock.ExpectCommit()
+	if err := sqlStore.BeginMFAAttempt(1, now); err != nil {
+		t.Errorf("unexpected error beginning attempt: %v", err)
+	}
+
+	// one more locks the user out
+	mock.ExpectBegin()
+	mock.ExpectExec(count).WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(attempts).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"Attempts", "LockedUntil"}).AddRow(maxMFAAttempts+1, nil))
+	mock.ExpectExec(lock).WithArgs(now.Add(mfaLockout), int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	lockout, ok := sqlStore.BeginMFAAttempt(1, now).(*MFALockoutError)
+	if !ok || !lockout.RetryAt.Equal(now.Add(mfaLockout)) {
+		t.Errorf("expected a lockout until %v but got %v", now.Add(mfaLockout), lockout)
+	}
+
+	// attempts while locked out aren't counted
+	mock.ExpectBegin()
+	mock.ExpectExec(count).WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(attempts).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"Attempts", "LockedUntil"}).AddRow(1, now.Add(time.Minute)))
+	mock.ExpectRollback()
+	if _, ok := sqlStore.BeginMFAAttempt(1, now).(*MFALockoutError); !ok {
+		t.Error("expected a lockout error while locked out")
+	}
+
+	// users who never enrolled have no codes to guess
+	mock.ExpectBegin()
+	mock.ExpectExec(count).WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(attempts).WithArgs(int64(2)).WillReturnRows(sqlmock.NewRows([]string{"Attempts", "LockedUntil"}))
+	mock.ExpectRollback()
+	if err := sqlStore.BeginMFAAttempt(2, now); err != ErrInvalidMFACode {
+		t.Errorf("expected %v but got %v", ErrInvalidMFACode, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	sqlStore := NewSQLStore(db, indexes.NewTrieNode())
+	hash, _ := bcrypt.GenerateFromPassword([]byte("abcdefgh"), bcrypt.MinCost)
+
+	codes := regexp.QuoteMeta("select id, CodeHash from RECOVERY_CODES where UserID = ?")
+	consume := regexp.QuoteMeta("delete from RECOVERY_CODES where id = ?")
+	mock.ExpectQuery(codes).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "CodeHash"}).AddRow(7, hash))
+	mock.ExpectExec(consume).WithArgs(int64(7)).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := sqlStore.UseRecoveryCode(1, "ABCD-EFGH"); err != nil {
+		t.Errorf("unexpected error using recovery code: %v", err)
+	}
+
+	// a request that read the code before the other one deleted it
+	mock.ExpectQuery(codes).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "CodeHash"}).AddRow(7, hash))
+	mock.ExpectExec(consume).WithArgs(int64(7)).WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := sqlStore.UseRecoveryCode(1, "abcdefgh"); err != ErrInvalidMFACode {
+		t.Errorf("expected %v for a code used at the same time but got %v", ErrInvalidMFACode, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
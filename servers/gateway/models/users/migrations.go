@@ -0,0 +1,226 @@
+package users
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//migration is one step in building the users database. Statements are
+//run in order, after their {tokens} are rewritten by Dialect.Schema,
+//and statements that rewrite to nothing are skipped.
+type migration struct {
+	Version     int
+	Description string
+	Statements  []string
+	//Probe is a query that only succeeds once the migration's changes
+	//are there. Databases made from the old db/schema.sql, before
+	//migrations were recorded, have the migrations whose probes succeed.
+	//Migrations from after db/schema.sql was retired don't have one.
+	Probe string
+}
+
+//migrations builds the users database on every dialect, starting from
+//the original USERS table, with a migration for each feature after it
+//in the order db/schema.sql gained them.
+//New migrations go at the end; never edit one that's been released.
+var migrations = []migration{
+	{1, "users", []string{
+		"{create nocase collation}",
+		`create table USERS (
+			id {id},
+			Email varchar(254){nocase} not null,
+			PassHash varchar(72) not null,
+			UserName varchar(255){nocase} not null,
+			FirstName varchar(64) not null,
+			LastName varchar(128) not null,
+			PhotoURL varchar(255) not null
+		)`,
+		"create index userIndex on USERS (Email, UserName)",
+	}, "select id, Email, PassHash, UserName, FirstName, LastName, PhotoURL from USERS where 1 = 0"},
+	{2, "two-factor authentication", []string{
+		`create table USER_MFA (
+			UserID int not null primary key,
+			Secret varchar(64) not null,
+			Enabled boolean not null default false
+		)`,
+		`create table RECOVERY_CODES (
+			id {id},
+			UserID int not null,
+			CodeHash varchar(72) not null
+		)`,
+		"create index recoveryCodeUserIndex on RECOVERY_CODES (UserID)",
+	}, "select UserID from RECOVERY_CODES where 1 = 0"},
+	{3, "OpenID Connect identities", []string{
+		`create table USER_IDENTITIES (
+			Provider varchar(64) not null,
+			Subject varchar(255) not null,
+			UserID int not null,
+			primary key (Provider, Subject)
+		)`,
+	}, "select UserID from USER_IDENTITIES where 1 = 0"},
+	{4, "personal access tokens", []string{
+		`create table ACCESS_TOKENS (
+			id {id},
+			UserID int not null,
+			TokenHash {hash} not null unique,
+			Name varchar(64) not null,
+			Scopes varchar(255) not null,
+			CreatedAt {datetime} not null,
+			ExpiresAt {datetime} not null,
+			LastUsedAt {datetime} null
+		)`,
+		"create index accessTokenUserIndex on ACCESS_TOKENS (UserID)",
+	}, "select UserID from ACCESS_TOKENS where 1 = 0"},
+	{5, "roles and disabling users", []string{
+		"alter table USERS add column Role varchar(16) not null default 'user'",
+		"alter table USERS add column Disabled boolean not null default false",
+	}, "select Role, Disabled from USERS where 1 = 0"},
+	{6, "avatar sources", []string{
+		"alter table USERS add column AvatarSource varchar(16) not null default ''",
+	}, "select AvatarSource from USERS where 1 = 0"},
+	{7, "extended profiles", []string{
+		"alter table USERS add column DisplayName varchar(64) not null default ''",
+		"alter table USERS add column Bio varchar(1024) not null default ''",
+		"alter table USERS add column Title varchar(128) not null default ''",
+		"alter table USERS add column Pronouns varchar(32) not null default ''",
+		"alter table USERS add column Timezone varchar(64) not null default ''",
+		"alter table USERS add column StatusText varchar(140) not null default ''",
+	}, "select DisplayName, Bio, Title, Pronouns, Timezone, StatusText from USERS where 1 = 0"},
+	{8, "username changes", []string{
+		"alter table USERS add column UserNameChangedAt {datetime} null",
+		"create unique index userNameIndex on USERS (UserName)",
+		`create table USERNAME_HISTORY (
+			UserName varchar(255){nocase} not null primary key,
+			UserID int not null,
+			ChangedAt {datetime} not null
+		)`,
+		"create index userNameHistoryUserIndex on USERNAME_HISTORY (UserID)",
+	}, "select UserID from USERNAME_HISTORY where 1 = 0"},
+	{9, "the user directory", []string{
+		"alter table USERS add column EmailVerified boolean not null default false",
+		"alter table USERS add column CreatedAt {datetime} not null default {now}",
+		"create index userCreatedAtIndex on USERS (CreatedAt, id)",
+	}, "select EmailVerified, CreatedAt from USERS where 1 = 0"},
+	{10, "invitations", []string{
+		`create table INVITATIONS (
+			TokenHash {hash} not null primary key,
+			UserID int not null,
+			ExpiresAt {datetime} not null
+		)`,
+		"create index invitationUserIndex on INVITATIONS (UserID)",
+	}, "select UserID from INVITATIONS where 1 = 0"},
+	{11, "soft deletion", []string{
+		"alter table USERS add column DeletedAt {datetime} null",
+		"create index userDeletedAtIndex on USERS (DeletedAt)",
+	}, "select DeletedAt from USERS where 1 = 0"},
+	{12, "blocks", []string{
+		`create table BLOCKS (
+			BlockerID int not null,
+			BlockedID int not null,
+			CreatedAt {datetime} not null,
+			primary key (BlockerID, BlockedID)
+		)`,
+		"create index blockedIndex on BLOCKS (BlockedID)",
+	}, "select BlockerID from BLOCKS where 1 = 0"},
+	{13, "the last TOTP time step each user signed in with, so codes can't be replayed", []string{
+		"alter table USER_MFA add column LastStep bigint not null default 0",
+	}, ""},
+	{14, "two-factor code attempts, so guessing codes locks the user out for a while", []string{
+		"alter table USER_MFA add column Attempts int not null default 0",
+		"alter table USER_MFA add column LockedUntil {datetime} null",
+	}, ""},
+}
+
+//Migrate brings the database up to date by running the migrations it
+//hasn't had yet, each in its own transaction, and returns how many ran.
+//MySQL commits schema changes as it goes, so a migration that fails
+//there has to be cleaned up by hand before it's run again.
+func (ss *SQLStore) Migrate() (int, error) {
+	if _, err := ss.db.Exec("create table if not exists SCHEMA_MIGRATIONS (" +
+		"Version int not null primary key, AppliedAt " + ss.db.dialect.Schema("{datetime}") + " not null)"); err != nil {
+		return 0, errors.New("Error creating SCHEMA_MIGRATIONS: " + err.Error())
+	}
+
+	applied, err := ss.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(applied) == 0 {
+		// databases made from the old db/schema.sql have the migrations
+		// for the features it had when they were made, which are recorded
+		// without running them
+		for _, m := range migrations {
+			if len(m.Probe) == 0 || !ss.probe(m.Probe) {
+				break
+			}
+			if _, err := ss.db.Exec("insert into SCHEMA_MIGRATIONS(Version, AppliedAt) values(?,?)", m.Version, time.Now().UTC()); err != nil {
+				return 0, errors.New("Error recording migration: " + err.Error())
+			}
+			applied[m.Version] = true
+		}
+	}
+	count := 0
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		if err := ss.runMigration(m); err != nil {
+			return count, errors.New("Problem running migration " + strconv.Itoa(m.Version) + ": " + err.Error())
+		}
+		count++
+	}
+	return count, nil
+}
+
+//appliedMigrations returns the versions of the migrations the database has had
+func (ss *SQLStore) appliedMigrations() (map[int]bool, error) {
+	rows, err := ss.db.Query("select Version from SCHEMA_MIGRATIONS")
+	if err != nil {
+		return nil, errors.New("Failed to query.")
+	}
+	defer rows.Close()
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, errors.New("Error scanning row.")
+		}
+		applied[version] = true
+	}
+	return applied, nil
+}
+
+//probe returns true if the query succeeds
+func (ss *SQLStore) probe(query string) bool {
+	rows, err := ss.db.Query(query)
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return rows.Err() == nil
+}
+
+//runMigration runs the migration's statements and records that it ran
+func (ss *SQLStore) runMigration(m migration) error {
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return errors.New("Error beginning transaction: " + err.Error())
+	}
+	defer tx.Rollback()
+
+	for _, statement := range m.Statements {
+		statement = ss.db.dialect.Schema(statement)
+		if len(strings.TrimSpace(statement)) == 0 {
+			continue
+		}
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("insert into SCHEMA_MIGRATIONS(Version, AppliedAt) values(?,?)", m.Version, time.Now().UTC()); err != nil {
+		return errors.New("Error recording migration: " + err.Error())
+	}
+	return tx.Commit()
+}
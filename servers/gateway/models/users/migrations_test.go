@@ -0,0 +1,133 @@
+package users
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/indexes"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const sqlCreateMigrations = "create table if not exists SCHEMA_MIGRATIONS"
+const sqlAppliedMigrations = "select Version from SCHEMA_MIGRATIONS"
+const sqlRecordMigration = "insert into SCHEMA_MIGRATIONS(Version, AppliedAt) values(?,?)"
+
+func TestMigrationSchema(t *testing.T) {
+	// every token is rewritten on every dialect
+	for _, dialect := range []Dialect{MySQL, Postgres, SQLite} {
+		for _, m := range migrations {
+			for _, statement := range m.Statements {
+				if rewritten := dialect.Schema(statement); strings.ContainsAny(rewritten, "{}") {
+					t.Errorf("%s migration %d has an unknown token: %s", dialect.Name(), m.Version, rewritten)
+				}
+			}
+		}
+	}
+	if got := SQLite.Schema("id {id}"); got != "id integer primary key autoincrement" {
+		t.Errorf("incorrect SQLite ID column: %s", got)
+	}
+	if got := Postgres.Schema("Email varchar(254){nocase} not null"); got != "Email varchar(254) collate nocase not null" {
+		t.Errorf("incorrect Postgres email column: %s", got)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := NewDialectSQLStore(db, SQLite, indexes.NewTrieNode())
+
+	// a new database runs every migration
+	mock.ExpectExec(regexp.QuoteMeta(sqlCreateMigrations)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(sqlAppliedMigrations)).WillReturnRows(sqlmock.NewRows([]string{"Version"}))
+	mock.ExpectQuery(regexp.QuoteMeta(migrations[0].Probe)).WillReturnError(errors.New("no such table: USERS"))
+	for _, m := range migrations {
+		mock.ExpectBegin()
+		for _, statement := range m.Statements {
+			// SQLite doesn't need a collation made
+			if statement == "{create nocase collation}" {
+				continue
+			}
+			mock.ExpectExec(regexp.QuoteMeta(SQLite.Schema(statement))).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		mock.ExpectExec(regexp.QuoteMeta(sqlRecordMigration)).WithArgs(m.Version, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	if count, err := store.Migrate(); err != nil || count != len(migrations) {
+		t.Errorf("expected %d migrations to run but ran %d: %v", len(migrations), count, err)
+	}
+
+	// an up to date database runs none
+	mock.ExpectExec(regexp.QuoteMeta(sqlCreateMigrations)).WillReturnResult(sqlmock.NewResult(0, 0))
+	applied := sqlmock.NewRows([]string{"Version"})
+	for _, m := range migrations {
+		applied.AddRow(m.Version)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(sqlAppliedMigrations)).WillReturnRows(applied)
+	if count, err := store.Migrate(); err != nil || count != 0 {
+		t.Errorf("expected no migrations to run but ran %d: %v", count, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestMigrateExistingSchema(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db, indexes.NewTrieNode())
+
+	// a database made from db/schema.sql when it had roles but not
+	// avatars has the migrations up to roles, and gets the rest
+	const existing = 5
+	mock.ExpectExec(regexp.QuoteMeta(sqlCreateMigrations)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(sqlAppliedMigrations)).WillReturnRows(sqlmock.NewRows([]string{"Version"}))
+	for _, m := range migrations[:existing] {
+		mock.ExpectQuery(regexp.QuoteMeta(m.Probe)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec(regexp.QuoteMeta(sqlRecordMigration)).WithArgs(m.Version, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(migrations[existing].Probe)).WillReturnError(errors.New("Unknown column 'AvatarSource'"))
+	for _, m := range migrations[existing:] {
+		mock.ExpectBegin()
+		for _, statement := range m.Statements {
+			if len(strings.TrimSpace(MySQL.Schema(statement))) > 0 {
+				mock.ExpectExec(regexp.QuoteMeta(MySQL.Schema(statement))).WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+		}
+		mock.ExpectExec(regexp.QuoteMeta(sqlRecordMigration)).WithArgs(m.Version, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	if count, err := store.Migrate(); err != nil || count != len(migrations)-existing {
+		t.Errorf("expected %d migrations to run but ran %d: %v", len(migrations)-existing, count, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestMigrationProbes(t *testing.T) {
+	// only migrations from before migrations were recorded
+	// have probes, and none come after one without
+	probed := true
+	for _, m := range migrations {
+		if len(m.Probe) > 0 && !probed {
+			t.Errorf("migration %d has a probe after one without", m.Version)
+		}
+		probed = len(m.Probe) > 0
+	}
+	if len(migrations[0].Probe) == 0 {
+		t.Error("expected the first migration to have a probe")
+	}
+}
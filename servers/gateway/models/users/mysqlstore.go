@@ -5,50 +5,75 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/indexes"
 )
 
 //RedisStore represents a session.Store backed by redis.
 type SQLStore struct {
-	// SQL database object
-	db *sql.DB
+	// SQL database object, which rebinds queries for its dialect
+	db *dialectDB
 
-	// Trie for searching users by UserName, FirstName, LastName
+	// Trie for searching users by UserName, FirstName, LastName, DisplayName
 	searchIndex *indexes.TrieNode
 }
 
-//NewSQLStore constructs a new SQLStore
+//NewSQLStore constructs a new SQLStore for a MySQL database
 func NewSQLStore(db *sql.DB, searchIndex *indexes.TrieNode) *SQLStore {
+	return NewDialectSQLStore(db, MySQL, searchIndex)
+}
+
+//NewDialectSQLStore constructs a new SQLStore for a database of the given dialect
+func NewDialectSQLStore(db *sql.DB, dialect Dialect, searchIndex *indexes.TrieNode) *SQLStore {
 	mySQLStore := SQLStore{
-		db:          db,
+		db:          &dialectDB{DB: db, dialect: dialect},
 		searchIndex: searchIndex,
 	}
 	return &mySQLStore
 }
 
+//userColumns are the columns selected for a User
+const userColumns = "id, Email, PassHash, UserName, FirstName, LastName, PhotoURL, Role, Disabled, AvatarSource, " +
+	"DisplayName, Bio, Title, Pronouns, Timezone, StatusText, EmailVerified, CreatedAt"
+
+//notDeleted is the condition that hides soft-deleted users.
+//Every query for users that clients can see must include it.
+const notDeleted = "DeletedAt is null"
+
+//scanUser scans a row of `userColumns` into a User
+func scanUser(scanner interface{ Scan(...interface{}) error }) (*User, error) {
+	user := User{}
+	if err := scanner.Scan(&user.ID, &user.Email,
+		&user.PassHash, &user.UserName, &user.FirstName,
+		&user.LastName, &user.PhotoURL, &user.Role, &user.Disabled, &user.AvatarSource,
+		&user.DisplayName, &user.Bio, &user.Title, &user.Pronouns,
+		&user.Timezone, &user.StatusText, &user.EmailVerified, &user.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 func (ss *SQLStore) Query(prefix string, max int) []int64 {
 	return ss.searchIndex.Find(prefix, max)
 }
 
 func (ss *SQLStore) AddAllUsersToTrie() error {
-	rows, err := ss.db.Query("select * from USERS")
+	// disabled and deleted users are hidden from search
+	rows, err := ss.db.Query("select " + userColumns + " from USERS where Disabled = false and " + notDeleted)
 	if err != nil {
 		return errors.New("Failed to select all users when adding to trie")
 	}
 
 	defer rows.Close()
 
-	users := User{}
-
 	for rows.Next() {
 		// Scans row into users struct.
-		if err := rows.Scan(&users.ID, &users.Email,
-			&users.PassHash, &users.UserName, &users.FirstName,
-			&users.LastName, &users.PhotoURL); err != nil {
+		users, err := scanUser(rows)
+		if err != nil {
 			return errors.New("Error scanning row.")
 		}
-		ss.AddUserToTrie(&users)
+		ss.AddUserToTrie(users)
 	}
 
 	return nil
@@ -58,9 +83,11 @@ func (ss *SQLStore) AddUserToTrie(users *User) {
 	id := users.ID
 	// make lower case
 	// if there are spaces, break it up
-	username := strings.Split(strings.ToLower(users.UserName), " ")
-	firstname := strings.Split(strings.ToLower(users.FirstName), " ")
-	lastname := strings.Split(strings.ToLower(users.LastName), " ")
+	// (Fields skips empty names, which can't be added to the trie)
+	username := strings.Fields(strings.ToLower(users.UserName))
+	firstname := strings.Fields(strings.ToLower(users.FirstName))
+	lastname := strings.Fields(strings.ToLower(users.LastName))
+	displayname := strings.Fields(strings.ToLower(users.DisplayName))
 
 	for _, element := range username {
 		ss.searchIndex.Add(element, id)
@@ -73,14 +100,24 @@ func (ss *SQLStore) AddUserToTrie(users *User) {
 	for _, element := range lastname {
 		ss.searchIndex.Add(element, id)
 	}
+
+	for _, element := range displayname {
+		ss.searchIndex.Add(element, id)
+	}
 }
 
 func (ss *SQLStore) DeleteUserFromTrie(users *User) {
 	id := users.ID
 	// make lower case
 	// if there are spaces, break it up
-	firstname := strings.Split(strings.ToLower(users.FirstName), " ")
-	lastname := strings.Split(strings.ToLower(users.LastName), " ")
+	username := strings.Fields(strings.ToLower(users.UserName))
+	firstname := strings.Fields(strings.ToLower(users.FirstName))
+	lastname := strings.Fields(strings.ToLower(users.LastName))
+	displayname := strings.Fields(strings.ToLower(users.DisplayName))
+
+	for _, element := range username {
+		ss.searchIndex.Remove(element, id)
+	}
 
 	for _, element := range firstname {
 		ss.searchIndex.Remove(element, id)
@@ -89,141 +126,152 @@ func (ss *SQLStore) DeleteUserFromTrie(users *User) {
 	for _, element := range lastname {
 		ss.searchIndex.Remove(element, id)
 	}
+
+	for _, element := range displayname {
+		ss.searchIndex.Remove(element, id)
+	}
 }
 
 //GetByID returns the User with the given ID
 func (ss *SQLStore) GetByID(id int64) (*User, error) {
-	rows, err := ss.db.Query("select id, Email, PassHash, UserName, FirstName, LastName, PhotoURL from USERS")
+	users, err := scanUser(ss.db.QueryRow("select "+userColumns+" from USERS where id = ? and "+notDeleted, id))
 	if err != nil {
-		return nil, errors.New("Failed to query.")
-	}
-
-	defer rows.Close()
-
-	users := User{}
-
-	for rows.Next() {
-		// Scans row into users struct.
-		if err := rows.Scan(&users.ID, &users.Email,
-			&users.PassHash, &users.UserName, &users.FirstName,
-			&users.LastName, &users.PhotoURL); err != nil {
-			return nil, errors.New("Error scanning row.")
-		}
-
-		// checks the ID, and if it matches, return that user.
-		if users.ID == id {
-			return &users, nil
+		if err == sql.ErrNoRows {
+			return nil, errors.New("User with ID not found.")
 		}
+		return nil, errors.New("Error scanning row.")
 	}
-
-	return nil, errors.New("User with ID not found.")
+	return users, nil
 }
 
 //GetByEmail returns the User with the given email
 func (ss *SQLStore) GetByEmail(email string) (*User, error) {
-	rows, err := ss.db.Query("select id, Email, PassHash, UserName, FirstName, LastName, PhotoURL from USERS")
+	users, err := scanUser(ss.db.QueryRow("select "+userColumns+" from USERS where Email = ? and "+notDeleted, email))
 	if err != nil {
-		return nil, errors.New("Failed to query.")
-	}
-
-	defer rows.Close()
-
-	users := User{}
-
-	for rows.Next() {
-		if err := rows.Scan(&users.ID, &users.Email,
-			&users.PassHash, &users.UserName, &users.FirstName,
-			&users.LastName, &users.PhotoURL); err != nil {
-			return nil, errors.New("Error scanning row.")
-		}
-
-		if users.Email == email {
-			return &users, nil
+		if err == sql.ErrNoRows {
+			return nil, nil
 		}
+		return nil, errors.New("Error scanning row.")
 	}
-	return nil, nil
+	return users, nil
 }
 
 //GetByUserName returns the User with the given Username
 func (ss *SQLStore) GetByUserName(username string) (*User, error) {
-	rows, err := ss.db.Query("select id, Email, PassHash, UserName, FirstName, LastName, PhotoURL from USERS")
+	users, err := scanUser(ss.db.QueryRow("select "+userColumns+" from USERS where UserName = ? and "+notDeleted, username))
 	if err != nil {
-		return nil, errors.New("Failed to query.")
-	}
-
-	defer rows.Close()
-
-	users := User{}
-
-	for rows.Next() {
-		if err := rows.Scan(&users.ID, &users.Email,
-			&users.PassHash, &users.UserName, &users.FirstName,
-			&users.LastName, &users.PhotoURL); err != nil {
-			return nil, errors.New("Error scanning row.")
-		}
-
-		if users.UserName == username {
-			return &users, nil
+		if err == sql.ErrNoRows {
+			return nil, nil
 		}
+		return nil, errors.New("Error scanning row.")
 	}
-
-	return nil, nil
+	return users, nil
 }
 
 //Insert inserts the user into the database, and returns
 //the newly-inserted User, complete with the DBMS-assigned ID
 func (ss *SQLStore) Insert(user *User) (*User, error) {
-	insq := "insert into USERS(Email, PassHash, UserName, FirstName, LastName, PhotoURL) values(?,?,?,?,?,?)"
-	res, err := ss.db.Exec(insq, user.Email, user.PassHash, user.UserName, user.FirstName, user.LastName, user.PhotoURL)
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return user, errors.New("Error beginning transaction: " + err.Error())
+	}
+	defer tx.Rollback()
+
+	// usernames that still redirect to someone else are taken too
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	if err := checkUserNameHistory(tx, user.UserName, 0, createdAt); err != nil {
+		return user, err
+	}
+	insq := "insert into USERS(Email, PassHash, UserName, FirstName, LastName, PhotoURL, CreatedAt) values(?,?,?,?,?,?,?)"
+	id, err := tx.Insert(insq, user.Email, user.PassHash, user.UserName, user.FirstName, user.LastName, user.PhotoURL, createdAt)
+	if isDuplicateEntry(err) {
+		return user, ErrUserNameTaken
+	}
 	if err != nil {
 		fmt.Printf("error inserting row: %v\n", err)
 		return user, errors.New("Error inserting row: " + err.Error())
-	} else {
-		id, err := res.LastInsertId()
-		if err != nil {
-			fmt.Printf("error getting new id: %v\n", err)
-			return user, errors.New("Error getting new ID")
-		} else {
-			user.ID = id
-			return user, nil
-		}
 	}
+	if err := tx.Commit(); err != nil {
+		return user, errors.New("Error committing insert: " + err.Error())
+	}
+	user.ID = id
+	user.CreatedAt = createdAt
+	return user, nil
 }
 
 //Update applies UserUpdates to the given user ID
 //and returns the newly-updated user
 func (ss *SQLStore) Update(id int64, updates *Updates) (*User, error) {
-	upsq := "update USERS set firstname = ?, lastname = ?, where id = ?"
-	res, err := ss.db.Exec(upsq, updates.FirstName, updates.LastName, id)
+	if err := updates.Validate(); err != nil {
+		return nil, err
+	}
+
+	// lock the row so concurrent patches don't undo each other
+	tx, err := ss.db.Begin()
 	if err != nil {
-		return nil, errors.New("Error updating row")
-	} else {
-		id, err := res.LastInsertId()
-		if err != nil {
-			return nil, errors.New("Error getting new ID")
-		} else {
-			updatedUser, err := ss.GetByID(id)
-			if err != nil {
-				return nil, errors.New("Failed to get new user after updating.")
-			}
+		return nil, errors.New("Error beginning transaction: " + err.Error())
+	}
+	defer tx.Rollback()
 
-			// delete user from trie
-			ss.DeleteUserFromTrie(updatedUser)
+	user, err := scanUser(tx.QueryRow("select "+userColumns+" from USERS where id = ? and "+notDeleted+" for update", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, errors.New("Error getting user: " + err.Error())
+	}
+	oldUser := *user
 
-			// add in updated user
-			ss.AddUserToTrie(updatedUser)
+	if err := user.ApplyUpdates(updates); err != nil {
+		return nil, err
+	}
 
-			return updatedUser, nil
-		}
+	upsq := "update USERS set FirstName = ?, LastName = ?, DisplayName = ?, Bio = ?, " +
+		"Title = ?, Pronouns = ?, Timezone = ?, StatusText = ? where id = ?"
+	if _, err := tx.Exec(upsq, user.FirstName, user.LastName, user.DisplayName, user.Bio,
+		user.Title, user.Pronouns, user.Timezone, user.StatusText, id); err != nil {
+		return nil, errors.New("Error updating row: " + err.Error())
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.New("Error committing update: " + err.Error())
+	}
+
+	// disabled users aren't in the trie
+	if updates.ChangesName() && !user.Disabled {
+		ss.DeleteUserFromTrie(&oldUser)
+		ss.AddUserToTrie(user)
 	}
+	return user, nil
 }
 
-//Delete deletes the user with the given ID
+//Delete soft-deletes the user with the given ID. The row is kept, so
+//references to the user elsewhere still resolve, but the user is hidden
+//from every query and from search. It can be restored until
+//DeletedUserRetention has passed, after which PurgeDeleted removes it.
 func (ss *SQLStore) Delete(id int64) error {
-	desq := "delete from USERS where id = ?"
-	_, err := ss.db.Exec(desq, id)
+	user, err := ss.GetByID(id)
+	if err != nil {
+		return ErrUserNotFound
+	}
+	res, err := ss.db.Exec("update USERS set DeletedAt = ? where id = ? and "+notDeleted, time.Now().UTC(), id)
 	if err != nil {
-		return errors.New("Error deleting row, given ID might be invalid.")
+		return errors.New("Error deleting row: " + err.Error())
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return ErrUserNotFound
+	}
+	ss.DeleteUserFromTrie(user)
+	return nil
+}
+
+//SetAvatar sets where the avatar of the user with the given ID
+//comes from, along with the PhotoURL clients should show
+func (ss *SQLStore) SetAvatar(id int64, source string, photoURL string) error {
+	if !ValidAvatarSource(source) {
+		return ErrInvalidAvatarSource
+	}
+	if _, err := ss.db.Exec("update USERS set AvatarSource = ?, PhotoURL = ? where id = ? and "+notDeleted, source, photoURL, id); err != nil {
+		return errors.New("Error updating row: " + err.Error())
 	}
 	return nil
 }
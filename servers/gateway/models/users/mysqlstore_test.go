@@ -2,6 +2,7 @@ package users
 
 import (
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/indexes"
@@ -10,11 +11,22 @@ import (
 )
 
 const sqlColumnListNoID = "Email, PassHash, UserName, FirstName, LastName, PhotoURL"
-const sqlInsertUser = "insert into USERS(" + sqlColumnListNoID + ") values(?,?,?,?,?,?)"
+const sqlInsertUser = "insert into USERS(" + sqlColumnListNoID + ", CreatedAt) values(?,?,?,?,?,?,?)"
 const sqlGetByID = "select ID," + sqlColumnListNoID + " from USERS where id = ?"
 const sqlGetByEmail = "select ID," + sqlColumnListNoID + " from USERS where Email = ?"
 const sqlGetByUserName = "select ID," + sqlColumnListNoID + " from USERS where UserName = ?"
 
+// userRows returns mock rows of `userColumns` holding the given users
+func userRows(list ...*User) *sqlmock.Rows {
+	rows := sqlmock.NewRows(strings.Split(strings.Replace(userColumns, " ", "", -1), ","))
+	for _, u := range list {
+		rows.AddRow(u.ID, u.Email, u.PassHash, u.UserName, u.FirstName, u.LastName, u.PhotoURL,
+			u.Role, u.Disabled, u.AvatarSource, u.DisplayName, u.Bio, u.Title, u.Pronouns,
+			u.Timezone, u.StatusText, u.EmailVerified, u.CreatedAt)
+	}
+	return rows
+}
+
 func TestInsert(t *testing.T) {
 	// =======================
 	// Step 1: Create User
@@ -66,6 +78,13 @@ func TestInsert(t *testing.T) {
 	// simple success case
 	var newID int64 = 1
 
+	// the username mustn't still redirect to anyone
+	historySQL := regexp.QuoteMeta("select count(*) from USERNAME_HISTORY where UserName = ? and UserID != ? and ChangedAt > ?")
+	mock.ExpectBegin()
+	mock.ExpectQuery(historySQL).
+		WithArgs(profile.UserName, 0, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
 	// expect to exec the following sql statement
 	mock.ExpectExec(expectedSQL).
 		// with these arguments
@@ -76,9 +95,11 @@ func TestInsert(t *testing.T) {
 			profile.FirstName,
 			profile.LastName,
 			profile.PhotoURL,
+			sqlmock.AnyArg(),
 		).
 		// with these results
 		WillReturnResult(sqlmock.NewResult(newID, 1))
+	mock.ExpectCommit()
 
 	// now execute the insertion
 	insertedUser, err := sqlStore.Insert(profile)
@@ -90,4 +111,69 @@ func TestInsert(t *testing.T) {
 	} else if insertedUser.ID != newID {
 		t.Fatalf("incorrect new ID: expected %d but got %d", newID, insertedUser.ID)
 	}
+
+	// a username someone changed away from recently is still theirs
+	mock.ExpectBegin()
+	mock.ExpectQuery(historySQL).
+		WithArgs(profile.UserName, 0, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+	if _, err := sqlStore.Insert(profile); err != ErrUserNameTaken {
+		t.Errorf("expected %v but got %v", ErrUserNameTaken, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sql expectations: %v", err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	trie := indexes.NewTrieNode()
+	sqlStore := NewSQLStore(db, trie)
+
+	// the user as stored before the update
+	existing := &User{ID: 1, Email: "jsm209@uw.edu", UserName: "jsm209",
+		FirstName: "Joshua", LastName: "Maza", Role: RoleUser, Bio: "old bio", Pronouns: "he/him"}
+	sqlStore.AddUserToTrie(existing)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("select " + userColumns + " from USERS where id = ? and DeletedAt is null for update")).
+		WithArgs(existing.ID).
+		WillReturnRows(userRows(existing))
+	// first name changes, bio is cleared, and everything else stays the same
+	mock.ExpectExec(regexp.QuoteMeta("update USERS set FirstName = ?")).
+		WithArgs("Josh", existing.LastName, "", "", "", existing.Pronouns, "", "", existing.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	updates := &Updates{FirstName: Patch("Josh"), Bio: PatchString{Set: true}}
+	updatedUser, err := sqlStore.Update(existing.ID, updates)
+	if err != nil {
+		t.Fatalf("unexpected error during successful update: %v", err)
+	}
+	if updatedUser.FirstName != "Josh" || updatedUser.Bio != "" || updatedUser.Pronouns != existing.Pronouns {
+		t.Errorf("incorrect updated user: %+v", updatedUser)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sql expectations: %v", err)
+	}
+
+	// the trie finds the new name, and not the old one
+	if found := sqlStore.Query("josh", 10); len(found) != 1 || found[0] != existing.ID {
+		t.Errorf("expected to find the user by their new first name but got %v", found)
+	}
+	if found := sqlStore.Query("joshua", 10); len(found) != 0 {
+		t.Errorf("expected not to find the user by their old first name but got %v", found)
+	}
+
+	// invalid updates never reach the database
+	if _, err := sqlStore.Update(existing.ID, &Updates{Timezone: Patch("Mars/Olympus_Mons")}); err == nil {
+		t.Error("expected an error for an invalid time zone")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unexpected sql for invalid updates: %v", err)
+	}
 }
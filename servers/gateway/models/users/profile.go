@@ -0,0 +1,136 @@
+package users
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+//PatchString is a string field of a JSON merge patch (RFC 7396).
+//It remembers whether the field was in the patch at all, so a missing
+//field can leave the value unchanged while null clears it.
+type PatchString struct {
+	Set   bool
+	Value string
+}
+
+//Patch returns a PatchString that sets the field to the value
+func Patch(value string) PatchString {
+	return PatchString{Set: true, Value: value}
+}
+
+//UnmarshalJSON is only called when the field is present in the patch,
+//including when it is null
+func (p *PatchString) UnmarshalJSON(data []byte) error {
+	p.Set = true
+	p.Value = ""
+	if string(data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(data, &p.Value)
+}
+
+//ValidationErrors maps the JSON name of each invalid field
+//to the reason it's invalid
+type ValidationErrors map[string]string
+
+//Error lists the invalid fields and why, in a stable order
+func (ve ValidationErrors) Error() string {
+	fields := make([]string, 0, len(ve))
+	for field := range ve {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	messages := make([]string, len(fields))
+	for i, field := range fields {
+		messages[i] = field + ": " + ve[field]
+	}
+	return "Invalid profile: " + strings.Join(messages, "; ")
+}
+
+//profileField describes the limits on one profile field
+type profileField struct {
+	maxLength int
+	multiline bool
+}
+
+//profileFields are the limits on each field of Updates, keyed by JSON name.
+//The lengths match the column sizes in the schema.
+var profileFields = map[string]profileField{
+	"firstName":   {maxLength: 64},
+	"lastName":    {maxLength: 128},
+	"displayName": {maxLength: 64},
+	"bio":         {maxLength: 1024, multiline: true},
+	"title":       {maxLength: 128},
+	"pronouns":    {maxLength: 32},
+	"timezone":    {maxLength: 64},
+	"statusText":  {maxLength: 140},
+}
+
+//fields returns each field of the updates keyed by its JSON name
+func (updates *Updates) fields() map[string]PatchString {
+	return map[string]PatchString{
+		"firstName":   updates.FirstName,
+		"lastName":    updates.LastName,
+		"displayName": updates.DisplayName,
+		"bio":         updates.Bio,
+		"title":       updates.Title,
+		"pronouns":    updates.Pronouns,
+		"timezone":    updates.Timezone,
+		"statusText":  updates.StatusText,
+	}
+}
+
+//Validate checks each field that is set in the updates, and returns
+//ValidationErrors for the ones that are invalid, or nil if all are valid
+func (updates *Updates) Validate() error {
+	errs := ValidationErrors{}
+	for name, field := range updates.fields() {
+		if !field.Set {
+			continue
+		}
+		limits := profileFields[name]
+		if !utf8.ValidString(field.Value) {
+			errs[name] = "must be valid UTF-8"
+		} else if utf8.RuneCountInString(field.Value) > limits.maxLength {
+			errs[name] = "must be at most " + strconv.Itoa(limits.maxLength) + " characters"
+		} else if hasControlCharacters(field.Value, limits.multiline) {
+			errs[name] = "must not contain control characters"
+		}
+	}
+
+	if _, invalid := errs["timezone"]; !invalid && len(updates.Timezone.Value) > 0 {
+		if _, err := time.LoadLocation(updates.Timezone.Value); err != nil || updates.Timezone.Value == "Local" {
+			errs["timezone"] = "must be an IANA time zone name, such as America/Los_Angeles"
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+//ChangesName returns true if the updates change any of the
+//names the user can be found by in the search trie
+func (updates *Updates) ChangesName() bool {
+	return updates.FirstName.Set || updates.LastName.Set || updates.DisplayName.Set
+}
+
+//hasControlCharacters returns true if the string contains control
+//characters, other than newlines and tabs when multiline is allowed
+func hasControlCharacters(s string, multiline bool) bool {
+	for _, r := range s {
+		if multiline && (r == '\n' || r == '\t') {
+			continue
+		}
+		if unicode.IsControl(r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package users
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// Tests that merge patches tell apart missing, null and set fields
+func TestUpdatesUnmarshal(t *testing.T) {
+	updates := Updates{}
+	if err := json.Unmarshal([]byte(`{"firstName": "Josh", "bio": null}`), &updates); err != nil {
+		t.Fatalf("unexpected error unmarshaling patch: %v", err)
+	}
+	if updates.FirstName != Patch("Josh") {
+		t.Errorf("expected firstName to be set but got %+v", updates.FirstName)
+	}
+	if !updates.Bio.Set || updates.Bio.Value != "" {
+		t.Errorf("expected null bio to be set to empty but got %+v", updates.Bio)
+	}
+	if updates.LastName.Set {
+		t.Errorf("expected missing lastName to be left unset but got %+v", updates.LastName)
+	}
+	if err := json.Unmarshal([]byte(`{"title": 5}`), &updates); err == nil {
+		t.Error("expected an error when a field isn't a string")
+	}
+}
+
+// Tests for the Updates.Validate() method
+func TestUpdatesValidate(t *testing.T) {
+	cases := []struct {
+		name          string
+		updates       Updates
+		invalidFields []string
+	}{
+		{"Empty Patch", Updates{}, nil},
+		{"Cleared Names", Updates{FirstName: PatchString{Set: true}, LastName: PatchString{Set: true}}, nil},
+		{"Valid Fields", Updates{Bio: Patch("Line one\nLine two"), Timezone: Patch("America/Los_Angeles"), Pronouns: Patch("they/them")}, nil},
+		{"Long Status", Updates{StatusText: Patch(strings.Repeat("a", 141))}, []string{"statusText"}},
+		{"Long Name In Runes", Updates{DisplayName: Patch(strings.Repeat("é", 65))}, []string{"displayName"}},
+		{"Multibyte Within Limit", Updates{DisplayName: Patch(strings.Repeat("é", 64))}, nil},
+		{"Newline In Title", Updates{Title: Patch("Head\nof things")}, []string{"title"}},
+		{"Unknown Time Zone", Updates{Timezone: Patch("Mars/Olympus_Mons")}, []string{"timezone"}},
+		{"Local Time Zone", Updates{Timezone: Patch("Local")}, []string{"timezone"}},
+		{"Several Invalid", Updates{Pronouns: Patch(strings.Repeat("x", 33)), FirstName: Patch("\x00")}, []string{"firstName", "pronouns"}},
+	}
+
+	for _, c := range cases {
+		err := c.updates.Validate()
+		if len(c.invalidFields) == 0 {
+			if err != nil {
+				t.Errorf("case %s: unexpected error: %v", c.name, err)
+			}
+			continue
+		}
+		errs, ok := err.(ValidationErrors)
+		if !ok {
+			t.Errorf("case %s: expected ValidationErrors but got %v", c.name, err)
+			continue
+		}
+		if len(errs) != len(c.invalidFields) {
+			t.Errorf("case %s: expected %d invalid fields but got %v", c.name, len(c.invalidFields), errs)
+		}
+		for _, field := range c.invalidFields {
+			if _, found := errs[field]; !found {
+				t.Errorf("case %s: expected %s to be invalid", c.name, field)
+			}
+		}
+	}
+}
+
+// Tests that ApplyUpdates only changes the fields in the patch
+func TestApplyPartialUpdates(t *testing.T) {
+	user := User{FirstName: "Joshua", LastName: "Maza", Bio: "bio", StatusText: "busy"}
+	if err := user.ApplyUpdates(&Updates{LastName: Patch("M"), StatusText: PatchString{Set: true}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.FirstName != "Joshua" || user.Bio != "bio" {
+		t.Errorf("fields missing from the patch were changed: %+v", user)
+	}
+	if user.LastName != "M" || user.StatusText != "" {
+		t.Errorf("fields in the patch weren't applied: %+v", user)
+	}
+
+	if err := user.ApplyUpdates(&Updates{Timezone: Patch("nowhere")}); err == nil {
+		t.Error("expected an error applying an invalid patch")
+	}
+	if user.Timezone != "" {
+		t.Errorf("invalid patch was applied: %+v", user)
+	}
+}
@@ -0,0 +1,59 @@
+package users
+
+import "errors"
+
+//Roles a user can have
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+//Permission represents something a role is allowed to do
+type Permission string
+
+//Permissions checked by the admin endpoints
+const (
+	PermListUsers    Permission = "users:list"
+	PermDisableUsers Permission = "users:disable"
+	PermChangeRoles  Permission = "users:roles"
+	PermDeleteUsers  Permission = "users:delete"
+)
+
+//rolePermissions maps each role to the permissions it grants
+var rolePermissions = map[string]map[Permission]bool{
+	RoleUser: {},
+	RoleModerator: {
+		PermListUsers:    true,
+		PermDisableUsers: true,
+	},
+	RoleAdmin: {
+		PermListUsers:    true,
+		PermDisableUsers: true,
+		PermChangeRoles:  true,
+		PermDeleteUsers:  true,
+	},
+}
+
+//ErrUserDisabled is returned when a disabled user tries to sign in
+var ErrUserDisabled = errors.New("user account is disabled")
+
+//ValidRole returns true if the role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+//Can returns true if the user's role grants the permission.
+//Disabled users can't do anything.
+func (u *User) Can(perm Permission) bool {
+	if u.Disabled {
+		return false
+	}
+	return rolePermissions[u.Role][perm]
+}
+
+//IsStaff returns true if the user is a moderator or admin
+func (u *User) IsStaff() bool {
+	return u.Role == RoleModerator || u.Role == RoleAdmin
+}
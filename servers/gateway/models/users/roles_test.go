@@ -0,0 +1,41 @@
+package users
+
+import "testing"
+
+// Tests for the Can() method
+func TestCan(t *testing.T) {
+	cases := []struct {
+		input       User
+		perm        Permission
+		expected    bool
+		errorReason string
+	}{
+		{User{Role: RoleUser}, PermListUsers, false, "Regular users shouldn't be able to list users."},
+		{User{Role: RoleModerator}, PermListUsers, true, "Moderators should be able to list users."},
+		{User{Role: RoleModerator}, PermDisableUsers, true, "Moderators should be able to disable users."},
+		{User{Role: RoleModerator}, PermChangeRoles, false, "Moderators shouldn't be able to change roles."},
+		{User{Role: RoleAdmin}, PermChangeRoles, true, "Admins should be able to change roles."},
+		{User{Role: RoleModerator}, PermDeleteUsers, false, "Moderators shouldn't be able to delete users."},
+		{User{Role: RoleAdmin}, PermDeleteUsers, true, "Admins should be able to delete and restore users."},
+		{User{Role: RoleAdmin, Disabled: true}, PermChangeRoles, false, "Disabled admins shouldn't be able to do anything."},
+		{User{Role: ""}, PermListUsers, false, "Users without a role shouldn't be able to do anything."},
+	}
+
+	for _, c := range cases {
+		if c.input.Can(c.perm) != c.expected {
+			t.Errorf(c.errorReason)
+		}
+	}
+}
+
+// Tests for the ValidRole() method
+func TestValidRole(t *testing.T) {
+	for _, role := range []string{RoleUser, RoleModerator, RoleAdmin} {
+		if !ValidRole(role) {
+			t.Errorf("Failed to validate role %s.", role)
+		}
+	}
+	if ValidRole("superuser") {
+		t.Errorf("Incorrectly validated an unknown role.")
+	}
+}
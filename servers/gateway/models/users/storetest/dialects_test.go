@@ -0,0 +1,52 @@
+package storetest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/indexes"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// runDSN runs the suite against the database the DSN names
+func runDSN(t *testing.T, dsn string) {
+	db, dialect, err := users.Open(dsn)
+	if err != nil {
+		t.Fatalf("error opening %s: %v", dsn, err)
+	}
+	defer db.Close()
+	Run(t, users.NewDialectSQLStore(db, dialect, indexes.NewTrieNode()))
+}
+
+func TestSQLite(t *testing.T) {
+	dir, err := os.MkdirTemp("", "storetest")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	runDSN(t, "sqlite://"+filepath.Join(dir, "users.db"))
+}
+
+// MySQL and Postgres need a server, so they only run when
+// MYSQL_TEST_DSN or POSTGRES_TEST_DSN name a database to use
+
+func TestMySQL(t *testing.T) {
+	dsn := os.Getenv("MYSQL_TEST_DSN")
+	if len(dsn) == 0 {
+		t.Skip("MYSQL_TEST_DSN isn't set")
+	}
+	runDSN(t, dsn)
+}
+
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if len(dsn) == 0 {
+		t.Skip("POSTGRES_TEST_DSN isn't set")
+	}
+	runDSN(t, dsn)
+}
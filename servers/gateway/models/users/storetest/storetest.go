@@ -0,0 +1,199 @@
+//Package storetest is the conformance suite every dialect of
+//users.SQLStore has to pass against a real database
+package storetest
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+)
+
+//Run migrates the store's database and checks the store against it.
+//The database may already hold users from earlier runs.
+func Run(t *testing.T, store *users.SQLStore) {
+	if _, err := store.Migrate(); err != nil {
+		t.Fatalf("error migrating: %v", err)
+	}
+	// running them again does nothing
+	if count, err := store.Migrate(); err != nil || count != 0 {
+		t.Fatalf("expected migrating again to do nothing but ran %d: %v", count, err)
+	}
+
+	// names are unique to this run
+	suffix := strconv.FormatInt(time.Now().UnixNano()%1e9, 36)
+	newUser := func(name string) *users.User {
+		user, err := store.Insert(&users.User{Email: name + suffix + "@example.com",
+			PassHash: []byte("hash"), UserName: name + suffix, FirstName: "First", LastName: "Last"})
+		if err != nil {
+			t.Fatalf("error inserting %s: %v", name, err)
+		}
+		return user
+	}
+
+	t.Run("users", func(t *testing.T) {
+		user := newUser("alice")
+		if user.ID == 0 {
+			t.Fatal("expected the new user to have an ID")
+		}
+		if got, err := store.GetByID(user.ID); err != nil || got.UserName != user.UserName {
+			t.Errorf("error getting user by ID: %v", err)
+		}
+		if got, err := store.GetByEmail(user.Email); err != nil || got.ID != user.ID {
+			t.Errorf("error getting user by email: %v", err)
+		}
+		// usernames ignore case on every database
+		if got, err := store.GetByUserName("ALICE" + suffix); err != nil || got.ID != user.ID {
+			t.Errorf("expected usernames to ignore case: %v", err)
+		}
+		if _, err := store.Insert(&users.User{Email: "other" + suffix + "@example.com",
+			PassHash: []byte("hash"), UserName: "Alice" + suffix}); err != users.ErrUserNameTaken {
+			t.Errorf("expected %v for a duplicate username but got %v", users.ErrUserNameTaken, err)
+		}
+
+		updated, err := store.Update(user.ID, &users.Updates{FirstName: users.Patch("Alicia"), Bio: users.Patch("hi")})
+		if err != nil || updated.FirstName != "Alicia" || updated.Bio != "hi" {
+			t.Errorf("error updating user: %v", err)
+		}
+
+		renamed, err := store.ChangeUserName(user.ID, "alicia"+suffix)
+		if err != nil || renamed.UserName != "alicia"+suffix {
+			t.Fatalf("error changing username: %v", err)
+		}
+		if got, err := store.GetByPreviousUserName(user.UserName); err != nil || got.ID != user.ID {
+			t.Errorf("expected the old username to redirect: %v", err)
+		}
+		// the old name is held for the user during the grace period
+		other := newUser("bob")
+		if _, err := store.ChangeUserName(other.ID, user.UserName); err != users.ErrUserNameTaken {
+			t.Errorf("expected %v for a recently changed username but got %v", users.ErrUserNameTaken, err)
+		}
+		// new users can't take it either, one at a time or in a batch
+		if _, err := store.Insert(&users.User{Email: "carol" + suffix + "@example.com",
+			PassHash: []byte("hash"), UserName: user.UserName}); err != users.ErrUserNameTaken {
+			t.Errorf("expected %v inserting a recently changed username but got %v", users.ErrUserNameTaken, err)
+		}
+		err = store.InsertBatch([]*users.User{{Email: "dave" + suffix + "@example.com", PassHash: []byte("hash"), UserName: user.UserName}})
+		if batchErr, ok := err.(*users.BatchError); !ok || batchErr.Err != users.ErrUserNameTaken {
+			t.Errorf("expected %v importing a recently changed username but got %v", users.ErrUserNameTaken, err)
+		}
+	})
+
+	t.Run("blocks", func(t *testing.T) {
+		blocker := newUser("blocker")
+		blocked := newUser("blocked")
+		for i := 0; i < 2; i++ {
+			if err := store.Block(blocker.ID, blocked.ID); err != nil {
+				t.Fatalf("error blocking: %v", err)
+			}
+		}
+		if list, err := store.GetBlocked(blocker.ID); err != nil || len(list) != 1 || list[0] != blocked.ID {
+			t.Errorf("expected one blocked user but got %v: %v", list, err)
+		}
+		if err := store.Unblock(blocker.ID, blocked.ID); err != nil {
+			t.Errorf("error unblocking: %v", err)
+		}
+		if list, _ := store.GetBlocked(blocker.ID); len(list) != 0 {
+			t.Errorf("expected no blocked users but got %v", list)
+		}
+	})
+
+	t.Run("two-factor authentication", func(t *testing.T) {
+		user := newUser("mfa")
+		for _, secret := range []string{"first", "second"} {
+			if err := store.BeginMFAEnrollment(user.ID, secret); err != nil {
+				t.Fatalf("error enrolling: %v", err)
+			}
+		}
+		if mfa, err := store.GetMFA(user.ID); err != nil || mfa.Secret != "second" || mfa.Enabled {
+			t.Errorf("expected the second secret, not yet enabled: %+v, %v", mfa, err)
+		}
+
+		// each time step's code is only accepted once
+		if err := store.UseTOTPStep(user.ID, 100); err != nil {
+			t.Errorf("error recording TOTP step: %v", err)
+		}
+		for _, step := range []int64{100, 99} {
+			if err := store.UseTOTPStep(user.ID, step); err != users.ErrInvalidMFACode {
+				t.Errorf("expected %v for step %d but got %v", users.ErrInvalidMFACode, step, err)
+			}
+		}
+		if mfa, err := store.GetMFA(user.ID); err != nil || mfa.LastStep != 100 {
+			t.Errorf("expected the last step to be recorded: %+v, %v", mfa, err)
+		}
+
+		// too many codes without one being accepted locks the user out
+		now := time.Now().UTC().Truncate(time.Second)
+		for i := 0; i < 5; i++ {
+			if err := store.BeginMFAAttempt(user.ID, now); err != nil {
+				t.Fatalf("error beginning attempt %d: %v", i+1, err)
+			}
+		}
+		lockout, ok := store.BeginMFAAttempt(user.ID, now).(*users.MFALockoutError)
+		if !ok || !lockout.RetryAt.After(now) {
+			t.Fatalf("expected the sixth attempt to lock the user out but got %v", lockout)
+		}
+		if mfa, err := store.GetMFA(user.ID); err != nil || !mfa.LockedUntil.Equal(lockout.RetryAt) {
+			t.Errorf("expected the lockout to be recorded: %+v, %v", mfa, err)
+		}
+		if _, ok := store.BeginMFAAttempt(user.ID, now).(*users.MFALockoutError); !ok {
+			t.Error("expected attempts to be refused during the lockout")
+		}
+		// attempts start over after the lockout, and once a code is accepted
+		later := lockout.RetryAt.Add(time.Second)
+		for i := 0; i < 5; i++ {
+			if err := store.BeginMFAAttempt(user.ID, later); err != nil {
+				t.Fatalf("error beginning attempt %d after the lockout: %v", i+1, err)
+			}
+		}
+		if err := store.ResetMFAAttempts(user.ID); err != nil {
+			t.Errorf("error resetting attempts: %v", err)
+		}
+		if err := store.BeginMFAAttempt(user.ID, later); err != nil {
+			t.Errorf("expected attempts to start over once a code was accepted but got %v", err)
+		}
+	})
+
+	t.Run("access tokens", func(t *testing.T) {
+		user := newUser("tokens")
+		newToken := &users.NewAccessToken{Name: "cli", Scopes: []string{users.ScopeUsersRead}, ExpiresInDays: 1}
+		token, plaintext, err := newToken.ToAccessToken(user.ID)
+		if err != nil {
+			t.Fatalf("error creating token: %v", err)
+		}
+		if token, err = store.InsertAccessToken(token); err != nil || token.ID == 0 {
+			t.Fatalf("error inserting token: %v", err)
+		}
+		if got, err := store.GetAccessTokenByToken(plaintext); err != nil || got.ID != token.ID {
+			t.Errorf("error getting token: %v", err)
+		}
+	})
+
+	t.Run("deletion", func(t *testing.T) {
+		user := newUser("deleted")
+		if err := store.Delete(user.ID); err != nil {
+			t.Fatalf("error deleting: %v", err)
+		}
+		if _, err := store.GetByID(user.ID); err != users.ErrUserNotFound {
+			t.Errorf("expected deleted users to be hidden but got %v", err)
+		}
+		if _, err := store.Restore(user.ID); err != nil {
+			t.Fatalf("error restoring: %v", err)
+		}
+		if err := store.Delete(user.ID); err != nil {
+			t.Fatalf("error deleting again: %v", err)
+		}
+		ids, err := store.PurgeDeleted(time.Now().UTC().Add(time.Minute))
+		if err != nil {
+			t.Fatalf("error purging: %v", err)
+		}
+		purged := false
+		for _, id := range ids {
+			purged = purged || id == user.ID
+		}
+		if !purged {
+			t.Errorf("expected user %d to be purged but got %v", user.ID, ids)
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package users
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//totpPeriod is the length of a single TOTP time step (RFC 6238 default)
+const totpPeriod = 30 * time.Second
+
+//totpDigits is the number of digits in a generated TOTP code
+const totpDigits = 6
+
+//totpSkew is how many time steps before or after the current one
+//are still accepted, to tolerate clock drift on the user's device
+const totpSkew = 1
+
+//totpSecretLength is the number of random bytes in a TOTP secret
+const totpSecretLength = 20
+
+//recoveryCodeCount is the number of recovery codes issued on enrollment
+const recoveryCodeCount = 10
+
+//recoveryCodeLength is the number of random bytes in each recovery code
+const recoveryCodeLength = 5
+
+//maxMFAAttempts is how many two-factor codes a user can send without
+//one being accepted before they're locked out for the mfaLockout
+const maxMFAAttempts = 5
+
+//mfaLockout is how long a user who sent too many wrong
+//two-factor codes has to wait to send another
+const mfaLockout = 15 * time.Minute
+
+//ErrInvalidMFACode is returned when a TOTP or recovery code doesn't match
+var ErrInvalidMFACode = errors.New("invalid two-factor authentication code")
+
+//MFALockoutError is returned when the user sent too many
+//wrong two-factor codes to have another one checked
+type MFALockoutError struct {
+	//RetryAt is when the user may send codes again
+	RetryAt time.Time
+}
+
+func (e *MFALockoutError) Error() string {
+	return "too many invalid two-factor codes, try again after " + e.RetryAt.UTC().Format(time.RFC3339)
+}
+
+//MFA represents the two-factor authentication settings for a user
+type MFA struct {
+	UserID  int64
+	Secret  string
+	Enabled bool
+	//LastStep is the time step of the last TOTP code accepted.
+	//Codes from it or earlier steps are rejected, so they can't be replayed.
+	LastStep int64
+	//LockedUntil is when the user may send codes again after sending
+	//too many wrong ones. It's zero if they were never locked out.
+	LockedUntil time.Time
+}
+
+//MFAEnrollment is returned to the user when they begin enrolling in
+//two-factor authentication
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+//MFACode represents a code supplied by the user to confirm enrollment,
+//finish a two-step sign-in, or disable two-factor authentication
+type MFACode struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+	Password     string `json:"password"`
+}
+
+//NewTOTPSecret generates a new random base32-encoded TOTP secret
+func NewTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", errors.New("Problem generating TOTP secret.")
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret), nil
+}
+
+//OTPAuthURI returns the otpauth:// URI for the secret, which authenticator
+//apps can import (usually by scanning it as a QR code)
+func OTPAuthURI(issuer string, accountName string, secret string) string {
+	label := url.PathEscape(issuer + ":" + accountName)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(int(totpPeriod/time.Second)))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+//TOTPCode returns the RFC 6238 code for the secret at the given time
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", errors.New("Problem decoding TOTP secret: " + err.Error())
+	}
+	return hotp(key, uint64(totpStep(t)), totpDigits), nil
+}
+
+//totpStep returns the TOTP time step the time is in
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+//ValidateTOTP returns the time step the code is from if it matches the
+//secret at the given time, allowing for `totpSkew` time steps of clock
+//drift, or ErrInvalidMFACode if not. Codes from lastStep or earlier are
+//rejected too, so a code that was already used can't be used again.
+func ValidateTOTP(secret string, code string, t time.Time, lastStep int64) (int64, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, ErrInvalidMFACode
+	}
+	for i := -totpSkew; i <= totpSkew; i++ {
+		at := t.Add(time.Duration(i) * totpPeriod)
+		expected, err := TOTPCode(secret, at)
+		if err != nil {
+			return 0, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 && totpStep(at) > lastStep {
+			return totpStep(at), nil
+		}
+	}
+	return 0, ErrInvalidMFACode
+}
+
+//hotp computes the RFC 4226 HOTP value for the key and counter
+func hotp(key []byte, counter uint64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+	h := hmac.New(sha1.New, key)
+	h.Write(msg)
+	sum := h.Sum(nil)
+
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+//NewRecoveryCodes generates a fresh set of single-use recovery codes,
+//returning both the plaintext codes (to show the user once)
+//and their bcrypt hashes (to store)
+func NewRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([][]byte, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, recoveryCodeLength)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, errors.New("Problem generating recovery code.")
+		}
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcryptCost)
+		if err != nil {
+			return nil, nil, errors.New("Error generating bcrypt hash.")
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hash)
+	}
+	return codes, hashes, nil
+}
+
+//normalizeRecoveryCode strips whitespace and dashes the user may have
+//typed and lowercases the code
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.Replace(code, "-", "", -1)
+}
@@ -0,0 +1,116 @@
+package users
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests TOTPCode() against the SHA1 test vectors from RFC 6238 Appendix B,
+// truncated to 6 digits
+func TestTOTPCode(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	cases := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, c := range cases {
+		code, err := TOTPCode(secret, time.Unix(c.unix, 0))
+		if err != nil {
+			t.Fatalf("unexpected error generating code: %v", err)
+		}
+		if code != c.expected {
+			t.Errorf("Incorrect code at time %d: expected %s but got %s", c.unix, c.expected, code)
+		}
+	}
+}
+
+// Tests for the ValidateTOTP() method
+func TestValidateTOTP(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatalf("unexpected error generating secret: %v", err)
+	}
+	now := time.Now()
+	code, _ := TOTPCode(secret, now)
+	previous, _ := TOTPCode(secret, now.Add(-totpPeriod))
+	stale, _ := TOTPCode(secret, now.Add(-5*totpPeriod))
+
+	cases := []struct {
+		code        string
+		expectError bool
+		errorReason string
+	}{
+		{code, false, "Failed to validate the current code."},
+		{" " + code + " ", false, "Failed to validate a code with surrounding whitespace."},
+		{previous, false, "Failed to allow a code from the previous time step."},
+		{stale, true, "Incorrectly validated a code from several time steps ago."},
+		{"", true, "Incorrectly validated an empty code."},
+		{"12345", true, "Incorrectly validated a code with too few digits."},
+	}
+
+	for _, c := range cases {
+		_, err := ValidateTOTP(secret, c.code, now, 0)
+		if (!c.expectError && err != nil) || (c.expectError && err == nil) {
+			t.Errorf(c.errorReason)
+		}
+	}
+
+	// codes can't be used again, or after a later one
+	step, err := ValidateTOTP(secret, code, now, 0)
+	if err != nil || step != totpStep(now) {
+		t.Fatalf("expected the current time step %d but got %d: %v", totpStep(now), step, err)
+	}
+	if _, err := ValidateTOTP(secret, code, now, step); err != ErrInvalidMFACode {
+		t.Errorf("expected %v for a replayed code but got %v", ErrInvalidMFACode, err)
+	}
+	if _, err := ValidateTOTP(secret, previous, now, step); err != ErrInvalidMFACode {
+		t.Errorf("expected %v for a code older than the last one used but got %v", ErrInvalidMFACode, err)
+	}
+}
+
+// Tests for the OTPAuthURI() method
+func TestOTPAuthURI(t *testing.T) {
+	uri := OTPAuthURI("Slack Clone", "jsm209", "ABCDEFGH")
+	if !strings.HasPrefix(uri, "otpauth://totp/Slack%20Clone:jsm209?") {
+		t.Errorf("Incorrect otpauth URI label: %s", uri)
+	}
+	if !strings.Contains(uri, "secret=ABCDEFGH") || !strings.Contains(uri, "issuer=Slack+Clone") {
+		t.Errorf("otpauth URI is missing the secret or issuer: %s", uri)
+	}
+}
+
+// Tests for the NewRecoveryCodes() method
+func TestNewRecoveryCodes(t *testing.T) {
+	// keep the test fast
+	defer func(cost int) { bcryptCost = cost }(bcryptCost)
+	bcryptCost = 4
+
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatalf("unexpected error generating recovery codes: %v", err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("expected %d recovery codes but got %d", recoveryCodeCount, len(codes))
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if seen[code] {
+			t.Errorf("Duplicate recovery code generated: %s", code)
+		}
+		seen[code] = true
+		if normalizeRecoveryCode(strings.ToUpper(code)) != code {
+			t.Errorf("Recovery code didn't survive normalization: %s", code)
+		}
+	}
+}
@@ -1,11 +1,9 @@
 package users
 
 import (
-	"crypto/md5"
-	"encoding/hex"
 	"errors"
 	"fmt"
-	"strings"
+	"time"
 
 	"github.com/badoux/checkmail"
 	"golang.org/x/crypto/bcrypt"
@@ -27,6 +25,21 @@ type User struct {
 	FirstName string `json:"firstName"`
 	LastName  string `json:"lastName"`
 	PhotoURL  string `json:"photoURL"`
+	Role      string `json:"role"`
+	Disabled  bool   `json:"disabled"`
+	//AvatarSource is where the user's avatar comes from,
+	//or empty to use the server's default
+	AvatarSource string `json:"avatarSource"`
+	DisplayName  string `json:"displayName"`
+	Bio          string `json:"bio"`
+	Title        string `json:"title"`
+	Pronouns     string `json:"pronouns"`
+	Timezone     string `json:"timezone"`
+	StatusText   string `json:"statusText"`
+	//EmailVerified is true once the email address has been confirmed,
+	//such as by an identity provider
+	EmailVerified bool      `json:"emailVerified"`
+	CreatedAt     time.Time `json:"createdAt"`
 }
 
 //Credentials represents user sign-in credentials
@@ -45,10 +58,18 @@ type NewUser struct {
 	LastName     string `json:"lastName"`
 }
 
-//Updates represents allowed updates to a user profile
+//Updates represents allowed updates to a user profile, as a JSON merge
+//patch (RFC 7396): fields left out are unchanged, fields set to null
+//are cleared, and fields with a value replace the old one
 type Updates struct {
-	FirstName string `json:"firstName"`
-	LastName  string `json:"lastName"`
+	FirstName   PatchString `json:"firstName"`
+	LastName    PatchString `json:"lastName"`
+	DisplayName PatchString `json:"displayName"`
+	Bio         PatchString `json:"bio"`
+	Title       PatchString `json:"title"`
+	Pronouns    PatchString `json:"pronouns"`
+	Timezone    PatchString `json:"timezone"`
+	StatusText  PatchString `json:"statusText"`
 }
 
 //Validate validates the new user and returns an error if
@@ -78,9 +99,9 @@ func (nu *NewUser) Validate() error {
 		return errors.New("Password and confirmed passwords must match.")
 	}
 
-	if len(nu.UserName) <= 0 || strings.Contains(nu.UserName, " ") {
-		fmt.Errorf("UserName must not contain spaces and not be zero length.")
-		return errors.New("UserName must not contain spaces and not be zero length.")
+	// the same rules as changing a username, including the reserved ones
+	if err := ValidateUserName(nu.UserName); err != nil {
+		return err
 	}
 
 	return nil
@@ -109,18 +130,6 @@ func (nu *NewUser) ToUser() (*User, error) {
 		return nil, err
 	}
 
-	// Format the email to md5 hash for gravatar
-	formattedEmail := nu.Email
-	formattedEmail = strings.TrimSpace(formattedEmail)
-	formattedEmail = strings.ToLower(formattedEmail)
-
-	// actually md5 hash it
-	m := md5.New()
-	m.Write([]byte(formattedEmail))
-	emailHash := hex.EncodeToString(m.Sum(nil))
-
-	gravatarPhotoURL := append([]byte(gravatarBasePhotoURL), []byte(emailHash)...)
-
 	newUser := User{
 		ID:        0,
 		Email:     nu.Email,
@@ -128,7 +137,8 @@ func (nu *NewUser) ToUser() (*User, error) {
 		UserName:  nu.UserName,
 		FirstName: nu.FirstName,
 		LastName:  nu.LastName,
-		PhotoURL:  string(gravatarPhotoURL),
+		PhotoURL:  GravatarURL(nu.Email),
+		Role:      RoleUser,
 	}
 	newUser.SetPassword(nu.Password)
 
@@ -181,13 +191,26 @@ func (u *User) Authenticate(password string) error {
 //ApplyUpdates applies the updates to the user. An error
 //is returned if the updates are invalid
 func (u *User) ApplyUpdates(updates *Updates) error {
-	//TODO: set the fields of `u` to the values of the related
-	//field in the `updates` struct
-	if len(updates.FirstName) == 0 && len(updates.LastName) == 0 {
-		return errors.New("Invalid first and last names.")
-	} else {
-		u.FirstName = updates.FirstName
-		u.LastName = updates.LastName
+	if err := updates.Validate(); err != nil {
+		return err
+	}
+	fields := []struct {
+		patch PatchString
+		value *string
+	}{
+		{updates.FirstName, &u.FirstName},
+		{updates.LastName, &u.LastName},
+		{updates.DisplayName, &u.DisplayName},
+		{updates.Bio, &u.Bio},
+		{updates.Title, &u.Title},
+		{updates.Pronouns, &u.Pronouns},
+		{updates.Timezone, &u.Timezone},
+		{updates.StatusText, &u.StatusText},
+	}
+	for _, field := range fields {
+		if field.patch.Set {
+			*field.value = field.patch.Value
+		}
 	}
 	return nil
 }
@@ -67,6 +67,22 @@ func TestValidate(t *testing.T) {
 			FirstName:    "Joshua",
 			LastName:     "Maza",
 		}, true, "Failed to validate correctly for usernames with a space."},
+		{NewUser{
+			Email:        "jsm209@uw.edu",
+			Password:     "password",
+			PasswordConf: "password",
+			UserName:     "jsm<209>", // invalid username because of the characters
+			FirstName:    "Joshua",
+			LastName:     "Maza",
+		}, true, "Failed to validate correctly for usernames with disallowed characters."},
+		{NewUser{
+			Email:        "jsm209@uw.edu",
+			Password:     "password",
+			PasswordConf: "password",
+			UserName:     "js", // invalid username because it's too short
+			FirstName:    "Joshua",
+			LastName:     "Maza",
+		}, true, "Failed to validate correctly for usernames that are too short."},
 	}
 
 	for _, c := range cases {
@@ -194,8 +210,8 @@ func TestAuthenticate(t *testing.T) {
 // Tests for the ApplyUpdates() method
 func TestApplyUpdates(t *testing.T) {
 	updates := Updates{
-		FirstName: "changed",
-		LastName:  "man",
+		FirstName: Patch("changed"),
+		LastName:  Patch("man"),
 	}
 
 	user := User{
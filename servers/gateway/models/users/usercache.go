@@ -0,0 +1,208 @@
+package users
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+//ErrCacheMiss is returned when the cache doesn't have the key
+var ErrCacheMiss = errors.New("key not found in cache")
+
+//UserCache stores the values CachedStore caches. Values expire
+//after the cache's TTL, so they can't be stale for longer than that.
+type UserCache interface {
+	//Get returns the value for the key, or ErrCacheMiss
+	Get(key string) ([]byte, error)
+
+	//Set stores the value for the key
+	Set(key string, value []byte) error
+
+	//Delete removes the keys
+	Delete(keys ...string) error
+}
+
+//RedisUserCache is a UserCache in redis, shared by every gateway
+type RedisUserCache struct {
+	Client redis.UniversalClient
+	TTL    time.Duration
+}
+
+//NewRedisUserCache constructs a new RedisUserCache
+func NewRedisUserCache(client redis.UniversalClient, ttl time.Duration) *RedisUserCache {
+	return &RedisUserCache{
+		Client: client,
+		TTL:    ttl,
+	}
+}
+
+//Get returns the value for the key, or ErrCacheMiss
+func (rc *RedisUserCache) Get(key string) ([]byte, error) {
+	value, err := rc.Client.Get(key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, errors.New("Problem getting cached value: " + err.Error())
+	}
+	return value, nil
+}
+
+//Set stores the value for the key
+func (rc *RedisUserCache) Set(key string, value []byte) error {
+	if err := rc.Client.Set(key, value, rc.TTL).Err(); err != nil {
+		return errors.New("Problem caching value: " + err.Error())
+	}
+	return nil
+}
+
+//Delete removes the keys. They're deleted one at a time,
+//since Redis Cluster may keep them in different slots.
+func (rc *RedisUserCache) Delete(keys ...string) error {
+	pipe := rc.Client.Pipeline()
+	for _, key := range keys {
+		pipe.Del(key)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return errors.New("Problem deleting cached values: " + err.Error())
+	}
+	return nil
+}
+
+//MemUserCache is a UserCache in this process, which evicts the least
+//recently used values once it's full. Other gateways don't see its
+//invalidations, so with more than one gateway it should be wrapped
+//in a BroadcastUserCache.
+type MemUserCache struct {
+	TTL     time.Duration
+	MaxSize int
+	entries map[string]*list.Element
+	order   *list.List
+	lock    sync.Mutex
+}
+
+//memCacheEntry is a value in a MemUserCache
+type memCacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+//NewMemUserCache constructs a new MemUserCache
+//holding at most maxSize values
+func NewMemUserCache(ttl time.Duration, maxSize int) *MemUserCache {
+	return &MemUserCache{
+		TTL:     ttl,
+		MaxSize: maxSize,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+//Get returns the value for the key, or ErrCacheMiss
+func (mc *MemUserCache) Get(key string) ([]byte, error) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	elem, ok := mc.entries[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	entry := elem.Value.(*memCacheEntry)
+	if time.Now().After(entry.expires) {
+		mc.remove(elem)
+		return nil, ErrCacheMiss
+	}
+	mc.order.MoveToFront(elem)
+	return entry.value, nil
+}
+
+//Set stores the value for the key
+func (mc *MemUserCache) Set(key string, value []byte) error {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	entry := &memCacheEntry{key: key, value: value, expires: time.Now().Add(mc.TTL)}
+	if elem, ok := mc.entries[key]; ok {
+		elem.Value = entry
+		mc.order.MoveToFront(elem)
+		return nil
+	}
+	mc.entries[key] = mc.order.PushFront(entry)
+	for mc.order.Len() > mc.MaxSize {
+		mc.remove(mc.order.Back())
+	}
+	return nil
+}
+
+//Delete removes the keys
+func (mc *MemUserCache) Delete(keys ...string) error {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	for _, key := range keys {
+		if elem, ok := mc.entries[key]; ok {
+			mc.remove(elem)
+		}
+	}
+	return nil
+}
+
+//remove removes the element, with the lock held
+func (mc *MemUserCache) remove(elem *list.Element) {
+	mc.order.Remove(elem)
+	delete(mc.entries, elem.Value.(*memCacheEntry).key)
+}
+
+//invalidationsChannel is the redis channel deleted cache keys are published on
+const invalidationsChannel = "users:invalidate"
+
+//BroadcastUserCache is a UserCache in this process whose deletions are
+//published over redis pub/sub and deleted from every gateway's cache,
+//so a user who's been disabled or had their role changed isn't still
+//cached as they were by the other gateways.
+type BroadcastUserCache struct {
+	UserCache
+	Client redis.UniversalClient
+}
+
+//NewBroadcastUserCache constructs a new BroadcastUserCache publishing
+//the cache's deletions with the client. Listen has to be called for
+//the cache to get the other gateways' deletions.
+func NewBroadcastUserCache(cache UserCache, client redis.UniversalClient) *BroadcastUserCache {
+	return &BroadcastUserCache{
+		UserCache: cache,
+		Client:    client,
+	}
+}
+
+//Delete removes the keys from this cache and publishes them
+//so every other gateway removes them from theirs
+func (bc *BroadcastUserCache) Delete(keys ...string) error {
+	if err := bc.UserCache.Delete(keys...); err != nil {
+		return err
+	}
+	value, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	if err := bc.Client.Publish(invalidationsChannel, value).Err(); err != nil {
+		return errors.New("Problem publishing cache invalidation: " + err.Error())
+	}
+	return nil
+}
+
+//Listen deletes the keys every gateway publishes from this
+//cache, until the subscription is closed
+func (bc *BroadcastUserCache) Listen() {
+	for message := range bc.Client.Subscribe(invalidationsChannel).Channel() {
+		keys := []string{}
+		if err := json.Unmarshal([]byte(message.Payload), &keys); err != nil {
+			log.Printf("error reading cache invalidation: %v", err)
+			continue
+		}
+		bc.UserCache.Delete(keys...)
+	}
+}
@@ -0,0 +1,75 @@
+package users
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func TestMemUserCache(t *testing.T) {
+	cache := NewMemUserCache(time.Hour, 2)
+	if _, err := cache.Get("a"); err != ErrCacheMiss {
+		t.Errorf("expected %v but got %v", ErrCacheMiss, err)
+	}
+
+	cache.Set("a", []byte("1"))
+	cache.Set("b", []byte("2"))
+	// using a makes b the least recently used
+	if value, err := cache.Get("a"); err != nil || string(value) != "1" {
+		t.Errorf("incorrect value for a: %s, %v", value, err)
+	}
+	cache.Set("c", []byte("3"))
+	if _, err := cache.Get("b"); err != ErrCacheMiss {
+		t.Errorf("expected b to be evicted but got %v", err)
+	}
+	if _, err := cache.Get("a"); err != nil {
+		t.Errorf("expected a to be kept but got %v", err)
+	}
+
+	cache.Delete("a", "c")
+	if _, err := cache.Get("c"); err != ErrCacheMiss {
+		t.Errorf("expected c to be deleted but got %v", err)
+	}
+
+	cache.TTL = time.Millisecond
+	cache.Set("d", []byte("4"))
+	time.Sleep(5 * time.Millisecond)
+	if _, err := cache.Get("d"); err != ErrCacheMiss {
+		t.Errorf("expected d to expire but got %v", err)
+	}
+}
+
+func TestBroadcastUserCache(t *testing.T) {
+	redisaddr := os.Getenv("REDISADDR")
+	if len(redisaddr) == 0 {
+		redisaddr = ":6379"
+	}
+	client := redis.NewClient(&redis.Options{Addr: redisaddr})
+	defer client.Close()
+	if err := client.Ping().Err(); err != nil {
+		t.Skipf("redis isn't available: %v", err)
+	}
+
+	// one gateway's deletions reach the other's cache
+	first := NewBroadcastUserCache(NewMemUserCache(time.Hour, 10), client)
+	second := NewBroadcastUserCache(NewMemUserCache(time.Hour, 10), client)
+	go second.Listen()
+	second.Set("user:1", []byte("cached"))
+	// let the subscription start before publishing
+	time.Sleep(100 * time.Millisecond)
+	if err := first.Delete("user:1"); err != nil {
+		t.Fatalf("error deleting: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := second.Get("user:1"); err == ErrCacheMiss {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the other cache to drop the deleted key")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,77 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+//Limits on the length of a username
+const (
+	MinUserNameLength = 3
+	MaxUserNameLength = 32
+)
+
+//UserNameCooldown is how long a user must wait between username changes
+const UserNameCooldown = 30 * 24 * time.Hour
+
+//UserNameGracePeriod is how long an old username keeps redirecting to
+//the user's new one. Until it ends, no one else can take the old username.
+const UserNameGracePeriod = 90 * 24 * time.Hour
+
+//userNamePattern allows letters, digits, dots, dashes and underscores,
+//starting with a letter or digit
+var userNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+//reservedUserNames can't be taken by anyone, since they could be used
+//to impersonate staff or collide with routes like /v1/users/me
+var reservedUserNames = map[string]bool{
+	"admin":         true,
+	"administrator": true,
+	"api":           true,
+	"help":          true,
+	"me":            true,
+	"moderator":     true,
+	"null":          true,
+	"root":          true,
+	"security":      true,
+	"staff":         true,
+	"support":       true,
+	"system":        true,
+	"undefined":     true,
+}
+
+//ErrUserNameTaken is returned when someone else has the username,
+//or had it recently enough that it still redirects to them
+var ErrUserNameTaken = errors.New("username is already taken")
+
+//ErrUserNameReserved is returned for usernames no one may take
+var ErrUserNameReserved = errors.New("username is reserved")
+
+//UserNameCooldownError is returned when the user changed their
+//username too recently to change it again
+type UserNameCooldownError struct {
+	//RetryAt is when the user may change their username again
+	RetryAt time.Time
+}
+
+func (e *UserNameCooldownError) Error() string {
+	return "username was changed too recently, try again after " + e.RetryAt.UTC().Format(time.RFC3339)
+}
+
+//ValidateUserName checks that the username follows the format rules and
+//isn't reserved. Whether it's taken is checked by the store.
+func ValidateUserName(userName string) error {
+	if len(userName) < MinUserNameLength || len(userName) > MaxUserNameLength {
+		return fmt.Errorf("username must be between %d and %d characters", MinUserNameLength, MaxUserNameLength)
+	}
+	if !userNamePattern.MatchString(userName) {
+		return errors.New("username may only contain letters, digits, dots, dashes and underscores, and must start with a letter or digit")
+	}
+	if reservedUserNames[strings.ToLower(userName)] {
+		return ErrUserNameReserved
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package users
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/indexes"
+)
+
+// Tests for the ValidateUserName() function
+func TestValidateUserName(t *testing.T) {
+	cases := []struct {
+		userName    string
+		expectError bool
+		errorReason string
+	}{
+		{"jsm209", false, "Letters and digits should be allowed."},
+		{"j.s-m_209", false, "Dots, dashes and underscores should be allowed."},
+		{"js", true, "Usernames shorter than 3 characters should be rejected."},
+		{"abcdefghijklmnopqrstuvwxyz1234567", true, "Usernames longer than 32 characters should be rejected."},
+		{"_jsm", true, "Usernames must start with a letter or digit."},
+		{"j sm", true, "Spaces should be rejected."},
+		{"jösm", true, "Non-ASCII letters should be rejected."},
+		{"Admin", true, "Reserved names should be rejected in any case."},
+		{"me", true, "Reserved names should be rejected."},
+	}
+	for _, c := range cases {
+		err := ValidateUserName(c.userName)
+		if (err != nil) != c.expectError {
+			t.Errorf("%s (%q: %v)", c.errorReason, c.userName, err)
+		}
+	}
+}
+
+// expectChangeUserNameStart expects the start of the ChangeUserName transaction,
+// up to checking whether the new name is taken
+func expectChangeUserNameStart(mock sqlmock.Sqlmock, user *User, changedAt interface{}) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("select " + userColumns + " from USERS where id = ? and DeletedAt is null for update")).
+		WithArgs(user.ID).
+		WillReturnRows(userRows(user))
+	mock.ExpectQuery(regexp.QuoteMeta("select UserNameChangedAt from USERS where id = ?")).
+		WithArgs(user.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"UserNameChangedAt"}).AddRow(changedAt))
+}
+
+func TestChangeUserName(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	sqlStore := NewSQLStore(db, indexes.NewTrieNode())
+	user := &User{ID: 1, Email: "jsm209@uw.edu", UserName: "jsm209", FirstName: "Joshua", LastName: "Maza"}
+	sqlStore.AddUserToTrie(user)
+
+	expectChangeUserNameStart(mock, user, nil)
+	mock.ExpectQuery(regexp.QuoteMeta("select count(*) from USERS where UserName = ? and id != ?")).
+		WithArgs("joshmaza", user.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta("select count(*) from USERNAME_HISTORY")).
+		WithArgs("joshmaza", user.ID, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(regexp.QuoteMeta("insert into USERNAME_HISTORY")).
+		WithArgs("jsm209", user.ID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("delete from USERNAME_HISTORY")).
+		WithArgs("joshmaza", user.ID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("update USERS set UserName = ?")).
+		WithArgs("joshmaza", sqlmock.AnyArg(), user.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	updatedUser, err := sqlStore.ChangeUserName(user.ID, "joshmaza")
+	if err != nil {
+		t.Fatalf("unexpected error changing username: %v", err)
+	}
+	if updatedUser.UserName != "joshmaza" {
+		t.Errorf("expected username to be changed but got %q", updatedUser.UserName)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sql expectations: %v", err)
+	}
+
+	// the trie drops the old username and finds the new one
+	if found := sqlStore.Query("jsm", 10); len(found) != 0 {
+		t.Errorf("expected old username to be removed from the trie but found %v", found)
+	}
+	if found := sqlStore.Query("joshm", 10); len(found) != 1 {
+		t.Errorf("expected new username to be in the trie but found %v", found)
+	}
+	if found := sqlStore.Query("maza", 10); len(found) != 1 {
+		t.Errorf("expected last name to still be in the trie but found %v", found)
+	}
+}
+
+func TestChangeUserNameTaken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	sqlStore := NewSQLStore(db, indexes.NewTrieNode())
+	user := &User{ID: 1, UserName: "jsm209"}
+
+	// someone else changed away from the name recently
+	expectChangeUserNameStart(mock, user, time.Now().Add(-2*UserNameCooldown))
+	mock.ExpectQuery(regexp.QuoteMeta("select count(*) from USERS where UserName = ? and id != ?")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta("select count(*) from USERNAME_HISTORY")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+
+	if _, err := sqlStore.ChangeUserName(user.ID, "oldname"); err != ErrUserNameTaken {
+		t.Errorf("expected %v but got %v", ErrUserNameTaken, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sql expectations: %v", err)
+	}
+}
+
+func TestChangeUserNameCooldown(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	sqlStore := NewSQLStore(db, indexes.NewTrieNode())
+	user := &User{ID: 1, UserName: "jsm209"}
+
+	changedAt := time.Now().Add(-time.Hour).UTC()
+	expectChangeUserNameStart(mock, user, changedAt)
+	mock.ExpectRollback()
+
+	_, err = sqlStore.ChangeUserName(user.ID, "joshmaza")
+	cooldown, ok := err.(*UserNameCooldownError)
+	if !ok {
+		t.Fatalf("expected a cooldown error but got %v", err)
+	}
+	if !cooldown.RetryAt.Equal(changedAt.Add(UserNameCooldown)) {
+		t.Errorf("incorrect retry time: %v", cooldown.RetryAt)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sql expectations: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+//checkUserNameHistory returns ErrUserNameTaken if the username still
+//redirects to someone other than the user with the given ID, which is
+//zero for users who are being inserted. It's checked in the transaction
+//that takes the name, so two users can't take it at once.
+func checkUserNameHistory(tx *dialectTx, userName string, id int64, now time.Time) error {
+	var count int
+	histq := "select count(*) from USERNAME_HISTORY where UserName = ? and UserID != ? and ChangedAt > ?"
+	if err := tx.QueryRow(histq, userName, id, now.Add(-UserNameGracePeriod)).Scan(&count); err != nil {
+		return errors.New("Error checking username history: " + err.Error())
+	}
+	if count > 0 {
+		return ErrUserNameTaken
+	}
+	return nil
+}
+
+//ChangeUserName changes the username of the user with the given ID and
+//returns the updated user. The old username is recorded so it redirects to
+//the new one for the UserNameGracePeriod. Comparisons are case-insensitive,
+//following the column's collation, so users may change the case of their
+//own username without it counting as taken.
+func (ss *SQLStore) ChangeUserName(id int64, userName string) (*User, error) {
+	if err := ValidateUserName(userName); err != nil {
+		return nil, err
+	}
+
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return nil, errors.New("Error beginning transaction: " + err.Error())
+	}
+	defer tx.Rollback()
+
+	user, err := scanUser(tx.QueryRow("select "+userColumns+" from USERS where id = ? and "+notDeleted+" for update", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, errors.New("Error getting user: " + err.Error())
+	}
+	if user.UserName == userName {
+		return user, nil
+	}
+
+	now := time.Now().UTC()
+	var changedAt sql.NullTime
+	if err := tx.QueryRow("select UserNameChangedAt from USERS where id = ?", id).Scan(&changedAt); err != nil {
+		return nil, errors.New("Error getting last username change: " + err.Error())
+	}
+	if changedAt.Valid && now.Before(changedAt.Time.Add(UserNameCooldown)) {
+		return nil, &UserNameCooldownError{RetryAt: changedAt.Time.Add(UserNameCooldown)}
+	}
+
+	var count int
+	// deleted users keep their names until they're purged, in case they're restored
+	if err := tx.QueryRow("select count(*) from USERS where UserName = ? and id != ?", userName, id).Scan(&count); err != nil {
+		return nil, errors.New("Error checking username: " + err.Error())
+	}
+	if count > 0 {
+		return nil, ErrUserNameTaken
+	}
+	if err := checkUserNameHistory(tx, userName, id, now); err != nil {
+		return nil, err
+	}
+
+	// the old name now redirects here, and reclaiming a name of
+	// our own stops it redirecting
+	insq := "insert into USERNAME_HISTORY(UserName, UserID, ChangedAt) values(?,?,?) " +
+		ss.db.dialect.Upsert("UserName", "UserID", "ChangedAt")
+	if _, err := tx.Exec(insq, user.UserName, id, now); err != nil {
+		return nil, errors.New("Error recording old username: " + err.Error())
+	}
+	if _, err := tx.Exec("delete from USERNAME_HISTORY where UserName = ? and UserID = ?", userName, id); err != nil {
+		return nil, errors.New("Error updating username history: " + err.Error())
+	}
+	if _, err := tx.Exec("update USERS set UserName = ?, UserNameChangedAt = ? where id = ?", userName, now, id); err != nil {
+		if isDuplicateEntry(err) {
+			return nil, ErrUserNameTaken
+		}
+		return nil, errors.New("Error updating row: " + err.Error())
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.New("Error committing username change: " + err.Error())
+	}
+
+	oldUser := *user
+	user.UserName = userName
+	if !user.Disabled {
+		ss.DeleteUserFromTrie(&oldUser)
+		ss.AddUserToTrie(user)
+	}
+	return user, nil
+}
+
+//GetByPreviousUserName returns the user who had the given username
+//within the UserNameGracePeriod, or ErrUserNotFound
+func (ss *SQLStore) GetByPreviousUserName(userName string) (*User, error) {
+	var userID int64
+	histq := "select UserID from USERNAME_HISTORY where UserName = ? and ChangedAt > ?"
+	err := ss.db.QueryRow(histq, userName, time.Now().UTC().Add(-UserNameGracePeriod)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, errors.New("Error checking username history: " + err.Error())
+	}
+	return ss.GetByID(userID)
+}
+
+//PreviousUserName is a username the user had before, and when they changed it
+type PreviousUserName struct {
+	UserName  string    `json:"userName"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+//GetUserNameHistory returns the user's previous usernames, oldest first.
+//Names are dropped from the history once another user reclaims them.
+func (ss *SQLStore) GetUserNameHistory(userID int64) ([]*PreviousUserName, error) {
+	rows, err := ss.db.Query("select UserName, ChangedAt from USERNAME_HISTORY where UserID = ? order by ChangedAt", userID)
+	if err != nil {
+		return nil, errors.New("Failed to query.")
+	}
+	defer rows.Close()
+
+	changes := []*PreviousUserName{}
+	for rows.Next() {
+		change := PreviousUserName{}
+		if err := rows.Scan(&change.UserName, &change.ChangedAt); err != nil {
+			return nil, errors.New("Error scanning row.")
+		}
+		changes = append(changes, &change)
+	}
+	return changes, nil
+}
@@ -0,0 +1,159 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/patrickmn/go-cache"
+)
+
+//FlowDuration is how long a user has to finish signing in with the provider
+const FlowDuration = 10 * time.Minute
+
+//ErrFlowNotFound is returned from FlowStore.Take() when the state
+//is unknown, has expired, or was already used
+var ErrFlowNotFound = errors.New("sign-in flow not found or expired")
+
+//Flow holds the per-attempt values for an authorization-code flow
+//that must survive the round trip through the provider
+type Flow struct {
+	Provider string
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+//NewFlow generates a new flow with random state, nonce and PKCE verifier
+func NewFlow(provider string) (*Flow, error) {
+	state, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	return &Flow{
+		Provider: provider,
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+	}, nil
+}
+
+//randomString returns 32 crypto random bytes, base64 URL encoded
+func randomString() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.New("Problem generating random bytes.")
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+//codeChallenge returns the PKCE S256 code challenge for the verifier
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+//FlowStore holds sign-in flows between the redirect to the provider
+//and the callback, keyed by state
+type FlowStore interface {
+	//Save saves the flow, keyed by its state
+	Save(flow *Flow) error
+
+	//Take returns and deletes the flow for the given state,
+	//so that each state can only be used once
+	Take(state string) (*Flow, error)
+}
+
+//RedisFlowStore is a FlowStore backed by redis, so any gateway
+//instance can handle the callback
+type RedisFlowStore struct {
+	Client redis.UniversalClient
+}
+
+//NewRedisFlowStore constructs a new RedisFlowStore
+func NewRedisFlowStore(client redis.UniversalClient) *RedisFlowStore {
+	return &RedisFlowStore{Client: client}
+}
+
+//Save saves the flow, keyed by its state
+func (rs *RedisFlowStore) Save(flow *Flow) error {
+	value, err := json.Marshal(flow)
+	if err != nil {
+		return errors.New("Problem during marshal of sign-in flow.")
+	}
+	if err := rs.Client.Set(flowRedisKey(flow.State), value, FlowDuration).Err(); err != nil {
+		return errors.New("Problem adding key and value: " + err.Error())
+	}
+	return nil
+}
+
+//Take returns and deletes the flow for the given state
+func (rs *RedisFlowStore) Take(state string) (*Flow, error) {
+	key := flowRedisKey(state)
+	pipe := rs.Client.TxPipeline()
+	get := pipe.Get(key)
+	pipe.Del(key)
+	if _, err := pipe.Exec(); err != nil {
+		if err == redis.Nil {
+			return nil, ErrFlowNotFound
+		}
+		return nil, err
+	}
+
+	flow := &Flow{}
+	if err := json.Unmarshal([]byte(get.Val()), flow); err != nil {
+		return nil, errors.New("Problem during unmarshal of sign-in flow.")
+	}
+	return flow, nil
+}
+
+//flowRedisKey returns the redis key to use for the state
+func flowRedisKey(state string) string {
+	return "oidc:" + state
+}
+
+//MemFlowStore is an in-process FlowStore.
+//This should be used only for testing and single-instance deployments.
+type MemFlowStore struct {
+	entries *cache.Cache
+	mx      sync.Mutex
+}
+
+//NewMemFlowStore constructs and returns a new MemFlowStore
+func NewMemFlowStore() *MemFlowStore {
+	return &MemFlowStore{
+		entries: cache.New(FlowDuration, time.Minute),
+	}
+}
+
+//Save saves the flow, keyed by its state
+func (ms *MemFlowStore) Save(flow *Flow) error {
+	copied := *flow
+	ms.entries.Set(flow.State, &copied, cache.DefaultExpiration)
+	return nil
+}
+
+//Take returns and deletes the flow for the given state
+func (ms *MemFlowStore) Take(state string) (*Flow, error) {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	flow, found := ms.entries.Get(state)
+	if !found {
+		return nil, ErrFlowNotFound
+	}
+	ms.entries.Delete(state)
+	return flow.(*Flow), nil
+}
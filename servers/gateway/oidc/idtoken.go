@@ -0,0 +1,223 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//clockSkew is how much leeway we allow when checking token timestamps
+const clockSkew = time.Minute
+
+//minRefreshInterval limits how often an unknown key ID can force
+//the key set to be fetched again
+const minRefreshInterval = time.Minute
+
+//ErrInvalidIDToken is returned when an ID token fails verification
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+//Claims represents the ID token claims used to link or create a user
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	Expiry            int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	PreferredUsername string   `json:"preferred_username"`
+	GivenName         string   `json:"given_name"`
+	FamilyName        string   `json:"family_name"`
+}
+
+//audience is the "aud" claim, which may be a single string or an array
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return err
+	}
+	*a = audience(multiple)
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+//tokenHeader is the JOSE header of a signed ID token
+type tokenHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+//VerifyIDToken checks the ID token's RS256 signature against the provider's
+//JWKS, then checks the issuer, audience, expiry and nonce claims
+func (p *Provider) VerifyIDToken(raw string, nonce string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIDToken
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	header := tokenHeader{}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("%v: unsupported signing algorithm %q", ErrInvalidIDToken, header.Algorithm)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	key, err := p.keys.get(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("%v: bad signature", ErrInvalidIDToken)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	claims := Claims{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Issuer != p.Issuer:
+		return nil, fmt.Errorf("%v: wrong issuer", ErrInvalidIDToken)
+	case !claims.Audience.contains(p.ClientID):
+		return nil, fmt.Errorf("%v: wrong audience", ErrInvalidIDToken)
+	case now.After(time.Unix(claims.Expiry, 0).Add(clockSkew)):
+		return nil, fmt.Errorf("%v: expired", ErrInvalidIDToken)
+	case now.Add(clockSkew).Before(time.Unix(claims.IssuedAt, 0)):
+		return nil, fmt.Errorf("%v: issued in the future", ErrInvalidIDToken)
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%v: wrong nonce", ErrInvalidIDToken)
+	case len(claims.Subject) == 0:
+		return nil, fmt.Errorf("%v: missing subject", ErrInvalidIDToken)
+	}
+
+	return &claims, nil
+}
+
+//jsonWebKey holds the fields we need from a single RSA JWK
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+}
+
+//keySet caches a provider's signing keys from its jwks_uri,
+//fetching them again when a token uses a key ID we haven't seen
+type keySet struct {
+	uri         string
+	client      *http.Client
+	keys        map[string]*rsa.PublicKey
+	lastFetched time.Time
+	mx          sync.Mutex
+}
+
+func newKeySet(uri string, client *http.Client) *keySet {
+	return &keySet{
+		uri:    uri,
+		client: client,
+		keys:   map[string]*rsa.PublicKey{},
+	}
+}
+
+//get returns the key with the given key ID
+func (ks *keySet) get(kid string) (*rsa.PublicKey, error) {
+	ks.mx.Lock()
+	defer ks.mx.Unlock()
+
+	if key, ok := ks.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(ks.lastFetched) < minRefreshInterval {
+		return nil, fmt.Errorf("%v: unknown key ID %q", ErrInvalidIDToken, kid)
+	}
+	if err := ks.fetch(); err != nil {
+		return nil, err
+	}
+	if key, ok := ks.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%v: unknown key ID %q", ErrInvalidIDToken, kid)
+}
+
+//fetch replaces the cached keys with the provider's current key set
+func (ks *keySet) fetch() error {
+	ks.lastFetched = time.Now()
+	resp, err := ks.client.Get(ks.uri)
+	if err != nil {
+		return errors.New("Problem fetching key set: " + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Problem fetching key set: status %d", resp.StatusCode)
+	}
+
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return errors.New("Problem decoding key set: " + err.Error())
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.KeyType != "RSA" || (len(jwk.Use) > 0 && jwk.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	ks.keys = keys
+	return nil
+}
@@ -0,0 +1,188 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+//defaultScopes are requested when a provider config doesn't list any
+var defaultScopes = []string{"openid", "email", "profile"}
+
+//ErrUnknownProvider is returned when a provider name isn't configured
+var ErrUnknownProvider = errors.New("unknown identity provider")
+
+//Config represents the configuration for a single OpenID Connect provider
+type Config struct {
+	//Name identifies the provider in URLs and in linked identities, e.g. "google"
+	Name string `json:"name"`
+	//Issuer is the provider's issuer URL, used for discovery
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"clientID"`
+	ClientSecret string   `json:"clientSecret"`
+	RedirectURL  string   `json:"redirectURL"`
+	Scopes       []string `json:"scopes"`
+}
+
+//discoveryDocument holds the fields we need from the provider's
+//discovery document at /.well-known/openid-configuration
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+//tokenResponse holds the fields we need from the token endpoint response
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+//Provider is a discovered OpenID Connect provider that the gateway
+//can sign users in with, acting as a relying party
+type Provider struct {
+	Config
+	authURL  string
+	tokenURL string
+	keys     *keySet
+	client   *http.Client
+}
+
+//NewProvider fetches the provider's discovery document
+//and constructs a new Provider
+func NewProvider(config Config, client *http.Client) (*Provider, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(config.Name) == 0 || len(config.Issuer) == 0 || len(config.ClientID) == 0 {
+		return nil, errors.New("Provider config requires a name, issuer and clientID.")
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = defaultScopes
+	}
+
+	wellKnown := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	resp, err := client.Get(wellKnown)
+	if err != nil {
+		return nil, errors.New("Problem fetching discovery document: " + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Problem fetching discovery document: status %d", resp.StatusCode)
+	}
+
+	doc := discoveryDocument{}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, errors.New("Problem decoding discovery document: " + err.Error())
+	}
+	if doc.Issuer != config.Issuer {
+		return nil, fmt.Errorf("Discovery document issuer %q doesn't match configured issuer %q", doc.Issuer, config.Issuer)
+	}
+
+	return &Provider{
+		Config:   config,
+		authURL:  doc.AuthorizationEndpoint,
+		tokenURL: doc.TokenEndpoint,
+		keys:     newKeySet(doc.JWKSURI, client),
+		client:   client,
+	}, nil
+}
+
+//LoadProviders reads a JSON array of provider configs from the file
+//at `path` and discovers each of them, keyed by provider name
+func LoadProviders(path string, client *http.Client) (map[string]*Provider, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.New("Problem opening provider config: " + err.Error())
+	}
+	defer file.Close()
+
+	configs := []Config{}
+	if err := json.NewDecoder(file).Decode(&configs); err != nil {
+		return nil, errors.New("Problem decoding provider config: " + err.Error())
+	}
+
+	providers := map[string]*Provider{}
+	for _, config := range configs {
+		provider, err := NewProvider(config, client)
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: %v", config.Name, err)
+		}
+		providers[config.Name] = provider
+	}
+	return providers, nil
+}
+
+//AuthCodeURL returns the URL to redirect the user to in order to sign in,
+//using the authorization-code flow with a PKCE S256 challenge
+func (p *Provider) AuthCodeURL(flow *Flow) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.ClientID)
+	params.Set("redirect_uri", p.RedirectURL)
+	params.Set("scope", strings.Join(p.Scopes, " "))
+	params.Set("state", flow.State)
+	params.Set("nonce", flow.Nonce)
+	params.Set("code_challenge", codeChallenge(flow.Verifier))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.authURL, "?") {
+		sep = "&"
+	}
+	return p.authURL + sep + params.Encode()
+}
+
+//Exchange trades the authorization code for tokens, then verifies
+//the returned ID token against the flow's nonce and returns its claims
+func (p *Provider) Exchange(code string, flow *Flow) (*Claims, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("code_verifier", flow.Verifier)
+
+	req, err := http.NewRequest(http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.New("Problem building token request: " + err.Error())
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if len(p.ClientSecret) > 0 {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, errors.New("Problem exchanging authorization code: " + err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.New("Problem reading token response: " + err.Error())
+	}
+
+	tokens := tokenResponse{}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, errors.New("Problem decoding token response: " + err.Error())
+	}
+	if resp.StatusCode != http.StatusOK || len(tokens.Error) > 0 {
+		return nil, fmt.Errorf("Token endpoint returned status %d: %s %s", resp.StatusCode, tokens.Error, tokens.Description)
+	}
+	if len(tokens.IDToken) == 0 {
+		return nil, errors.New("Token response didn't include an ID token.")
+	}
+
+	return p.VerifyIDToken(tokens.IDToken, flow.Nonce)
+}
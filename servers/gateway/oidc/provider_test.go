@@ -0,0 +1,240 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+//testIdP is a local stand-in identity provider that implements just enough
+//of discovery, JWKS and the token endpoint to run the authorization-code flow
+type testIdP struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	kid      string
+	clientID string
+	//codes maps issued authorization codes to the nonce and PKCE
+	//challenge from the authorization request
+	codes map[string]url.Values
+	//claims can be modified by a test before the token is issued
+	claims map[string]interface{}
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating RSA key: %v", err)
+	}
+	idp := &testIdP{
+		key:      key,
+		kid:      "test-key",
+		clientID: "test-client",
+		codes:    map[string]url.Values{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": idp.kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		auth, ok := idp.codes[r.Form.Get("code")]
+		delete(idp.codes, r.Form.Get("code"))
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != auth.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		idp.claims["nonce"] = auth.Get("nonce")
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     idp.sign(t, idp.claims),
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+
+	idp.claims = map[string]interface{}{
+		"iss":            idp.server.URL,
+		"sub":            "user-123",
+		"aud":            idp.clientID,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"email":          "jsm209@uw.edu",
+		"email_verified": true,
+	}
+	return idp
+}
+
+//authorize simulates the user signing in at the provider,
+//returning the authorization code the provider would redirect back with
+func (idp *testIdP) authorize(t *testing.T, authURL string) string {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("error parsing auth URL: %v", err)
+	}
+	code := "code-" + parsed.Query().Get("state")
+	idp.codes[code] = parsed.Query()
+	return code
+}
+
+func (idp *testIdP) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": idp.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("error signing token: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+
+	provider, err := NewProvider(Config{
+		Name:        "test",
+		Issuer:      idp.server.URL,
+		ClientID:    idp.clientID,
+		RedirectURL: "https://gateway.test/v1/sessions/oidc/test/callback",
+	}, nil)
+	if err != nil {
+		t.Fatalf("error discovering provider: %v", err)
+	}
+
+	store := NewMemFlowStore()
+	flow, err := NewFlow(provider.Name)
+	if err != nil {
+		t.Fatalf("error generating flow: %v", err)
+	}
+	if err := store.Save(flow); err != nil {
+		t.Fatalf("error saving flow: %v", err)
+	}
+
+	authURL := provider.AuthCodeURL(flow)
+	if !strings.HasPrefix(authURL, idp.server.URL+"/authorize?") {
+		t.Errorf("auth URL doesn't point at the authorization endpoint: %s", authURL)
+	}
+	params, _ := url.Parse(authURL)
+	if params.Query().Get("code_challenge_method") != "S256" || params.Query().Get("code_challenge") == flow.Verifier {
+		t.Errorf("auth URL doesn't use a PKCE S256 challenge: %s", authURL)
+	}
+	code := idp.authorize(t, authURL)
+
+	// the callback comes back with the state
+	taken, err := store.Take(flow.State)
+	if err != nil {
+		t.Fatalf("error taking flow: %v", err)
+	}
+	if _, err := store.Take(flow.State); err != ErrFlowNotFound {
+		t.Errorf("expected %v when reusing a state but got %v", ErrFlowNotFound, err)
+	}
+
+	claims, err := provider.Exchange(code, taken)
+	if err != nil {
+		t.Fatalf("unexpected error exchanging code: %v", err)
+	}
+	if claims.Subject != "user-123" || claims.Email != "jsm209@uw.edu" || !claims.EmailVerified {
+		t.Errorf("incorrect claims returned: %+v", claims)
+	}
+
+	// codes can't be exchanged twice
+	if _, err := provider.Exchange(code, taken); err == nil {
+		t.Error("expected error when reusing an authorization code")
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+
+	provider, err := NewProvider(Config{Name: "test", Issuer: idp.server.URL, ClientID: idp.clientID}, nil)
+	if err != nil {
+		t.Fatalf("error discovering provider: %v", err)
+	}
+	flow, _ := NewFlow(provider.Name)
+	code := idp.authorize(t, provider.AuthCodeURL(flow))
+
+	flow.Verifier = "not the verifier"
+	if _, err := provider.Exchange(code, flow); err == nil {
+		t.Error("expected error when exchanging a code with the wrong PKCE verifier")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+
+	provider, err := NewProvider(Config{Name: "test", Issuer: idp.server.URL, ClientID: idp.clientID}, nil)
+	if err != nil {
+		t.Fatalf("error discovering provider: %v", err)
+	}
+
+	cases := []struct {
+		name        string
+		mutate      func(claims map[string]interface{})
+		tamper      bool
+		nonce       string
+		expectError bool
+	}{
+		{"Valid Token", nil, false, "nonce", false},
+		{"Audience Array", func(c map[string]interface{}) { c["aud"] = []string{"other", idp.clientID} }, false, "nonce", false},
+		{"Wrong Nonce", nil, false, "other nonce", true},
+		{"Wrong Issuer", func(c map[string]interface{}) { c["iss"] = "https://evil.test" }, false, "nonce", true},
+		{"Wrong Audience", func(c map[string]interface{}) { c["aud"] = "other" }, false, "nonce", true},
+		{"Expired", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, false, "nonce", true},
+		{"Tampered Signature", nil, true, "nonce", true},
+	}
+
+	for _, c := range cases {
+		claims := map[string]interface{}{}
+		for k, v := range idp.claims {
+			claims[k] = v
+		}
+		claims["nonce"] = "nonce"
+		if c.mutate != nil {
+			c.mutate(claims)
+		}
+		token := idp.sign(t, claims)
+		if c.tamper {
+			token = token[:len(token)-4] + "AAAA"
+		}
+
+		_, err := provider.VerifyIDToken(token, c.nonce)
+		if err != nil && !c.expectError {
+			t.Errorf("case %s: unexpected error: %v", c.name, err)
+		}
+		if c.expectError && err == nil {
+			t.Errorf("case %s: expected error but didn't get one", c.name)
+		}
+	}
+}
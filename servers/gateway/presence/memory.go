@@ -0,0 +1,91 @@
+package presence
+
+import (
+	"sync"
+	"time"
+)
+
+//memBackend keeps presence in process memory
+type memBackend struct {
+	records     map[int64]*record
+	subscribers []chan *Presence
+	mx          sync.Mutex
+}
+
+//NewMemTracker constructs a Tracker that keeps presence in memory.
+//This should be used only for testing and single-instance deployments.
+func NewMemTracker() *Tracker {
+	return &Tracker{
+		backend: &memBackend{records: map[int64]*record{}},
+		Grace:   DisconnectGrace,
+	}
+}
+
+func (mb *memBackend) addConnections(userID int64, delta int64) (int64, error) {
+	mb.mx.Lock()
+	defer mb.mx.Unlock()
+	rec, ok := mb.records[userID]
+	if !ok {
+		rec = &record{Status: StatusOffline}
+		mb.records[userID] = rec
+	}
+	rec.Connections += delta
+	return rec.Connections, nil
+}
+
+func (mb *memBackend) get(userIDs ...int64) ([]*record, error) {
+	mb.mx.Lock()
+	defer mb.mx.Unlock()
+	recs := make([]*record, len(userIDs))
+	for i, userID := range userIDs {
+		if rec, ok := mb.records[userID]; ok {
+			copied := *rec
+			recs[i] = &copied
+		}
+	}
+	return recs, nil
+}
+
+func (mb *memBackend) setStatus(userID int64, status string, since time.Time) error {
+	mb.mx.Lock()
+	defer mb.mx.Unlock()
+	if rec, ok := mb.records[userID]; ok {
+		rec.Status = status
+		rec.Since = since
+	}
+	return nil
+}
+
+func (mb *memBackend) remove(userID int64) error {
+	mb.mx.Lock()
+	defer mb.mx.Unlock()
+	delete(mb.records, userID)
+	return nil
+}
+
+//refresh does nothing, since records in memory don't expire
+func (mb *memBackend) refresh(userIDs []int64) error {
+	return nil
+}
+
+//publish sends the change to every subscriber, dropping it for
+//subscribers that aren't keeping up rather than blocking
+func (mb *memBackend) publish(change *Presence) error {
+	mb.mx.Lock()
+	defer mb.mx.Unlock()
+	for _, subscriber := range mb.subscribers {
+		select {
+		case subscriber <- change:
+		default:
+		}
+	}
+	return nil
+}
+
+func (mb *memBackend) subscribe() <-chan *Presence {
+	mb.mx.Lock()
+	defer mb.mx.Unlock()
+	changes := make(chan *Presence, 64)
+	mb.subscribers = append(mb.subscribers, changes)
+	return changes
+}
@@ -0,0 +1,134 @@
+package presence
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+//changesChannel is the redis channel presence changes are published on
+const changesChannel = "presence"
+
+//redisBackend stores presence as a redis hash per user, with
+//the fields status, since (unix milliseconds) and connections
+type redisBackend struct {
+	client redis.UniversalClient
+}
+
+//NewRedisTracker constructs a Tracker that keeps presence in redis,
+//so every gateway instance agrees on it
+func NewRedisTracker(client redis.UniversalClient) *Tracker {
+	return &Tracker{
+		backend: &redisBackend{client: client},
+		Grace:   DisconnectGrace,
+	}
+}
+
+//presenceRedisKey returns the redis key of the user's presence
+func presenceRedisKey(userID int64) string {
+	return "presence:" + strconv.FormatInt(userID, 10)
+}
+
+func (rb *redisBackend) addConnections(userID int64, delta int64) (int64, error) {
+	key := presenceRedisKey(userID)
+	pipe := rb.client.TxPipeline()
+	count := pipe.HIncrBy(key, "connections", delta)
+	// new records start out offline until the tracker sets a status
+	pipe.HSetNX(key, "status", StatusOffline)
+	pipe.Expire(key, presenceTTL)
+	if _, err := pipe.Exec(); err != nil {
+		return 0, errors.New("Problem updating presence: " + err.Error())
+	}
+	return count.Val(), nil
+}
+
+func (rb *redisBackend) get(userIDs ...int64) ([]*record, error) {
+	pipe := rb.client.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(userIDs))
+	for i, userID := range userIDs {
+		cmds[i] = pipe.HGetAll(presenceRedisKey(userID))
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return nil, errors.New("Problem getting presence: " + err.Error())
+	}
+
+	recs := make([]*record, len(userIDs))
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		if len(fields) == 0 {
+			continue
+		}
+		since, _ := strconv.ParseInt(fields["since"], 10, 64)
+		connections, _ := strconv.ParseInt(fields["connections"], 10, 64)
+		recs[i] = &record{
+			Status:      fields["status"],
+			Since:       time.Unix(0, since*int64(time.Millisecond)).UTC(),
+			Connections: connections,
+		}
+	}
+	return recs, nil
+}
+
+func (rb *redisBackend) setStatus(userID int64, status string, since time.Time) error {
+	key := presenceRedisKey(userID)
+	pipe := rb.client.TxPipeline()
+	pipe.HMSet(key, map[string]interface{}{
+		"status": status,
+		"since":  since.UnixNano() / int64(time.Millisecond),
+	})
+	pipe.Expire(key, presenceTTL)
+	if _, err := pipe.Exec(); err != nil {
+		return errors.New("Problem updating presence: " + err.Error())
+	}
+	return nil
+}
+
+func (rb *redisBackend) remove(userID int64) error {
+	if err := rb.client.Del(presenceRedisKey(userID)).Err(); err != nil {
+		return errors.New("Problem removing presence: " + err.Error())
+	}
+	return nil
+}
+
+func (rb *redisBackend) refresh(userIDs []int64) error {
+	pipe := rb.client.Pipeline()
+	for _, userID := range userIDs {
+		pipe.Expire(presenceRedisKey(userID), presenceTTL)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return errors.New("Problem refreshing presence: " + err.Error())
+	}
+	return nil
+}
+
+func (rb *redisBackend) publish(change *Presence) error {
+	value, err := json.Marshal(change)
+	if err != nil {
+		return errors.New("Problem during marshal of presence.")
+	}
+	if err := rb.client.Publish(changesChannel, value).Err(); err != nil {
+		return errors.New("Problem publishing presence: " + err.Error())
+	}
+	return nil
+}
+
+func (rb *redisBackend) subscribe() <-chan *Presence {
+	changes := make(chan *Presence)
+	messages := rb.client.Subscribe(changesChannel).Channel()
+	go func() {
+		defer close(changes)
+		for message := range messages {
+			change := &Presence{}
+			if err := json.Unmarshal([]byte(message.Payload), change); err != nil {
+				log.Printf("error reading presence change: %v", err)
+				continue
+			}
+			changes <- change
+		}
+	}()
+	return changes
+}
@@ -0,0 +1,176 @@
+package presence
+
+import (
+	"log"
+	"time"
+)
+
+//Statuses a user's presence can have
+const (
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusOffline = "offline"
+)
+
+//DisconnectGrace is how long a user stays online after their last
+//websocket closes, so reloading the page doesn't flicker them offline
+const DisconnectGrace = 30 * time.Second
+
+//AwayAfter is how long a connected user can go without any
+//activity before they're marked away
+const AwayAfter = 5 * time.Minute
+
+//HeartbeatInterval is how often gateways refresh the presence of their
+//connected users. Presence that isn't refreshed expires after
+//a few intervals, so users of a gateway that dies go offline.
+const HeartbeatInterval = 30 * time.Second
+
+//presenceTTL is how long presence lasts without a heartbeat
+const presenceTTL = 4 * HeartbeatInterval
+
+//Presence is whether a user is online, away or offline, and since when
+type Presence struct {
+	UserID int64      `json:"userID"`
+	Status string     `json:"status"`
+	Since  *time.Time `json:"since,omitempty"`
+}
+
+//record is the presence stored for a user who is, or was
+//within DisconnectGrace, connected. Records are created offline
+//and brought online as soon as the connection is counted.
+type record struct {
+	Status      string
+	Since       time.Time
+	Connections int64
+}
+
+//backend stores presence records where every gateway can see them,
+//and relays changes between gateways
+type backend interface {
+	//addConnections adds delta to the user's connection count, creating
+	//the record if needed, and returns the new count
+	addConnections(userID int64, delta int64) (int64, error)
+
+	//get returns the records of the users, in the same order,
+	//with nil for users who don't have one
+	get(userIDs ...int64) ([]*record, error)
+
+	//setStatus sets the status of an existing record
+	setStatus(userID int64, status string, since time.Time) error
+
+	//remove deletes the user's record, taking them offline
+	remove(userID int64) error
+
+	//refresh extends the lifetime of the users' records
+	refresh(userIDs []int64) error
+
+	//publish sends the change to every gateway
+	publish(change *Presence) error
+
+	//subscribe returns the changes published by every gateway
+	subscribe() <-chan *Presence
+}
+
+//Tracker tracks which users are online, away or offline, based on
+//their websocket connections, and announces when that changes
+type Tracker struct {
+	backend backend
+	//Grace is how long a user stays online after disconnecting
+	Grace time.Duration
+}
+
+//Connect records a new websocket connection for the user,
+//bringing them online
+func (t *Tracker) Connect(userID int64) error {
+	if _, err := t.backend.addConnections(userID, 1); err != nil {
+		return err
+	}
+	// a new connection counts as activity, even if the user was away
+	return t.setStatus(userID, StatusOnline)
+}
+
+//Disconnect records that one of the user's websockets closed.
+//If it was their last one, they go offline after the grace period
+//unless they reconnect first.
+func (t *Tracker) Disconnect(userID int64) error {
+	count, err := t.backend.addConnections(userID, -1)
+	if err != nil {
+		return err
+	}
+	if count <= 0 {
+		time.AfterFunc(t.Grace, func() {
+			if err := t.expire(userID); err != nil {
+				log.Printf("error taking user %d offline: %v", userID, err)
+			}
+		})
+	}
+	return nil
+}
+
+//expire takes the user offline if they haven't reconnected
+func (t *Tracker) expire(userID int64) error {
+	recs, err := t.backend.get(userID)
+	if err != nil || recs[0] == nil || recs[0].Connections > 0 {
+		return err
+	}
+	if err := t.backend.remove(userID); err != nil {
+		return err
+	}
+	return t.backend.publish(&Presence{UserID: userID, Status: StatusOffline})
+}
+
+//SetIdle marks a connected user away when they've gone idle,
+//and back online when they're active again
+func (t *Tracker) SetIdle(userID int64, idle bool) error {
+	if idle {
+		return t.setStatus(userID, StatusAway)
+	}
+	return t.setStatus(userID, StatusOnline)
+}
+
+//setStatus changes the status of a connected user,
+//announcing the change if there is one
+func (t *Tracker) setStatus(userID int64, status string) error {
+	recs, err := t.backend.get(userID)
+	if err != nil || recs[0] == nil || recs[0].Status == status {
+		return err
+	}
+	since := time.Now().UTC()
+	if err := t.backend.setStatus(userID, status, since); err != nil {
+		return err
+	}
+	return t.backend.publish(&Presence{UserID: userID, Status: status, Since: &since})
+}
+
+//Refresh keeps the presence of the given connected users from expiring.
+//Gateways call it every HeartbeatInterval.
+func (t *Tracker) Refresh(userIDs []int64) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	return t.backend.refresh(userIDs)
+}
+
+//Get returns the presence of each of the users, in the same order
+func (t *Tracker) Get(userIDs []int64) ([]*Presence, error) {
+	recs, err := t.backend.get(userIDs...)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Presence, len(userIDs))
+	for i, userID := range userIDs {
+		rec := recs[i]
+		list[i] = &Presence{UserID: userID, Status: StatusOffline}
+		if rec != nil && rec.Status != StatusOffline {
+			since := rec.Since
+			list[i].Status = rec.Status
+			list[i].Since = &since
+		}
+	}
+	return list, nil
+}
+
+//Changes returns the presence changes announced by every gateway
+func (t *Tracker) Changes() <-chan *Presence {
+	return t.backend.subscribe()
+}
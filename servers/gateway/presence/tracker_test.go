@@ -0,0 +1,90 @@
+package presence
+
+import (
+	"testing"
+	"time"
+)
+
+//nextChange waits briefly for the next published change
+func nextChange(t *testing.T, changes <-chan *Presence) *Presence {
+	select {
+	case change := <-changes:
+		return change
+	case <-time.After(time.Second):
+		t.Fatal("expected a presence change")
+		return nil
+	}
+}
+
+//status returns the user's current status
+func status(t *testing.T, tracker *Tracker, userID int64) string {
+	list, err := tracker.Get([]int64{userID})
+	if err != nil {
+		t.Fatalf("error getting presence: %v", err)
+	}
+	return list[0].Status
+}
+
+func TestTracker(t *testing.T) {
+	tracker := NewMemTracker()
+	tracker.Grace = 20 * time.Millisecond
+	changes := tracker.Changes()
+
+	if status(t, tracker, 1) != StatusOffline {
+		t.Errorf("users who never connected should be offline")
+	}
+
+	tracker.Connect(1)
+	if change := nextChange(t, changes); change.UserID != 1 || change.Status != StatusOnline || change.Since == nil {
+		t.Errorf("incorrect change on connect: %+v", change)
+	}
+
+	tracker.SetIdle(1, true)
+	if change := nextChange(t, changes); change.Status != StatusAway {
+		t.Errorf("expected user to be away but got %+v", change)
+	}
+	// repeating a status doesn't announce anything
+	tracker.SetIdle(1, true)
+	tracker.SetIdle(1, false)
+	if change := nextChange(t, changes); change.Status != StatusOnline {
+		t.Errorf("expected user to be back online but got %+v", change)
+	}
+
+	// a second connection keeps the user online when the first closes
+	tracker.Connect(1)
+	tracker.Disconnect(1)
+	time.Sleep(50 * time.Millisecond)
+	if status(t, tracker, 1) != StatusOnline {
+		t.Errorf("user with an open connection should stay online")
+	}
+
+	// reconnecting within the grace period doesn't flicker offline
+	tracker.Disconnect(1)
+	if status(t, tracker, 1) != StatusOnline {
+		t.Errorf("user should stay online during the grace period")
+	}
+	tracker.Connect(1)
+	time.Sleep(50 * time.Millisecond)
+	if status(t, tracker, 1) != StatusOnline {
+		t.Errorf("user who reconnected should stay online")
+	}
+
+	tracker.Disconnect(1)
+	if change := nextChange(t, changes); change.Status != StatusOffline {
+		t.Errorf("expected user to go offline after the grace period but got %+v", change)
+	}
+	if status(t, tracker, 1) != StatusOffline {
+		t.Errorf("user should be offline after the grace period")
+	}
+
+	// idle signals from users who aren't connected are ignored
+	tracker.SetIdle(2, true)
+	if status(t, tracker, 2) != StatusOffline {
+		t.Errorf("idle signal brought a disconnected user online")
+	}
+	select {
+	case change := <-changes:
+		t.Errorf("unexpected change: %+v", change)
+	default:
+	}
+}
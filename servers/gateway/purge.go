@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+	"github.com/go-redis/redis"
+)
+
+// purgeChannel is the redis channel purge events are published on,
+// so other services can drop what they hold about purged users
+const purgeChannel = "users:purged"
+
+// purgeInterval is how often each gateway looks for deleted users to purge
+const purgeInterval = time.Hour
+
+// PurgeEvent is published on purgeChannel for each user who is
+// permanently deleted
+type PurgeEvent struct {
+	Type     string    `json:"type"`
+	UserID   int64     `json:"userID"`
+	PurgedAt time.Time `json:"purgedAt"`
+}
+
+// purgeDeletedUsers permanently deletes users once they've been deleted
+// for longer than users.DeletedUserRetention, publishing a PurgeEvent
+// for each of them. It runs until the process exits.
+func purgeDeletedUsers(userStore *users.CachedStore, redisClient redis.UniversalClient) {
+	for {
+		now := time.Now().UTC()
+		purged, err := userStore.PurgeDeleted(now.Add(-users.DeletedUserRetention))
+		if err != nil {
+			log.Printf("error purging deleted users: %v", err)
+		}
+		for _, userID := range purged {
+			event, _ := json.Marshal(&PurgeEvent{Type: "user-purged", UserID: userID, PurgedAt: now})
+			if err := redisClient.Publish(purgeChannel, event).Err(); err != nil {
+				log.Printf("error publishing purge of user %d: %v", userID, err)
+			}
+		}
+		time.Sleep(purgeInterval)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// defaultRedisMaxRetries is how many times a failed command is retried
+// when REDISMAXRETRIES isn't set, enough to ride out a failover
+const defaultRedisMaxRetries = 5
+
+// redisMinRetryBackoff and redisMaxRetryBackoff bound how long the
+// client waits between retries. It backs off exponentially, so a few
+// retries span the seconds a Sentinel takes to promote a replica.
+const (
+	redisMinRetryBackoff = 100 * time.Millisecond
+	redisMaxRetryBackoff = 2 * time.Second
+)
+
+// newRedisClient returns the client for redis. REDISMODE is standalone
+// (the default), sentinel or cluster. REDISADDR is the server's address,
+// or with sentinel or cluster a comma-separated list of the sentinels' or
+// cluster nodes' addresses, and REDISMASTERNAME is the name the sentinels
+// know the master by. Commands that fail because a server is down are
+// retried REDISMAXRETRIES times with backoff, and with cluster, commands
+// redirected to another node by a failover or resharding are followed.
+func newRedisClient(mode string, addr string, masterName string, password string, maxRetries string) (redis.UniversalClient, error) {
+	retries := defaultRedisMaxRetries
+	if len(maxRetries) > 0 {
+		var err error
+		if retries, err = strconv.Atoi(maxRetries); err != nil || retries < 0 {
+			return nil, errors.New("REDISMAXRETRIES must be a number of retries such as 5")
+		}
+	}
+	addrs := []string{}
+	for _, a := range strings.Split(addr, ",") {
+		if a = strings.TrimSpace(a); len(a) > 0 {
+			addrs = append(addrs, a)
+		}
+	}
+
+	switch mode {
+	case "", "standalone":
+		if len(addrs) > 1 {
+			return nil, errors.New("REDISADDR must be one address unless REDISMODE is sentinel or cluster")
+		}
+		return redis.NewClient(&redis.Options{
+			Addr:            addr,
+			Password:        password,
+			DB:              0,
+			MaxRetries:      retries,
+			MinRetryBackoff: redisMinRetryBackoff,
+			MaxRetryBackoff: redisMaxRetryBackoff,
+		}), nil
+	case "sentinel":
+		if len(addrs) == 0 || len(masterName) == 0 {
+			return nil, errors.New("REDISMODE sentinel requires the sentinels' addresses in REDISADDR and REDISMASTERNAME")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:      masterName,
+			SentinelAddrs:   addrs,
+			Password:        password,
+			DB:              0,
+			MaxRetries:      retries,
+			MinRetryBackoff: redisMinRetryBackoff,
+			MaxRetryBackoff: redisMaxRetryBackoff,
+		}), nil
+	case "cluster":
+		if len(addrs) == 0 {
+			return nil, errors.New("REDISMODE cluster requires the cluster nodes' addresses in REDISADDR")
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:           addrs,
+			Password:        password,
+			MaxRetries:      retries,
+			MinRetryBackoff: redisMinRetryBackoff,
+			MaxRetryBackoff: redisMaxRetryBackoff,
+		}), nil
+	}
+	return nil, errors.New("REDISMODE must be standalone, sentinel or cluster")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// defaultSessionIdleTimeout is how long sessions last without
+// being used when SESSIONIDLETIMEOUT isn't set
+const defaultSessionIdleTimeout = time.Hour
+
+// defaultSessionMaxLifetime is how long sessions last after they
+// begin, however often they're used, when SESSIONMAXLIFETIME isn't set
+const defaultSessionMaxLifetime = 24 * time.Hour
+
+// sessionLifetime returns the idle timeout and maximum lifetime of
+// sessions. A maximum lifetime of 0 lets sessions last as long as
+// they keep being used.
+func sessionLifetime(idle string, max string) (time.Duration, time.Duration, error) {
+	idleTimeout, maxLifetime := defaultSessionIdleTimeout, defaultSessionMaxLifetime
+	var err error
+	if len(idle) > 0 {
+		if idleTimeout, err = time.ParseDuration(idle); err != nil || idleTimeout <= 0 {
+			return 0, 0, errors.New("SESSIONIDLETIMEOUT must be a positive duration such as 1h")
+		}
+	}
+	if len(max) > 0 {
+		if maxLifetime, err = time.ParseDuration(max); err != nil || maxLifetime < 0 {
+			return 0, 0, errors.New("SESSIONMAXLIFETIME must be a duration such as 24h, or 0 for no limit")
+		}
+	}
+	return idleTimeout, maxLifetime, nil
+}
+
+// defaultAccessTokenTTL is how long access tokens last when ACCESSTOKENTTL isn't set
+const defaultAccessTokenTTL = 15 * time.Minute
+
+// defaultRefreshTokenTTL is how long refresh tokens last without
+// being used when REFRESHTOKENTTL isn't set
+const defaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+// tokenLifetime returns how long access tokens last, and how long
+// refresh tokens last without being used
+func tokenLifetime(access string, refresh string) (time.Duration, time.Duration, error) {
+	accessTTL, refreshTTL := defaultAccessTokenTTL, defaultRefreshTokenTTL
+	var err error
+	if len(access) > 0 {
+		if accessTTL, err = time.ParseDuration(access); err != nil || accessTTL <= 0 {
+			return 0, 0, errors.New("ACCESSTOKENTTL must be a positive duration such as 15m")
+		}
+	}
+	if len(refresh) > 0 {
+		if refreshTTL, err = time.ParseDuration(refresh); err != nil || refreshTTL <= 0 {
+			return 0, 0, errors.New("REFRESHTOKENTTL must be a positive duration such as 720h")
+		}
+	}
+	return accessTTL, refreshTTL, nil
+}
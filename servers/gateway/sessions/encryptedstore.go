@@ -0,0 +1,232 @@
+package sessions
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+//sealedPrefix marks metadata fields that are encrypted
+const sealedPrefix = "sealed:"
+
+//ErrDecryptionFailed is returned when session state can't be decrypted,
+//because its key is no longer in the keyring or it was tampered with
+var ErrDecryptionFailed = errors.New("session state couldn't be decrypted")
+
+//EncryptedStore is a Store that encrypts session state with AES-GCM
+//before saving it to another Store, so the data in that store is
+//useless without the keys. The keyring's active key encrypts, and
+//each sealed state records the ID of its key, so keys can be rotated.
+//Plaintext state, and state sealed with a key that's no longer active,
+//is encrypted again with the active key when it's next read.
+//The IP address, user agent and device name in session metadata are
+//encrypted too.
+type EncryptedStore struct {
+	Store   Store
+	Keyring *Keyring
+}
+
+//sealedState is how an EncryptedStore saves state to its Store
+type sealedState struct {
+	Sealed *sealed `json:"sealed"`
+}
+
+//sealed is encrypted data and the ID of the key that encrypted it
+type sealed struct {
+	KeyID string `json:"keyID"`
+	//Data is the nonce followed by the ciphertext
+	Data []byte `json:"data"`
+}
+
+//NewEncryptedStore constructs a new EncryptedStore
+//saving to the store with keys from the keyring
+func NewEncryptedStore(store Store, keyring *Keyring) *EncryptedStore {
+	return &EncryptedStore{
+		Store:   store,
+		Keyring: keyring,
+	}
+}
+
+//aead returns the AES-GCM cipher for the key. Keyring keys can be
+//any length, so the AES-256 key is derived from them.
+func (es *EncryptedStore) aead(key []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("session state encryption"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//seal encrypts the plaintext with the active key. The session's public
+//ID is authenticated too, so state can't be moved to another session.
+//It's the same for the handles stores return in metadata, so their
+//metadata can be decrypted without the session ID.
+func (es *EncryptedStore) seal(sid SessionID, plaintext []byte) (*sealed, error) {
+	keyID, key := es.Keyring.activeKey()
+	aead, err := es.aead(key)
+	if err != nil {
+		return nil, errors.New("Problem creating cipher: " + err.Error())
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.New("Problem generating nonce: " + err.Error())
+	}
+	return &sealed{KeyID: keyID, Data: aead.Seal(nonce, nonce, plaintext, []byte(sid.PublicID()))}, nil
+}
+
+//open decrypts the sealed data. Data sealed before the public
+//ID was authenticated, with the session ID instead, is reported
+//as legacy, so it can be sealed again.
+func (es *EncryptedStore) open(sid SessionID, s *sealed) (plaintext []byte, legacy bool, err error) {
+	key, ok := es.Keyring.key(s.KeyID)
+	if !ok {
+		return nil, false, ErrDecryptionFailed
+	}
+	aead, err := es.aead(key)
+	if err != nil || len(s.Data) < aead.NonceSize() {
+		return nil, false, ErrDecryptionFailed
+	}
+	nonce, ciphertext := s.Data[:aead.NonceSize()], s.Data[aead.NonceSize():]
+	if plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(sid.PublicID())); err == nil {
+		return plaintext, false, nil
+	}
+	if _, _, isHandle := sid.handle(); !isHandle {
+		if plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(sid)); err == nil {
+			return plaintext, true, nil
+		}
+	}
+	return nil, false, ErrDecryptionFailed
+}
+
+//Save encrypts the provided `sessionState` and saves it to the store
+func (es *EncryptedStore) Save(sid SessionID, sessionState interface{}) error {
+	plaintext, err := json.Marshal(sessionState)
+	if err != nil {
+		return errors.New("Problem during marshal of session state.")
+	}
+	s, err := es.seal(sid, plaintext)
+	if err != nil {
+		return err
+	}
+	return es.Store.Save(sid, &sealedState{Sealed: s})
+}
+
+//Get decrypts the state saved for the given SessionID into `sessionState`.
+//State saved before it was encrypted is read as it is.
+func (es *EncryptedStore) Get(sid SessionID, sessionState interface{}) error {
+	var raw json.RawMessage
+	if err := es.Store.Get(sid, &raw); err != nil {
+		return err
+	}
+	saved := &sealedState{}
+	plaintext := []byte(raw)
+	legacy := false
+	if err := json.Unmarshal(raw, saved); err == nil && saved.Sealed != nil {
+		if plaintext, legacy, err = es.open(sid, saved.Sealed); err != nil {
+			return err
+		}
+	}
+	if err := json.Unmarshal(plaintext, sessionState); err != nil {
+		return err
+	}
+
+	// migrate plaintext state and state sealed with an old key or the session ID
+	if active, _ := es.Keyring.activeKey(); saved.Sealed == nil || saved.Sealed.KeyID != active || legacy {
+		if s, err := es.seal(sid, plaintext); err == nil {
+			es.Store.Save(sid, &sealedState{Sealed: s})
+		}
+	}
+	return nil
+}
+
+//Expiry returns when the session will expire unless it's used again
+func (es *EncryptedStore) Expiry(sid SessionID) (time.Time, error) {
+	return es.Store.Expiry(sid)
+}
+
+//Delete deletes all state data associated with the SessionID from the store,
+//including its metadata.
+func (es *EncryptedStore) Delete(sid SessionID) error {
+	return es.Store.Delete(sid)
+}
+
+//sealString encrypts a metadata field
+func (es *EncryptedStore) sealString(sid SessionID, value string) (string, error) {
+	s, err := es.seal(sid, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	encoded, _ := json.Marshal(s)
+	return sealedPrefix + base64.RawURLEncoding.EncodeToString(encoded), nil
+}
+
+//openString decrypts a metadata field, which
+//may have been saved before it was encrypted
+func (es *EncryptedStore) openString(sid SessionID, value string) string {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return value
+	}
+	encoded, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil {
+		return ""
+	}
+	s := &sealed{}
+	if err := json.Unmarshal(encoded, s); err != nil {
+		return ""
+	}
+	plaintext, _, err := es.open(sid, s)
+	if err != nil {
+		return ""
+	}
+	return string(plaintext)
+}
+
+//SaveMetadata encrypts the IP address, user agent and device
+//name of the session's metadata and saves it to the store
+func (es *EncryptedStore) SaveMetadata(sid SessionID, meta *Metadata) error {
+	encrypted := *meta
+	var err error
+	if encrypted.IP, err = es.sealString(sid, meta.IP); err != nil {
+		return err
+	}
+	if encrypted.UserAgent, err = es.sealString(sid, meta.UserAgent); err != nil {
+		return err
+	}
+	if encrypted.DeviceName, err = es.sealString(sid, meta.DeviceName); err != nil {
+		return err
+	}
+	return es.Store.SaveMetadata(sid, &encrypted)
+}
+
+//Touch records that the session was just used from the IP address
+func (es *EncryptedStore) Touch(sid SessionID, ip string) error {
+	encrypted, err := es.sealString(sid, ip)
+	if err != nil {
+		return err
+	}
+	return es.Store.Touch(sid, encrypted)
+}
+
+//Sessions returns the metadata of the user's sessions
+//that haven't expired, most recently seen first
+func (es *EncryptedStore) Sessions(userID int64) ([]*Metadata, error) {
+	list, err := es.Store.Sessions(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, meta := range list {
+		meta.IP = es.openString(meta.SessionID, meta.IP)
+		meta.UserAgent = es.openString(meta.SessionID, meta.UserAgent)
+		meta.DeviceName = es.openString(meta.SessionID, meta.DeviceName)
+	}
+	return list, nil
+}
@@ -0,0 +1,131 @@
+package sessions
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncryptedStore(t *testing.T) {
+	type sessionState struct {
+		Email string
+	}
+	keyring, err := NewKeyring("1", map[string]string{"1": "first key"})
+	if err != nil {
+		t.Fatalf("error constructing keyring: %v", err)
+	}
+	inner := NewMemStore(time.Hour, time.Minute)
+	store := NewEncryptedStore(inner, keyring)
+	sid, _ := NewSessionID("test key")
+
+	if err := store.Save(sid, &sessionState{Email: "test@example.com"}); err != nil {
+		t.Fatalf("error saving state: %v", err)
+	}
+	var raw json.RawMessage
+	if err := inner.Get(sid, &raw); err != nil {
+		t.Fatalf("error getting raw state: %v", err)
+	}
+	if strings.Contains(string(raw), "test@example.com") {
+		t.Errorf("expected state to be encrypted but got %s", raw)
+	}
+	state := &sessionState{}
+	if err := store.Get(sid, state); err != nil || state.Email != "test@example.com" {
+		t.Fatalf("error getting state: %+v, %v", state, err)
+	}
+
+	// sealed state can't be moved to another session
+	other, _ := NewSessionID("test key")
+	inner.Save(other, raw)
+	if err := store.Get(other, state); err != ErrDecryptionFailed {
+		t.Errorf("expected %v but got %v", ErrDecryptionFailed, err)
+	}
+
+	// plaintext state is read, and encrypted once it has been
+	inner.Save(other, &sessionState{Email: "plain@example.com"})
+	if err := store.Get(other, state); err != nil || state.Email != "plain@example.com" {
+		t.Fatalf("error getting plaintext state: %+v, %v", state, err)
+	}
+	inner.Get(other, &raw)
+	if strings.Contains(string(raw), "plain@example.com") {
+		t.Errorf("expected plaintext state to be encrypted when read but got %s", raw)
+	}
+}
+
+func TestEncryptedStoreRotation(t *testing.T) {
+	first, _ := NewKeyring("1", map[string]string{"1": "first key"})
+	second, _ := NewKeyring("2", map[string]string{"2": "second key", "1": "first key"})
+	inner := NewMemStore(time.Hour, time.Minute)
+	sid, _ := NewSessionID("test key")
+
+	if err := NewEncryptedStore(inner, first).Save(sid, "state"); err != nil {
+		t.Fatalf("error saving state: %v", err)
+	}
+	state := ""
+	if err := NewEncryptedStore(inner, second).Get(sid, &state); err != nil || state != "state" {
+		t.Fatalf("error getting state sealed with an old key: %v", err)
+	}
+	saved := &sealedState{}
+	inner.Get(sid, saved)
+	if saved.Sealed == nil || saved.Sealed.KeyID != "2" {
+		t.Errorf("expected state to be sealed again with the active key but got %+v", saved.Sealed)
+	}
+
+	// once the old key is gone, only the new one decrypts
+	if err := NewEncryptedStore(inner, first).Get(sid, &state); err != ErrDecryptionFailed {
+		t.Errorf("expected %v but got %v", ErrDecryptionFailed, err)
+	}
+}
+
+func TestEncryptedStoreMetadata(t *testing.T) {
+	keyring, _ := NewKeyring("1", map[string]string{"1": "first key"})
+	inner := NewMemStore(time.Hour, time.Minute)
+	store := NewEncryptedStore(inner, keyring)
+	sid, _ := NewSessionID("test key")
+	store.Save(sid, "state")
+
+	if err := store.SaveMetadata(sid, &Metadata{UserID: 1, IP: "10.0.0.1", UserAgent: "curl/7.68.0", DeviceName: "work laptop"}); err != nil {
+		t.Fatalf("error saving metadata: %v", err)
+	}
+	if err := store.Touch(sid, "10.0.0.2"); err != nil {
+		t.Fatalf("error touching session: %v", err)
+	}
+	list, _ := inner.Sessions(1)
+	if len(list) != 1 || !strings.HasPrefix(list[0].IP, sealedPrefix) || !strings.HasPrefix(list[0].UserAgent, sealedPrefix) ||
+		!strings.HasPrefix(list[0].DeviceName, sealedPrefix) {
+		t.Fatalf("expected metadata to be encrypted but got %+v", list)
+	}
+	sealedName := list[0].DeviceName
+	list, err := store.Sessions(1)
+	if err != nil || len(list) != 1 || list[0].IP != "10.0.0.2" || list[0].UserAgent != "curl/7.68.0" || list[0].DeviceName != "work laptop" {
+		t.Errorf("expected decrypted metadata but got %+v: %v", list, err)
+	}
+
+	// stores that only keep hashes of session IDs return handles,
+	// which decrypt the metadata since they have the same public ID
+	if name := store.openString(newHandle(sid.PublicID(), "hash"), sealedName); name != "work laptop" {
+		t.Errorf("expected the handle to decrypt the device name but got %q", name)
+	}
+}
+
+func TestEncryptedStoreLegacyState(t *testing.T) {
+	keyring, _ := NewKeyring("1", map[string]string{"1": "first key"})
+	inner := NewMemStore(time.Hour, time.Minute)
+	store := NewEncryptedStore(inner, keyring)
+	sid, _ := NewSessionID("test key")
+
+	// state used to be sealed with the session ID rather than its public ID
+	aead, _ := store.aead([]byte("first key"))
+	nonce := make([]byte, aead.NonceSize())
+	inner.Save(sid, &sealedState{Sealed: &sealed{KeyID: "1", Data: aead.Seal(nonce, nonce, []byte(`"state"`), []byte(sid))}})
+
+	state := ""
+	if err := store.Get(sid, &state); err != nil || state != "state" {
+		t.Fatalf("error getting legacy state: %v", err)
+	}
+	saved := &sealedState{}
+	inner.Get(sid, saved)
+	if _, legacy, err := store.open(sid, saved.Sealed); err != nil || legacy {
+		t.Errorf("expected legacy state to be sealed again but got %v, %v", legacy, err)
+	}
+}
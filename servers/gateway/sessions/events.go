@@ -0,0 +1,122 @@
+package sessions
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/go-redis/redis"
+)
+
+//eventsChannel is the redis channel session events are published on
+const eventsChannel = "session-events"
+
+//EventType is how a session ended
+type EventType string
+
+const (
+	//EventDeleted is published when a user signs out of the session
+	EventDeleted EventType = "deleted"
+	//EventExpired is published when the session is used after it expired
+	EventExpired EventType = "expired"
+	//EventRevoked is published when the session is ended from another
+	//session, or because its user was disabled
+	EventRevoked EventType = "revoked"
+)
+
+//Event is published when a session ends, so every gateway can close
+//the websockets opened with it. It carries the session's public ID,
+//since the session ID itself is a credential.
+type Event struct {
+	Type EventType `json:"type"`
+	ID   string    `json:"id"`
+}
+
+//Events publishes session events to every gateway
+type Events interface {
+	//Publish sends the event to every subscriber
+	Publish(event *Event) error
+	//Subscribe returns the events published from now on
+	Subscribe() <-chan *Event
+}
+
+//NewEvent returns the event for the session
+func NewEvent(eventType EventType, sid SessionID) *Event {
+	return &Event{Type: eventType, ID: sid.PublicID()}
+}
+
+//RedisEvents is Events published over redis pub/sub,
+//so every gateway instance gets them
+type RedisEvents struct {
+	Client redis.UniversalClient
+}
+
+//NewRedisEvents constructs a new RedisEvents
+func NewRedisEvents(client redis.UniversalClient) *RedisEvents {
+	return &RedisEvents{Client: client}
+}
+
+//Publish sends the event to every gateway
+func (re *RedisEvents) Publish(event *Event) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if err := re.Client.Publish(eventsChannel, value).Err(); err != nil {
+		return errors.New("Problem publishing session event: " + err.Error())
+	}
+	return nil
+}
+
+//Subscribe returns the events published by every gateway
+func (re *RedisEvents) Subscribe() <-chan *Event {
+	events := make(chan *Event)
+	messages := re.Client.Subscribe(eventsChannel).Channel()
+	go func() {
+		defer close(events)
+		for message := range messages {
+			event := &Event{}
+			if err := json.Unmarshal([]byte(message.Payload), event); err != nil {
+				log.Printf("error reading session event: %v", err)
+				continue
+			}
+			events <- event
+		}
+	}()
+	return events
+}
+
+//MemEvents is Events within this process, for a single gateway
+type MemEvents struct {
+	subscribers []chan *Event
+	lock        sync.Mutex
+}
+
+//NewMemEvents constructs a new MemEvents
+func NewMemEvents() *MemEvents {
+	return &MemEvents{}
+}
+
+//Publish sends the event to every subscriber, dropping
+//it for any that are too far behind to take it
+func (me *MemEvents) Publish(event *Event) error {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+	for _, subscriber := range me.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+	return nil
+}
+
+//Subscribe returns the events published from now on
+func (me *MemEvents) Subscribe() <-chan *Event {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+	events := make(chan *Event, 64)
+	me.subscribers = append(me.subscribers, events)
+	return events
+}
@@ -0,0 +1,28 @@
+package sessions
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemEvents(t *testing.T) {
+	events := NewMemEvents()
+	sid, err := NewSessionID("test key")
+	if err != nil {
+		t.Fatalf("error generating new SessionID: %v", err)
+	}
+	first, second := events.Subscribe(), events.Subscribe()
+	if err := events.Publish(NewEvent(EventRevoked, sid)); err != nil {
+		t.Fatalf("error publishing: %v", err)
+	}
+	for _, subscriber := range []<-chan *Event{first, second} {
+		select {
+		case event := <-subscriber:
+			if event.Type != EventRevoked || event.ID != sid.PublicID() {
+				t.Errorf("incorrect event: %+v", event)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected every subscriber to get the event")
+		}
+	}
+}
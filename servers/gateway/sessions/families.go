@@ -0,0 +1,289 @@
+package sessions
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+//Family is the chain of refresh tokens issued from one session.
+//Only its newest token can be used, and using it rotates it.
+type Family struct {
+	ID     string `json:"id"`
+	UserID int64  `json:"userID"`
+	//Session is the public ID of the session the family was issued from
+	Session string `json:"session"`
+	//StartedAt is when that session signed in. The family ends the
+	//sessions' maximum lifetime after it, like the session does.
+	StartedAt time.Time `json:"startedAt"`
+	//Current is the hash of the only token in the family that can be used
+	Current string `json:"current"`
+}
+
+//FamilyStore keeps refresh token families
+type FamilyStore interface {
+	//Create saves the new family, which lasts ttl unless it's rotated
+	Create(family *Family, ttl time.Duration) error
+
+	//Get returns the family, or ErrInvalidToken if it
+	//doesn't exist because it expired or was revoked
+	Get(id string) (*Family, error)
+
+	//Rotate replaces the family's current token hash with next, and resets
+	//its ttl, if current is its current token hash. Otherwise an old token
+	//was used again, so the family is deleted and ErrTokenReused returned.
+	//Checking and rotating is one atomic step, so only one of two requests
+	//using the same token can succeed.
+	Rotate(id string, current string, next string, ttl time.Duration) error
+
+	//Delete deletes the family
+	Delete(id string) error
+
+	//DeleteUser deletes every one of the user's families
+	DeleteUser(userID int64) error
+}
+
+//StoreFamilies is a FamilyStore kept in a Store, such as a FileStore.
+//Families expire after the store's idle timeout rather than their ttl.
+//Rotating is only atomic within this process, so it's for single-node
+//deployments.
+type StoreFamilies struct {
+	Store Store
+	lock  sync.Mutex
+}
+
+//NewStoreFamilies constructs a new StoreFamilies
+func NewStoreFamilies(store Store) *StoreFamilies {
+	return &StoreFamilies{Store: store}
+}
+
+//Create saves the new family and adds it to its user's families
+func (sf *StoreFamilies) Create(family *Family, ttl time.Duration) error {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+	if err := sf.Store.Save(familyKey(family.ID), family); err != nil {
+		return err
+	}
+	ids := []string{}
+	sf.Store.Get(userFamiliesKey(family.UserID), &ids)
+	// drop the families that have expired
+	live := []string{family.ID}
+	for _, id := range ids {
+		if _, err := sf.Store.Expiry(familyKey(id)); err == nil {
+			live = append(live, id)
+		}
+	}
+	return sf.Store.Save(userFamiliesKey(family.UserID), live)
+}
+
+//Get returns the family
+func (sf *StoreFamilies) Get(id string) (*Family, error) {
+	family := &Family{}
+	if err := sf.Store.Get(familyKey(id), family); err != nil {
+		return nil, ErrInvalidToken
+	}
+	return family, nil
+}
+
+//Rotate replaces the family's current token hash if it's current
+func (sf *StoreFamilies) Rotate(id string, current string, next string, ttl time.Duration) error {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+	family := &Family{}
+	if err := sf.Store.Get(familyKey(id), family); err != nil {
+		return ErrInvalidToken
+	}
+	if family.Current != current {
+		sf.Store.Delete(familyKey(id))
+		return ErrTokenReused
+	}
+	family.Current = next
+	return sf.Store.Save(familyKey(id), family)
+}
+
+//Delete deletes the family
+func (sf *StoreFamilies) Delete(id string) error {
+	return sf.Store.Delete(familyKey(id))
+}
+
+//DeleteUser deletes every one of the user's families
+func (sf *StoreFamilies) DeleteUser(userID int64) error {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+	ids := []string{}
+	if err := sf.Store.Get(userFamiliesKey(userID), &ids); err != nil && err != ErrStateNotFound {
+		return err
+	}
+	for _, id := range ids {
+		if err := sf.Store.Delete(familyKey(id)); err != nil {
+			return err
+		}
+	}
+	return sf.Store.Delete(userFamiliesKey(userID))
+}
+
+//familyKey returns the ID the family is kept under in a Store
+func familyKey(id string) SessionID {
+	return SessionID("refresh-family-" + id)
+}
+
+//userFamiliesKey returns the ID the user's families are kept under in a Store
+func userFamiliesKey(userID int64) SessionID {
+	return SessionID("refresh-user-" + strconv.FormatInt(userID, 10))
+}
+
+//rotateScript rotates a family kept in a redis hash, or deletes it if
+//the token isn't its current one. It returns 1 when the family was
+//rotated, 0 when it doesn't exist and -1 when the token was reused.
+var rotateScript = redis.NewScript(`
+local current = redis.call("HGET", KEYS[1], "current")
+if not current then
+	return 0
+end
+if current ~= ARGV[1] then
+	redis.call("DEL", KEYS[1])
+	return -1
+end
+redis.call("HSET", KEYS[1], "current", ARGV[2])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return 1
+`)
+
+//RedisFamilyStore is a FamilyStore in redis, which rotates families
+//with a script so it's atomic however many gateways share it. Families
+//only hold token hashes, so they're useless if redis is dumped.
+type RedisFamilyStore struct {
+	Client redis.UniversalClient
+	//HashTags puts the family or user ID of each key in braces,
+	//for Redis Cluster, like RedisStore.HashTags
+	HashTags bool
+}
+
+//NewRedisFamilyStore constructs a new RedisFamilyStore
+//using the client, with hash tags if it's a Cluster client
+func NewRedisFamilyStore(client redis.UniversalClient) *RedisFamilyStore {
+	_, cluster := client.(*redis.ClusterClient)
+	return &RedisFamilyStore{Client: client, HashTags: cluster}
+}
+
+//tag returns the ID, in a hash tag if the store uses them
+func (rf *RedisFamilyStore) tag(id string) string {
+	if rf.HashTags {
+		return "{" + id + "}"
+	}
+	return id
+}
+
+//key returns the redis key of the family
+func (rf *RedisFamilyStore) key(id string) string {
+	return "refresh-family:" + rf.tag(id)
+}
+
+//userKey returns the redis key of the set of the user's family IDs
+func (rf *RedisFamilyStore) userKey(userID int64) string {
+	return "refresh-families:" + rf.tag(strconv.FormatInt(userID, 10))
+}
+
+//Create saves the new family and adds it to its user's families
+func (rf *RedisFamilyStore) Create(family *Family, ttl time.Duration) error {
+	pipe := rf.Client.TxPipeline()
+	pipe.HMSet(rf.key(family.ID), map[string]interface{}{
+		"userID":    family.UserID,
+		"session":   family.Session,
+		"startedAt": family.StartedAt.UTC().Format(time.RFC3339Nano),
+		"current":   family.Current,
+	})
+	pipe.PExpire(rf.key(family.ID), ttl)
+	pipe.SAdd(rf.userKey(family.UserID), family.ID)
+	if _, err := pipe.Exec(); err != nil {
+		return errors.New("Problem saving refresh token family: " + err.Error())
+	}
+	rf.prune(family.UserID)
+	return nil
+}
+
+//prune removes the families that have expired from the user's
+//families. Rotating extends families without touching the index,
+//so the index doesn't expire, and is pruned instead.
+func (rf *RedisFamilyStore) prune(userID int64) {
+	ids, err := rf.Client.SMembers(rf.userKey(userID)).Result()
+	if err != nil {
+		return
+	}
+	pipe := rf.Client.Pipeline()
+	exists := make([]*redis.IntCmd, len(ids))
+	for i, id := range ids {
+		exists[i] = pipe.Exists(rf.key(id))
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return
+	}
+	expired := []interface{}{}
+	for i, id := range ids {
+		if exists[i].Val() == 0 {
+			expired = append(expired, id)
+		}
+	}
+	if len(expired) > 0 {
+		rf.Client.SRem(rf.userKey(userID), expired...)
+	}
+}
+
+//Get returns the family
+func (rf *RedisFamilyStore) Get(id string) (*Family, error) {
+	fields, err := rf.Client.HGetAll(rf.key(id)).Result()
+	if err != nil {
+		return nil, errors.New("Problem getting refresh token family: " + err.Error())
+	}
+	if len(fields) == 0 {
+		return nil, ErrInvalidToken
+	}
+	family := &Family{ID: id, Session: fields["session"], Current: fields["current"]}
+	family.UserID, _ = strconv.ParseInt(fields["userID"], 10, 64)
+	family.StartedAt, _ = time.Parse(time.RFC3339Nano, fields["startedAt"])
+	return family, nil
+}
+
+//Rotate replaces the family's current token hash if it's current
+func (rf *RedisFamilyStore) Rotate(id string, current string, next string, ttl time.Duration) error {
+	result, err := rotateScript.Run(rf.Client, []string{rf.key(id)}, current, next, int64(ttl/time.Millisecond)).Int64()
+	if err != nil {
+		return errors.New("Problem rotating refresh token: " + err.Error())
+	}
+	switch result {
+	case 0:
+		return ErrInvalidToken
+	case -1:
+		return ErrTokenReused
+	}
+	return nil
+}
+
+//Delete deletes the family
+func (rf *RedisFamilyStore) Delete(id string) error {
+	if err := rf.Client.Del(rf.key(id)).Err(); err != nil {
+		return errors.New("Problem deleting refresh token family: " + err.Error())
+	}
+	return nil
+}
+
+//DeleteUser deletes every one of the user's families
+func (rf *RedisFamilyStore) DeleteUser(userID int64) error {
+	ids, err := rf.Client.SMembers(rf.userKey(userID)).Result()
+	if err != nil {
+		return errors.New("Problem getting refresh token families: " + err.Error())
+	}
+	// the families may be in different slots, so they're deleted one at a time
+	pipe := rf.Client.Pipeline()
+	for _, id := range ids {
+		pipe.Del(rf.key(id))
+	}
+	pipe.Del(rf.userKey(userID))
+	if _, err := pipe.Exec(); err != nil {
+		return errors.New("Problem deleting refresh token families: " + err.Error())
+	}
+	return nil
+}
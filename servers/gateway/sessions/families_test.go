@@ -0,0 +1,89 @@
+package sessions
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+//testFamilyStore runs a FamilyStore through creating,
+//rotating, reusing and deleting families
+func testFamilyStore(t *testing.T, store FamilyStore) {
+	started := time.Now().Add(-time.Hour).UTC().Round(time.Millisecond)
+	family := &Family{ID: "family-1", UserID: 42, Session: "session", StartedAt: started, Current: "first"}
+	if err := store.Create(family, time.Hour); err != nil {
+		t.Fatalf("error creating family: %v", err)
+	}
+	got, err := store.Get(family.ID)
+	if err != nil || got.UserID != 42 || got.Session != "session" || !got.StartedAt.Equal(started) || got.Current != "first" {
+		t.Fatalf("expected the family back but got %+v: %v", got, err)
+	}
+
+	// only one of the rotations racing on the same token succeeds
+	var wg sync.WaitGroup
+	results := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- store.Rotate(family.ID, "first", "second", time.Hour)
+		}()
+	}
+	wg.Wait()
+	close(results)
+	succeeded := 0
+	for err := range results {
+		if err == nil {
+			succeeded++
+		} else if err != ErrTokenReused && err != ErrInvalidToken {
+			t.Errorf("unexpected error rotating family: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("expected exactly one rotation to succeed but %d did", succeeded)
+	}
+	if _, err := store.Get(family.ID); err != ErrInvalidToken {
+		t.Errorf("expected the reused family to be deleted but got %v", err)
+	}
+
+	other := &Family{ID: "family-2", UserID: 42, StartedAt: started, Current: "token"}
+	kept := &Family{ID: "family-3", UserID: 7, StartedAt: started, Current: "token"}
+	store.Create(other, time.Hour)
+	store.Create(kept, time.Hour)
+	if err := store.DeleteUser(42); err != nil {
+		t.Fatalf("error deleting user's families: %v", err)
+	}
+	if _, err := store.Get(other.ID); err != ErrInvalidToken {
+		t.Errorf("expected the user's families to be deleted but got %v", err)
+	}
+	if _, err := store.Get(kept.ID); err != nil {
+		t.Errorf("expected other users' families to be kept but got %v", err)
+	}
+	if err := store.Delete(kept.ID); err != nil {
+		t.Errorf("error deleting family: %v", err)
+	}
+	if err := store.Rotate(kept.ID, "token", "next", time.Hour); err != ErrInvalidToken {
+		t.Errorf("expected a deleted family to be invalid but got %v", err)
+	}
+}
+
+func TestStoreFamilies(t *testing.T) {
+	testFamilyStore(t, NewStoreFamilies(NewMemStore(time.Hour, time.Minute)))
+}
+
+//TestRedisFamilyStore uses a local instance of redis, like TestRedisStore
+func TestRedisFamilyStore(t *testing.T) {
+	redisaddr := os.Getenv("REDISADDR")
+	if len(redisaddr) == 0 {
+		redisaddr = ":6379"
+	}
+	client := redis.NewClient(&redis.Options{Addr: redisaddr})
+	defer client.Close()
+	if err := client.Ping().Err(); err != nil {
+		t.Skipf("redis isn't running at %s: %v", redisaddr, err)
+	}
+	testFamilyStore(t, NewRedisFamilyStore(client))
+}
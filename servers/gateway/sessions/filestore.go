@@ -0,0 +1,526 @@
+package sessions
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//fileRecordHeaderLength is the length and checksum in front of each record
+const fileRecordHeaderLength = 8
+
+//maxFileRecordLength is the longest record, so a corrupt length can't
+//make the store allocate more memory than it has
+const maxFileRecordLength = 1 << 24
+
+//FileStore is a Store that persists sessions to a file, for single-node
+//deployments without redis. Every change is appended to the file as
+//a checksummed record, and is synced to disk before the change returns
+//unless it only extends a session's expiry, so a crash loses nothing
+//more than the record being written, which is dropped when the file is
+//next opened. The file is compacted periodically, dropping expired and
+//replaced records by writing the sessions to a new file and renaming
+//it over the old one. Only one process can use the file at a time.
+//Like in a RedisStore, sessions are kept under an HMAC of their ID,
+//so a copy of the file doesn't hold any session IDs that could be used.
+type FileStore struct {
+	//Keyring has the keys the HMACs of session IDs are made with
+	Keyring *Keyring
+	//SessionDuration is how long sessions last without being used
+	SessionDuration time.Duration
+	//MaxLifetime is how long sessions last after they begin, however
+	//often they're used. Zero means there's no limit.
+	MaxLifetime time.Duration
+	path        string
+	file        *os.File
+	items       map[string]*fileItem
+	//HMACs of the IDs of each user's sessions, which may have expired
+	userSessions map[int64]map[string]bool
+	//stale is how many records in the file have been replaced
+	stale int
+	done  chan struct{}
+	lock  sync.Mutex
+}
+
+//fileItem is a value in a FileStore
+type fileItem struct {
+	value   []byte
+	expires time.Time
+}
+
+//fileRecord is a change to a FileStore, as it's written to the file
+type fileRecord struct {
+	Key     string          `json:"k"`
+	Value   json.RawMessage `json:"v,omitempty"`
+	Expires int64           `json:"e,omitempty"`
+	Deleted bool            `json:"d,omitempty"`
+}
+
+//fileMetadata is session metadata as it's kept in a FileStore
+type fileMetadata struct {
+	*Metadata
+	UserID int64 `json:"userID"`
+}
+
+//NewFileStore opens the FileStore at the path, creating it if it doesn't
+//exist, and compacts it every compactInterval until it's closed. The
+//keyring is the one that signs session IDs.
+func NewFileStore(path string, keyring *Keyring, sessionDuration time.Duration, compactInterval time.Duration) (*FileStore, error) {
+	fs := &FileStore{
+		Keyring:         keyring,
+		SessionDuration: sessionDuration,
+		path:            path,
+		items:           map[string]*fileItem{},
+		userSessions:    map[int64]map[string]bool{},
+		done:            make(chan struct{}),
+	}
+	if err := fs.load(); err != nil {
+		return nil, err
+	}
+	if err := fs.compact(); err != nil {
+		fs.file.Close()
+		return nil, err
+	}
+	go fs.compactEvery(compactInterval)
+	return fs, nil
+}
+
+//load reads the records in the file. A record that's cut short or
+//doesn't match its checksum was being written when the process stopped,
+//so it and anything after it are dropped.
+func (fs *FileStore) load() error {
+	file, err := os.OpenFile(fs.path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.New("Problem opening session file: " + err.Error())
+	}
+	fs.file = file
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, fileRecordHeaderLength)
+	//offset is where the last whole record ends
+	var offset int64
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			break
+		}
+		length := binary.BigEndian.Uint32(header[:4])
+		if length > maxFileRecordLength {
+			break
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+			break
+		}
+		record := &fileRecord{}
+		if err := json.Unmarshal(payload, record); err != nil {
+			break
+		}
+		fs.apply(record)
+		offset += int64(fileRecordHeaderLength + length)
+	}
+	// new records go after the last whole one
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return errors.New("Problem truncating session file: " + err.Error())
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return errors.New("Problem seeking session file: " + err.Error())
+	}
+
+	now := time.Now()
+	for key, item := range fs.items {
+		if now.After(item.expires) {
+			delete(fs.items, key)
+			fs.stale++
+		}
+	}
+	if err := fs.migrate(); err != nil {
+		file.Close()
+		return err
+	}
+	for key, item := range fs.items {
+		if strings.HasPrefix(key, "sidmeta:") {
+			fs.index(strings.TrimPrefix(key, "sidmeta:"), item.value)
+		}
+	}
+	return nil
+}
+
+//migrate moves sessions saved under their IDs, before sessions were
+//kept under the HMACs of their IDs, to their hashed keys, with the lock held
+func (fs *FileStore) migrate() error {
+	moved := false
+	for key, item := range fs.items {
+		prefix := "sid:"
+		if strings.HasPrefix(key, "sidmeta:") {
+			prefix = "sidmeta:"
+		}
+		id := strings.TrimPrefix(key, prefix)
+		if !strings.HasPrefix(key, prefix) || isStoreHash(id) {
+			continue
+		}
+		sid := SessionID(id)
+		value := item.value
+		if prefix == "sidmeta:" {
+			// the metadata didn't record the session's public ID
+			meta := &fileMetadata{Metadata: &Metadata{}}
+			if err := json.Unmarshal(value, meta); err == nil {
+				meta.ID = sid.PublicID()
+				value, _ = json.Marshal(meta)
+			}
+		}
+		record := &fileRecord{Key: prefix + fs.hash(sid), Value: value, Expires: item.expires.UnixNano()}
+		if err := fs.write(record, false); err != nil {
+			return err
+		}
+		if err := fs.write(&fileRecord{Key: key, Deleted: true}, false); err != nil {
+			return err
+		}
+		moved = true
+	}
+	if moved {
+		// so the compaction after loading rewrites the file without the IDs
+		fs.stale = len(fs.items)
+	}
+	return nil
+}
+
+//isStoreHash reports whether the key's ID is an HMAC from storeHash
+func isStoreHash(id string) bool {
+	decoded, err := hex.DecodeString(id)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+//apply applies the record to the items in memory
+func (fs *FileStore) apply(record *fileRecord) {
+	if _, ok := fs.items[record.Key]; ok {
+		fs.stale++
+	}
+	if record.Deleted {
+		delete(fs.items, record.Key)
+		fs.stale++
+		return
+	}
+	fs.items[record.Key] = &fileItem{value: record.Value, expires: time.Unix(0, record.Expires)}
+}
+
+//index adds the session with the HMAC to the index of its user's sessions
+func (fs *FileStore) index(hash string, value []byte) {
+	meta := &fileMetadata{Metadata: &Metadata{}}
+	if err := json.Unmarshal(value, meta); err != nil {
+		return
+	}
+	if fs.userSessions[meta.UserID] == nil {
+		fs.userSessions[meta.UserID] = map[string]bool{}
+	}
+	fs.userSessions[meta.UserID][hash] = true
+}
+
+//write appends the record to the file and applies it, with the lock held.
+//Unless sync is false, the record is on disk when write returns.
+func (fs *FileStore) write(record *fileRecord, sync bool) error {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, fileRecordHeaderLength, fileRecordHeaderLength+len(payload))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(payload))
+	if _, err := fs.file.Write(append(buf, payload...)); err != nil {
+		return errors.New("Problem writing session file: " + err.Error())
+	}
+	if sync {
+		if err := fs.file.Sync(); err != nil {
+			return errors.New("Problem syncing session file: " + err.Error())
+		}
+	}
+	fs.apply(record)
+	return nil
+}
+
+//set sets the key's value until it expires, with the lock held
+func (fs *FileStore) set(key string, value []byte, ttl time.Duration, sync bool) error {
+	return fs.write(&fileRecord{Key: key, Value: value, Expires: time.Now().Add(ttl).UnixNano()}, sync)
+}
+
+//get returns the key's item if it hasn't expired, with the lock held
+func (fs *FileStore) get(key string) (*fileItem, bool) {
+	item, ok := fs.items[key]
+	if !ok || time.Now().After(item.expires) {
+		return nil, false
+	}
+	return item, true
+}
+
+//compactEvery compacts the file every interval until the store is closed
+func (fs *FileStore) compactEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fs.lock.Lock()
+			select {
+			case <-fs.done:
+				// closed while waiting for the lock
+			default:
+				fs.compact()
+			}
+			fs.lock.Unlock()
+		case <-fs.done:
+			return
+		}
+	}
+}
+
+//compact drops expired sessions, and rewrites the file with only the
+//current records if it's mostly stale ones, with the lock held. The new
+//file is synced before it replaces the old one, so a crash leaves one
+//or the other.
+func (fs *FileStore) compact() error {
+	now := time.Now()
+	for key, item := range fs.items {
+		if now.After(item.expires) {
+			delete(fs.items, key)
+			fs.stale++
+		}
+	}
+	if fs.stale == 0 || fs.stale < len(fs.items) {
+		return nil
+	}
+
+	tmpPath := fs.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.New("Problem creating session file: " + err.Error())
+	}
+	old := fs.file
+	fs.file = tmp
+	items := fs.items
+	fs.items = map[string]*fileItem{}
+	for key, item := range items {
+		if err := fs.write(&fileRecord{Key: key, Value: item.value, Expires: item.expires.UnixNano()}, false); err != nil {
+			fs.file, fs.items = old, items
+			tmp.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		fs.file, fs.items = old, items
+		tmp.Close()
+		os.Remove(tmpPath)
+		return errors.New("Problem syncing session file: " + err.Error())
+	}
+	if err := os.Rename(tmpPath, fs.path); err != nil {
+		fs.file, fs.items = old, items
+		tmp.Close()
+		os.Remove(tmpPath)
+		return errors.New("Problem replacing session file: " + err.Error())
+	}
+	// the rename is only durable once the directory is synced
+	if dir, err := os.Open(filepath.Dir(fs.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	old.Close()
+	fs.stale = 0
+	return nil
+}
+
+//Close compacts the file and closes it
+func (fs *FileStore) Close() error {
+	close(fs.done)
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	fs.compact()
+	return fs.file.Close()
+}
+
+//Save saves the provided `sessionState` and associated SessionID to the store.
+//The `sessionState` parameter is typically a pointer to a struct containing
+//all the data you want to associated with the given SessionID.
+func (fs *FileStore) Save(sid SessionID, sessionState interface{}) error {
+	value, err := json.Marshal(sessionState)
+	if err != nil {
+		return errors.New("Problem during marshal of session state.")
+	}
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	env := &envelope{CreatedAt: time.Now().UTC(), State: value}
+	if item, ok := fs.get(fs.key(sid)); ok {
+		// saving again doesn't extend the session's lifetime
+		prev, _ := decodeEnvelope(item.value)
+		env.CreatedAt = prev.CreatedAt
+	}
+	ttl := remaining(env.CreatedAt, fs.SessionDuration, fs.MaxLifetime)
+	if ttl <= 0 {
+		return ErrSessionExpired
+	}
+	return fs.set(fs.key(sid), env.encode(), ttl, true)
+}
+
+//Get populates `sessionState` with the data previously saved
+//for the given SessionID, and resets its idle timeout
+func (fs *FileStore) Get(sid SessionID, sessionState interface{}) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	item, ok := fs.get(fs.key(sid))
+	if !ok {
+		return ErrStateNotFound
+	}
+	env, _ := decodeEnvelope(item.value)
+	ttl := remaining(env.CreatedAt, fs.SessionDuration, fs.MaxLifetime)
+	if ttl <= 0 {
+		fs.delete(sid)
+		return ErrSessionExpired
+	}
+
+	// losing a later expiry in a crash only ends the session sooner
+	if err := fs.set(fs.key(sid), env.encode(), ttl, false); err != nil {
+		return err
+	}
+	if meta, ok := fs.get(fs.metadataKey(sid)); ok {
+		if err := fs.set(fs.metadataKey(sid), meta.value, ttl, false); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(env.State, sessionState)
+}
+
+//Expiry returns when the session will expire unless it's used again
+func (fs *FileStore) Expiry(sid SessionID) (time.Time, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	item, ok := fs.get(fs.key(sid))
+	if !ok {
+		return time.Time{}, ErrStateNotFound
+	}
+	return item.expires, nil
+}
+
+//Delete deletes all state data associated with the SessionID from the store,
+//including its metadata.
+func (fs *FileStore) Delete(sid SessionID) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return fs.delete(sid)
+}
+
+//delete deletes the session, with the lock held
+func (fs *FileStore) delete(sid SessionID) error {
+	if meta, ok := fs.items[fs.metadataKey(sid)]; ok {
+		decoded := &fileMetadata{Metadata: &Metadata{}}
+		if err := json.Unmarshal(meta.value, decoded); err == nil {
+			delete(fs.userSessions[decoded.UserID], fs.hash(sid))
+		}
+		if err := fs.write(&fileRecord{Key: fs.metadataKey(sid), Deleted: true}, false); err != nil {
+			return err
+		}
+	}
+	if _, ok := fs.items[fs.key(sid)]; !ok {
+		return nil
+	}
+	return fs.write(&fileRecord{Key: fs.key(sid), Deleted: true}, true)
+}
+
+//SaveMetadata saves the metadata of the session and adds it to
+//the index of its user's sessions. It expires with the session.
+func (fs *FileStore) SaveMetadata(sid SessionID, meta *Metadata) error {
+	saved := *meta
+	saved.ID = sid.PublicID()
+	value, err := json.Marshal(&fileMetadata{Metadata: &saved, UserID: meta.UserID})
+	if err != nil {
+		return err
+	}
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	ttl := fs.SessionDuration
+	if item, ok := fs.get(fs.key(sid)); ok {
+		ttl = time.Until(item.expires)
+	}
+	if err := fs.set(fs.metadataKey(sid), value, ttl, true); err != nil {
+		return err
+	}
+	fs.index(fs.hash(sid), value)
+	return nil
+}
+
+//Touch records that the session was just used from the IP address
+func (fs *FileStore) Touch(sid SessionID, ip string) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	item, ok := fs.get(fs.metadataKey(sid))
+	if !ok {
+		return ErrStateNotFound
+	}
+	meta := &fileMetadata{Metadata: &Metadata{}}
+	if err := json.Unmarshal(item.value, meta); err != nil {
+		return err
+	}
+	meta.LastSeenAt = time.Now().UTC()
+	meta.IP = ip
+	value, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return fs.set(fs.metadataKey(sid), value, time.Until(item.expires), false)
+}
+
+//Sessions returns the metadata of the user's sessions
+//that haven't expired, most recently seen first. The index only
+//has the HMACs of their IDs, so each SessionID is a handle.
+func (fs *FileStore) Sessions(userID int64) ([]*Metadata, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	list := []*Metadata{}
+	for hash := range fs.userSessions[userID] {
+		item, ok := fs.get("sidmeta:" + hash)
+		if !ok {
+			// the session expired
+			delete(fs.userSessions[userID], hash)
+			continue
+		}
+		meta := &fileMetadata{Metadata: &Metadata{}}
+		if err := json.Unmarshal(item.value, meta); err != nil {
+			continue
+		}
+		meta.Metadata.SessionID = newHandle(meta.Metadata.ID, hash)
+		meta.Metadata.UserID = meta.UserID
+		list = append(list, meta.Metadata)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeenAt.After(list[j].LastSeenAt)
+	})
+	return list, nil
+}
+
+//hash returns the HMAC of the session ID the session is kept under
+func (fs *FileStore) hash(sid SessionID) string {
+	return storeHash(fs.Keyring, sid)
+}
+
+//key returns the key of the session's state
+func (fs *FileStore) key(sid SessionID) string {
+	return "sid:" + fs.hash(sid)
+}
+
+//metadataKey returns the key of the session's metadata
+func (fs *FileStore) metadataKey(sid SessionID) string {
+	return "sidmeta:" + fs.hash(sid)
+}
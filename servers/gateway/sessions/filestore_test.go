@@ -0,0 +1,291 @@
+package sessions
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//newTestFileStore returns the path of a FileStore
+//in a temp dir, and a function that removes it
+func newTestFileStore(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	return filepath.Join(dir, "sessions.db"), func() { os.RemoveAll(dir) }
+}
+
+//TestFileStore runs through the same CRUD cycle as TestMemStore
+func TestFileStore(t *testing.T) {
+	type sessionState struct {
+		Sval string
+		Ival int
+	}
+
+	state := &sessionState{
+		Sval: "testing",
+		Ival: 99,
+	}
+	stateRet := &sessionState{}
+
+	sid, err := NewSessionID("test key")
+	if err != nil {
+		t.Fatalf("error generating new SessionID: %v", err)
+	}
+
+	path, cleanup := newTestFileStore(t)
+	defer cleanup()
+	store, err := NewFileStore(path, SingleKey("test key"), time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("error opening store: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Get(sid, stateRet); err != ErrStateNotFound {
+		t.Errorf("incorrect error when getting state that was never stored: expected %v but got %v", ErrStateNotFound, err)
+	}
+
+	if err := store.Save(sid, &state); err != nil {
+		t.Fatalf("error saving state: %v", err)
+	}
+
+	if err := store.Get(sid, &stateRet); err != nil {
+		t.Fatalf("error getting state: %v", err)
+	}
+	if !reflect.DeepEqual(state, stateRet) {
+		t.Errorf("incorrect state retrieved: expected %v but got %v", state, stateRet)
+	}
+
+	if err := store.Delete(sid); err != nil {
+		t.Errorf("error deleting state: %v", err)
+	}
+
+	if err := store.Get(sid, &stateRet); err != ErrStateNotFound {
+		t.Fatalf("incorrect error when getting state that was deleted: expected %v but got %v", ErrStateNotFound, err)
+	}
+
+	if err := store.Save(sid, func() {}); err == nil {
+		t.Error("expected error when attempting to save a session state with an unmarshalable field")
+	}
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	path, cleanup := newTestFileStore(t)
+	defer cleanup()
+	store, err := NewFileStore(path, SingleKey("test key"), time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("error opening store: %v", err)
+	}
+	defer func() { store.Close() }()
+
+	kept, _ := NewSessionID("test key")
+	deleted, _ := NewSessionID("test key")
+	for _, sid := range []SessionID{kept, deleted} {
+		if err := store.Save(sid, "state"); err != nil {
+			t.Fatalf("error saving state: %v", err)
+		}
+		if err := store.SaveMetadata(sid, &Metadata{UserID: 1, IP: "10.0.0.1"}); err != nil {
+			t.Fatalf("error saving metadata: %v", err)
+		}
+	}
+	if err := store.Delete(deleted); err != nil {
+		t.Fatalf("error deleting state: %v", err)
+	}
+	if err := store.Touch(kept, "10.0.0.2"); err != nil {
+		t.Fatalf("error touching session: %v", err)
+	}
+	expires, _ := store.Expiry(kept)
+	if err := store.Close(); err != nil {
+		t.Fatalf("error closing store: %v", err)
+	}
+
+	// the last record was being written when the process stopped
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	file.Write([]byte{0, 0, 0, 200, 1, 2, 3, 4, '{'})
+	file.Close()
+
+	store, err = NewFileStore(path, SingleKey("test key"), time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("error reopening store: %v", err)
+	}
+	state := ""
+	if err := store.Get(kept, &state); err != nil || state != "state" {
+		t.Errorf("expected the session to be kept but got %q: %v", state, err)
+	}
+	if err := store.Get(deleted, &state); err != ErrStateNotFound {
+		t.Errorf("expected the deleted session to stay deleted but got %v", err)
+	}
+	if reopened, _ := store.Expiry(kept); reopened.Before(expires) {
+		t.Errorf("expected the session to expire at %v but got %v", expires, reopened)
+	}
+	list, err := store.Sessions(1)
+	if err != nil || len(list) != 1 || list[0].ID != kept.PublicID() || store.key(list[0].SessionID) != store.key(kept) || list[0].IP != "10.0.0.2" {
+		t.Fatalf("expected the kept session's metadata but got %v: %v", list, err)
+	}
+
+	// records written after the torn one can be read too
+	if err := store.Save(deleted, "again"); err != nil {
+		t.Fatalf("error saving state: %v", err)
+	}
+	store.Close()
+	store, err = NewFileStore(path, SingleKey("test key"), time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("error reopening store: %v", err)
+	}
+	if err := store.Get(deleted, &state); err != nil || state != "again" {
+		t.Errorf("expected state saved after reopening but got %q: %v", state, err)
+	}
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	path, cleanup := newTestFileStore(t)
+	defer cleanup()
+	store, err := NewFileStore(path, SingleKey("test key"), time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("error opening store: %v", err)
+	}
+	defer func() { store.Close() }()
+
+	sid, _ := NewSessionID("test key")
+	expired, _ := NewSessionID("test key")
+	for i := 0; i < 100; i++ {
+		if err := store.Save(sid, i); err != nil {
+			t.Fatalf("error saving state: %v", err)
+		}
+	}
+	store.Save(expired, "state")
+	store.items[store.key(expired)].expires = time.Now().Add(-time.Second)
+	info, _ := os.Stat(path)
+	before := info.Size()
+
+	store.lock.Lock()
+	err = store.compact()
+	store.lock.Unlock()
+	if err != nil {
+		t.Fatalf("error compacting: %v", err)
+	}
+	info, _ = os.Stat(path)
+	if info.Size()*50 > before {
+		t.Errorf("expected compaction to drop stale records, but the file went from %d to %d bytes", before, info.Size())
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected the temp file to be renamed: %v", err)
+	}
+
+	latest := 0
+	if err := store.Get(sid, &latest); err != nil || latest != 99 {
+		t.Errorf("expected the latest state after compacting but got %d: %v", latest, err)
+	}
+	if err := store.Get(expired, &latest); err != ErrStateNotFound {
+		t.Errorf("expected the expired session to be dropped but got %v", err)
+	}
+	// the compacted file is still written to
+	if err := store.Save(sid, 100); err != nil {
+		t.Fatalf("error saving state: %v", err)
+	}
+	store.Close()
+	store, err = NewFileStore(path, SingleKey("test key"), time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("error reopening store: %v", err)
+	}
+	if err := store.Get(sid, &latest); err != nil || latest != 100 {
+		t.Errorf("expected state saved after compacting but got %d: %v", latest, err)
+	}
+}
+
+func TestFileStoreLifetime(t *testing.T) {
+	path, cleanup := newTestFileStore(t)
+	defer cleanup()
+	store, err := NewFileStore(path, SingleKey("test key"), time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("error opening store: %v", err)
+	}
+	defer store.Close()
+	store.MaxLifetime = 24 * time.Hour
+
+	sid, _ := NewSessionID("test key")
+	store.lock.Lock()
+	store.set(store.key(sid), (&envelope{CreatedAt: time.Now().Add(-23*time.Hour - 50*time.Minute), State: []byte(`"state"`)}).encode(), time.Hour, true)
+	store.lock.Unlock()
+	state := ""
+	if err := store.Get(sid, &state); err != nil {
+		t.Fatalf("error getting state: %v", err)
+	}
+	if expires, _ := store.Expiry(sid); time.Until(expires) > 10*time.Minute {
+		t.Errorf("expected the session to expire at its maximum lifetime but got %v", expires)
+	}
+
+	store.lock.Lock()
+	store.set(store.key(sid), (&envelope{CreatedAt: time.Now().Add(-25 * time.Hour), State: []byte(`"state"`)}).encode(), time.Hour, true)
+	store.lock.Unlock()
+	if err := store.Get(sid, &state); err != ErrSessionExpired {
+		t.Errorf("expected %v but got %v", ErrSessionExpired, err)
+	}
+	if err := store.Get(sid, &state); err != ErrStateNotFound {
+		t.Errorf("expected the expired session to be deleted but got %v", err)
+	}
+}
+
+func TestFileStoreHashedKeys(t *testing.T) {
+	path, cleanup := newTestFileStore(t)
+	defer cleanup()
+	store, err := NewFileStore(path, SingleKey("test key"), time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("error opening store: %v", err)
+	}
+	defer func() { store.Close() }()
+
+	// a session saved before IDs were hashed, under the ID itself
+	legacy, _ := NewSessionID("test key")
+	meta, _ := json.Marshal(&fileMetadata{Metadata: &Metadata{IP: "10.0.0.1"}, UserID: 1})
+	store.lock.Lock()
+	store.set("sid:"+legacy.String(), (&envelope{CreatedAt: time.Now(), State: []byte(`"legacy"`)}).encode(), time.Hour, true)
+	store.set("sidmeta:"+legacy.String(), meta, time.Hour, true)
+	store.lock.Unlock()
+	sid, _ := NewSessionID("test key")
+	if err := store.Save(sid, "state"); err != nil {
+		t.Fatalf("error saving state: %v", err)
+	}
+	if err := store.SaveMetadata(sid, &Metadata{UserID: 1}); err != nil {
+		t.Fatalf("error saving metadata: %v", err)
+	}
+	store.Close()
+
+	store, err = NewFileStore(path, SingleKey("test key"), time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("error reopening store: %v", err)
+	}
+	state := ""
+	if err := store.Get(legacy, &state); err != nil || state != "legacy" {
+		t.Errorf("expected the legacy session but got %q: %v", state, err)
+	}
+	list, err := store.Sessions(1)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("expected both sessions but got %v: %v", list, err)
+	}
+	for _, meta := range list {
+		if meta.ID != sid.PublicID() && meta.ID != legacy.PublicID() {
+			t.Errorf("expected the sessions' public IDs but got %s", meta.ID)
+		}
+	}
+
+	// reopening rewrote the file without either session's ID
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	for _, id := range []SessionID{legacy, sid} {
+		if bytes.Contains(data, []byte(id)) {
+			t.Errorf("expected the file not to hold the session ID %s", id)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package sessions
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+//maxKeyIDLength is the longest key ID, since its length is encoded in one byte
+const maxKeyIDLength = 255
+
+//ErrUnknownKey is returned when a session ID was signed by a key that isn't in the keyring
+var ErrUnknownKey = errors.New("session ID was signed by an unknown key")
+
+//Keyring holds the keys session IDs are signed with: the active key,
+//which signs new session IDs, and older keys that only validate the
+//session IDs they signed, so keys can be rotated without signing
+//everyone out. Each key's ID is encoded into the IDs it signs.
+//Session IDs from before keys had IDs are validated with the key
+//whose ID is empty. An EncryptedStore's keys are kept in a Keyring too.
+type Keyring struct {
+	path   string
+	active string
+	keys   map[string][]byte
+	lock   sync.RWMutex
+}
+
+//keyringFile is the JSON layout of a keyring file, such as
+//{"active": "2020-03", "keys": {"2020-03": "new key", "2020-01": "old key", "": "SESSIONKEY"}}
+type keyringFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+//NewKeyring constructs a new Keyring that signs with
+//the key with the active ID and validates with all of them
+func NewKeyring(active string, keys map[string]string) (*Keyring, error) {
+	kr := &Keyring{}
+	if err := kr.set(&keyringFile{Active: active, Keys: keys}); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+//SingleKey returns a Keyring with just the signing key,
+//which signs session IDs without a key ID
+func SingleKey(signingKey string) *Keyring {
+	return &Keyring{keys: map[string][]byte{"": []byte(signingKey)}}
+}
+
+//LoadKeyring constructs a new Keyring from the JSON file at the path.
+//The file can be changed and reloaded to rotate keys.
+func LoadKeyring(path string) (*Keyring, error) {
+	kr := &Keyring{path: path}
+	if err := kr.Reload(); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+//Reload reloads the keys from the keyring's file.
+//If the file isn't valid, the current keys are kept.
+func (kr *Keyring) Reload() error {
+	if len(kr.path) == 0 {
+		return errors.New("Keyring wasn't loaded from a file")
+	}
+	contents, err := ioutil.ReadFile(kr.path)
+	if err != nil {
+		return errors.New("Problem reading keyring: " + err.Error())
+	}
+	file := &keyringFile{}
+	if err := json.Unmarshal(contents, file); err != nil {
+		return errors.New("Problem decoding keyring: " + err.Error())
+	}
+	return kr.set(file)
+}
+
+//set replaces the keys after checking them
+func (kr *Keyring) set(file *keyringFile) error {
+	keys := map[string][]byte{}
+	for id, key := range file.Keys {
+		if len(id) > maxKeyIDLength {
+			return errors.New("Key ID is too long: " + id)
+		}
+		if len(key) == 0 {
+			return errors.New("Key is empty: " + id)
+		}
+		keys[id] = []byte(key)
+	}
+	if _, ok := keys[file.Active]; !ok {
+		return errors.New("Active key isn't in the keyring: " + file.Active)
+	}
+	kr.lock.Lock()
+	defer kr.lock.Unlock()
+	kr.active = file.Active
+	kr.keys = keys
+	return nil
+}
+
+//activeKey returns the ID and key of the active key
+func (kr *Keyring) activeKey() (string, []byte) {
+	kr.lock.RLock()
+	defer kr.lock.RUnlock()
+	return kr.active, kr.keys[kr.active]
+}
+
+//key returns the key with the ID, if it's in the keyring
+func (kr *Keyring) key(id string) ([]byte, bool) {
+	kr.lock.RLock()
+	defer kr.lock.RUnlock()
+	key, ok := kr.keys[id]
+	return key, ok
+}
+
+//Sign signs the payload for the purpose, such as "directory", with a
+//key derived from the active key, so the keys that sign session IDs
+//aren't used for anything else. The signature includes the key's ID,
+//so it verifies until that key is removed from the keyring.
+func (kr *Keyring) Sign(purpose string, payload []byte) string {
+	active, key := kr.activeKey()
+	return base64.RawURLEncoding.EncodeToString([]byte(active)) + "." +
+		base64.RawURLEncoding.EncodeToString(derivedSignature(key, purpose, payload))
+}
+
+//Verify reports whether the signature was made by Sign for the purpose
+//and payload, with a key that's still in the keyring
+func (kr *Keyring) Verify(purpose string, payload []byte, signature string) bool {
+	parts := strings.Split(signature, ".")
+	if len(parts) != 2 {
+		return false
+	}
+	keyID, err1 := base64.RawURLEncoding.DecodeString(parts[0])
+	mac, err2 := base64.RawURLEncoding.DecodeString(parts[1])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	key, ok := kr.key(string(keyID))
+	if !ok {
+		return false
+	}
+	return hmac.Equal(mac, derivedSignature(key, purpose, payload))
+}
+
+//derivedSignature signs the payload with a key derived
+//from the keyring's key for the purpose
+func derivedSignature(key []byte, purpose string, payload []byte) []byte {
+	derived := hmac.New(sha256.New, key)
+	derived.Write([]byte(purpose))
+	mac := hmac.New(sha256.New, derived.Sum(nil))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+//storeKeyPurpose is what the keys that make the HMACs stores keep
+//sessions under are derived from the signing keys for. It was first
+//only used by RedisStore, and keeps its name so existing keys still match.
+const storeKeyPurpose = "redis key"
+
+//storeHash returns the HMAC of the session ID, in hex, that stores keep
+//the session under, made with a key derived from the keyring's key that
+//signed it, or the HMAC in a handle
+func storeHash(keyring *Keyring, sid SessionID) string {
+	if _, hash, ok := sid.handle(); ok {
+		return hash
+	}
+	// IDs are validated before they get here, so a key is only missing
+	// if it was removed since, and then the session has ended anyway
+	var key []byte
+	if keyID, _, _, err := decodeID(sid.String()); err == nil && keyring != nil {
+		key, _ = keyring.key(keyID)
+	}
+	return hex.EncodeToString(derivedSignature(key, storeKeyPurpose, []byte(sid)))
+}
+
+//NewSessionID creates and returns a new session ID signed with the active key
+func (kr *Keyring) NewSessionID() (SessionID, error) {
+	active, key := kr.activeKey()
+	if len(key) == 0 {
+		return InvalidSessionID, errors.New("Signing key cannot be zero-length")
+	}
+	return newSignedID(active, key)
+}
+
+//ValidateID validates the string in the `id` parameter with
+//the key that signed it, and returns an error if it's invalid
+//or the key is no longer in the keyring, or a SessionID if valid
+func (kr *Keyring) ValidateID(id string) (SessionID, error) {
+	keyID, unsigned, signature, err := decodeID(id)
+	if err != nil {
+		return InvalidSessionID, err
+	}
+	key, ok := kr.key(keyID)
+	if !ok {
+		return InvalidSessionID, ErrUnknownKey
+	}
+	if !validSignature(unsigned, signature, key) {
+		return InvalidSessionID, ErrInvalidID
+	}
+	return SessionID(id), nil
+}
+
+//BeginSession is like the BeginSession function,
+//signing the new session ID with the active key
+func (kr *Keyring) BeginSession(store Store, sessionState interface{}, w http.ResponseWriter) (SessionID, error) {
+	return beginSession(kr, HeaderTransport, store, sessionState, w)
+}
+
+//GetSessionID is like the GetSessionID function,
+//validating the session ID with the keyring
+func (kr *Keyring) GetSessionID(r *http.Request) (SessionID, error) {
+	return getSessionID(r, kr)
+}
+
+//GetState is like the GetState function,
+//validating the session ID with the keyring
+func (kr *Keyring) GetState(r *http.Request, store Store, sessionState interface{}) (SessionID, error) {
+	return getState(r, kr, store, sessionState)
+}
+
+//EndSession is like the EndSession function,
+//validating the session ID with the keyring
+func (kr *Keyring) EndSession(r *http.Request, store Store) (SessionID, error) {
+	return endSession(r, kr, store)
+}
@@ -0,0 +1,123 @@
+package sessions
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	legacy, err := NewSessionID("old key")
+	if err != nil {
+		t.Fatalf("error generating SessionID: %v", err)
+	}
+	first, err := NewKeyring("1", map[string]string{"1": "first key", "": "old key"})
+	if err != nil {
+		t.Fatalf("error constructing keyring: %v", err)
+	}
+	signed, err := first.NewSessionID()
+	if err != nil {
+		t.Fatalf("error generating SessionID: %v", err)
+	}
+	if keyID, _, _, err := decodeID(string(signed)); err != nil || keyID != "1" {
+		t.Errorf("expected the key ID to be encoded but got %q: %v", keyID, err)
+	}
+
+	// the first key only verifies once the second is active
+	second, err := NewKeyring("2", map[string]string{"2": "second key", "1": "first key", "": "old key"})
+	if err != nil {
+		t.Fatalf("error constructing keyring: %v", err)
+	}
+	for _, sid := range []SessionID{legacy, signed} {
+		if validated, err := second.ValidateID(string(sid)); err != nil || validated != sid {
+			t.Errorf("expected %s to stay valid after rotating: %v", sid, err)
+		}
+	}
+	rotated, _ := second.NewSessionID()
+	if _, err := first.ValidateID(string(rotated)); err != ErrUnknownKey {
+		t.Errorf("expected %v but got %v", ErrUnknownKey, err)
+	}
+
+	// an ID signed with one key can't claim to be from another
+	other, _ := NewKeyring("2", map[string]string{"2": "first key"})
+	forged, _ := other.NewSessionID()
+	if _, err := second.ValidateID(string(forged)); err != ErrInvalidID {
+		t.Errorf("expected %v but got %v", ErrInvalidID, err)
+	}
+
+	if _, err := NewKeyring("3", map[string]string{"2": "second key"}); err == nil {
+		t.Error("expected an error when the active key isn't in the keyring")
+	}
+}
+
+func TestKeyringSign(t *testing.T) {
+	first, _ := NewKeyring("1", map[string]string{"1": "first key"})
+	payload := []byte("payload")
+	signature := first.Sign("cursor", payload)
+	if !first.Verify("cursor", payload, signature) {
+		t.Error("expected the signature to verify")
+	}
+	if first.Verify("export", payload, signature) {
+		t.Error("expected the signature not to verify for another purpose")
+	}
+	if first.Verify("cursor", []byte("other payload"), signature) {
+		t.Error("expected the signature not to verify for another payload")
+	}
+	for _, bad := range []string{"", "nope", "MQ", "MQ.AAAA"} {
+		if first.Verify("cursor", payload, bad) {
+			t.Errorf("expected %q not to verify", bad)
+		}
+	}
+
+	// signatures verify until their key is removed
+	second, _ := NewKeyring("2", map[string]string{"2": "second key", "1": "first key"})
+	if !second.Verify("cursor", payload, signature) {
+		t.Error("expected the signature to verify after rotating keys")
+	}
+	third, _ := NewKeyring("3", map[string]string{"3": "third key", "2": "second key"})
+	if third.Verify("cursor", payload, signature) {
+		t.Error("expected the signature not to verify once its key was removed")
+	}
+	if signature == second.Sign("cursor", payload) {
+		t.Error("expected the active key to sign")
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keyring.json")
+	write := func(contents string) {
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatalf("error writing keyring: %v", err)
+		}
+	}
+
+	write(`{"active": "1", "keys": {"1": "first key"}}`)
+	keyring, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("error loading keyring: %v", err)
+	}
+	sid, _ := keyring.NewSessionID()
+
+	write(`{"active": "2", "keys": {"2": "second key", "1": "first key"}}`)
+	if err := keyring.Reload(); err != nil {
+		t.Fatalf("error reloading keyring: %v", err)
+	}
+	if _, err := keyring.ValidateID(string(sid)); err != nil {
+		t.Errorf("expected the old key to still verify: %v", err)
+	}
+
+	// a broken file keeps the current keys
+	write(`{"active": "3"`)
+	if err := keyring.Reload(); err == nil {
+		t.Error("expected an error reloading an invalid keyring")
+	}
+	if _, err := keyring.ValidateID(string(sid)); err != nil {
+		t.Errorf("expected the keys to be kept: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package sessions
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+//ErrSessionExpired is returned from Store.Get() when the session
+//has outlived the store's MaxLifetime, so its user has to sign in again
+var ErrSessionExpired = errors.New("the session has expired, please sign in again")
+
+//envelope is how the stores save session state, along with
+//when the session began so its lifetime can be limited
+type envelope struct {
+	CreatedAt time.Time       `json:"createdAt"`
+	State     json.RawMessage `json:"state"`
+}
+
+//decodeEnvelope decodes a saved session. Sessions saved before their
+//creation time was recorded are treated as beginning now, in which
+//case ok is false and the envelope should be saved in their place.
+func decodeEnvelope(value []byte) (env *envelope, ok bool) {
+	env = &envelope{}
+	if err := json.Unmarshal(value, env); err != nil || env.State == nil || env.CreatedAt.IsZero() {
+		return &envelope{CreatedAt: time.Now().UTC(), State: value}, false
+	}
+	return env, true
+}
+
+//encode encodes the envelope to save it
+func (env *envelope) encode() []byte {
+	// a time and raw JSON always marshal
+	value, _ := json.Marshal(env)
+	return value
+}
+
+//remaining returns how long a session that began at createdAt lasts
+//if it's used now: the idle timeout, unless the session reaches its
+//maximum lifetime first. A maxLifetime of zero means there's no limit.
+//It's zero or less once the session has expired.
+func remaining(createdAt time.Time, idleTimeout time.Duration, maxLifetime time.Duration) time.Duration {
+	if maxLifetime <= 0 {
+		return idleTimeout
+	}
+	if left := time.Until(createdAt.Add(maxLifetime)); left < idleTimeout {
+		return left
+	}
+	return idleTimeout
+}
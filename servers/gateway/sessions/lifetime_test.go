@@ -0,0 +1,40 @@
+package sessions
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRemaining(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name        string
+		createdAt   time.Time
+		maxLifetime time.Duration
+		min, max    time.Duration
+	}{
+		{"no limit", now.Add(-48 * time.Hour), 0, time.Hour, time.Hour},
+		{"new session", now, 24 * time.Hour, time.Hour, time.Hour},
+		{"near its limit", now.Add(-23*time.Hour - 30*time.Minute), 24 * time.Hour, 29 * time.Minute, 30 * time.Minute},
+		{"past its limit", now.Add(-25 * time.Hour), 24 * time.Hour, -time.Hour - time.Second, -time.Hour + time.Second},
+	}
+	for _, c := range cases {
+		if ttl := remaining(c.createdAt, time.Hour, c.maxLifetime); ttl < c.min || ttl > c.max {
+			t.Errorf("%s: expected between %v and %v but got %v", c.name, c.min, c.max, ttl)
+		}
+	}
+}
+
+func TestDecodeEnvelope(t *testing.T) {
+	createdAt := time.Now().Add(-time.Hour).UTC()
+	env, ok := decodeEnvelope((&envelope{CreatedAt: createdAt, State: []byte(`{"a":1}`)}).encode())
+	if !ok || !env.CreatedAt.Equal(createdAt) || string(env.State) != `{"a":1}` {
+		t.Errorf("incorrect envelope: %+v", env)
+	}
+
+	// sessions saved before creation times were recorded begin now
+	env, ok = decodeEnvelope([]byte(`{"a":1}`))
+	if ok || time.Since(env.CreatedAt) > time.Minute || string(env.State) != `{"a":1}` {
+		t.Errorf("incorrect envelope for a session without a creation time: %+v", env)
+	}
+}
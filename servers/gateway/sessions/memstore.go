@@ -2,6 +2,8 @@ package sessions
 
 import (
 	"encoding/json"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/patrickmn/go-cache"
@@ -11,13 +13,25 @@ import (
 //This should be used only for testing and prototyping.
 //Production systems should use a shared server store like redis
 type MemStore struct {
-	entries *cache.Cache
+	//MaxLifetime is how long sessions last after they begin, however
+	//often they're used. Zero means there's no limit.
+	MaxLifetime time.Duration
+	idleTimeout time.Duration
+	entries     *cache.Cache
+	metadata    *cache.Cache
+	//IDs of each user's sessions, which may have expired
+	userSessions map[int64]map[SessionID]bool
+	lock         sync.Mutex
 }
 
-//NewMemStore constructs and returns a new MemStore
+//NewMemStore constructs and returns a new MemStore,
+//whose sessions expire after sessionDuration without being used
 func NewMemStore(sessionDuration time.Duration, purgeInterval time.Duration) *MemStore {
 	return &MemStore{
-		entries: cache.New(sessionDuration, purgeInterval),
+		idleTimeout:  sessionDuration,
+		entries:      cache.New(sessionDuration, purgeInterval),
+		metadata:     cache.New(sessionDuration, purgeInterval),
+		userSessions: map[int64]map[SessionID]bool{},
 	}
 }
 
@@ -29,7 +43,17 @@ func (ms *MemStore) Save(sid SessionID, state interface{}) error {
 	if nil != err {
 		return err
 	}
-	ms.entries.Set(sid.String(), j, cache.DefaultExpiration)
+	env := &envelope{CreatedAt: time.Now().UTC(), State: j}
+	if saved, found := ms.entries.Get(sid.String()); found {
+		// saving again doesn't extend the session's lifetime
+		prev, _ := decodeEnvelope(saved.([]byte))
+		env.CreatedAt = prev.CreatedAt
+	}
+	ttl := remaining(env.CreatedAt, ms.idleTimeout, ms.MaxLifetime)
+	if ttl <= 0 {
+		return ErrSessionExpired
+	}
+	ms.entries.Set(sid.String(), env.encode(), ttl)
 	return nil
 }
 
@@ -40,13 +64,97 @@ func (ms *MemStore) Get(sid SessionID, state interface{}) error {
 	if !found {
 		return ErrStateNotFound
 	}
+	env, _ := decodeEnvelope(j.([]byte))
+	ttl := remaining(env.CreatedAt, ms.idleTimeout, ms.MaxLifetime)
+	if ttl <= 0 {
+		ms.Delete(sid)
+		return ErrSessionExpired
+	}
 	//reset TTL
-	ms.entries.Set(sid.String(), j, 0)
-	return json.Unmarshal(j.([]byte), state)
+	ms.entries.Set(sid.String(), env.encode(), ttl)
+	if meta, found := ms.metadata.Get(sid.String()); found {
+		ms.metadata.Set(sid.String(), meta, ttl)
+	}
+	return json.Unmarshal(env.State, state)
 }
 
-//Delete deletes all state data associated with the SessionID from the store.
+//Expiry returns when the session will expire unless it's used again
+func (ms *MemStore) Expiry(sid SessionID) (time.Time, error) {
+	_, expires, found := ms.entries.GetWithExpiration(sid.String())
+	if !found {
+		return time.Time{}, ErrStateNotFound
+	}
+	return expires, nil
+}
+
+//sessionTTL returns how long the session has left, which
+//is how long its metadata should be kept for
+func (ms *MemStore) sessionTTL(sid SessionID) time.Duration {
+	if expires, err := ms.Expiry(sid); err == nil {
+		return time.Until(expires)
+	}
+	return cache.DefaultExpiration
+}
+
+//Delete deletes all state data associated with the SessionID from the store,
+//including its metadata.
 func (ms *MemStore) Delete(sid SessionID) error {
 	ms.entries.Delete(sid.String())
+	if meta, found := ms.metadata.Get(sid.String()); found {
+		ms.lock.Lock()
+		delete(ms.userSessions[meta.(Metadata).UserID], sid)
+		ms.lock.Unlock()
+		ms.metadata.Delete(sid.String())
+	}
 	return nil
 }
+
+//SaveMetadata saves the metadata of the session and adds it to
+//the index of its user's sessions. It expires with the session.
+func (ms *MemStore) SaveMetadata(sid SessionID, meta *Metadata) error {
+	ms.metadata.Set(sid.String(), *meta, ms.sessionTTL(sid))
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	if ms.userSessions[meta.UserID] == nil {
+		ms.userSessions[meta.UserID] = map[SessionID]bool{}
+	}
+	ms.userSessions[meta.UserID][sid] = true
+	return nil
+}
+
+//Touch records that the session was just used from the IP address
+func (ms *MemStore) Touch(sid SessionID, ip string) error {
+	value, found := ms.metadata.Get(sid.String())
+	if !found {
+		return ErrStateNotFound
+	}
+	meta := value.(Metadata)
+	meta.LastSeenAt = time.Now().UTC()
+	meta.IP = ip
+	ms.metadata.Set(sid.String(), meta, ms.sessionTTL(sid))
+	return nil
+}
+
+//Sessions returns the metadata of the user's sessions
+//that haven't expired, most recently seen first
+func (ms *MemStore) Sessions(userID int64) ([]*Metadata, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	list := []*Metadata{}
+	for sid := range ms.userSessions[userID] {
+		value, found := ms.metadata.Get(sid.String())
+		if !found {
+			// the session expired
+			delete(ms.userSessions[userID], sid)
+			continue
+		}
+		meta := value.(Metadata)
+		meta.ID = sid.PublicID()
+		meta.SessionID = sid
+		list = append(list, &meta)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeenAt.After(list[j].LastSeenAt)
+	})
+	return list, nil
+}
@@ -75,3 +75,113 @@ func TestMemStoreSaveUnmarshalble(t *testing.T) {
 		t.Error("expected error when attempting to save a session state with an unmarshalable field")
 	}
 }
+
+func TestMemStoreSessions(t *testing.T) {
+	store := NewMemStore(time.Hour, time.Minute)
+	newSession := func(userID int64, lastSeen time.Time) SessionID {
+		sid, err := NewSessionID("test key")
+		if err != nil {
+			t.Fatalf("error generating new SessionID: %v", err)
+		}
+		if err := store.Save(sid, "state"); err != nil {
+			t.Fatalf("error saving state: %v", err)
+		}
+		if err := store.SaveMetadata(sid, &Metadata{UserID: userID, CreatedAt: lastSeen, LastSeenAt: lastSeen, IP: "10.0.0.1"}); err != nil {
+			t.Fatalf("error saving metadata: %v", err)
+		}
+		return sid
+	}
+
+	now := time.Now().UTC()
+	older := newSession(1, now.Add(-time.Hour))
+	newer := newSession(1, now)
+	newSession(2, now)
+
+	list, err := store.Sessions(1)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("expected 2 sessions but got %d: %v", len(list), err)
+	}
+	if list[0].SessionID != newer || list[1].SessionID != older {
+		t.Error("expected the most recently seen session first")
+	}
+	if list[0].ID != newer.PublicID() || list[0].ID == newer.String() {
+		t.Errorf("incorrect public ID: %s", list[0].ID)
+	}
+	if meta, err := FindSession(list, older.PublicID()); err != nil || meta.SessionID != older {
+		t.Errorf("error finding session: %v", err)
+	}
+	if _, err := FindSession(list, "unknown"); err != ErrSessionNotFound {
+		t.Errorf("expected %v but got %v", ErrSessionNotFound, err)
+	}
+
+	if err := store.Touch(older, "10.0.0.2"); err != nil {
+		t.Fatalf("error touching session: %v", err)
+	}
+	list, _ = store.Sessions(1)
+	if list[0].SessionID != older || list[0].IP != "10.0.0.2" {
+		t.Error("expected the touched session first, with its new IP address")
+	}
+
+	if err := store.Delete(older); err != nil {
+		t.Fatalf("error deleting session: %v", err)
+	}
+	if list, _ = store.Sessions(1); len(list) != 1 || list[0].SessionID != newer {
+		t.Errorf("expected only the remaining session but got %d", len(list))
+	}
+	if err := store.Touch(older, "10.0.0.2"); err != ErrStateNotFound {
+		t.Errorf("expected %v touching a deleted session but got %v", ErrStateNotFound, err)
+	}
+}
+
+func TestPublicID(t *testing.T) {
+	sid, err := NewSessionID("test key")
+	if err != nil {
+		t.Fatalf("error generating new SessionID: %v", err)
+	}
+	if len(sid.PublicID()) != publicIDLength*2 || sid.PublicID() != sid.PublicID() {
+		t.Errorf("incorrect public ID: %s", sid.PublicID())
+	}
+}
+
+func TestMemStoreLifetime(t *testing.T) {
+	store := NewMemStore(time.Hour, time.Minute)
+	store.MaxLifetime = 24 * time.Hour
+	sid, err := NewSessionID("test key")
+	if err != nil {
+		t.Fatalf("error generating new SessionID: %v", err)
+	}
+	if err := store.Save(sid, "state"); err != nil {
+		t.Fatalf("error saving state: %v", err)
+	}
+	state := ""
+	if err := store.Get(sid, &state); err != nil || state != "state" {
+		t.Fatalf("error getting state: %v", err)
+	}
+	if expires, err := store.Expiry(sid); err != nil || time.Until(expires) > time.Hour || time.Until(expires) < 59*time.Minute {
+		t.Errorf("expected the session to expire after the idle timeout but got %v: %v", expires, err)
+	}
+
+	// a session that began almost a day ago only lasts until its maximum lifetime
+	store.entries.Set(sid.String(), (&envelope{CreatedAt: time.Now().Add(-23*time.Hour - 50*time.Minute), State: []byte(`"state"`)}).encode(), 0)
+	if err := store.Get(sid, &state); err != nil {
+		t.Fatalf("error getting state: %v", err)
+	}
+	if expires, _ := store.Expiry(sid); time.Until(expires) > 10*time.Minute {
+		t.Errorf("expected the session to expire at its maximum lifetime but got %v", expires)
+	}
+	// saving it again doesn't extend it
+	if err := store.Save(sid, "changed"); err != nil {
+		t.Fatalf("error saving state: %v", err)
+	}
+	if expires, _ := store.Expiry(sid); time.Until(expires) > 10*time.Minute {
+		t.Errorf("expected saving not to extend the session but got %v", expires)
+	}
+
+	store.entries.Set(sid.String(), (&envelope{CreatedAt: time.Now().Add(-25 * time.Hour), State: []byte(`"state"`)}).encode(), 0)
+	if err := store.Get(sid, &state); err != ErrSessionExpired {
+		t.Errorf("expected %v but got %v", ErrSessionExpired, err)
+	}
+	if err := store.Get(sid, &state); err != ErrStateNotFound {
+		t.Errorf("expected the expired session to be deleted but got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package sessions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+//publicIDLength is the number of bytes of the hashed session ID in a public ID
+const publicIDLength = 12
+
+//ErrSessionNotFound is returned when a user has no session with the given public ID
+var ErrSessionNotFound = errors.New("session not found")
+
+//Metadata describes a session, so users can recognize and
+//revoke their sessions on other devices
+type Metadata struct {
+	//ID is the session's public ID. Session IDs are bearer
+	//credentials, so they're never shown to anyone.
+	ID string `json:"id"`
+	//SessionID identifies the session to the store's methods. Stores
+	//that only keep a hash of session IDs set it to a handle instead,
+	//which works with the store but not as a credential.
+	SessionID  SessionID `json:"-"`
+	UserID     int64     `json:"-"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"userAgent"`
+	DeviceName string    `json:"deviceName"`
+}
+
+//PublicID returns an ID for the session that can be shown to its user
+//and used to revoke it, without letting anyone use the session
+func (sid SessionID) PublicID() string {
+	if publicID, _, ok := sid.handle(); ok {
+		return publicID
+	}
+	hash := sha256.Sum256([]byte(sid))
+	return hex.EncodeToString(hash[:publicIDLength])
+}
+
+//handlePrefix starts session handles. It isn't in the
+//base64 URL alphabet, so no session ID starts with it.
+const handlePrefix = "#"
+
+//newHandle returns the handle of the session with the public ID,
+//which a store keeps under the hash of its session ID
+func newHandle(publicID string, hash string) SessionID {
+	return SessionID(handlePrefix + publicID + "." + hash)
+}
+
+//handle returns the public ID and hash in the
+//session ID, if it's a handle made by newHandle
+func (sid SessionID) handle() (publicID string, hash string, ok bool) {
+	if !strings.HasPrefix(string(sid), handlePrefix) {
+		return "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(string(sid), handlePrefix), ".", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+//FindSession returns the metadata of the session with
+//the given public ID, or ErrSessionNotFound
+func FindSession(list []*Metadata, publicID string) (*Metadata, error) {
+	for _, meta := range list {
+		if meta.ID == publicID {
+			return meta, nil
+		}
+	}
+	return nil, ErrSessionNotFound
+}
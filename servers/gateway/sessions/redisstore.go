@@ -3,25 +3,46 @@ package sessions
 import (
 	"encoding/json"
 	"errors"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/go-redis/redis"
 )
 
 //RedisStore represents a session.Store backed by redis.
+//Sessions are kept under an HMAC of their ID, so a copy of the
+//data in redis doesn't hold any session IDs that could be used.
 type RedisStore struct {
-	//Redis client used to talk to redis server.
-	Client *redis.Client
-	//Used for key expiry time on redis.
+	//Redis client used to talk to redis server, which can be
+	//a standalone server, a Sentinel failover group or a Cluster.
+	Client redis.UniversalClient
+	//Keyring has the keys the HMACs of session IDs are made with.
+	//Each session's is made with the key that signed its ID.
+	Keyring *Keyring
+	//Used for key expiry time on redis: how long
+	//sessions last without being used.
 	SessionDuration time.Duration
+	//MaxLifetime is how long sessions last after they begin, however
+	//often they're used. Zero means there's no limit.
+	MaxLifetime time.Duration
+	//HashTags puts the session ID, or the user ID, of each key in braces,
+	//so Redis Cluster hashes a session's keys to the same slot and its
+	//multi-key commands work, and each user's index to a slot of its own.
+	//Changing it moves every key, so existing sessions are lost.
+	HashTags bool
 }
 
-//NewRedisStore constructs a new RedisStore
-func NewRedisStore(client *redis.Client, sessionDuration time.Duration) *RedisStore {
+//NewRedisStore constructs a new RedisStore using the client and the
+//keyring that signs session IDs, with hash tags if it's a Cluster client
+func NewRedisStore(client redis.UniversalClient, keyring *Keyring, sessionDuration time.Duration) *RedisStore {
 	//initialize and return a new RedisStore struct
+	_, cluster := client.(*redis.ClusterClient)
 	myReddisStore := RedisStore{
 		Client:          client,
+		Keyring:         keyring,
 		SessionDuration: sessionDuration,
+		HashTags:        cluster,
 	}
 
 	return &myReddisStore
@@ -34,14 +55,24 @@ func NewRedisStore(client *redis.Client, sessionDuration time.Duration) *RedisSt
 //all the data you want to associated with the given SessionID.
 func (rs *RedisStore) Save(sid SessionID, sessionState interface{}) error {
 	//TODO: marshal the `sessionState` to JSON and save it in the redis database,
-	//using `sid.getRedisKey()` for the key.
+	//using `rs.key(sid)` for the key.
 	value, err := json.Marshal(sessionState)
 	if err != nil {
 		return errors.New("Problem during marshal of session state.")
 	}
 
-	key := sid.getRedisKey()
-	err2 := rs.Client.Set(key, value, rs.SessionDuration).Err()
+	key := rs.key(sid)
+	env := &envelope{CreatedAt: time.Now().UTC(), State: value}
+	if saved, err := rs.Client.Get(key).Bytes(); err == nil {
+		// saving again doesn't extend the session's lifetime
+		prev, _ := decodeEnvelope(saved)
+		env.CreatedAt = prev.CreatedAt
+	}
+	ttl := remaining(env.CreatedAt, rs.SessionDuration, rs.MaxLifetime)
+	if ttl <= 0 {
+		return ErrSessionExpired
+	}
+	err2 := rs.Client.Set(key, env.encode(), ttl).Err()
 	if err2 != nil {
 		return errors.New("Problem adding key and value: " + err2.Error())
 	}
@@ -57,8 +88,19 @@ func (rs *RedisStore) Get(sid SessionID, sessionState interface{}) error {
 	//unmarshal it back into the `sessionState` parameter
 	//and reset the expiry time, so that it doesn't get deleted until
 	//the SessionDuration has elapsed.
-	key := sid.getRedisKey()
-	value, err := rs.Client.Get(key).Result()
+	key := rs.key(sid)
+	value, err := rs.Client.Get(key).Bytes()
+	if err == redis.Nil {
+		// it may have been saved before IDs were hashed
+		migrated, migrateErr := rs.migrate(sid)
+		if migrateErr != nil {
+			return migrateErr
+		}
+		if !migrated {
+			return ErrStateNotFound
+		}
+		value, err = rs.Client.Get(key).Bytes()
+	}
 	if err != nil {
 		if err == redis.Nil {
 			return ErrStateNotFound
@@ -66,35 +108,251 @@ func (rs *RedisStore) Get(sid SessionID, sessionState interface{}) error {
 		return err
 	}
 
-	json.Unmarshal([]byte(value), sessionState)
+	env, ok := decodeEnvelope(value)
+	ttl := remaining(env.CreatedAt, rs.SessionDuration, rs.MaxLifetime)
+	if ttl <= 0 {
+		rs.Delete(sid)
+		return ErrSessionExpired
+	}
 
-	err3 := rs.Client.Set(key, value, rs.SessionDuration).Err()
-	if err3 != nil {
-		return errors.New("Problem adding key and value and resetting expiry time.")
+	pipe := rs.Client.TxPipeline()
+	if ok {
+		pipe.Expire(key, ttl)
+	} else {
+		// sessions saved before creation times were recorded begin now
+		pipe.Set(key, env.encode(), ttl)
+	}
+	// the metadata lasts as long as the session
+	pipe.Expire(rs.metadataKey(sid), ttl)
+	if _, err := pipe.Exec(); err != nil {
+		return errors.New("Problem resetting expiry time: " + err.Error())
 	}
 
-	//for extra-credit using the Pipeline feature of the redis
-	//package to do both the get and the reset of the expiry time
-	//in just one network round trip!
+	return json.Unmarshal(env.State, sessionState)
+}
 
-	return nil
+//Expiry returns when the session will expire unless it's used again
+func (rs *RedisStore) Expiry(sid SessionID) (time.Time, error) {
+	ttl, err := rs.Client.PTTL(rs.key(sid)).Result()
+	if err != nil {
+		return time.Time{}, errors.New("Problem getting expiry time: " + err.Error())
+	}
+	// negative when the key doesn't exist
+	if ttl < 0 {
+		if migrated, err := rs.migrate(sid); err != nil || !migrated {
+			return time.Time{}, ErrStateNotFound
+		}
+		return rs.Expiry(sid)
+	}
+	return time.Now().Add(ttl), nil
 }
 
-//Delete deletes all state data associated with the SessionID from the store.
+//Delete deletes all state data associated with the SessionID from the store,
+//including its metadata.
 func (rs *RedisStore) Delete(sid SessionID) error {
 	//TODO: delete the data stored in redis for the provided SessionID
-	err := rs.Client.Del(sid.getRedisKey()).Err()
-	if err != nil {
+	// so sessions saved before IDs were hashed are deleted too
+	if _, err := rs.migrate(sid); err != nil {
+		return err
+	}
+	userID, _ := rs.Client.HGet(rs.metadataKey(sid), "userID").Int64()
+	pipe := rs.Client.TxPipeline()
+	pipe.Del(rs.key(sid), rs.metadataKey(sid))
+	if userID != 0 {
+		pipe.SRem(rs.userSessionsKey(userID), rs.hash(sid))
+	}
+	if _, err := pipe.Exec(); err != nil {
 		return errors.New("Problem with deleting value with given key.")
 	}
 
 	return nil
 }
 
-//getRedisKey() returns the redis key to use for the SessionID
-func (sid SessionID) getRedisKey() string {
-	//convert the SessionID to a string and add the prefix "sid:" to keep
-	//SessionID keys separate from other keys that might end up in this
-	//redis instance
-	return "sid:" + sid.String()
+//migrate moves a session saved under its ID, before sessions were kept
+//under the HMACs of their IDs, to its hashed keys, and reports whether
+//there was one. Its keys and the hashed ones can be in different Redis
+//Cluster slots, so it can't be a transaction, but moving it twice is harmless.
+func (rs *RedisStore) migrate(sid SessionID) (bool, error) {
+	if _, _, ok := sid.handle(); ok {
+		return false, nil
+	}
+	legacyKey := "sid:" + rs.tag(sid.String())
+	legacyMetadataKey := "sidmeta:" + rs.tag(sid.String())
+	value, err := rs.Client.Get(legacyKey).Bytes()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.New("Problem getting session: " + err.Error())
+	}
+	ttl, err := rs.Client.PTTL(legacyKey).Result()
+	if err != nil {
+		return false, errors.New("Problem getting expiry time: " + err.Error())
+	}
+	if ttl <= 0 {
+		ttl = rs.SessionDuration
+	}
+	fields, err := rs.Client.HGetAll(legacyMetadataKey).Result()
+	if err != nil {
+		return false, errors.New("Problem getting session metadata: " + err.Error())
+	}
+
+	pipe := rs.Client.Pipeline()
+	pipe.Set(rs.key(sid), value, ttl)
+	if len(fields) > 0 {
+		metadata := map[string]interface{}{"id": sid.PublicID()}
+		for field, value := range fields {
+			metadata[field] = value
+		}
+		pipe.HMSet(rs.metadataKey(sid), metadata)
+		pipe.PExpire(rs.metadataKey(sid), ttl)
+		if userID, err := strconv.ParseInt(fields["userID"], 10, 64); err == nil {
+			pipe.SRem(rs.userSessionsKey(userID), sid.String())
+			pipe.SAdd(rs.userSessionsKey(userID), rs.hash(sid))
+		}
+	}
+	pipe.Del(legacyKey)
+	pipe.Del(legacyMetadataKey)
+	if _, err := pipe.Exec(); err != nil {
+		return false, errors.New("Problem migrating session: " + err.Error())
+	}
+	return true, nil
+}
+
+//SaveMetadata saves the metadata of the session and adds it to
+//the index of its user's sessions. It expires with the session.
+func (rs *RedisStore) SaveMetadata(sid SessionID, meta *Metadata) error {
+	ttl := rs.SessionDuration
+	if expires, err := rs.Expiry(sid); err == nil {
+		ttl = time.Until(expires)
+	}
+	pipe := rs.Client.TxPipeline()
+	pipe.HMSet(rs.metadataKey(sid), map[string]interface{}{
+		"id":         sid.PublicID(),
+		"userID":     meta.UserID,
+		"createdAt":  meta.CreatedAt.UTC().Format(time.RFC3339Nano),
+		"lastSeenAt": meta.LastSeenAt.UTC().Format(time.RFC3339Nano),
+		"ip":         meta.IP,
+		"userAgent":  meta.UserAgent,
+		"deviceName": meta.DeviceName,
+	})
+	pipe.Expire(rs.metadataKey(sid), ttl)
+	pipe.SAdd(rs.userSessionsKey(meta.UserID), rs.hash(sid))
+	pipe.Expire(rs.userSessionsKey(meta.UserID), rs.SessionDuration)
+	if _, err := pipe.Exec(); err != nil {
+		return errors.New("Problem saving session metadata: " + err.Error())
+	}
+	return nil
+}
+
+//Touch records that the session was just used from the IP address
+func (rs *RedisStore) Touch(sid SessionID, ip string) error {
+	userID, err := rs.Client.HGet(rs.metadataKey(sid), "userID").Int64()
+	if err == redis.Nil {
+		return ErrStateNotFound
+	}
+	if err != nil {
+		return errors.New("Problem getting session metadata: " + err.Error())
+	}
+	pipe := rs.Client.TxPipeline()
+	pipe.HMSet(rs.metadataKey(sid), map[string]interface{}{
+		"lastSeenAt": time.Now().UTC().Format(time.RFC3339Nano),
+		"ip":         ip,
+	})
+	// the index lasts as long as the user's newest session
+	pipe.Expire(rs.userSessionsKey(userID), rs.SessionDuration)
+	if _, err := pipe.Exec(); err != nil {
+		return errors.New("Problem updating session metadata: " + err.Error())
+	}
+	return nil
+}
+
+//Sessions returns the metadata of the user's sessions
+//that haven't expired, most recently seen first. The index only
+//has the HMACs of their IDs, so each SessionID is a handle.
+func (rs *RedisStore) Sessions(userID int64) ([]*Metadata, error) {
+	hashes, err := rs.Client.SMembers(rs.userSessionsKey(userID)).Result()
+	if err != nil {
+		return nil, errors.New("Problem getting sessions: " + err.Error())
+	}
+	// sessions saved before IDs were hashed are indexed by their IDs,
+	// which are too long to be HMACs
+	for i, member := range hashes {
+		if _, _, _, err := decodeID(member); err == nil {
+			if migrated, _ := rs.migrate(SessionID(member)); migrated {
+				hashes[i] = rs.hash(SessionID(member))
+			}
+		}
+	}
+	pipe := rs.Client.Pipeline()
+	results := make([]*redis.StringStringMapCmd, len(hashes))
+	for i, hash := range hashes {
+		results[i] = pipe.HGetAll(rs.hashedKey("sidmeta:", hash))
+	}
+	if len(hashes) > 0 {
+		if _, err := pipe.Exec(); err != nil {
+			return nil, errors.New("Problem getting session metadata: " + err.Error())
+		}
+	}
+
+	list := []*Metadata{}
+	expired := []interface{}{}
+	for i, hash := range hashes {
+		fields := results[i].Val()
+		if len(fields) == 0 {
+			expired = append(expired, hash)
+			continue
+		}
+		meta := &Metadata{
+			ID:         fields["id"],
+			SessionID:  newHandle(fields["id"], hash),
+			UserID:     userID,
+			IP:         fields["ip"],
+			UserAgent:  fields["userAgent"],
+			DeviceName: fields["deviceName"],
+		}
+		meta.CreatedAt, _ = time.Parse(time.RFC3339Nano, fields["createdAt"])
+		meta.LastSeenAt, _ = time.Parse(time.RFC3339Nano, fields["lastSeenAt"])
+		list = append(list, meta)
+	}
+	if len(expired) > 0 {
+		rs.Client.SRem(rs.userSessionsKey(userID), expired...)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeenAt.After(list[j].LastSeenAt)
+	})
+	return list, nil
+}
+
+//hash returns the HMAC of the session ID the session is kept under
+func (rs *RedisStore) hash(sid SessionID) string {
+	return storeHash(rs.Keyring, sid)
+}
+
+//tag returns the ID, in a hash tag if the store uses them
+func (rs *RedisStore) tag(id string) string {
+	if rs.HashTags {
+		return "{" + id + "}"
+	}
+	return id
+}
+
+//hashedKey returns the redis key with the prefix of the session with the HMAC
+func (rs *RedisStore) hashedKey(prefix string, hash string) string {
+	return prefix + rs.tag(hash)
+}
+
+//key returns the redis key of the session's state
+func (rs *RedisStore) key(sid SessionID) string {
+	return rs.hashedKey("sid:", rs.hash(sid))
+}
+
+//metadataKey returns the redis key of the session's metadata
+func (rs *RedisStore) metadataKey(sid SessionID) string {
+	return rs.hashedKey("sidmeta:", rs.hash(sid))
+}
+
+//userSessionsKey returns the redis key of the set of the HMACs of the user's session IDs
+func (rs *RedisStore) userSessionsKey(userID int64) string {
+	return "user-sessions:" + rs.tag(strconv.FormatInt(userID, 10))
 }
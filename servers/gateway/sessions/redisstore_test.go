@@ -3,6 +3,7 @@ package sessions
 import (
 	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -48,7 +49,7 @@ func TestRedisStore(t *testing.T) {
 		Addr: redisaddr,
 	})
 
-	store := NewRedisStore(client, time.Hour)
+	store := NewRedisStore(client, SingleKey("test key"), time.Hour)
 
 	if err := store.Get(sid, stateRet); err != ErrStateNotFound {
 		t.Errorf("incorrect error when getting state that was never stored: expected %v but got %v", ErrStateNotFound, err)
@@ -81,3 +82,96 @@ func TestRedisStore(t *testing.T) {
 		t.Fatalf("incorrect error when getting state that was deleted: expected %v but got %v", ErrStateNotFound, err)
 	}
 }
+
+func TestRedisStoreHashTags(t *testing.T) {
+	sid, err := NewSessionID("test key")
+	if err != nil {
+		t.Fatalf("error generating new SessionID: %v", err)
+	}
+
+	// keys hold an HMAC of the session ID rather than the ID itself
+	keyring := SingleKey("test key")
+	store := NewRedisStore(redis.NewClient(&redis.Options{}), keyring, time.Hour)
+	hash := store.hash(sid)
+	if strings.Contains(store.key(sid), sid.String()) || hash == sid.String() {
+		t.Errorf("expected the key not to hold the session ID but got %s", store.key(sid))
+	}
+	if store.HashTags || store.key(sid) != "sid:"+hash || store.metadataKey(sid) != "sidmeta:"+hash {
+		t.Errorf("expected keys without hash tags but got %s and %s", store.key(sid), store.metadataKey(sid))
+	}
+
+	// the handles Sessions returns find the same keys
+	handle := newHandle(sid.PublicID(), hash)
+	if store.key(handle) != store.key(sid) || handle.PublicID() != sid.PublicID() {
+		t.Errorf("expected the handle %s to find the session's keys", handle)
+	}
+
+	// the HMAC needs the key that signed the session ID
+	other := NewRedisStore(redis.NewClient(&redis.Options{}), SingleKey("other key"), time.Hour)
+	if other.hash(sid) == hash {
+		t.Error("expected a different key to make a different HMAC")
+	}
+
+	// a cluster hashes a session's keys to the same slot,
+	// and each user's index by their ID
+	cluster := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{":7000"}})
+	defer cluster.Close()
+	store = NewRedisStore(cluster, keyring, time.Hour)
+	tag := "{" + hash + "}"
+	if !store.HashTags || store.key(sid) != "sid:"+tag || store.metadataKey(sid) != "sidmeta:"+tag {
+		t.Errorf("expected keys hash tagged by the HMAC of the session ID but got %s and %s", store.key(sid), store.metadataKey(sid))
+	}
+	if key := store.userSessionsKey(42); key != "user-sessions:{42}" {
+		t.Errorf("expected the index hash tagged by user ID but got %s", key)
+	}
+}
+
+func TestRedisStoreLegacyKeys(t *testing.T) {
+	redisaddr := os.Getenv("REDISADDR")
+	if len(redisaddr) == 0 {
+		redisaddr = ":6379"
+	}
+	client := redis.NewClient(&redis.Options{Addr: redisaddr})
+	defer client.Close()
+	if err := client.Ping().Err(); err != nil {
+		t.Skipf("redis isn't running at %s: %v", redisaddr, err)
+	}
+
+	sid, err := NewSessionID("test key")
+	if err != nil {
+		t.Fatalf("error generating new SessionID: %v", err)
+	}
+	store := NewRedisStore(client, SingleKey("test key"), time.Hour)
+	defer store.Delete(sid)
+
+	// a session saved before IDs were hashed, under the ID itself
+	legacyKey, legacyMetadataKey := "sid:"+sid.String(), "sidmeta:"+sid.String()
+	client.Set(legacyKey, `{"Sval":"legacy"}`, time.Hour)
+	client.HMSet(legacyMetadataKey, map[string]interface{}{
+		"userID":     1,
+		"lastSeenAt": time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	client.SAdd(store.userSessionsKey(1), sid.String())
+
+	state := struct{ Sval string }{}
+	if err := store.Get(sid, &state); err != nil || state.Sval != "legacy" {
+		t.Fatalf("expected the legacy session but got %v, %v", state, err)
+	}
+	if n := client.Exists(legacyKey, legacyMetadataKey).Val(); n != 0 {
+		t.Errorf("expected the legacy keys to be deleted but %d remain", n)
+	}
+	if n := client.Exists(store.key(sid), store.metadataKey(sid)).Val(); n != 2 {
+		t.Errorf("expected the session under its hashed keys but found %d of them", n)
+	}
+
+	list, err := store.Sessions(1)
+	if err != nil {
+		t.Fatalf("error listing sessions: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != sid.PublicID() || store.key(list[0].SessionID) != store.key(sid) {
+		t.Errorf("expected the migrated session to be listed but got %v", list)
+	}
+	if members := client.SMembers(store.userSessionsKey(1)).Val(); len(members) != 1 || members[0] != store.hash(sid) {
+		t.Errorf("expected the index to hold only the HMAC but got %v", members)
+	}
+}
@@ -10,6 +10,10 @@ const headerAuthorization = "Authorization"
 const paramAuthorization = "auth"
 const schemeBearer = "Bearer "
 
+//HeaderSessionExpires is the response header telling
+//clients when their session will expire
+const HeaderSessionExpires = "Session-Expires"
+
 //ErrNoSessionID is used when no session ID was found in the Authorization header
 var ErrNoSessionID = errors.New("no session ID found in " + headerAuthorization + " header")
 
@@ -19,10 +23,14 @@ var ErrInvalidScheme = errors.New("authorization scheme not supported")
 //BeginSession creates a new SessionID, saves the `sessionState` to the store, adds an
 //Authorization header to the response with the SessionID, and returns the new SessionID
 func BeginSession(signingKey string, store Store, sessionState interface{}, w http.ResponseWriter) (SessionID, error) {
+	return beginSession(SingleKey(signingKey), HeaderTransport, store, sessionState, w)
+}
+
+func beginSession(kr *Keyring, t *Transport, store Store, sessionState interface{}, w http.ResponseWriter) (SessionID, error) {
 	//TODO:
 	//- create a new SessionID
 
-	id, err := NewSessionID(signingKey)
+	id, err := kr.NewSessionID()
 	if err != nil {
 		return InvalidSessionID, ErrInvalidID
 	}
@@ -31,53 +39,89 @@ func BeginSession(signingKey string, store Store, sessionState interface{}, w ht
 	store.Save(id, sessionState)
 	//- add a header to the ResponseWriter that looks like this:
 	//    "Authorization: Bearer <sessionID>"
-	w.Header().Add(headerAuthorization, schemeBearer+id.String())
+	t.send(id, w)
 	//  where "<sessionID>" is replaced with the newly-created SessionID
 	//  (note the constants declared for you above, which will help you avoid typos)
+	WriteExpiry(store, id, w)
 
 	return id, nil
 }
 
-//GetSessionID extracts and validates the SessionID from the request headers
+//WriteExpiry adds a Session-Expires header to the response
+//with when the session will expire unless it's used again
+func WriteExpiry(store Store, sid SessionID, w http.ResponseWriter) error {
+	expires, err := store.Expiry(sid)
+	if err != nil {
+		return err
+	}
+	w.Header().Set(HeaderSessionExpires, expires.UTC().Format(http.TimeFormat))
+	return nil
+}
+
+//GetSessionID extracts and validates the SessionID from the Authorization
+//header, or the session cookie. Unsafe requests authenticated by the cookie have
+//to repeat the session's CSRF token in the X-CSRF-Token header.
 func GetSessionID(r *http.Request, signingKey string) (SessionID, error) {
+	return getSessionID(r, SingleKey(signingKey))
+}
+
+func getSessionID(r *http.Request, kr *Keyring) (SessionID, error) {
 	//TODO: get the value of the Authorization header,
 	//or the "auth" query string parameter if no Authorization header is present,
 	//and validate it. If it's valid, return the SessionID. If not
 	//return the validation error.
 	id := ""
+	fromCookie := false
 	if strings.HasPrefix(r.Header.Get(headerAuthorization), schemeBearer) &&
 		!strings.HasPrefix(r.Header.Get(headerAuthorization), schemeBearer+"invalid") {
 		id = strings.TrimPrefix(r.Header.Get(headerAuthorization), schemeBearer)
 	}
+	if cookie, err := r.Cookie(cookieSession); id == "" && err == nil && len(cookie.Value) > 0 {
+		id = cookie.Value
+		fromCookie = true
+	}
+	// the auth query string parameter isn't read here, since query
+	// strings end up in logs and browser history. Only the websocket
+	// handshake, which browsers can't set headers on, accepts it.
 	if id == "" {
-		params := r.URL.Query()
-		if params[paramAuthorization] != nil {
-			id = strings.TrimPrefix(strings.Join(params[paramAuthorization], ""), schemeBearer)
-		} else {
-			return InvalidSessionID, ErrNoSessionID
-		}
-
+		return InvalidSessionID, ErrNoSessionID
 	}
 
-	validID, err := ValidateID(id, signingKey)
+	validID, err := kr.ValidateID(id)
 	if err != nil {
 		return InvalidSessionID, ErrInvalidID
-	} else {
-		return validID, nil
 	}
+	if fromCookie {
+		// browsers send cookies with requests from other sites too
+		if err := checkCSRF(r, validID); err != nil {
+			return InvalidSessionID, err
+		}
+	}
+	return validID, nil
 }
 
 //GetState extracts the SessionID from the request,
 //gets the associated state from the provided store into
-//the `sessionState` parameter, and returns the SessionID
+//the `sessionState` parameter, and returns the SessionID.
+//With ErrSessionExpired, the expired session's ID is returned too.
 func GetState(r *http.Request, signingKey string, store Store, sessionState interface{}) (SessionID, error) {
+	return getState(r, SingleKey(signingKey), store, sessionState)
+}
+
+func getState(r *http.Request, kr *Keyring, store Store, sessionState interface{}) (SessionID, error) {
 	//TODO: get the SessionID from the request, and get the data
 	//associated with that SessionID from the store.
-	validID, err := GetSessionID(r, signingKey)
+	validID, err := getSessionID(r, kr)
+	if err == ErrInvalidCSRFToken {
+		return InvalidSessionID, err
+	}
 	if err != nil {
 		return InvalidSessionID, ErrInvalidID
 	}
 	err2 := Store.Get(store, validID, sessionState)
+	if err2 == ErrSessionExpired {
+		return validID, ErrSessionExpired
+	}
 	if err2 != nil {
 		return InvalidSessionID, ErrStateNotFound
 	}
@@ -88,9 +132,13 @@ func GetState(r *http.Request, signingKey string, store Store, sessionState inte
 //and deletes the associated data in the provided store, returning
 //the extracted SessionID.
 func EndSession(r *http.Request, signingKey string, store Store) (SessionID, error) {
+	return endSession(r, SingleKey(signingKey), store)
+}
+
+func endSession(r *http.Request, kr *Keyring, store Store) (SessionID, error) {
 	//TODO: get the SessionID from the request, and delete the
 	//data associated with it in the store.
-	validID, err := GetSessionID(r, signingKey)
+	validID, err := getSessionID(r, kr)
 	if err != nil {
 		return InvalidSessionID, ErrInvalidID
 	}
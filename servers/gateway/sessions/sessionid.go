@@ -1,7 +1,6 @@
 package sessions
 
 import (
-	"bytes"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
@@ -28,6 +27,11 @@ const signedLength = idLength + sha256.Size
 //+-----------------------------------------------------+
 //|...32 crypto random bytes...|HMAC hash of those bytes|
 //+-----------------------------------------------------+
+//IDs signed by a Keyring key with a non-empty ID start with the
+//length of the key ID and the key ID, which are signed too:
+//+-------------------------------------------------------------------+
+//|length|...key ID...|...32 crypto random bytes...|HMAC hash of those|
+//+-------------------------------------------------------------------+
 type SessionID string
 
 //ErrInvalidID is returned when an invalid session id is passed to ValidateID()
@@ -51,21 +55,31 @@ func NewSessionID(signingKey string) (SessionID, error) {
 	//- encode that byte slice using base64 URL Encoding and return
 	//  the result as a SessionID type
 
+	return newSignedID("", []byte(signingKey))
+}
+
+//newSignedID creates a new session ID signed with the key, which has
+//the key's ID encoded in front of the random bytes unless it's empty
+func newSignedID(keyID string, key []byte) (SessionID, error) {
 	salt := make([]byte, idLength)
 	_, err := rand.Read(salt)
 	if err != nil {
 		return InvalidSessionID, errors.New("Problem generating salt.")
 	}
+	unsigned := salt
+	if len(keyID) > 0 {
+		unsigned = append(append([]byte{byte(len(keyID))}, keyID...), salt...)
+	}
 
 	// take those same salt values
 	// HMAC hash it
 	// using the signingKey as the HMAC key
-	h := hmac.New(sha256.New, []byte(signingKey))
-	h.Write(salt)
+	h := hmac.New(sha256.New, key)
+	h.Write(unsigned)
 	signature := h.Sum(nil)
 
 	// encode byte slice using base64 URL encoding
-	combined := append(salt, signature...)
+	combined := append(unsigned, signature...)
 	encoded := base64.URLEncoding.EncodeToString(combined)
 
 	// now assemble together the salt and signature in a single slice
@@ -85,27 +99,45 @@ func ValidateID(id string, signingKey string) (SessionID, error) {
 	//return the entire `id` parameter as a SessionID type.
 	//If not, return InvalidSessionID and ErrInvalidID.
 
+	_, unsigned, signature, err := decodeID(id)
+	if err != nil {
+		return InvalidSessionID, err
+	}
+	if !validSignature(unsigned, signature, []byte(signingKey)) {
+		return InvalidSessionID, ErrInvalidID
+	}
+	return SessionID(id), nil
+}
+
+//decodeID decodes the session ID into the ID of the key that signed it,
+//which is empty if it wasn't encoded, the signed bytes and the signature
+func decodeID(id string) (keyID string, unsigned []byte, signature []byte, err error) {
 	// decode id parameter, get back string of salt + signature?
 	decoded, err := base64.URLEncoding.DecodeString(id)
 	if err != nil {
-		return InvalidSessionID, errors.New("Problem decoding id during validation: " + err.Error())
+		return "", nil, nil, errors.New("Problem decoding id during validation: " + err.Error())
 	}
 
-	firsthalf := decoded[:idLength]
-	secondhalf := decoded[idLength:]
-
-	// HMAC hash the ID portion of the byte slice
-	h := hmac.New(sha256.New, []byte(signingKey))
-	h.Write(firsthalf)
-	idcheck := h.Sum(nil)
-
-	// compare it
-	if bytes.Compare(secondhalf, idcheck) == 0 {
-		return SessionID(id), nil
-	} else {
-		return InvalidSessionID, ErrInvalidID
+	// anything shorter can't hold the random bytes and the signature
+	if len(decoded) < signedLength {
+		return "", nil, nil, ErrInvalidID
+	}
+	if len(decoded) > signedLength {
+		if len(decoded) != 1+int(decoded[0])+signedLength || decoded[0] == 0 {
+			return "", nil, nil, ErrInvalidID
+		}
+		keyID = string(decoded[1 : 1+decoded[0]])
 	}
+	split := len(decoded) - sha256.Size
+	return keyID, decoded[:split], decoded[split:], nil
+}
 
+//validSignature reports whether the signature is the HMAC hash of the
+//unsigned bytes, comparing in constant time so it can't be guessed
+func validSignature(unsigned []byte, signature []byte, key []byte) bool {
+	h := hmac.New(sha256.New, key)
+	h.Write(unsigned)
+	return hmac.Equal(signature, h.Sum(nil))
 }
 
 //String returns a string representation of the sessionID
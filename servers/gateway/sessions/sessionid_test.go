@@ -154,3 +154,19 @@ func TestValidateID(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateIDShortInput(t *testing.T) {
+	ids := []string{
+		"",
+		"abc",
+		base64.URLEncoding.EncodeToString(make([]byte, idLength)),
+		base64.URLEncoding.EncodeToString(make([]byte, signedLength-1)),
+		// claims a longer key ID than it has
+		base64.URLEncoding.EncodeToString(append([]byte{200}, make([]byte, signedLength+2)...)),
+	}
+	for _, id := range ids {
+		if _, err := ValidateID(id, "test key"); err == nil {
+			t.Errorf("expected an error validating %q", id)
+		}
+	}
+}
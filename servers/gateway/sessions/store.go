@@ -2,6 +2,7 @@ package sessions
 
 import (
 	"errors"
+	"time"
 )
 
 //ErrStateNotFound is returned from Store.Get() when the requested
@@ -20,9 +21,26 @@ type Store interface {
 	Save(sid SessionID, sessionState interface{}) error
 
 	//Get populates `sessionState` with the data previously saved
-	//for the given SessionID
+	//for the given SessionID, and resets its idle timeout.
+	//Sessions past their maximum lifetime return ErrSessionExpired.
 	Get(sid SessionID, sessionState interface{}) error
 
-	//Delete deletes all state data associated with the SessionID from the store.
+	//Expiry returns when the session will expire unless it's used again
+	Expiry(sid SessionID) (time.Time, error)
+
+	//Delete deletes all state data associated with the SessionID from the store,
+	//including its metadata.
 	Delete(sid SessionID) error
+
+	//SaveMetadata saves the metadata of the session and adds it to
+	//the index of its user's sessions. It expires with the session.
+	SaveMetadata(sid SessionID, meta *Metadata) error
+
+	//Touch records that the session was just used from the IP address
+	Touch(sid SessionID, ip string) error
+
+	//Sessions returns the metadata of the user's sessions
+	//that haven't expired, most recently seen first. Their
+	//SessionIDs may be handles, which only work with this store.
+	Sessions(userID int64) ([]*Metadata, error)
 }
@@ -0,0 +1,287 @@
+package sessions
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+//refreshTokenLength is the number of random bytes in a refresh token
+const refreshTokenLength = 32
+
+//accessPurpose is what access tokens' keys are derived for
+const accessPurpose = "access token"
+
+//ErrInvalidToken is returned when a token is malformed, wasn't
+//signed by a key in the keyring, or has expired
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+//ErrTokenReused is returned when a refresh token is used again after
+//it was rotated. It was probably stolen, so its whole family is revoked.
+var ErrTokenReused = errors.New("refresh token was already used, please sign in again")
+
+//TokenService issues short-lived access tokens, which are signed
+//JWTs that can be verified without looking anything up, along with
+//long-lived refresh tokens for getting new ones. Refresh tokens are
+//opaque, and belong to a Family kept by the hash of its current token.
+//Each one can be used once, and is rotated for a new one in the same
+//family; using one again revokes the family, so a stolen token stops
+//working as soon as either its thief or its owner uses it. Families are
+//issued from a session, are revoked when it ends, and end at its maximum
+//lifetime however often they're used. Access tokens that were already
+//issued stay valid until they expire, so their TTL should be short.
+type TokenService struct {
+	//Keyring signs access tokens, and the key's ID is in their header
+	Keyring *Keyring
+	//Families keeps refresh token families
+	Families FamilyStore
+	//AccessTTL is how long access tokens last
+	AccessTTL time.Duration
+	//RefreshTTL is how long refresh tokens last without being used
+	RefreshTTL time.Duration
+	//MaxLifetime is how long after its session signed in a family
+	//lasts, like the session. Zero means there's no limit.
+	MaxLifetime time.Duration
+}
+
+//TokenPair is an access token and the refresh token to get the next one with
+type TokenPair struct {
+	AccessToken  string `json:"accessToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int64  `json:"expiresIn"`
+	RefreshToken string `json:"refreshToken"`
+	//Family is the ID of the refresh token's family
+	Family string `json:"-"`
+}
+
+//AccessClaims are the claims in an access token
+type AccessClaims struct {
+	//UserID is the user the token was issued to
+	UserID int64 `json:"uid"`
+	//Family is the refresh token family the token was issued from
+	Family    string `json:"fam"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+//accessHeader is the header of an access token
+type accessHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+//NewTokenService constructs a new TokenService
+func NewTokenService(keyring *Keyring, families FamilyStore, accessTTL time.Duration, refreshTTL time.Duration, maxLifetime time.Duration) *TokenService {
+	return &TokenService{
+		Keyring:     keyring,
+		Families:    families,
+		AccessTTL:   accessTTL,
+		RefreshTTL:  refreshTTL,
+		MaxLifetime: maxLifetime,
+	}
+}
+
+//IsAccessToken reports whether the bearer credential is an access
+//token rather than a session ID, which never contains dots
+func IsAccessToken(credential string) bool {
+	return strings.Count(credential, ".") == 2
+}
+
+//Issue issues a new access token and refresh token to the user, in a
+//new refresh token family for the session, which signed in at startedAt
+func (ts *TokenService) Issue(userID int64, sid SessionID, startedAt time.Time) (*TokenPair, error) {
+	ttl := remaining(startedAt, ts.RefreshTTL, ts.MaxLifetime)
+	if ttl <= 0 {
+		return nil, ErrSessionExpired
+	}
+	id, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := newRefreshToken(id)
+	if err != nil {
+		return nil, err
+	}
+	family := &Family{
+		ID:        id,
+		UserID:    userID,
+		Session:   sid.PublicID(),
+		StartedAt: startedAt,
+		Current:   hashToken(refreshToken),
+	}
+	if err := ts.Families.Create(family, ttl); err != nil {
+		return nil, err
+	}
+	return ts.pair(userID, id, refreshToken)
+}
+
+//Refresh rotates the refresh token for a new access token and refresh
+//token, returning the pair and the ID of the user they were issued to
+func (ts *TokenService) Refresh(refreshToken string) (*TokenPair, int64, error) {
+	id, ok := refreshTokenFamily(refreshToken)
+	if !ok {
+		return nil, 0, ErrInvalidToken
+	}
+	family, err := ts.Families.Get(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	ttl := remaining(family.StartedAt, ts.RefreshTTL, ts.MaxLifetime)
+	if ttl <= 0 {
+		ts.Families.Delete(id)
+		return nil, 0, ErrInvalidToken
+	}
+
+	next, err := newRefreshToken(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := ts.Families.Rotate(id, hashToken(refreshToken), hashToken(next), ttl); err != nil {
+		return nil, 0, err
+	}
+	pair, err := ts.pair(family.UserID, id, next)
+	return pair, family.UserID, err
+}
+
+//Revoke revokes the refresh token's family, so none of its tokens can
+//be used. Only the family's current token can revoke it.
+func (ts *TokenService) Revoke(refreshToken string) error {
+	id, ok := refreshTokenFamily(refreshToken)
+	if !ok {
+		return ErrInvalidToken
+	}
+	family, err := ts.Families.Get(id)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(family.Current), []byte(hashToken(refreshToken))) {
+		return ErrInvalidToken
+	}
+	return ts.Families.Delete(id)
+}
+
+//RevokeFamily revokes the family, when the session it was issued from ends
+func (ts *TokenService) RevokeFamily(id string) error {
+	return ts.Families.Delete(id)
+}
+
+//RevokeUser revokes every one of the user's families
+func (ts *TokenService) RevokeUser(userID int64) error {
+	return ts.Families.DeleteUser(userID)
+}
+
+//pair signs a new access token to go with the refresh token
+func (ts *TokenService) pair(userID int64, family string, refreshToken string) (*TokenPair, error) {
+	now := time.Now()
+	accessToken, err := ts.sign(&AccessClaims{
+		UserID:    userID,
+		Family:    family,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ts.AccessTTL).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(ts.AccessTTL / time.Second),
+		RefreshToken: refreshToken,
+		Family:       family,
+	}, nil
+}
+
+//sign encodes the claims as a JWT signed with the keyring's active key
+func (ts *TokenService) sign(claims *AccessClaims) (string, error) {
+	keyID, key := ts.Keyring.activeKey()
+	header, err := json.Marshal(&accessHeader{Algorithm: "HS256", Type: "JWT", KeyID: keyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(derivedSignature(key, accessPurpose, []byte(unsigned))), nil
+}
+
+//Verify verifies the access token and returns its claims,
+//or ErrInvalidToken if it's invalid or has expired
+func (ts *TokenService) Verify(accessToken string) (*AccessClaims, error) {
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	header := &accessHeader{}
+	if err := decodeSegment(parts[0], header); err != nil || header.Algorithm != "HS256" {
+		return nil, ErrInvalidToken
+	}
+	key, ok := ts.Keyring.key(header.KeyID)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, derivedSignature(key, accessPurpose, []byte(parts[0]+"."+parts[1]))) {
+		return nil, ErrInvalidToken
+	}
+	claims := &AccessClaims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+//decodeSegment decodes a base64 encoded JSON segment of a JWT
+func decodeSegment(segment string, v interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decoded, v)
+}
+
+//randomToken returns a new random token
+func randomToken() (string, error) {
+	buf := make([]byte, refreshTokenLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.New("Problem generating token: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+//newRefreshToken returns a new refresh token in the family. It starts
+//with the family's ID, so its family can be found without a lookup.
+func newRefreshToken(family string) (string, error) {
+	secret, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	return family + "." + secret, nil
+}
+
+//refreshTokenFamily returns the ID of the refresh token's family
+func refreshTokenFamily(refreshToken string) (string, bool) {
+	parts := strings.Split(refreshToken, ".")
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", false
+	}
+	return parts[0], true
+}
+
+//hashToken returns the hash refresh tokens are kept by,
+//so the store's contents can't be used as tokens
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,185 @@
+package sessions
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestTokenService(t *testing.T) *TokenService {
+	keyring, err := NewKeyring("1", map[string]string{"1": "first key"})
+	if err != nil {
+		t.Fatalf("error constructing keyring: %v", err)
+	}
+	return NewTokenService(keyring, NewStoreFamilies(NewMemStore(time.Hour, time.Minute)), time.Minute, time.Hour, 24*time.Hour)
+}
+
+func testSessionID(t *testing.T) SessionID {
+	sid, err := NewSessionID("test key")
+	if err != nil {
+		t.Fatalf("error generating new SessionID: %v", err)
+	}
+	return sid
+}
+
+func TestAccessTokens(t *testing.T) {
+	ts := newTestTokenService(t)
+	pair, err := ts.Issue(42, testSessionID(t), time.Now())
+	if err != nil {
+		t.Fatalf("error issuing tokens: %v", err)
+	}
+	if !IsAccessToken(pair.AccessToken) || IsAccessToken(pair.RefreshToken) {
+		t.Error("expected only the access token to look like one")
+	}
+	claims, err := ts.Verify(pair.AccessToken)
+	if err != nil || claims.UserID != 42 {
+		t.Fatalf("error verifying access token: %+v, %v", claims, err)
+	}
+
+	parts := strings.Split(pair.AccessToken, ".")
+	expired, _ := ts.sign(&AccessClaims{UserID: 42, ExpiresAt: time.Now().Add(-time.Second).Unix()})
+	other, _ := NewKeyring("2", map[string]string{"2": "second key"})
+	unknownKey, _ := (&TokenService{Keyring: other, AccessTTL: time.Minute}).sign(&AccessClaims{UserID: 42, ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	tokens := map[string]string{
+		"expired":           expired,
+		"tampered claims":   parts[0] + "." + strings.ToUpper(parts[1]) + "." + parts[2],
+		"missing signature": parts[0] + "." + parts[1] + ".",
+		"unknown key":       unknownKey,
+		"session ID":        "abc",
+	}
+	for name, token := range tokens {
+		if _, err := ts.Verify(token); err != ErrInvalidToken {
+			t.Errorf("%s: expected %v but got %v", name, ErrInvalidToken, err)
+		}
+	}
+
+	// tokens signed with an old key verify until it's removed
+	rotated, _ := NewKeyring("2", map[string]string{"2": "second key", "1": "first key"})
+	if _, err := (&TokenService{Keyring: rotated}).Verify(pair.AccessToken); err != nil {
+		t.Errorf("expected the token to verify after rotating keys: %v", err)
+	}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	ts := newTestTokenService(t)
+	first, err := ts.Issue(42, testSessionID(t), time.Now())
+	if err != nil {
+		t.Fatalf("error issuing tokens: %v", err)
+	}
+	second, userID, err := ts.Refresh(first.RefreshToken)
+	if err != nil || userID != 42 || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("error refreshing tokens: %v", err)
+	}
+	if _, err := ts.Verify(second.AccessToken); err != nil {
+		t.Errorf("error verifying refreshed access token: %v", err)
+	}
+
+	// using the first token again revokes the whole family
+	if _, _, err := ts.Refresh(first.RefreshToken); err != ErrTokenReused {
+		t.Errorf("expected %v but got %v", ErrTokenReused, err)
+	}
+	if _, _, err := ts.Refresh(second.RefreshToken); err != ErrInvalidToken {
+		t.Errorf("expected the family to be revoked but got %v", err)
+	}
+
+	// signing out revokes the family too
+	other, _ := ts.Issue(42, testSessionID(t), time.Now())
+	if err := ts.Revoke(other.RefreshToken); err != nil {
+		t.Fatalf("error revoking tokens: %v", err)
+	}
+	if _, _, err := ts.Refresh(other.RefreshToken); err != ErrInvalidToken {
+		t.Errorf("expected a revoked token to be invalid but got %v", err)
+	}
+	if _, _, err := ts.Refresh("unknown"); err != ErrInvalidToken {
+		t.Errorf("expected %v but got %v", ErrInvalidToken, err)
+	}
+
+	// only the current token can revoke its family
+	current, _ := ts.Issue(42, testSessionID(t), time.Now())
+	rotated, _, _ := ts.Refresh(current.RefreshToken)
+	if err := ts.Revoke(current.RefreshToken); err != ErrInvalidToken {
+		t.Errorf("expected an old token not to revoke its family but got %v", err)
+	}
+	if err := ts.Revoke(rotated.RefreshToken); err != nil {
+		t.Errorf("error revoking tokens: %v", err)
+	}
+}
+
+func TestRefreshTokensConcurrently(t *testing.T) {
+	ts := newTestTokenService(t)
+	pair, err := ts.Issue(42, testSessionID(t), time.Now())
+	if err != nil {
+		t.Fatalf("error issuing tokens: %v", err)
+	}
+
+	// only one of the requests racing to use the token gets a new one
+	var wg sync.WaitGroup
+	results := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := ts.Refresh(pair.RefreshToken)
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+	succeeded := 0
+	for err := range results {
+		if err == nil {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("expected exactly one refresh to succeed but %d did", succeeded)
+	}
+}
+
+func TestRefreshTokenFamilies(t *testing.T) {
+	ts := newTestTokenService(t)
+
+	// families end at their session's maximum lifetime
+	old, err := ts.Issue(42, testSessionID(t), time.Now().Add(-23*time.Hour))
+	if err != nil {
+		t.Fatalf("error issuing tokens: %v", err)
+	}
+	if _, err := ts.Issue(42, testSessionID(t), time.Now().Add(-25*time.Hour)); err != ErrSessionExpired {
+		t.Errorf("expected %v for a session past its maximum lifetime but got %v", ErrSessionExpired, err)
+	}
+	ts.MaxLifetime = 22 * time.Hour
+	if _, _, err := ts.Refresh(old.RefreshToken); err != ErrInvalidToken {
+		t.Errorf("expected a family past its maximum lifetime to be invalid but got %v", err)
+	}
+	ts.MaxLifetime = 24 * time.Hour
+
+	// families are tied to their session and user
+	sid := testSessionID(t)
+	pair, _ := ts.Issue(42, sid, time.Now())
+	family, err := ts.Families.Get(pair.Family)
+	if err != nil || family.UserID != 42 || family.Session != sid.PublicID() {
+		t.Fatalf("expected the family to record its session and user but got %+v: %v", family, err)
+	}
+	if err := ts.RevokeFamily(pair.Family); err != nil {
+		t.Fatalf("error revoking family: %v", err)
+	}
+	if _, _, err := ts.Refresh(pair.RefreshToken); err != ErrInvalidToken {
+		t.Errorf("expected a revoked family to be invalid but got %v", err)
+	}
+
+	first, _ := ts.Issue(42, testSessionID(t), time.Now())
+	second, _ := ts.Issue(42, testSessionID(t), time.Now())
+	other, _ := ts.Issue(7, testSessionID(t), time.Now())
+	if err := ts.RevokeUser(42); err != nil {
+		t.Fatalf("error revoking user's families: %v", err)
+	}
+	for _, pair := range []*TokenPair{first, second} {
+		if _, _, err := ts.Refresh(pair.RefreshToken); err != ErrInvalidToken {
+			t.Errorf("expected the user's families to be revoked but got %v", err)
+		}
+	}
+	if _, _, err := ts.Refresh(other.RefreshToken); err != nil {
+		t.Errorf("expected other users' families to be kept but got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package sessions
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+)
+
+//cookieSession is the cookie carrying the session ID, which scripts can't read
+const cookieSession = "sid"
+
+//cookieCSRF is the cookie carrying the session's CSRF token, which scripts can read
+const cookieCSRF = "csrf"
+
+//HeaderCSRF is the header that requests authenticated by the session
+//cookie have to repeat the session's CSRF token in, unless they're safe
+const HeaderCSRF = "X-CSRF-Token"
+
+//ErrInvalidCSRFToken is returned when a request authenticated by the session
+//cookie uses an unsafe method without the session's CSRF token
+var ErrInvalidCSRFToken = errors.New("missing or invalid " + HeaderCSRF + " header")
+
+//Transport configures how new session IDs are sent to clients.
+//Browser clients can be sent them in a Secure, HttpOnly cookie so
+//scripts can't read them, and needn't keep them in localStorage.
+//Requests authenticated by the cookie are protected from cross-site
+//request forgery by a double-submitted CSRF token: the session's token
+//is sent in a cookie scripts can read, and unsafe requests have to
+//repeat it in the X-CSRF-Token header, which other sites can't do.
+type Transport struct {
+	//Header sends session IDs in the Authorization header
+	Header bool
+	//Cookie sends session IDs in a cookie
+	Cookie bool
+	//Domain is the domain of the cookies, if they're shared with subdomains
+	Domain string
+	//SameSite restricts which cross-site requests browsers send the cookies with
+	SameSite http.SameSite
+}
+
+//HeaderTransport sends session IDs in the Authorization header only
+var HeaderTransport = &Transport{Header: true}
+
+//BeginSession is like the BeginSession function, signing the new session
+//ID with the keyring's active key and sending it the transport's way
+func (t *Transport) BeginSession(kr *Keyring, store Store, sessionState interface{}, w http.ResponseWriter) (SessionID, error) {
+	return beginSession(kr, t, store, sessionState, w)
+}
+
+//send sends the session ID to the client
+func (t *Transport) send(sid SessionID, w http.ResponseWriter) {
+	if t == nil {
+		t = HeaderTransport
+	}
+	if t.Header {
+		w.Header().Add(headerAuthorization, schemeBearer+sid.String())
+	}
+	if t.Cookie {
+		token := CSRFToken(sid)
+		http.SetCookie(w, t.cookie(cookieSession, sid.String(), true))
+		http.SetCookie(w, t.cookie(cookieCSRF, token, false))
+		w.Header().Set(HeaderCSRF, token)
+	}
+}
+
+//ClearCookies tells the client to forget the session cookies
+func (t *Transport) ClearCookies(w http.ResponseWriter) {
+	if t == nil || !t.Cookie {
+		return
+	}
+	for _, name := range []string{cookieSession, cookieCSRF} {
+		cookie := t.cookie(name, "", name == cookieSession)
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+	}
+}
+
+//cookie returns one of the session cookies. They last until the browser
+//closes, since the store decides when the session expires.
+func (t *Transport) cookie(name string, value string, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   t.Domain,
+		Secure:   true,
+		HttpOnly: httpOnly,
+		SameSite: t.SameSite,
+	}
+}
+
+//CSRFToken returns the session's CSRF token. It's derived from the
+//session ID, which other sites can't read, so there's nothing to store.
+func CSRFToken(sid SessionID) string {
+	mac := hmac.New(sha256.New, []byte(sid))
+	mac.Write([]byte("csrf token"))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+//checkCSRF returns ErrInvalidCSRFToken if the request is unsafe and
+//doesn't repeat the session's CSRF token in the X-CSRF-Token header
+func checkCSRF(r *http.Request, sid SessionID) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+	if !hmac.Equal([]byte(r.Header.Get(HeaderCSRF)), []byte(CSRFToken(sid))) {
+		return ErrInvalidCSRFToken
+	}
+	return nil
+}
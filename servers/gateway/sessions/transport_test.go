@@ -0,0 +1,71 @@
+package sessions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCookieTransport(t *testing.T) {
+	keyring := SingleKey("test key")
+	store := NewMemStore(time.Hour, time.Minute)
+	transport := &Transport{Cookie: true, SameSite: http.SameSiteStrictMode}
+
+	respRec := httptest.NewRecorder()
+	sid, err := transport.BeginSession(keyring, store, 100, respRec)
+	if err != nil {
+		t.Fatalf("error beginning session: %v", err)
+	}
+	if len(respRec.Header().Get(headerAuthorization)) > 0 {
+		t.Error("expected no Authorization header when only using cookies")
+	}
+	cookies := respRec.Result().Cookies()
+	if len(cookies) != 2 || cookies[0].Name != cookieSession || cookies[0].Value != sid.String() {
+		t.Fatalf("expected the session and CSRF cookies but got %v", cookies)
+	}
+	if !cookies[0].HttpOnly || !cookies[0].Secure || cookies[1].HttpOnly {
+		t.Error("expected the session cookie to be Secure and HttpOnly, and the CSRF cookie to be readable")
+	}
+	if cookies[1].Value != CSRFToken(sid) || respRec.Header().Get(HeaderCSRF) != CSRFToken(sid) {
+		t.Error("expected the CSRF token in a cookie and header")
+	}
+
+	cases := []struct {
+		name      string
+		method    string
+		csrfToken string
+		expected  error
+	}{
+		{"safe method", "GET", "", nil},
+		{"unsafe method without a token", "POST", "", ErrInvalidCSRFToken},
+		{"unsafe method with another session's token", "DELETE", CSRFToken("other"), ErrInvalidCSRFToken},
+		{"unsafe method with the token", "PATCH", CSRFToken(sid), nil},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "/", nil)
+		req.AddCookie(cookies[0])
+		if len(c.csrfToken) > 0 {
+			req.Header.Set(HeaderCSRF, c.csrfToken)
+		}
+		state := 0
+		if _, err := keyring.GetState(req, store, &state); err != c.expected {
+			t.Errorf("case %s: expected %v but got %v", c.name, c.expected, err)
+		}
+	}
+
+	// the Authorization header needs no CSRF token
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set(headerAuthorization, schemeBearer+sid.String())
+	if _, err := keyring.GetSessionID(req); err != nil {
+		t.Errorf("unexpected error using the Authorization header: %v", err)
+	}
+
+	respRec = httptest.NewRecorder()
+	transport.ClearCookies(respRec)
+	for _, cookie := range respRec.Result().Cookies() {
+		if cookie.MaxAge >= 0 {
+			t.Errorf("expected cookie %s to be deleted", cookie.Name)
+		}
+	}
+}
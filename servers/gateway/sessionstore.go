@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/sessions"
+	"github.com/go-redis/redis"
+)
+
+// defaultSessionFile is where file sessions are kept when SESSIONFILE isn't set
+const defaultSessionFile = "/var/lib/gateway/sessions.db"
+
+// sessionCompactInterval is how often the session files are compacted
+const sessionCompactInterval = 10 * time.Minute
+
+// newSessionStores returns the stores for sessions and refresh token
+// families. SESSIONSTORE is redis (the default) or file, which keeps
+// sessions in SESSIONFILE and refresh token families next to it, for
+// single-node deployments. Sessions expire when they go unused or reach
+// their maximum lifetime, whichever is first, and refresh tokens when
+// they go unused for refreshTTL. Sessions, and families kept in files,
+// are encrypted with encryptionKeyring if it isn't nil; families kept in
+// redis only hold token hashes, so they aren't. Redis keeps sessions under
+// HMACs of their IDs, made with the keys in keyring that signed them.
+func newSessionStores(kind string, path string, client redis.UniversalClient, keyring *sessions.Keyring, encryptionKeyring *sessions.Keyring, idleTimeout time.Duration, maxLifetime time.Duration, refreshTTL time.Duration) (sessions.Store, sessions.FamilyStore, error) {
+	encrypt := func(store sessions.Store) sessions.Store {
+		if encryptionKeyring == nil {
+			return store
+		}
+		return sessions.NewEncryptedStore(store, encryptionKeyring)
+	}
+
+	switch kind {
+	case "", "redis":
+		redisStore := sessions.NewRedisStore(client, keyring, idleTimeout)
+		redisStore.MaxLifetime = maxLifetime
+		return encrypt(redisStore), sessions.NewRedisFamilyStore(client), nil
+	case "file":
+		if len(path) == 0 {
+			path = defaultSessionFile
+		}
+		fileStore, err := sessions.NewFileStore(path, keyring, idleTimeout, sessionCompactInterval)
+		if err != nil {
+			return nil, nil, err
+		}
+		fileStore.MaxLifetime = maxLifetime
+		refreshStore, err := sessions.NewFileStore(path+".refresh", keyring, refreshTTL, sessionCompactInterval)
+		if err != nil {
+			fileStore.Close()
+			return nil, nil, err
+		}
+		return encrypt(fileStore), sessions.NewStoreFamilies(encrypt(refreshStore)), nil
+	default:
+		return nil, nil, errors.New("SESSIONSTORE must be redis or file")
+	}
+}
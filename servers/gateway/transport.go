@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/sessions"
+)
+
+// newTransport returns how new session IDs are sent to clients:
+// SESSIONTRANSPORT is header (the default), cookie for browser clients,
+// or both. SESSIONCOOKIEDOMAIN shares the cookies with subdomains, and
+// SESSIONCOOKIESAMESITE is lax (the default), strict or none.
+func newTransport(kind string, domain string, sameSite string) (*sessions.Transport, error) {
+	transport := &sessions.Transport{Domain: domain}
+	switch kind {
+	case "", "header":
+		transport.Header = true
+	case "cookie":
+		transport.Cookie = true
+	case "both":
+		transport.Header = true
+		transport.Cookie = true
+	default:
+		return nil, errors.New("SESSIONTRANSPORT must be header, cookie or both")
+	}
+
+	switch strings.ToLower(sameSite) {
+	case "", "lax":
+		transport.SameSite = http.SameSiteLaxMode
+	case "strict":
+		transport.SameSite = http.SameSiteStrictMode
+	case "none":
+		transport.SameSite = http.SameSiteNoneMode
+	default:
+		return nil, errors.New("SESSIONCOOKIESAMESITE must be lax, strict or none")
+	}
+	return transport, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+	"github.com/go-redis/redis"
+)
+
+// defaultUserCacheTTL is how long users stay cached when USERCACHETTL isn't set
+const defaultUserCacheTTL = 5 * time.Minute
+
+// memUserCacheSize is the most values the in-process cache holds
+const memUserCacheSize = 10000
+
+// newUserCache returns the cache users are read through: redis by default,
+// which every gateway shares, memory for an in-process LRU cache whose
+// invalidations are published to every gateway over redis, or nil for
+// none. The TTL bounds how stale a user can be if an invalidation is lost.
+func newUserCache(kind string, ttl string, redisClient redis.UniversalClient) (users.UserCache, error) {
+	lifetime := defaultUserCacheTTL
+	if len(ttl) > 0 {
+		var err error
+		if lifetime, err = time.ParseDuration(ttl); err != nil {
+			return nil, errors.New("USERCACHETTL must be a duration such as 5m: " + err.Error())
+		}
+	}
+
+	switch kind {
+	case "", "redis":
+		return users.NewRedisUserCache(redisClient, lifetime), nil
+	case "memory":
+		// changes are published so every gateway's cache drops them
+		cache := users.NewBroadcastUserCache(users.NewMemUserCache(lifetime, memUserCacheSize), redisClient)
+		go cache.Listen()
+		return cache, nil
+	case "none":
+		return nil, nil
+	}
+	return nil, errors.New("USERCACHE must be redis, memory or none")
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/bulk"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/indexes"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/mailer"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-jsm209/servers/gateway/models/users"
+)
+
+// reindexChannel is the redis channel that tells every gateway
+// to add any new users to its search trie
+const reindexChannel = "users:reindex"
+
+// usersUsage describes the `gateway users` subcommands
+const usersUsage = `usage:
+  gateway users import [-format csv|jsonl] [-dry-run] [-invite] [-batch-size n] [-report file] <file|->
+  gateway users export [-format csv|jsonl] [-o file]
+`
+
+// usersCommand runs `gateway users <subcommand>` and returns the exit code
+func usersCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usersUsage)
+		return 2
+	}
+	switch args[0] {
+	case "import":
+		return importUsers(args[1:])
+	case "export":
+		return exportUsers(args[1:])
+	}
+	fmt.Fprint(os.Stderr, usersUsage)
+	return 2
+}
+
+// openUserStore connects to the users database
+func openUserStore() (*users.SQLStore, *sql.DB, error) {
+	sqlDB, dialect, err := users.Open(usersDSN())
+	if err != nil {
+		return nil, nil, err
+	}
+	return users.NewDialectSQLStore(sqlDB, dialect, indexes.NewTrieNode()), sqlDB, nil
+}
+
+func importUsers(args []string) int {
+	flags := flag.NewFlagSet("gateway users import", flag.ContinueOnError)
+	format := flags.String("format", "", "csv or jsonl, guessed from the file name by default")
+	dryRun := flags.Bool("dry-run", false, "validate the users without importing them")
+	invite := flags.Bool("invite", false, "email an invitation to users without a password")
+	batchSize := flags.Int("batch-size", bulk.DefaultBatchSize, "users inserted per transaction")
+	reportPath := flags.String("report", "", "also write the report as JSON to this file")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usersUsage)
+		return 2
+	}
+
+	path := flags.Arg(0)
+	var input io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error opening %s: %v\n", path, err)
+			return 1
+		}
+		defer file.Close()
+		input = file
+	}
+	if len(*format) == 0 {
+		*format = bulk.FormatFromName(path)
+	}
+
+	rows, rowErrors, err := bulk.ReadRecords(input, *format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading %s: %v\n", path, err)
+		return 1
+	}
+
+	userStore, sqlDB, err := openUserStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error connecting to database: %v\n", err)
+		return 1
+	}
+	defer sqlDB.Close()
+
+	importer := &bulk.Importer{Store: userStore, BatchSize: *batchSize, DryRun: *dryRun}
+	if *invite {
+		acceptURL := os.Getenv("INVITEURL")
+		if len(os.Getenv("SMTPADDR")) == 0 || len(os.Getenv("SMTPFROM")) == 0 || len(acceptURL) == 0 {
+			fmt.Fprintln(os.Stderr, "-invite requires the SMTPADDR, SMTPFROM and INVITEURL environment variables")
+			return 2
+		}
+		importer.Inviter = &bulk.EmailInviter{
+			Mailer: &mailer.SMTPMailer{
+				Addr:     os.Getenv("SMTPADDR"),
+				From:     os.Getenv("SMTPFROM"),
+				Username: os.Getenv("SMTPUSER"),
+				Password: os.Getenv("SMTPPASSWORD"),
+			},
+			AcceptURL: acceptURL,
+		}
+	}
+
+	report := importer.Import(rows, rowErrors)
+	for _, rowErr := range report.Errors {
+		fmt.Printf("row %d (%s): %s\n", rowErr.Row, rowErr.Email, rowErr.Error)
+	}
+	if report.DryRun {
+		fmt.Printf("dry run: %d of %d users are valid\n", report.Valid, report.Total)
+	} else {
+		fmt.Printf("imported %d of %d users, invited %d\n", report.Imported, report.Total, report.Invited)
+	}
+
+	if len(*reportPath) > 0 {
+		encoded, _ := json.MarshalIndent(report, "", "  ")
+		if err := ioutil.WriteFile(*reportPath, encoded, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing report: %v\n", err)
+		}
+	}
+
+	// the running gateways keep their own search tries
+	if report.Imported > 0 {
+		redisClient, err := newRedisClient(os.Getenv("REDISMODE"), os.Getenv("REDISADDR"), os.Getenv("REDISMASTERNAME"), os.Getenv("REDISPASSWORD"), os.Getenv("REDISMAXRETRIES"))
+		if err == nil {
+			err = redisClient.Publish(reindexChannel, "import").Err()
+			redisClient.Close()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "imported users won't be searchable until the gateway restarts: %v\n", err)
+		}
+	}
+
+	if len(report.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func exportUsers(args []string) int {
+	flags := flag.NewFlagSet("gateway users export", flag.ContinueOnError)
+	format := flags.String("format", bulk.FormatCSV, "csv or jsonl")
+	outputPath := flags.String("o", "", "file to write to, standard output by default")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		fmt.Fprint(os.Stderr, usersUsage)
+		return 2
+	}
+
+	var output io.Writer = os.Stdout
+	if len(*outputPath) > 0 {
+		file, err := os.Create(*outputPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error creating %s: %v\n", *outputPath, err)
+			return 1
+		}
+		defer file.Close()
+		output = file
+	}
+	writer, err := bulk.NewRecordWriter(output, *format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	userStore, sqlDB, err := openUserStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error connecting to database: %v\n", err)
+		return 1
+	}
+	defer sqlDB.Close()
+
+	// passwords are never exported, not even as hashes
+	const pageSize = 500
+	for offset := 0; ; offset += pageSize {
+		list, _, err := userStore.ListUsers(offset, pageSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error listing users: %v\n", err)
+			return 1
+		}
+		for _, user := range list {
+			if err := writer.Write(bulk.RecordFromUser(user)); err != nil {
+				fmt.Fprintf(os.Stderr, "error writing user: %v\n", err)
+				return 1
+			}
+		}
+		if len(list) < pageSize {
+			break
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing users: %v\n", err)
+		return 1
+	}
+	return 0
+}